@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/MarcGrol/forwardhttp/destination"
+)
+
+//go:generate mockgen -source=api.go -destination=gen_AuthenticatorMock.go -package=auth github.com/MarcGrol/forwardhttp/auth Authenticator
+
+var (
+	ErrMissingCredentials = errors.New("Missing credentials")
+	ErrInvalidCredentials = errors.New("Invalid credentials")
+)
+
+// Client is an authenticated caller, together with the destinations it may forward to
+type Client struct {
+	Name   string
+	Routes []string // names of routes and groups; "*" allows all
+	Hosts  []string // hosts for HostToForwardTo; "*.example.com" allows subdomains, "*" allows all
+}
+
+// MayUseRoute tells whether the client may forward via the named route or group
+func (c Client) MayUseRoute(name string) bool {
+	for _, allowed := range c.Routes {
+		if allowed == "*" || allowed == name {
+			return true
+		}
+	}
+	return false
+}
+
+// MayUseHost tells whether the client may forward to the host (without port) of HostToForwardTo
+func (c Client) MayUseHost(host string) bool {
+	for _, allowed := range c.Hosts {
+		if destination.MatchesHost(allowed, host) {
+			return true
+		}
+	}
+	return false
+}
+
+type Authenticator interface {
+	// Authenticate returns ErrMissingCredentials or ErrInvalidCredentials (wrapped) when the caller is not known.
+	// The credentials are removed from the request, so that they are not forwarded.
+	Authenticate(r *http.Request) (*Client, error)
+}
@@ -0,0 +1,21 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+)
+
+// authenticateAPIKey compares all keys in constant time, so that timing does not reveal a key
+func (a *authenticator) authenticateAPIKey(key string) (*Client, error) {
+	var found *Client
+	for candidate, client := range a.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(key)) == 1 {
+			c := client
+			found = &c
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: unknown api-key", ErrInvalidCredentials)
+	}
+	return found, nil
+}
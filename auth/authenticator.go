@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/MarcGrol/forwardhttp/config"
+	"github.com/MarcGrol/forwardhttp/signature"
+)
+
+type authenticator struct {
+	apiKeys     map[string]Client
+	hmacClients map[string]hmacClient
+	jwt         *jwtVerifier
+}
+
+type hmacClient struct {
+	client   Client
+	verifier *signature.Verifier
+}
+
+// authFile describes the clients that may use the forwarder, like:
+//
+//	{"Clients": {"webshop": {"ApiKeys": ["k3y"], "HmacSecrets": ["whsec_c2VjcmV0"], "Subjects": ["webshop"],
+//		"Routes": ["billing"], "Hosts": ["*.example.com"]}},
+//	 "Jwt": {"JwksFile": "jwks.json", "Issuer": "https://idp.example.com", "Audience": "forwardhttp"}}
+type authFile struct {
+	Clients map[string]struct {
+		ApiKeys     []string
+		HmacSecrets []string
+		Subjects    []string // of jwt bearer-tokens
+		Routes      []string
+		Hosts       []string
+	}
+	Jwt struct {
+		JwksFile string
+		Issuer   string
+		Audience string
+	}
+}
+
+// NewFromSettings reads the clients from AUTH_FILE; without it, every caller may forward anywhere
+func NewFromSettings(settings config.Settings) (Authenticator, error) {
+	filename := settings.Get("AUTH_FILE")
+	if filename == "" {
+		log.Printf("No AUTH_FILE configured: forwarding is not authenticated")
+		return Anonymous(), nil
+	}
+	return Load(filename)
+}
+
+func Load(filename string) (Authenticator, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading auth file %s: %s", filename, err)
+	}
+	var file authFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing auth file %s: %s", filename, err)
+	}
+
+	a := &authenticator{
+		apiKeys:     map[string]Client{},
+		hmacClients: map[string]hmacClient{},
+	}
+	if file.Jwt.JwksFile != "" {
+		a.jwt, err = newJWTVerifier(file.Jwt.JwksFile, file.Jwt.Issuer, file.Jwt.Audience)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for name, c := range file.Clients {
+		client := Client{Name: name, Routes: c.Routes, Hosts: c.Hosts}
+		if len(c.ApiKeys) == 0 && len(c.HmacSecrets) == 0 && len(c.Subjects) == 0 {
+			return nil, fmt.Errorf("Invalid client '%s': missing api-keys, hmac-secrets or subjects", name)
+		}
+		for _, key := range c.ApiKeys {
+			if _, found := a.apiKeys[key]; found || key == "" {
+				return nil, fmt.Errorf("Invalid client '%s': api-keys must be non-empty and unique", name)
+			}
+			a.apiKeys[key] = client
+		}
+		if len(c.HmacSecrets) > 0 {
+			verifier, err := signature.NewVerifier(c.HmacSecrets, signature.DefaultTolerance)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid client '%s': %s", name, err)
+			}
+			a.hmacClients[name] = hmacClient{client: client, verifier: verifier}
+		}
+		if len(c.Subjects) > 0 && a.jwt == nil {
+			return nil, fmt.Errorf("Invalid client '%s': subjects require a jwks-file", name)
+		}
+		for _, subject := range c.Subjects {
+			if _, found := a.jwt.subjects[subject]; found {
+				return nil, fmt.Errorf("Invalid client '%s': subject '%s' is already used", name, subject)
+			}
+			a.jwt.subjects[subject] = client
+		}
+	}
+	return a, nil
+}
+
+// Authenticate picks the kind of credentials from the headers of the request
+func (a *authenticator) Authenticate(r *http.Request) (*Client, error) {
+	if key := r.Header.Get("X-Api-Key"); key != "" {
+		r.Header.Del("X-Api-Key")
+		return a.authenticateAPIKey(key)
+	}
+	if clientID := r.Header.Get("X-Client-Id"); clientID != "" {
+		client, err := a.authenticateHMAC(r, clientID)
+		for _, name := range []string{"X-Client-Id", signature.HeaderID, signature.HeaderTimestamp, signature.HeaderSignature, signature.HeaderRequestSignature} {
+			r.Header.Del(name)
+		}
+		return client, err
+	}
+	// without a jwks-file, a bearer-token is meant for the destination
+	if authorization := r.Header.Get("Authorization"); a.jwt != nil && strings.HasPrefix(authorization, "Bearer ") {
+		r.Header.Del("Authorization")
+		return a.jwt.authenticate(strings.TrimPrefix(authorization, "Bearer "))
+	}
+	return nil, ErrMissingCredentials
+}
+
+type anonymous struct{}
+
+// Anonymous lets every caller forward anywhere
+func Anonymous() Authenticator {
+	return anonymous{}
+}
+
+func (anonymous) Authenticate(r *http.Request) (*Client, error) {
+	return &Client{Name: "anonymous", Routes: []string{"*"}, Hosts: []string{"*"}}, nil
+}
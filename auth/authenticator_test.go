@@ -0,0 +1,244 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/config"
+	"github.com/MarcGrol/forwardhttp/signature"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticate(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	jwksFile := writeFile(t, dir, "jwks.json", fmt.Sprintf(`{"keys": [{"kid": "key-1", "kty": "RSA", "n": "%s", "e": "%s"}]}`,
+		base64.RawURLEncoding.EncodeToString(privateKey.N.Bytes()), base64.RawURLEncoding.EncodeToString(big.NewInt(int64(privateKey.E)).Bytes())))
+	authFile := writeFile(t, dir, "auth.json", `{
+		"Clients": {
+			"webshop": {"ApiKeys": ["k3y"], "HmacSecrets": ["whsec_c2VjcmV0"], "Routes": ["billing"], "Hosts": ["*.example.com"]},
+			"backoffice": {"Subjects": ["backoffice"], "Routes": ["*"]}
+		},
+		"Jwt": {"JwksFile": "`+jwksFile+`", "Issuer": "https://idp.example.com", "Audience": "forwardhttp"}
+	}`)
+	authenticator, err := NewFromSettings(config.New(map[string]string{"AUTH_FILE": authFile}))
+	assert.NoError(t, err)
+
+	validClaims := jwt.MapClaims{"iss": "https://idp.example.com", "aud": "forwardhttp", "sub": "backoffice", "exp": time.Now().Add(time.Hour).Unix()}
+
+	testCases := []struct {
+		name           string
+		request        *http.Request
+		expectedClient string
+		expectedError  error
+	}{
+		{
+			name:          "No credentials",
+			request:       httpRequest(t, "POST", "/doit", "body", nil),
+			expectedError: ErrMissingCredentials,
+		},
+		{
+			name:          "Bearer token meant for destination",
+			request:       httpRequest(t, "POST", "/doit", "body", map[string]string{"Authorization": "Basic dXNlcjpwYXNz"}),
+			expectedError: ErrMissingCredentials,
+		},
+		{
+			name:           "Api-key",
+			request:        httpRequest(t, "POST", "/doit", "body", map[string]string{"X-Api-Key": "k3y"}),
+			expectedClient: "webshop",
+		},
+		{
+			name:          "Unknown api-key",
+			request:       httpRequest(t, "POST", "/doit", "body", map[string]string{"X-Api-Key": "other"}),
+			expectedError: ErrInvalidCredentials,
+		},
+		{
+			name:           "Hmac",
+			request:        signedRequest(t, "webshop", "whsec_c2VjcmV0", "/doit?HostToForwardTo=api.example.com"),
+			expectedClient: "webshop",
+		},
+		{
+			name:          "Hmac: wrong secret",
+			request:       signedRequest(t, "webshop", "other", "/doit?HostToForwardTo=api.example.com"),
+			expectedError: ErrInvalidCredentials,
+		},
+		{
+			name:          "Hmac: unknown client",
+			request:       signedRequest(t, "backoffice", "whsec_c2VjcmV0", "/doit"),
+			expectedError: ErrInvalidCredentials,
+		},
+		{
+			name:           "Jwt",
+			request:        bearerRequest(t, privateKey, "key-1", validClaims),
+			expectedClient: "backoffice",
+		},
+		{
+			name:          "Jwt: signed with other key",
+			request:       bearerRequest(t, otherKey, "key-1", validClaims),
+			expectedError: ErrInvalidCredentials,
+		},
+		{
+			name:          "Jwt: expired",
+			request:       bearerRequest(t, privateKey, "key-1", jwt.MapClaims{"iss": "https://idp.example.com", "aud": "forwardhttp", "sub": "backoffice", "exp": time.Now().Add(-time.Hour).Unix()}),
+			expectedError: ErrInvalidCredentials,
+		},
+		{
+			name:          "Jwt: without expiry",
+			request:       bearerRequest(t, privateKey, "key-1", jwt.MapClaims{"iss": "https://idp.example.com", "aud": "forwardhttp", "sub": "backoffice"}),
+			expectedError: ErrInvalidCredentials,
+		},
+		{
+			name:          "Jwt: other audience",
+			request:       bearerRequest(t, privateKey, "key-1", jwt.MapClaims{"iss": "https://idp.example.com", "aud": "other", "sub": "backoffice", "exp": time.Now().Add(time.Hour).Unix()}),
+			expectedError: ErrInvalidCredentials,
+		},
+		{
+			name:          "Jwt: unknown subject",
+			request:       bearerRequest(t, privateKey, "key-1", jwt.MapClaims{"iss": "https://idp.example.com", "aud": "forwardhttp", "sub": "intruder", "exp": time.Now().Add(time.Hour).Unix()}),
+			expectedError: ErrInvalidCredentials,
+		},
+		{
+			name:          "Jwt: symmetric",
+			request:       hmacBearerRequest(t, validClaims),
+			expectedError: ErrInvalidCredentials,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := authenticator.Authenticate(tc.request)
+			if tc.expectedError != nil {
+				assert.True(t, errors.Is(err, tc.expectedError), "unexpected error: %v", err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedClient, client.Name)
+
+			// credentials are not forwarded, the body can still be read
+			for _, name := range []string{"X-Api-Key", "X-Client-Id", "Authorization", signature.HeaderRequestSignature} {
+				assert.Equal(t, "", tc.request.Header.Get(name))
+			}
+			body, err := ioutil.ReadAll(tc.request.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, "body", string(body))
+		})
+	}
+}
+
+func TestClientPermissions(t *testing.T) {
+	client := Client{Name: "webshop", Routes: []string{"billing"}, Hosts: []string{"*.example.com", "home.nl"}}
+
+	assert.True(t, client.MayUseRoute("billing"))
+	assert.False(t, client.MayUseRoute("orders"))
+	assert.True(t, client.MayUseHost("api.example.com"))
+	assert.True(t, client.MayUseHost("Home.NL"))
+	assert.False(t, client.MayUseHost("example.com"))
+	assert.False(t, client.MayUseHost("evil-example.com"))
+	assert.False(t, client.MayUseHost("169.254.169.254"))
+
+	anyone := Client{Routes: []string{"*"}, Hosts: []string{"*"}}
+	assert.True(t, anyone.MayUseRoute("orders"))
+	assert.True(t, anyone.MayUseHost("home.nl"))
+}
+
+func TestLoadErrors(t *testing.T) {
+	testCases := []struct {
+		name          string
+		authFile      string
+		expectedError string
+	}{
+		{
+			name:          "Client without credentials",
+			authFile:      `{"Clients": {"webshop": {"Routes": ["billing"]}}}`,
+			expectedError: "Invalid client 'webshop': missing api-keys, hmac-secrets or subjects",
+		},
+		{
+			name:          "Subjects without jwks",
+			authFile:      `{"Clients": {"webshop": {"Subjects": ["webshop"]}}}`,
+			expectedError: "Invalid client 'webshop': subjects require a jwks-file",
+		},
+		{
+			name:          "Invalid hmac secret",
+			authFile:      `{"Clients": {"webshop": {"HmacSecrets": ["whsec_not base64"]}}}`,
+			expectedError: "Invalid client 'webshop': Invalid secret 1: secret with prefix 'whsec_' must be base64 encoded",
+		},
+		{
+			name:          "Jwt without audience",
+			authFile:      `{"Jwt": {"JwksFile": "jwks.json", "Issuer": "https://idp.example.com"}}`,
+			expectedError: "Invalid jwt settings: missing Audience",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Load(writeFile(t, t.TempDir(), "auth.json", tc.authFile))
+			assert.EqualError(t, err, tc.expectedError)
+		})
+	}
+}
+
+func TestWithoutAuthFile(t *testing.T) {
+	authenticator, err := NewFromSettings(config.New(map[string]string{}))
+	assert.NoError(t, err)
+
+	client, err := authenticator.Authenticate(httpRequest(t, "POST", "/doit", "body", nil))
+	assert.NoError(t, err)
+	assert.True(t, client.MayUseHost("home.nl"))
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	filename := filepath.Join(dir, name)
+	assert.NoError(t, os.WriteFile(filename, []byte(content), 0600))
+	return filename
+}
+
+func httpRequest(t *testing.T, method, url, body string, headers map[string]string) *http.Request {
+	httpReq, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("Error creating http-request: %s", err)
+	}
+	httpReq.RequestURI = url
+	for k, v := range headers {
+		httpReq.Header.Set(k, v)
+	}
+	return httpReq
+}
+
+func signedRequest(t *testing.T, clientID, secret, url string) *http.Request {
+	httpReq := httpRequest(t, "POST", url, "body", map[string]string{"X-Client-Id": clientID})
+	signer, err := signature.NewSigner([]string{secret})
+	assert.NoError(t, err)
+	signer.Sign(httpReq.Header, "msg-1", time.Now(), "POST", url, []byte("body"))
+	return httpReq
+}
+
+func bearerRequest(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) *http.Request {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	tokenString, err := token.SignedString(key)
+	assert.NoError(t, err)
+	return httpRequest(t, "POST", "/doit", "body", map[string]string{"Authorization": "Bearer " + tokenString})
+}
+
+func hmacBearerRequest(t *testing.T, claims jwt.MapClaims) *http.Request {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = "key-1"
+	tokenString, err := token.SignedString([]byte("guessed"))
+	assert.NoError(t, err)
+	return httpRequest(t, "POST", "/doit", "body", map[string]string{"Authorization": "Bearer " + tokenString})
+}
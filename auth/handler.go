@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// HandlerFunc serves a request of an authenticated client
+type HandlerFunc func(w http.ResponseWriter, r *http.Request, client Client)
+
+// Required answers 401 to callers that are not known, so that the handler only serves authenticated clients
+func Required(authenticator Authenticator, handler HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, err := authenticator.Authenticate(r)
+		if errors.Is(err, ErrMissingCredentials) || errors.Is(err, ErrInvalidCredentials) {
+			reportError(w, http.StatusUnauthorized, err)
+			return
+		}
+		if err != nil {
+			reportError(w, http.StatusInternalServerError, fmt.Errorf("Error authenticating request: %s", err))
+			return
+		}
+		handler(w, r, *client)
+	}
+}
+
+func reportError(w http.ResponseWriter, httpResponseStatus int, err error) {
+	log.Print(err.Error())
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(httpResponseStatus)
+	fmt.Fprint(w, err.Error())
+}
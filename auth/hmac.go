@@ -0,0 +1,30 @@
+package auth
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// authenticateHMAC verifies a request that was signed like the forwarder signs its own requests (see package signature),
+// where the url is the request-uri, e.g. "/doit?HostToForwardTo=example.com"
+func (a *authenticator) authenticateHMAC(r *http.Request, clientID string) (*Client, error) {
+	hc, found := a.hmacClients[clientID]
+	if !found {
+		return nil, fmt.Errorf("%w: unknown client '%s'", ErrInvalidCredentials, clientID)
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading request body: %s", err)
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	err = hc.verifier.Verify(r.Header, r.Method, r.RequestURI, body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCredentials, err)
+	}
+	client := hc.client
+	return &client, nil
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+type jwtVerifier struct {
+	keys     map[string]interface{} // public keys by key-id
+	issuer   string
+	audience string
+	subjects map[string]Client
+}
+
+// jwk is a public key of a json web key set, as served by most identity providers
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func newJWTVerifier(jwksFile, issuer, audience string) (*jwtVerifier, error) {
+	if audience == "" {
+		// without it, a token that was issued for any other service of the identity provider would be accepted
+		return nil, fmt.Errorf("Invalid jwt settings: missing Audience")
+	}
+	data, err := os.ReadFile(jwksFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading jwks file %s: %s", jwksFile, err)
+	}
+	keys, err := ParseJWKS(data)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing jwks file %s: %s", jwksFile, err)
+	}
+	return &jwtVerifier{
+		keys:     keys,
+		issuer:   issuer,
+		audience: audience,
+		subjects: map[string]Client{},
+	}, nil
+}
+
+// ParseJWKS returns the public keys of a json web key set by key-id
+func ParseJWKS(data []byte) (map[string]interface{}, error) {
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	err := json.Unmarshal(data, &jwks)
+	if err != nil {
+		return nil, err
+	}
+	keys := map[string]interface{}{}
+	for _, k := range jwks.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("invalid key '%s': %s", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, found := curves[k.Crv]
+		if !found {
+			return nil, fmt.Errorf("unsupported curve '%s'", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type '%s'", k.Kty)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64url value: %s", err)
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// AsymmetricKeyFunc only accepts asymmetrically signed tokens, so that a public key can never be used as hmac-secret
+func AsymmetricKeyFunc(lookup func(kid string) (interface{}, bool)) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		switch token.Method.(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodECDSA, *jwt.SigningMethodRSAPSS:
+		default:
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+		kid, _ := token.Header["kid"].(string)
+		key, found := lookup(kid)
+		if !found {
+			return nil, fmt.Errorf("unknown key '%s'", kid)
+		}
+		return key, nil
+	}
+}
+
+func (v *jwtVerifier) authenticate(tokenString string) (*Client, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, AsymmetricKeyFunc(func(kid string) (interface{}, bool) {
+		key, found := v.keys[kid]
+		return key, found
+	}))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCredentials, err)
+	}
+	// parsing only checks the expiry of tokens that have one
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return nil, fmt.Errorf("%w: missing expiry", ErrInvalidCredentials)
+	}
+	if v.issuer != "" && !claims.VerifyIssuer(v.issuer, true) {
+		return nil, fmt.Errorf("%w: unexpected issuer", ErrInvalidCredentials)
+	}
+	if !claims.VerifyAudience(v.audience, true) {
+		return nil, fmt.Errorf("%w: unexpected audience", ErrInvalidCredentials)
+	}
+	subject, _ := claims["sub"].(string)
+	client, found := v.subjects[subject]
+	if !found {
+		return nil, fmt.Errorf("%w: unknown subject '%s'", ErrInvalidCredentials, subject)
+	}
+	return &client, nil
+}
@@ -0,0 +1,51 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+//go:generate mockgen -source=api.go -destination=gen_CircuitInspectorMock.go -package=circuitbreaker github.com/MarcGrol/forwardhttp/circuitbreaker Inspector
+
+type State string
+
+const (
+	StateClosed   State = "closed"    // requests are sent
+	StateOpen     State = "open"      // requests are refused until the circuit is probed again
+	StateHalfOpen State = "half-open" // a single probe is sent, its outcome closes or re-opens the circuit
+)
+
+// Circuit is the state of the circuit-breaker of a single host
+type Circuit struct {
+	Host                string
+	State               State
+	ConsecutiveFailures int
+	OpenedAt            time.Time
+	RetryAt             time.Time
+}
+
+// ErrCircuitOpen is wrapped by the error that is returned when a request is refused
+var ErrCircuitOpen = errors.New("Circuit open")
+
+// OpenError tells until when requests to a host are refused
+type OpenError struct {
+	Host    string
+	RetryAt time.Time
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("Circuit of host %s is open until %s", e.Host, e.RetryAt.Format(time.RFC3339))
+}
+
+func (e *OpenError) Unwrap() error {
+	return ErrCircuitOpen
+}
+
+// Inspector exposes the circuits of the hosts that were sent to
+type Inspector interface {
+	List(c context.Context) []Circuit
+	Get(c context.Context, host string) (Circuit, bool)
+	Reset(c context.Context, host string) bool
+}
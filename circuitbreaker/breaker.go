@@ -0,0 +1,224 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/config"
+	"github.com/MarcGrol/forwardhttp/destination"
+	"github.com/MarcGrol/forwardhttp/httpclient"
+	"github.com/MarcGrol/forwardhttp/ratelimit"
+)
+
+// probeDeferral is how long requests are refused while the probe of a half-open circuit is in flight
+const probeDeferral = 10 * time.Second
+
+type Config struct {
+	FailureThreshold int           // consecutive failures that open the circuit, zero disables the circuit-breaker
+	OpenDuration     time.Duration // how long the circuit stays open before it is probed
+}
+
+func DefaultConfig() Config {
+	return Config{
+		FailureThreshold: 5,
+		OpenDuration:     30 * time.Second,
+	}
+}
+
+type circuit struct {
+	state    State
+	failures int
+	openedAt time.Time
+	retryAt  time.Time
+}
+
+// breakers keeps a circuit per host in memory, so each instance of the service has its own view on the hosts
+type breakers struct {
+	sync.Mutex
+	next     httpclient.HTTPSender
+	config   Config
+	nowFunc  func() time.Time
+	circuits map[string]*circuit
+}
+
+func New(next httpclient.HTTPSender, config Config) *breakers {
+	return &breakers{
+		next:     next,
+		config:   config,
+		nowFunc:  time.Now,
+		circuits: map[string]*circuit{},
+	}
+}
+
+// NewFromSettings reads CIRCUIT_FAILURE_THRESHOLD (default 5) and CIRCUIT_OPEN_DURATION (default 30s)
+func NewFromSettings(settings config.Settings, next httpclient.HTTPSender) (*breakers, error) {
+	defaults := DefaultConfig()
+	threshold, err := settings.Int("CIRCUIT_FAILURE_THRESHOLD", defaults.FailureThreshold)
+	if err != nil {
+		return nil, err
+	}
+	if threshold < 0 {
+		return nil, fmt.Errorf("Invalid setting 'CIRCUIT_FAILURE_THRESHOLD': must not be negative")
+	}
+	openDuration, err := settings.Duration("CIRCUIT_OPEN_DURATION", defaults.OpenDuration)
+	if err != nil {
+		return nil, err
+	}
+	if openDuration <= 0 {
+		return nil, fmt.Errorf("Invalid setting 'CIRCUIT_OPEN_DURATION': must be positive")
+	}
+	return New(next, Config{FailureThreshold: threshold, OpenDuration: openDuration}), nil
+}
+
+func (b *breakers) Send(c context.Context, req httpclient.Request) (*httpclient.Response, error) {
+	if b.config.FailureThreshold <= 0 {
+		return b.next.Send(c, req)
+	}
+
+	host := hostOf(req.URL)
+	err := b.allow(host)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := b.next.Send(c, req)
+	if !reachedHost(err) {
+		// says nothing about the health of the host: neither closes nor opens the circuit
+		return resp, err
+	}
+	b.record(host, isFailure(resp, err))
+
+	return resp, err
+}
+
+func (b *breakers) allow(host string) error {
+	b.Lock()
+	defer b.Unlock()
+
+	now := b.nowFunc()
+	cb, found := b.circuits[host]
+	if !found {
+		return nil
+	}
+	switch cb.state {
+	case StateOpen:
+		if now.Before(cb.retryAt) {
+			return &OpenError{Host: host, RetryAt: cb.retryAt}
+		}
+		// let this request probe the host
+		cb.state = StateHalfOpen
+		cb.retryAt = now.Add(probeDeferral)
+		log.Printf("Circuit of host %s is half-open", host)
+		return nil
+	case StateHalfOpen:
+		if now.Before(cb.retryAt) {
+			return &OpenError{Host: host, RetryAt: cb.retryAt}
+		}
+		// the previous probe never reported back
+		cb.retryAt = now.Add(probeDeferral)
+		return nil
+	default:
+		return nil
+	}
+}
+
+func (b *breakers) record(host string, failed bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	cb, found := b.circuits[host]
+	if !failed {
+		if found && cb.state != StateClosed {
+			log.Printf("Circuit of host %s is closed", host)
+		}
+		delete(b.circuits, host)
+		return
+	}
+
+	if !found {
+		cb = &circuit{state: StateClosed}
+		b.circuits[host] = cb
+	}
+	cb.failures++
+	if cb.state == StateHalfOpen || cb.failures >= b.config.FailureThreshold {
+		now := b.nowFunc()
+		cb.state = StateOpen
+		cb.openedAt = now
+		cb.retryAt = now.Add(b.config.OpenDuration)
+		log.Printf("Circuit of host %s is open until %s, after %d consecutive failures", host, cb.retryAt.Format(time.RFC3339), cb.failures)
+	}
+}
+
+// reachedHost tells whether the request was sent at all, rather than refused on our side
+func reachedHost(err error) bool {
+	return !errors.Is(err, destination.ErrDenied) && !errors.Is(err, ratelimit.ErrLimitExceeded)
+}
+
+// isFailure tells whether the host is in trouble: client errors say nothing about the health of the host
+func isFailure(resp *httpclient.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return resp.Status >= http.StatusInternalServerError || resp.Status == http.StatusTooManyRequests
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return u.Host
+}
+
+func (b *breakers) List(c context.Context) []Circuit {
+	b.Lock()
+	defer b.Unlock()
+
+	circuits := []Circuit{}
+	for host, cb := range b.circuits {
+		circuits = append(circuits, cb.toCircuit(host))
+	}
+	sort.Slice(circuits, func(i, j int) bool {
+		return circuits[i].Host < circuits[j].Host
+	})
+	return circuits
+}
+
+// Get returns a closed circuit for hosts without recent failures
+func (b *breakers) Get(c context.Context, host string) (Circuit, bool) {
+	b.Lock()
+	defer b.Unlock()
+
+	cb, found := b.circuits[host]
+	if !found {
+		return Circuit{Host: host, State: StateClosed}, false
+	}
+	return cb.toCircuit(host), true
+}
+
+// Reset closes the circuit of a host
+func (b *breakers) Reset(c context.Context, host string) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	_, found := b.circuits[host]
+	delete(b.circuits, host)
+	return found
+}
+
+func (cb circuit) toCircuit(host string) Circuit {
+	return Circuit{
+		Host:                host,
+		State:               cb.state,
+		ConsecutiveFailures: cb.failures,
+		OpenedAt:            cb.openedAt,
+		RetryAt:             cb.retryAt,
+	}
+}
@@ -0,0 +1,182 @@
+package circuitbreaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/config"
+	"github.com/MarcGrol/forwardhttp/httpclient"
+	"github.com/MarcGrol/forwardhttp/ratelimit"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+var testTimestamp = time.Date(2021, time.November, 11, 10, 0, 0, 0, time.UTC)
+
+func TestCircuitLifecycle(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c := context.Background()
+	now := testTimestamp
+	sender := httpclient.NewMockHTTPSender(ctrl)
+	b := New(sender, Config{FailureThreshold: 2, OpenDuration: time.Minute})
+	b.nowFunc = func() time.Time { return now }
+	req := httpclient.Request{Method: "POST", URL: "https://home.nl/doit"}
+
+	// failures below the threshold keep the circuit closed
+	sender.EXPECT().Send(gomock.Any(), req).Return(&httpclient.Response{Status: 503}, nil)
+	_, err := b.Send(c, req)
+	assert.NoError(t, err)
+	circuit, found := b.Get(c, "home.nl")
+	assert.True(t, found)
+	assert.Equal(t, StateClosed, circuit.State)
+	assert.Equal(t, 1, circuit.ConsecutiveFailures)
+
+	// reaching the threshold opens the circuit
+	sender.EXPECT().Send(gomock.Any(), req).Return(nil, fmt.Errorf("network error"))
+	_, err = b.Send(c, req)
+	assert.Error(t, err)
+	circuit, _ = b.Get(c, "home.nl")
+	assert.Equal(t, StateOpen, circuit.State)
+	assert.Equal(t, testTimestamp.Add(time.Minute), circuit.RetryAt)
+
+	// open circuit refuses without sending
+	_, err = b.Send(c, req)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	var openErr *OpenError
+	assert.True(t, errors.As(err, &openErr))
+	assert.Equal(t, testTimestamp.Add(time.Minute), openErr.RetryAt)
+
+	// other hosts are not affected
+	other := httpclient.Request{Method: "POST", URL: "https://other.nl/doit"}
+	sender.EXPECT().Send(gomock.Any(), other).Return(&httpclient.Response{Status: 200}, nil)
+	_, err = b.Send(c, other)
+	assert.NoError(t, err)
+
+	// failing probe re-opens the circuit
+	now = now.Add(time.Minute)
+	sender.EXPECT().Send(gomock.Any(), req).Return(&httpclient.Response{Status: 500}, nil)
+	_, err = b.Send(c, req)
+	assert.NoError(t, err)
+	circuit, _ = b.Get(c, "home.nl")
+	assert.Equal(t, StateOpen, circuit.State)
+	assert.Equal(t, now.Add(time.Minute), circuit.RetryAt)
+
+	// successful probe closes the circuit
+	now = now.Add(time.Minute)
+	sender.EXPECT().Send(gomock.Any(), req).DoAndReturn(func(_ context.Context, _ httpclient.Request) (*httpclient.Response, error) {
+		circuit, _ := b.Get(c, "home.nl")
+		assert.Equal(t, StateHalfOpen, circuit.State)
+		// only a single probe is allowed
+		assert.Error(t, b.allow("home.nl"))
+		return &httpclient.Response{Status: 200}, nil
+	})
+	_, err = b.Send(c, req)
+	assert.NoError(t, err)
+	_, found = b.Get(c, "home.nl")
+	assert.False(t, found)
+	assert.Len(t, b.List(c), 0)
+}
+
+func TestClientErrorsDoNotOpenCircuit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c := context.Background()
+	sender := httpclient.NewMockHTTPSender(ctrl)
+	sender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(&httpclient.Response{Status: 404}, nil).Times(3)
+	b := New(sender, Config{FailureThreshold: 1, OpenDuration: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		_, err := b.Send(c, httpclient.Request{Method: "POST", URL: "https://home.nl/doit"})
+		assert.NoError(t, err)
+	}
+	assert.Len(t, b.List(c), 0)
+}
+
+func TestHeldBackRequestsDoNotCloseCircuit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c := context.Background()
+	now := testTimestamp
+	sender := httpclient.NewMockHTTPSender(ctrl)
+	b := New(sender, Config{FailureThreshold: 1, OpenDuration: time.Minute})
+	b.nowFunc = func() time.Time { return now }
+	req := httpclient.Request{Method: "POST", URL: "https://home.nl/doit"}
+
+	sender.EXPECT().Send(gomock.Any(), req).Return(&httpclient.Response{Status: 503}, nil)
+	_, err := b.Send(c, req)
+	assert.NoError(t, err)
+
+	// the probe is held back by the rate-limit, so it never reached the host
+	now = now.Add(time.Minute)
+	sender.EXPECT().Send(gomock.Any(), req).Return(nil, &ratelimit.LimitError{Host: "home.nl", Reason: "rate-limit", RetryAt: now.Add(time.Second)})
+	_, err = b.Send(c, req)
+	assert.True(t, errors.Is(err, ratelimit.ErrLimitExceeded))
+	circuit, found := b.Get(c, "home.nl")
+	assert.True(t, found)
+	assert.Equal(t, StateHalfOpen, circuit.State)
+}
+
+func TestResetCircuit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c := context.Background()
+	sender := httpclient.NewMockHTTPSender(ctrl)
+	sender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(nil, fmt.Errorf("network error"))
+	b := New(sender, Config{FailureThreshold: 1, OpenDuration: time.Minute})
+
+	b.Send(c, httpclient.Request{Method: "POST", URL: "https://home.nl/doit"})
+	circuits := b.List(c)
+	assert.Len(t, circuits, 1)
+	assert.Equal(t, "home.nl", circuits[0].Host)
+	assert.Equal(t, StateOpen, circuits[0].State)
+
+	assert.True(t, b.Reset(c, "home.nl"))
+	assert.False(t, b.Reset(c, "home.nl"))
+	assert.Len(t, b.List(c), 0)
+}
+
+func TestNewFromSettings(t *testing.T) {
+	testCases := []struct {
+		name          string
+		settings      map[string]string
+		expectedError string
+	}{
+		{
+			name:     "Defaults",
+			settings: map[string]string{},
+		},
+		{
+			name:     "Disabled",
+			settings: map[string]string{"CIRCUIT_FAILURE_THRESHOLD": "0"},
+		},
+		{
+			name:          "Negative threshold",
+			settings:      map[string]string{"CIRCUIT_FAILURE_THRESHOLD": "-1"},
+			expectedError: "Invalid setting 'CIRCUIT_FAILURE_THRESHOLD': must not be negative",
+		},
+		{
+			name:          "Zero open duration",
+			settings:      map[string]string{"CIRCUIT_OPEN_DURATION": "0s"},
+			expectedError: "Invalid setting 'CIRCUIT_OPEN_DURATION': must be positive",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewFromSettings(config.New(tc.settings), nil)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
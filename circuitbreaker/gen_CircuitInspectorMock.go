@@ -0,0 +1,77 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: api.go
+
+// Package circuitbreaker is a generated GoMock package.
+package circuitbreaker
+
+import (
+	context "context"
+	gomock "github.com/golang/mock/gomock"
+	reflect "reflect"
+)
+
+// MockInspector is a mock of Inspector interface
+type MockInspector struct {
+	ctrl     *gomock.Controller
+	recorder *MockInspectorMockRecorder
+}
+
+// MockInspectorMockRecorder is the mock recorder for MockInspector
+type MockInspectorMockRecorder struct {
+	mock *MockInspector
+}
+
+// NewMockInspector creates a new mock instance
+func NewMockInspector(ctrl *gomock.Controller) *MockInspector {
+	mock := &MockInspector{ctrl: ctrl}
+	mock.recorder = &MockInspectorMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockInspector) EXPECT() *MockInspectorMockRecorder {
+	return m.recorder
+}
+
+// Get mocks base method
+func (m *MockInspector) Get(c context.Context, host string) (Circuit, bool) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", c, host)
+	ret0, _ := ret[0].(Circuit)
+	ret1, _ := ret[1].(bool)
+	return ret0, ret1
+}
+
+// Get indicates an expected call of Get
+func (mr *MockInspectorMockRecorder) Get(c, host interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockInspector)(nil).Get), c, host)
+}
+
+// List mocks base method
+func (m *MockInspector) List(c context.Context) []Circuit {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", c)
+	ret0, _ := ret[0].([]Circuit)
+	return ret0
+}
+
+// List indicates an expected call of List
+func (mr *MockInspectorMockRecorder) List(c interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockInspector)(nil).List), c)
+}
+
+// Reset mocks base method
+func (m *MockInspector) Reset(c context.Context, host string) bool {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Reset", c, host)
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+// Reset indicates an expected call of Reset
+func (mr *MockInspectorMockRecorder) Reset(c, host interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Reset", reflect.TypeOf((*MockInspector)(nil).Reset), c, host)
+}
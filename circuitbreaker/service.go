@@ -0,0 +1,74 @@
+package circuitbreaker
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/MarcGrol/forwardhttp/auth"
+	"github.com/gorilla/mux"
+)
+
+const circuitsPath = "/_forwardhttp/circuits"
+
+type webService struct {
+	inspector     Inspector
+	authenticator auth.Authenticator
+}
+
+func NewWebService(inspector Inspector, authenticator auth.Authenticator) *webService {
+	return &webService{
+		inspector:     inspector,
+		authenticator: authenticator,
+	}
+}
+
+func (s *webService) RegisterEndpoint(router *mux.Router) *mux.Router {
+	subRouter := router.PathPrefix(circuitsPath).Subrouter()
+	subRouter.HandleFunc("", auth.Required(s.authenticator, s.list())).Methods("GET")
+	subRouter.HandleFunc("/{host}", auth.Required(s.authenticator, s.get())).Methods("GET")
+	subRouter.HandleFunc("/{host}", auth.Required(s.authenticator, s.reset())).Methods("DELETE")
+	return router
+}
+
+func (s *webService) list() auth.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ auth.Client) {
+		writeJSON(w, http.StatusOK, s.inspector.List(r.Context()))
+	}
+}
+
+func (s *webService) get() auth.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ auth.Client) {
+		circuit, _ := s.inspector.Get(r.Context(), mux.Vars(r)["host"])
+		writeJSON(w, http.StatusOK, circuit)
+	}
+}
+
+func (s *webService) reset() auth.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, _ auth.Client) {
+		host := mux.Vars(r)["host"]
+		if !s.inspector.Reset(r.Context(), host) {
+			reportError(w, http.StatusNotFound, fmt.Errorf("No circuit for host %s", host))
+			return
+		}
+		log.Printf("Circuit of host %s was reset", host)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, httpResponseStatus int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpResponseStatus)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		log.Printf("Error encoding response: %s", err)
+	}
+}
+
+func reportError(w http.ResponseWriter, httpResponseStatus int, err error) {
+	log.Print(err.Error())
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(httpResponseStatus)
+	fmt.Fprint(w, err.Error())
+}
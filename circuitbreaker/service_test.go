@@ -0,0 +1,124 @@
+package circuitbreaker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MarcGrol/forwardhttp/auth"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuits(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	testCases := []struct {
+		name                    string
+		inspector               Inspector
+		authenticator           auth.Authenticator
+		request                 *http.Request
+		expectedResponseStatus  int
+		expectedResponsePayload string
+	}{
+		{
+			name:                    "List circuits",
+			inspector:               inspectorList(ctrl, []Circuit{{Host: "home.nl", State: StateOpen, ConsecutiveFailures: 5, OpenedAt: testTimestamp, RetryAt: testTimestamp}}),
+			request:                 httptest.NewRequest("GET", "/_forwardhttp/circuits", nil),
+			expectedResponseStatus:  200,
+			expectedResponsePayload: `[{"Host":"home.nl","State":"open","ConsecutiveFailures":5,"OpenedAt":"2021-11-11T10:00:00Z","RetryAt":"2021-11-11T10:00:00Z"}]` + "\n",
+		},
+		{
+			name:                    "Get circuit",
+			inspector:               inspectorGet(ctrl, "home.nl", Circuit{Host: "home.nl", State: StateClosed}),
+			request:                 httptest.NewRequest("GET", "/_forwardhttp/circuits/home.nl", nil),
+			expectedResponseStatus:  200,
+			expectedResponsePayload: `{"Host":"home.nl","State":"closed","ConsecutiveFailures":0,"OpenedAt":"0001-01-01T00:00:00Z","RetryAt":"0001-01-01T00:00:00Z"}` + "\n",
+		},
+		{
+			name:                    "Reset circuit",
+			inspector:               inspectorReset(ctrl, "home.nl", true),
+			request:                 httptest.NewRequest("DELETE", "/_forwardhttp/circuits/home.nl", nil),
+			expectedResponseStatus:  204,
+			expectedResponsePayload: "",
+		},
+		{
+			name:                    "Reset circuit: not found",
+			inspector:               inspectorReset(ctrl, "home.nl", false),
+			request:                 httptest.NewRequest("DELETE", "/_forwardhttp/circuits/home.nl", nil),
+			expectedResponseStatus:  404,
+			expectedResponsePayload: "No circuit for host home.nl",
+		},
+		{
+			name:                    "Reset circuit: not authenticated",
+			authenticator:           authenticate(ctrl, nil, auth.ErrMissingCredentials),
+			request:                 httptest.NewRequest("DELETE", "/_forwardhttp/circuits/home.nl", nil),
+			expectedResponseStatus:  401,
+			expectedResponsePayload: "Missing credentials",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// setup
+			if tc.authenticator == nil {
+				tc.authenticator = auth.Anonymous()
+			}
+			service := NewWebService(tc.inspector, tc.authenticator)
+
+			// when
+			httpResp := httptest.NewRecorder()
+			service.RegisterEndpoint(mux.NewRouter()).ServeHTTP(httpResp, tc.request)
+
+			// then
+			assert.Equal(t, tc.expectedResponseStatus, httpResp.Code)
+			assert.Equal(t, tc.expectedResponsePayload, httpResp.Body.String())
+		})
+	}
+}
+
+func inspectorList(ctrlr *gomock.Controller, circuits []Circuit) Inspector {
+	inspectorMock := NewMockInspector(ctrlr)
+
+	inspectorMock.
+		EXPECT().
+		List(gomock.Any()).
+		Return(circuits)
+
+	return inspectorMock
+}
+
+func inspectorGet(ctrlr *gomock.Controller, host string, circuit Circuit) Inspector {
+	inspectorMock := NewMockInspector(ctrlr)
+
+	inspectorMock.
+		EXPECT().
+		Get(gomock.Any(), host).
+		Return(circuit, circuit.State != StateClosed)
+
+	return inspectorMock
+}
+
+func inspectorReset(ctrlr *gomock.Controller, host string, found bool) Inspector {
+	inspectorMock := NewMockInspector(ctrlr)
+
+	inspectorMock.
+		EXPECT().
+		Reset(gomock.Any(), host).
+		Return(found)
+
+	return inspectorMock
+}
+
+func authenticate(ctrlr *gomock.Controller, client *auth.Client, err error) auth.Authenticator {
+	authenticatorMock := auth.NewMockAuthenticator(ctrlr)
+
+	authenticatorMock.
+		EXPECT().
+		Authenticate(gomock.Any()).
+		Return(client, err)
+
+	return authenticatorMock
+}
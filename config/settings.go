@@ -0,0 +1,129 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigFileEnvVar names the environment variable that points to an optional config file
+const ConfigFileEnvVar = "CONFIG_FILE"
+
+// Settings provides configuration values: environment variables take precedence over values from the config file
+type Settings struct {
+	lookupEnv func(key string) (string, bool)
+	values    map[string]string
+}
+
+// Load reads settings from the environment and from the file pointed to by CONFIG_FILE (if any).
+// The config file contains KEY=VALUE lines, lines starting with '#' are ignored.
+func Load() (Settings, error) {
+	values := map[string]string{}
+	filename := os.Getenv(ConfigFileEnvVar)
+	if filename != "" {
+		var err error
+		values, err = readFile(filename)
+		if err != nil {
+			return Settings{}, fmt.Errorf("Error reading config file %s: %s", filename, err)
+		}
+	}
+	return Settings{
+		lookupEnv: os.LookupEnv,
+		values:    values,
+	}, nil
+}
+
+// New creates settings from the given values only, ignoring the environment
+func New(values map[string]string) Settings {
+	return Settings{
+		lookupEnv: func(key string) (string, bool) { return "", false },
+		values:    values,
+	}
+}
+
+func readFile(filename string) (map[string]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("Invalid line %d: expected KEY=VALUE", lineNumber)
+		}
+		values[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return values, scanner.Err()
+}
+
+func (s Settings) Get(key string) string {
+	if s.lookupEnv != nil {
+		value, found := s.lookupEnv(key)
+		if found && value != "" {
+			return value
+		}
+	}
+	return s.values[key]
+}
+
+func (s Settings) GetOrDefault(key, defaultValue string) string {
+	value := s.Get(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+func (s Settings) Mandatory(key string) (string, error) {
+	value := s.Get(key)
+	if value == "" {
+		return "", fmt.Errorf("Missing mandatory setting '%s'", key)
+	}
+	return value, nil
+}
+
+func (s Settings) Int(key string, defaultValue int) (int, error) {
+	value := s.Get(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid setting '%s': '%s' is not a number", key, value)
+	}
+	return i, nil
+}
+
+func (s Settings) Duration(key string, defaultValue time.Duration) (time.Duration, error) {
+	value := s.Get(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid setting '%s': '%s' is not a duration", key, value)
+	}
+	return d, nil
+}
+
+func (s Settings) Bool(key string, defaultValue bool) (bool, error) {
+	value := s.Get(key)
+	if value == "" {
+		return defaultValue, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("Invalid setting '%s': '%s' is not a boolean", key, value)
+	}
+	return b, nil
+}
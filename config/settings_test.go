@@ -0,0 +1,62 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "forwardhttp.conf")
+	err := os.WriteFile(filename, []byte(`
+# comment
+QUEUE_BACKEND = file
+QUEUE_FILE=/tmp/queue.log
+QUEUE_MAX_BACKOFF=10s
+`), 0600)
+	assert.NoError(t, err)
+
+	os.Setenv(ConfigFileEnvVar, filename)
+	defer os.Unsetenv(ConfigFileEnvVar)
+	os.Setenv("QUEUE_FILE", "/var/lib/queue.log")
+	defer os.Unsetenv("QUEUE_FILE")
+
+	settings, err := Load()
+	assert.NoError(t, err)
+
+	assert.Equal(t, "file", settings.Get("QUEUE_BACKEND"))
+	assert.Equal(t, "/var/lib/queue.log", settings.Get("QUEUE_FILE")) // env wins
+	assert.Equal(t, "memory", settings.GetOrDefault("STORE_BACKEND", "memory"))
+
+	maxBackoff, err := settings.Duration("QUEUE_MAX_BACKOFF", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, maxBackoff)
+
+	_, err = settings.Mandatory("POSTGRES_DSN")
+	assert.EqualError(t, err, "Missing mandatory setting 'POSTGRES_DSN'")
+}
+
+func TestLoadInvalidFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "forwardhttp.conf")
+	err := os.WriteFile(filename, []byte("QUEUE_BACKEND\n"), 0600)
+	assert.NoError(t, err)
+
+	os.Setenv(ConfigFileEnvVar, filename)
+	defer os.Unsetenv(ConfigFileEnvVar)
+
+	_, err = Load()
+	assert.Error(t, err)
+}
+
+func TestInvalidValues(t *testing.T) {
+	settings := New(map[string]string{"QUEUE_MAX_ATTEMPTS": "ten", "QUEUE_MIN_BACKOFF": "1"})
+
+	_, err := settings.Int("QUEUE_MAX_ATTEMPTS", 10)
+	assert.EqualError(t, err, "Invalid setting 'QUEUE_MAX_ATTEMPTS': 'ten' is not a number")
+
+	_, err = settings.Duration("QUEUE_MIN_BACKOFF", time.Second)
+	assert.EqualError(t, err, "Invalid setting 'QUEUE_MIN_BACKOFF': '1' is not a duration")
+}
@@ -0,0 +1,15 @@
+package deadletter
+
+import (
+	"github.com/MarcGrol/forwardhttp/auth"
+	"github.com/MarcGrol/forwardhttp/forwarder"
+	"github.com/MarcGrol/forwardhttp/lastdelivery"
+	"github.com/MarcGrol/forwardhttp/uniqueid"
+)
+
+type webService struct {
+	deadLetters   lastdelivery.DeadLetterStorer
+	forwarder     forwarder.Forwarder
+	uidGenerator  uniqueid.Generator
+	authenticator auth.Authenticator
+}
@@ -0,0 +1,361 @@
+package deadletter
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/auth"
+	"github.com/MarcGrol/forwardhttp/forwarder"
+	"github.com/MarcGrol/forwardhttp/lastdelivery"
+	"github.com/MarcGrol/forwardhttp/uniqueid"
+	"github.com/gorilla/mux"
+)
+
+const deadLettersPath = "/_forwardhttp/deadletters"
+
+func NewWebService(deadLetters lastdelivery.DeadLetterStorer, forwarder forwarder.Forwarder, uidGenerator uniqueid.Generator, authenticator auth.Authenticator) *webService {
+	s := &webService{
+		deadLetters:   deadLetters,
+		forwarder:     forwarder,
+		uidGenerator:  uidGenerator,
+		authenticator: authenticator,
+	}
+	return s
+}
+
+func (s *webService) RegisterEndpoint(router *mux.Router) *mux.Router {
+	subRouter := router.PathPrefix(deadLettersPath).Subrouter()
+	subRouter.HandleFunc("", auth.Required(s.authenticator, s.list())).Methods("GET")
+	subRouter.HandleFunc("", auth.Required(s.authenticator, s.deleteAll())).Methods("DELETE")
+	subRouter.HandleFunc("/replay", auth.Required(s.authenticator, s.replayAll())).Methods("POST")
+	subRouter.HandleFunc("/{taskUid}", auth.Required(s.authenticator, s.get())).Methods("GET")
+	subRouter.HandleFunc("/{taskUid}", auth.Required(s.authenticator, s.delete())).Methods("DELETE")
+	subRouter.HandleFunc("/{taskUid}/replay", auth.Required(s.authenticator, s.replay())).Methods("POST")
+	return router
+}
+
+// replayResult tells under which task-uid a dead letter was enqueued again
+type replayResult struct {
+	TaskUID         string
+	ReplayedTaskUID string
+}
+
+type bulkResult struct {
+	Replayed []replayResult `json:",omitempty"`
+	Deleted  []string       `json:",omitempty"`
+}
+
+func (s *webService) list() auth.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, client auth.Client) {
+		c := r.Context()
+		filter, err := parseFilter(r)
+		if err != nil {
+			reportError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		letters, err := s.deadLetters.List(c, filter)
+		if err != nil {
+			reportError(w, http.StatusInternalServerError, fmt.Errorf("Error listing dead letters: %s", err))
+			return
+		}
+
+		writeJSON(w, http.StatusOK, visible(client, letters))
+	}
+}
+
+func (s *webService) get() auth.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, client auth.Client) {
+		c := r.Context()
+		taskUID := mux.Vars(r)["taskUid"]
+
+		letter, found, err := s.deadLetters.Get(c, taskUID)
+		if err != nil {
+			reportError(w, http.StatusInternalServerError, fmt.Errorf("Error fetching dead letter %s: %s", taskUID, err))
+			return
+		}
+		if !found {
+			reportError(w, http.StatusNotFound, fmt.Errorf("Dead letter %s not found", taskUID))
+			return
+		}
+		err = authorize(client, *letter, "")
+		if err != nil {
+			reportError(w, http.StatusForbidden, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, letter)
+	}
+}
+
+func (s *webService) delete() auth.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, client auth.Client) {
+		c := r.Context()
+		taskUID := mux.Vars(r)["taskUid"]
+
+		letter, found, err := s.deadLetters.Get(c, taskUID)
+		if err != nil {
+			reportError(w, http.StatusInternalServerError, fmt.Errorf("Error fetching dead letter %s: %s", taskUID, err))
+			return
+		}
+		if !found {
+			reportError(w, http.StatusNotFound, fmt.Errorf("Dead letter %s not found", taskUID))
+			return
+		}
+		err = authorize(client, *letter, "")
+		if err != nil {
+			reportError(w, http.StatusForbidden, err)
+			return
+		}
+
+		err = s.deadLetters.Delete(c, taskUID)
+		if err != nil {
+			reportError(w, http.StatusInternalServerError, fmt.Errorf("Error deleting dead letter %s: %s", taskUID, err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *webService) replay() auth.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, client auth.Client) {
+		c := r.Context()
+		taskUID := mux.Vars(r)["taskUid"]
+
+		letter, found, err := s.deadLetters.Get(c, taskUID)
+		if err != nil {
+			reportError(w, http.StatusInternalServerError, fmt.Errorf("Error fetching dead letter %s: %s", taskUID, err))
+			return
+		}
+		if !found {
+			reportError(w, http.StatusNotFound, fmt.Errorf("Dead letter %s not found", taskUID))
+			return
+		}
+		hostToForwardTo := r.URL.Query().Get("HostToForwardTo")
+		err = authorize(client, *letter, hostToForwardTo)
+		if err != nil {
+			reportError(w, http.StatusForbidden, err)
+			return
+		}
+
+		replayed, err := s.replayOne(c, *letter, hostToForwardTo)
+		if err != nil {
+			reportError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		writeJSON(w, http.StatusAccepted, replayed)
+	}
+}
+
+func (s *webService) replayAll() auth.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, client auth.Client) {
+		c := r.Context()
+		filter, err := parseFilter(r)
+		if err != nil {
+			reportError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		letters, err := s.deadLetters.List(c, filter)
+		if err != nil {
+			reportError(w, http.StatusInternalServerError, fmt.Errorf("Error listing dead letters: %s", err))
+			return
+		}
+
+		// nothing is replayed when a single letter of the client may not be
+		letters = owned(client, letters)
+		hostToForwardTo := r.URL.Query().Get("HostToForwardTo")
+		for _, letter := range letters {
+			err := authorize(client, letter, hostToForwardTo)
+			if err != nil {
+				reportError(w, http.StatusForbidden, err)
+				return
+			}
+		}
+
+		result := bulkResult{Replayed: []replayResult{}}
+		for _, letter := range letters {
+			replayed, err := s.replayOne(c, letter, hostToForwardTo)
+			if err != nil {
+				reportError(w, http.StatusInternalServerError, err)
+				return
+			}
+			result.Replayed = append(result.Replayed, replayed)
+		}
+
+		writeJSON(w, http.StatusAccepted, result)
+	}
+}
+
+func (s *webService) deleteAll() auth.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, client auth.Client) {
+		c := r.Context()
+		filter, err := parseFilter(r)
+		if err != nil {
+			reportError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		letters, err := s.deadLetters.List(c, filter)
+		if err != nil {
+			reportError(w, http.StatusInternalServerError, fmt.Errorf("Error listing dead letters: %s", err))
+			return
+		}
+
+		result := bulkResult{Deleted: []string{}}
+		for _, letter := range visible(client, letters) {
+			err := s.deadLetters.Delete(c, letter.TaskUID)
+			if err != nil {
+				reportError(w, http.StatusInternalServerError, fmt.Errorf("Error deleting dead letter %s: %s", letter.TaskUID, err))
+				return
+			}
+			result.Deleted = append(result.Deleted, letter.TaskUID)
+		}
+
+		writeJSON(w, http.StatusOK, result)
+	}
+}
+
+// authorize only gives a client access to its own dead letters, and checks the destination of a replay
+// like the entrypoint checks the destination of a submission
+func authorize(client auth.Client, letter lastdelivery.DeadLetter, hostToForwardTo string) error {
+	if letter.Request.Owner != client.Name {
+		return fmt.Errorf("Client %s may not access dead letter %s", client.Name, letter.TaskUID)
+	}
+	if hostToForwardTo == "" && letter.Request.Route != "" {
+		if !client.MayUseRoute(letter.Request.Route) {
+			return fmt.Errorf("Client %s may not use route '%s'", client.Name, letter.Request.Route)
+		}
+		return nil
+	}
+	targetURL := letter.Request.URL
+	if hostToForwardTo != "" {
+		var err error
+		targetURL, err = replaceHost(targetURL, hostToForwardTo)
+		if err != nil {
+			return fmt.Errorf("Error replaying dead letter %s: %s", letter.TaskUID, err)
+		}
+	}
+	u, err := url.Parse(targetURL)
+	if err != nil || !client.MayUseHost(u.Hostname()) {
+		return fmt.Errorf("Client %s may not forward to '%s'", client.Name, targetURL)
+	}
+	return nil
+}
+
+// visible keeps the dead letters the client may access, at their original destination
+func visible(client auth.Client, letters []lastdelivery.DeadLetter) []lastdelivery.DeadLetter {
+	result := []lastdelivery.DeadLetter{}
+	for _, letter := range letters {
+		if authorize(client, letter, "") == nil {
+			result = append(result, letter)
+		}
+	}
+	return result
+}
+
+func owned(client auth.Client, letters []lastdelivery.DeadLetter) []lastdelivery.DeadLetter {
+	result := []lastdelivery.DeadLetter{}
+	for _, letter := range letters {
+		if letter.Request.Owner == client.Name {
+			result = append(result, letter)
+		}
+	}
+	return result
+}
+
+// replayOne enqueues the request under a new task-uid, because the queue may still remember the original one
+func (s *webService) replayOne(c context.Context, letter lastdelivery.DeadLetter, hostToForwardTo string) (replayResult, error) {
+	req := letter.Request
+	req.TaskUID = s.uidGenerator.Generate()
+	// a replay starts afresh: with all attempts, and behind the current end of its ordering-key (if any)
+	req.PrevAttempts = 0
+	req.Predecessor = ""
+	if hostToForwardTo != "" {
+		targetURL, err := replaceHost(req.URL, hostToForwardTo)
+		if err != nil {
+			return replayResult{}, fmt.Errorf("Error replaying dead letter %s: %s", letter.TaskUID, err)
+		}
+		req.URL = targetURL
+	}
+
+	err := s.forwarder.ForwardAsync(c, req)
+	if err != nil {
+		return replayResult{}, fmt.Errorf("Error replaying dead letter %s: %s", letter.TaskUID, err)
+	}
+
+	err = s.deadLetters.Delete(c, letter.TaskUID)
+	if err != nil {
+		return replayResult{}, fmt.Errorf("Error deleting replayed dead letter %s: %s", letter.TaskUID, err)
+	}
+
+	log.Printf("Replayed dead letter %s as %s", letter.TaskUID, req.TaskUID)
+
+	return replayResult{TaskUID: letter.TaskUID, ReplayedTaskUID: req.TaskUID}, nil
+}
+
+func replaceHost(targetURL, hostToForwardTo string) (string, error) {
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing url %s: %s", targetURL, err)
+	}
+	if strings.HasPrefix(hostToForwardTo, "http://") {
+		u.Scheme = "http"
+	} else {
+		u.Scheme = "https"
+	}
+	u.Host = strings.TrimPrefix(strings.TrimPrefix(hostToForwardTo, "http://"), "https://")
+	return u.String(), nil
+}
+
+func parseFilter(r *http.Request) (lastdelivery.DeadLetterFilter, error) {
+	filter := lastdelivery.DeadLetterFilter{
+		Host: r.URL.Query().Get("host"),
+	}
+	var err error
+	filter.From, err = parseTime(r, "from")
+	if err != nil {
+		return filter, err
+	}
+	filter.Until, err = parseTime(r, "until")
+	if err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+func parseTime(r *http.Request, fieldName string) (time.Time, error) {
+	value := r.URL.Query().Get(fieldName)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid %s '%s': expected RFC3339 timestamp", fieldName, value)
+	}
+	return t, nil
+}
+
+func writeJSON(w http.ResponseWriter, httpResponseStatus int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpResponseStatus)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		log.Printf("Error encoding response: %s", err)
+	}
+}
+
+func reportError(w http.ResponseWriter, httpResponseStatus int, err error) {
+	log.Print(err.Error())
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(httpResponseStatus)
+	fmt.Fprint(w, err.Error())
+}
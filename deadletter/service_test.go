@@ -0,0 +1,292 @@
+package deadletter
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/auth"
+	"github.com/MarcGrol/forwardhttp/forwarder"
+	"github.com/MarcGrol/forwardhttp/httpclient"
+	"github.com/MarcGrol/forwardhttp/lastdelivery"
+	"github.com/MarcGrol/forwardhttp/uniqueid"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+var testTimestamp = time.Date(2021, time.November, 11, 10, 0, 0, 0, time.UTC)
+
+func TestDeadLetters(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	letter := lastdelivery.DeadLetter{
+		TaskUID:   "abc",
+		Host:      "home.nl",
+		Request:   httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit?a=b", Owner: "anonymous"},
+		Error:     "network error",
+		Timestamp: testTimestamp,
+	}
+
+	// submitted by another client than the anonymous one
+	letterOfWebshop := letter
+	letterOfWebshop.TaskUID = "def"
+	letterOfWebshop.Request.TaskUID = "def"
+	letterOfWebshop.Request.Owner = "webshop"
+
+	// made attempts before its Retry-After, and waited for a predecessor that has completed since
+	rescheduled := letter
+	rescheduled.Request.PrevAttempts = 9
+	rescheduled.Request.OrderingKey = "order-1"
+	rescheduled.Request.Predecessor = "aaa"
+
+	testCases := []struct {
+		name                    string
+		deadLetters             lastdelivery.DeadLetterStorer
+		forwarder               forwarder.Forwarder
+		uidGenerator            uniqueid.Generator
+		authenticator           auth.Authenticator
+		request                 *http.Request
+		expectedResponseStatus  int
+		expectedResponsePayload string
+	}{
+		{
+			name:                    "List",
+			deadLetters:             deadLettersList(ctrl, lastdelivery.DeadLetterFilter{Host: "home.nl", From: testTimestamp}, []lastdelivery.DeadLetter{letter, letterOfWebshop}),
+			request:                 httpRequest(t, "GET", "/_forwardhttp/deadletters?host=home.nl&from=2021-11-11T10:00:00Z"),
+			expectedResponseStatus:  200,
+			expectedResponsePayload: `[{"TaskUID":"abc","Host":"home.nl","Request":{"TaskUID":"abc","Method":"POST","URL":"https://home.nl/doit?a=b","Headers":null,"Body":null,"Route":"","MaxAttempts":0,"Timeout":0,"DeliverAt":"0001-01-01T00:00:00Z","Owner":"anonymous"},"Error":"network error","Timestamp":"2021-11-11T10:00:00Z"}]` + "\n",
+		},
+		{
+			name:                    "List: invalid time",
+			request:                 httpRequest(t, "GET", "/_forwardhttp/deadletters?until=yesterday"),
+			expectedResponseStatus:  400,
+			expectedResponsePayload: "Invalid until 'yesterday': expected RFC3339 timestamp",
+		},
+		{
+			name:                    "Get: not found",
+			deadLetters:             deadLettersGet(ctrl, "abc", nil),
+			request:                 httpRequest(t, "GET", "/_forwardhttp/deadletters/abc"),
+			expectedResponseStatus:  404,
+			expectedResponsePayload: "Dead letter abc not found",
+		},
+		{
+			name:                    "Get: of another client",
+			deadLetters:             deadLettersGet(ctrl, "def", &letterOfWebshop),
+			request:                 httpRequest(t, "GET", "/_forwardhttp/deadletters/def"),
+			expectedResponseStatus:  403,
+			expectedResponsePayload: "Client anonymous may not access dead letter def",
+		},
+		{
+			name:                    "Delete",
+			deadLetters:             deadLettersDelete(ctrl, deadLettersGet(ctrl, "abc", &letter), "abc"),
+			request:                 httpRequest(t, "DELETE", "/_forwardhttp/deadletters/abc"),
+			expectedResponseStatus:  204,
+			expectedResponsePayload: "",
+		},
+		{
+			name:                    "Delete: of another client",
+			deadLetters:             deadLettersGet(ctrl, "def", &letterOfWebshop),
+			request:                 httpRequest(t, "DELETE", "/_forwardhttp/deadletters/def"),
+			expectedResponseStatus:  403,
+			expectedResponsePayload: "Client anonymous may not access dead letter def",
+		},
+		{
+			name:                    "Replay",
+			deadLetters:             deadLettersDelete(ctrl, deadLettersGet(ctrl, "abc", &letter), "abc"),
+			forwarder:               forwarderExpecting(ctrl, "xyz", "https://home.nl/doit?a=b", nil),
+			uidGenerator:            generateUID(ctrl, "xyz"),
+			request:                 httpRequest(t, "POST", "/_forwardhttp/deadletters/abc/replay"),
+			expectedResponseStatus:  202,
+			expectedResponsePayload: `{"TaskUID":"abc","ReplayedTaskUID":"xyz"}` + "\n",
+		},
+		{
+			name:                    "Replay: starts afresh",
+			deadLetters:             deadLettersDelete(ctrl, deadLettersGet(ctrl, "abc", &rescheduled), "abc"),
+			forwarder:               forwarderExpectingRequest(ctrl, httpclient.Request{TaskUID: "xyz", Method: "POST", URL: "https://home.nl/doit?a=b", OrderingKey: "order-1", Owner: "anonymous"}),
+			uidGenerator:            generateUID(ctrl, "xyz"),
+			request:                 httpRequest(t, "POST", "/_forwardhttp/deadletters/abc/replay"),
+			expectedResponseStatus:  202,
+			expectedResponsePayload: `{"TaskUID":"abc","ReplayedTaskUID":"xyz"}` + "\n",
+		},
+		{
+			name:                    "Replay: to new host",
+			deadLetters:             deadLettersDelete(ctrl, deadLettersGet(ctrl, "abc", &letter), "abc"),
+			forwarder:               forwarderExpecting(ctrl, "xyz", "http://localhost:8081/doit?a=b", nil),
+			uidGenerator:            generateUID(ctrl, "xyz"),
+			request:                 httpRequest(t, "POST", "/_forwardhttp/deadletters/abc/replay?HostToForwardTo=http://localhost:8081"),
+			expectedResponseStatus:  202,
+			expectedResponsePayload: `{"TaskUID":"abc","ReplayedTaskUID":"xyz"}` + "\n",
+		},
+		{
+			name:                    "Replay: to host not allowed",
+			deadLetters:             deadLettersGet(ctrl, "def", &letterOfWebshop),
+			authenticator:           authenticate(ctrl, &auth.Client{Name: "webshop", Hosts: []string{"home.nl"}}, nil),
+			request:                 httpRequest(t, "POST", "/_forwardhttp/deadletters/def/replay?HostToForwardTo=http://localhost:8081"),
+			expectedResponseStatus:  403,
+			expectedResponsePayload: "Client webshop may not forward to 'http://localhost:8081/doit?a=b'",
+		},
+		{
+			name:                    "Replay: of another client",
+			deadLetters:             deadLettersGet(ctrl, "def", &letterOfWebshop),
+			request:                 httpRequest(t, "POST", "/_forwardhttp/deadletters/def/replay"),
+			expectedResponseStatus:  403,
+			expectedResponsePayload: "Client anonymous may not access dead letter def",
+		},
+		{
+			name:                    "Replay: not authenticated",
+			authenticator:           authenticate(ctrl, nil, auth.ErrMissingCredentials),
+			request:                 httpRequest(t, "POST", "/_forwardhttp/deadletters/abc/replay"),
+			expectedResponseStatus:  401,
+			expectedResponsePayload: "Missing credentials",
+		},
+		{
+			name:                    "Replay: enqueue error keeps dead letter",
+			deadLetters:             deadLettersGet(ctrl, "abc", &letter),
+			forwarder:               forwarderExpecting(ctrl, "xyz", "https://home.nl/doit?a=b", fmt.Errorf("queue error")),
+			uidGenerator:            generateUID(ctrl, "xyz"),
+			request:                 httpRequest(t, "POST", "/_forwardhttp/deadletters/abc/replay"),
+			expectedResponseStatus:  500,
+			expectedResponsePayload: "Error replaying dead letter abc: queue error",
+		},
+		{
+			name:                    "Replay all by host",
+			deadLetters:             deadLettersDelete(ctrl, deadLettersList(ctrl, lastdelivery.DeadLetterFilter{Host: "home.nl"}, []lastdelivery.DeadLetter{letter, letterOfWebshop}), "abc"),
+			forwarder:               forwarderExpecting(ctrl, "xyz", "https://home.nl/doit?a=b", nil),
+			uidGenerator:            generateUID(ctrl, "xyz"),
+			request:                 httpRequest(t, "POST", "/_forwardhttp/deadletters/replay?host=home.nl"),
+			expectedResponseStatus:  202,
+			expectedResponsePayload: `{"Replayed":[{"TaskUID":"abc","ReplayedTaskUID":"xyz"}]}` + "\n",
+		},
+		{
+			name:                    "Replay all: route not allowed",
+			deadLetters:             deadLettersList(ctrl, lastdelivery.DeadLetterFilter{}, []lastdelivery.DeadLetter{letterOfWebshop, {TaskUID: "ghi", Request: httpclient.Request{TaskUID: "ghi", URL: "https://billing.example.com/doit", Route: "billing", Owner: "webshop"}}}),
+			authenticator:           authenticate(ctrl, &auth.Client{Name: "webshop", Hosts: []string{"home.nl"}}, nil),
+			request:                 httpRequest(t, "POST", "/_forwardhttp/deadletters/replay"),
+			expectedResponseStatus:  403,
+			expectedResponsePayload: "Client webshop may not use route 'billing'",
+		},
+		{
+			name:                    "Delete all in time range",
+			deadLetters:             deadLettersDelete(ctrl, deadLettersList(ctrl, lastdelivery.DeadLetterFilter{Until: testTimestamp}, []lastdelivery.DeadLetter{letter, letterOfWebshop}), "abc"),
+			request:                 httpRequest(t, "DELETE", "/_forwardhttp/deadletters?until=2021-11-11T10:00:00Z"),
+			expectedResponseStatus:  200,
+			expectedResponsePayload: `{"Deleted":["abc"]}` + "\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// setup
+			if tc.authenticator == nil {
+				tc.authenticator = auth.Anonymous()
+			}
+			webservice := NewWebService(tc.deadLetters, tc.forwarder, tc.uidGenerator, tc.authenticator)
+
+			// when
+			httpResp := httptest.NewRecorder()
+			webservice.RegisterEndpoint(mux.NewRouter()).ServeHTTP(httpResp, tc.request)
+
+			// then
+			assert.Equal(t, tc.expectedResponseStatus, httpResp.Code)
+			assert.Equal(t, tc.expectedResponsePayload, httpResp.Body.String())
+		})
+	}
+}
+
+func httpRequest(t *testing.T, method, url string) *http.Request {
+	httpReq, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatalf("Error creating http-request: %s", err)
+	}
+	httpReq.RequestURI = url
+	return httpReq
+}
+
+func deadLettersMock(ctrlr *gomock.Controller) *lastdelivery.MockDeadLetterStorer {
+	return lastdelivery.NewMockDeadLetterStorer(ctrlr)
+}
+
+func deadLettersList(ctrlr *gomock.Controller, filter lastdelivery.DeadLetterFilter, letters []lastdelivery.DeadLetter) *lastdelivery.MockDeadLetterStorer {
+	deadLettersMock := lastdelivery.NewMockDeadLetterStorer(ctrlr)
+
+	deadLettersMock.
+		EXPECT().
+		List(gomock.Any(), filter).
+		Return(letters, nil)
+
+	return deadLettersMock
+}
+
+func deadLettersGet(ctrlr *gomock.Controller, taskUID string, letter *lastdelivery.DeadLetter) *lastdelivery.MockDeadLetterStorer {
+	deadLettersMock := lastdelivery.NewMockDeadLetterStorer(ctrlr)
+
+	deadLettersMock.
+		EXPECT().
+		Get(gomock.Any(), taskUID).
+		Return(letter, letter != nil, nil)
+
+	return deadLettersMock
+}
+
+func deadLettersDelete(ctrlr *gomock.Controller, deadLettersMock *lastdelivery.MockDeadLetterStorer, taskUID string) *lastdelivery.MockDeadLetterStorer {
+	deadLettersMock.
+		EXPECT().
+		Delete(gomock.Any(), taskUID).
+		Return(nil)
+
+	return deadLettersMock
+}
+
+func forwarderExpecting(ctrlr *gomock.Controller, expectedTaskUID, expectedURL string, err error) forwarder.Forwarder {
+	forwarderMock := forwarder.NewMockForwarder(ctrlr)
+
+	forwarderMock.
+		EXPECT().
+		ForwardAsync(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req httpclient.Request) error {
+			if req.TaskUID != expectedTaskUID || req.URL != expectedURL {
+				return fmt.Errorf("Unexpected request %s", req.URL)
+			}
+			return err
+		})
+
+	return forwarderMock
+}
+
+func forwarderExpectingRequest(ctrlr *gomock.Controller, expected httpclient.Request) forwarder.Forwarder {
+	forwarderMock := forwarder.NewMockForwarder(ctrlr)
+
+	forwarderMock.
+		EXPECT().
+		ForwardAsync(gomock.Any(), expected).
+		Return(nil)
+
+	return forwarderMock
+}
+
+func generateUID(ctrlr *gomock.Controller, uid string) uniqueid.Generator {
+	generator := uniqueid.NewMockGenerator(ctrlr)
+
+	generator.
+		EXPECT().
+		Generate().
+		Return(uid)
+
+	return generator
+}
+
+func authenticate(ctrlr *gomock.Controller, client *auth.Client, err error) auth.Authenticator {
+	authenticatorMock := auth.NewMockAuthenticator(ctrlr)
+
+	authenticatorMock.
+		EXPECT().
+		Authenticate(gomock.Any()).
+		Return(client, err)
+
+	return authenticatorMock
+}
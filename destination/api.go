@@ -0,0 +1,41 @@
+package destination
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//go:generate mockgen -source=api.go -destination=gen_DestinationCheckerMock.go -package=destination github.com/MarcGrol/forwardhttp/destination Checker
+
+var ErrDenied = errors.New("Destination not allowed")
+
+// DeniedError tells why a request may not be sent to its destination
+type DeniedError struct {
+	Destination string
+	Reason      string
+}
+
+func (e *DeniedError) Error() string {
+	return fmt.Sprintf("%s: %s %s", ErrDenied, e.Destination, e.Reason)
+}
+
+func (e *DeniedError) Unwrap() error {
+	return ErrDenied
+}
+
+type Checker interface {
+	// Check returns a DeniedError when the url may not be requested
+	Check(c context.Context, rawURL string) error
+}
+
+// MatchesHost tells whether a host matches a pattern like "example.com", "*.example.com" (subdomains only) or "*"
+func MatchesHost(pattern, host string) bool {
+	pattern = strings.ToLower(pattern)
+	host = strings.ToLower(host)
+	if pattern == "*" || pattern == host {
+		return true
+	}
+	return strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:])
+}
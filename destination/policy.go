@@ -0,0 +1,164 @@
+package destination
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/MarcGrol/forwardhttp/config"
+)
+
+// Policy decides which destinations requests may be sent to, to prevent the forwarder from reaching internal services
+type Policy struct {
+	AllowHosts   []string // patterns like "*.example.com"; empty allows all hosts that are not denied
+	DenyHosts    []string // take precedence over AllowHosts
+	AllowPorts   []int    // empty allows all ports
+	AllowPrivate bool     // allows loopback, link-local, private and other non-public addresses
+}
+
+// carrierGradeNAT is shared address space (RFC 6598), that is not covered by net.IP.IsPrivate
+var carrierGradeNAT = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// NewFromSettings reads DESTINATION_ALLOW_HOSTS and DESTINATION_DENY_HOSTS (comma-separated, optional),
+// DESTINATION_ALLOW_PORTS (default "80,443", "*" allows all) and DESTINATION_ALLOW_PRIVATE (default false)
+func NewFromSettings(settings config.Settings) (*Policy, error) {
+	policy := &Policy{
+		AllowHosts: splitList(settings.Get("DESTINATION_ALLOW_HOSTS")),
+		DenyHosts:  splitList(settings.Get("DESTINATION_DENY_HOSTS")),
+	}
+	ports := settings.GetOrDefault("DESTINATION_ALLOW_PORTS", "80,443")
+	if ports != "*" {
+		for _, value := range splitList(ports) {
+			port, err := strconv.Atoi(value)
+			if err != nil || port <= 0 || port > 65535 {
+				return nil, fmt.Errorf("Invalid setting 'DESTINATION_ALLOW_PORTS': '%s' is not a port", value)
+			}
+			policy.AllowPorts = append(policy.AllowPorts, port)
+		}
+	}
+	var err error
+	policy.AllowPrivate, err = settings.Bool("DESTINATION_ALLOW_PRIVATE", false)
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func splitList(value string) []string {
+	values := []string{}
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// Check validates the url and the addresses its host currently resolves to.
+// The addresses are checked again when connecting, because dns can change in between.
+func (p *Policy) Check(c context.Context, rawURL string) error {
+	err := p.CheckURL(rawURL)
+	if err != nil {
+		return err
+	}
+	u, _ := url.Parse(rawURL)
+	if net.ParseIP(u.Hostname()) != nil || p.AllowPrivate {
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(c, u.Hostname())
+	if err != nil {
+		// the host may become resolvable later: connecting will tell
+		return nil
+	}
+	for _, addr := range addrs {
+		if p.isDeniedIP(addr.IP) {
+			return &DeniedError{Destination: rawURL, Reason: fmt.Sprintf("resolves to non-public address %s", addr.IP)}
+		}
+	}
+	return nil
+}
+
+// CheckURL validates scheme, host and port, without resolving the host
+func (p *Policy) CheckURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Hostname() == "" {
+		return &DeniedError{Destination: rawURL, Reason: "is not a valid url"}
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return &DeniedError{Destination: rawURL, Reason: fmt.Sprintf("has scheme '%s'", u.Scheme)}
+	}
+	host := strings.TrimSuffix(u.Hostname(), ".")
+	for _, pattern := range p.DenyHosts {
+		if MatchesHost(pattern, host) {
+			return &DeniedError{Destination: rawURL, Reason: fmt.Sprintf("matches denied host '%s'", pattern)}
+		}
+	}
+	if len(p.AllowHosts) > 0 && !p.isAllowedHost(host) {
+		return &DeniedError{Destination: rawURL, Reason: "is not an allowed host"}
+	}
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	if !p.isAllowedPort(port) {
+		return &DeniedError{Destination: rawURL, Reason: fmt.Sprintf("has port %s that is not allowed", port)}
+	}
+	if ip := net.ParseIP(host); ip != nil && p.isDeniedIP(ip) {
+		return &DeniedError{Destination: rawURL, Reason: fmt.Sprintf("is non-public address %s", ip)}
+	}
+	return nil
+}
+
+// isDeniedIP rejects non-public addresses, unless those are allowed
+func (p *Policy) isDeniedIP(ip net.IP) bool {
+	if p.AllowPrivate {
+		return false
+	}
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || carrierGradeNAT.Contains(ip)
+}
+
+// Control is a hook for net.Dialer, that checks the address that is actually connected to
+func (p *Policy) Control(network, address string, _ syscall.RawConn) error {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return &DeniedError{Destination: address, Reason: "is not a valid address"}
+	}
+	if !p.isAllowedPort(port) {
+		return &DeniedError{Destination: address, Reason: fmt.Sprintf("has port %s that is not allowed", port)}
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return &DeniedError{Destination: address, Reason: "is not an ip-address"}
+	}
+	if p.isDeniedIP(ip) {
+		return &DeniedError{Destination: address, Reason: "is a non-public address"}
+	}
+	return nil
+}
+
+func (p *Policy) isAllowedHost(host string) bool {
+	for _, pattern := range p.AllowHosts {
+		if MatchesHost(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) isAllowedPort(port string) bool {
+	if len(p.AllowPorts) == 0 {
+		return true
+	}
+	for _, allowed := range p.AllowPorts {
+		if strconv.Itoa(allowed) == port {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,183 @@
+package destination
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/MarcGrol/forwardhttp/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckURL(t *testing.T) {
+	policy := &Policy{DenyHosts: []string{"*.internal.example.com"}, AllowPorts: []int{80, 443}}
+	restricted := &Policy{AllowHosts: []string{"*.example.com", "example.org"}}
+
+	testCases := []struct {
+		name          string
+		policy        *Policy
+		url           string
+		expectedError string
+	}{
+		{
+			name:   "Public host",
+			policy: policy,
+			url:    "https://www.example.com/doit",
+		},
+		{
+			name:          "Invalid url",
+			policy:        policy,
+			url:           "/doit",
+			expectedError: "Destination not allowed: /doit is not a valid url",
+		},
+		{
+			name:          "Other scheme",
+			policy:        policy,
+			url:           "file:///etc/passwd",
+			expectedError: "Destination not allowed: file:///etc/passwd is not a valid url",
+		},
+		{
+			name:          "Gopher scheme",
+			policy:        policy,
+			url:           "gopher://example.com/",
+			expectedError: "Destination not allowed: gopher://example.com/ has scheme 'gopher'",
+		},
+		{
+			name:          "Denied host",
+			policy:        policy,
+			url:           "https://db.internal.example.com/",
+			expectedError: "Destination not allowed: https://db.internal.example.com/ matches denied host '*.internal.example.com'",
+		},
+		{
+			name:          "Denied port",
+			policy:        policy,
+			url:           "http://example.com:6379/",
+			expectedError: "Destination not allowed: http://example.com:6379/ has port 6379 that is not allowed",
+		},
+		{
+			name:          "Loopback",
+			policy:        policy,
+			url:           "http://127.0.0.1/admin",
+			expectedError: "Destination not allowed: http://127.0.0.1/admin is non-public address 127.0.0.1",
+		},
+		{
+			name:          "Cloud metadata",
+			policy:        policy,
+			url:           "http://169.254.169.254/latest/meta-data",
+			expectedError: "Destination not allowed: http://169.254.169.254/latest/meta-data is non-public address 169.254.169.254",
+		},
+		{
+			name:          "IPv6 loopback",
+			policy:        policy,
+			url:           "http://[::1]/",
+			expectedError: "Destination not allowed: http://[::1]/ is non-public address ::1",
+		},
+		{
+			name:          "Carrier-grade nat",
+			policy:        policy,
+			url:           "http://100.64.1.1/",
+			expectedError: "Destination not allowed: http://100.64.1.1/ is non-public address 100.64.1.1",
+		},
+		{
+			name:   "Private allowed",
+			policy: &Policy{AllowPrivate: true},
+			url:    "http://10.0.0.1:8080/",
+		},
+		{
+			name:   "Allowed subdomain",
+			policy: restricted,
+			url:    "https://api.example.com/",
+		},
+		{
+			name:   "Allowed host",
+			policy: restricted,
+			url:    "https://EXAMPLE.org./",
+		},
+		{
+			name:          "Not an allowed host",
+			policy:        restricted,
+			url:           "https://example.net/",
+			expectedError: "Destination not allowed: https://example.net/ is not an allowed host",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.policy.CheckURL(tc.url)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				assert.True(t, errors.Is(err, ErrDenied))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCheckResolvesHost(t *testing.T) {
+	err := (&Policy{}).Check(context.Background(), "http://localhost/")
+	assert.True(t, errors.Is(err, ErrDenied))
+
+	err = (&Policy{AllowPrivate: true}).Check(context.Background(), "http://localhost/")
+	assert.NoError(t, err)
+}
+
+func TestControl(t *testing.T) {
+	policy := &Policy{AllowPorts: []int{443}}
+
+	assert.NoError(t, policy.Control("tcp4", "93.184.216.34:443", nil))
+	assert.EqualError(t, policy.Control("tcp4", "93.184.216.34:22", nil), "Destination not allowed: 93.184.216.34:22 has port 22 that is not allowed")
+	assert.EqualError(t, policy.Control("tcp4", "10.1.2.3:443", nil), "Destination not allowed: 10.1.2.3:443 is a non-public address")
+	assert.EqualError(t, policy.Control("tcp6", "[fe80::1]:443", nil), "Destination not allowed: [fe80::1]:443 is a non-public address")
+}
+
+func TestMatchesHost(t *testing.T) {
+	assert.True(t, MatchesHost("*", "example.com"))
+	assert.True(t, MatchesHost("example.com", "Example.COM"))
+	assert.True(t, MatchesHost("*.example.com", "a.b.example.com"))
+	assert.False(t, MatchesHost("*.example.com", "example.com"))
+	assert.False(t, MatchesHost("*.example.com", "badexample.com"))
+	assert.False(t, MatchesHost("example.com", "example.com.evil.org"))
+}
+
+func TestNewFromSettings(t *testing.T) {
+	testCases := []struct {
+		name          string
+		settings      map[string]string
+		expected      *Policy
+		expectedError string
+	}{
+		{
+			name:     "Defaults",
+			settings: map[string]string{},
+			expected: &Policy{AllowHosts: []string{}, DenyHosts: []string{}, AllowPorts: []int{80, 443}},
+		},
+		{
+			name: "Configured",
+			settings: map[string]string{
+				"DESTINATION_ALLOW_HOSTS":   "*.example.com, example.org",
+				"DESTINATION_DENY_HOSTS":    "admin.example.com",
+				"DESTINATION_ALLOW_PORTS":   "*",
+				"DESTINATION_ALLOW_PRIVATE": "true",
+			},
+			expected: &Policy{AllowHosts: []string{"*.example.com", "example.org"}, DenyHosts: []string{"admin.example.com"}, AllowPrivate: true},
+		},
+		{
+			name:          "Invalid port",
+			settings:      map[string]string{"DESTINATION_ALLOW_PORTS": "443,https"},
+			expectedError: "Invalid setting 'DESTINATION_ALLOW_PORTS': 'https' is not a port",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, err := NewFromSettings(config.New(tc.settings))
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expected, policy)
+			}
+		})
+	}
+}
@@ -1,11 +1,17 @@
 package entrypoint
 
 import (
+	"github.com/MarcGrol/forwardhttp/auth"
 	"github.com/MarcGrol/forwardhttp/forwarder"
+	"github.com/MarcGrol/forwardhttp/idempotency"
+	"github.com/MarcGrol/forwardhttp/route"
 	"github.com/MarcGrol/forwardhttp/uniqueid"
 )
 
 type webService struct {
-	uidGenerator uniqueid.Generator
-	forwarder    forwarder.Forwarder
+	uidGenerator  uniqueid.Generator
+	forwarder     forwarder.Forwarder
+	routes        route.Config
+	idempotency   idempotency.Keeper
+	authenticator auth.Authenticator
 }
@@ -0,0 +1,126 @@
+package entrypoint
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/MarcGrol/forwardhttp/destination"
+	"github.com/MarcGrol/forwardhttp/forwarder"
+	"github.com/MarcGrol/forwardhttp/httpclient"
+	"github.com/MarcGrol/forwardhttp/queue"
+	"github.com/MarcGrol/forwardhttp/warehouse"
+)
+
+// fanOutResult tells the caller which child-task was created per target
+type fanOutResult struct {
+	TaskUID  string
+	Children []childResult
+}
+
+type childResult struct {
+	TaskUID        string
+	URL            string
+	ResponseStatus int    `json:",omitempty"` // outcome of the TryFirst attempt
+	Error          string `json:",omitempty"` // error of the TryFirst attempt
+	Enqueued       bool   // whether the child is delivered asynchronously
+}
+
+// fanOut creates a child-task per target, each with its own task-uid, retries and status.
+// With TryFirst, each target is tried once synchronously: children that completed are not enqueued.
+// The caller gets 200 when all children completed synchronously, otherwise 202.
+func (s *webService) fanOut(w http.ResponseWriter, r *http.Request, tryFirst bool, parent httpclient.Request, targets []target, idempotencyKey idempotencyKey) {
+	c := r.Context()
+
+	children := []httpclient.Request{}
+	urls := []string{}
+	for i, tgt := range targets {
+		child, err := s.applyTarget(r, parent, tgt)
+		if err != nil {
+			reportError(w, http.StatusBadRequest, fmt.Errorf("Error parsing request: %s", err))
+			return
+		}
+		child.TaskUID = fmt.Sprintf("%s-%d", parent.TaskUID, i+1)
+		child.ParentTaskUID = parent.TaskUID
+		if child.OrderingKey != "" {
+			// order is kept per target: a slow target should not hold back the others
+			child.OrderingKey = child.OrderingKey + "@" + hostOf(child.URL)
+		}
+		children = append(children, child)
+		urls = append(urls, child.URL)
+	}
+
+	// identifies the submission as a whole
+	submission := httpclient.Request{TaskUID: parent.TaskUID, Method: parent.Method, URL: strings.Join(urls, " "), Body: parent.Body}
+	if idempotencyKey.value != "" {
+		replayed := s.replay(w, r, idempotencyKey, submission)
+		if replayed {
+			return
+		}
+	}
+
+	w.Header().Set("X-TaskUid", parent.TaskUID)
+
+	result := fanOutResult{TaskUID: parent.TaskUID, Children: []childResult{}}
+	status := http.StatusOK
+	for _, child := range children {
+		childResult := childResult{TaskUID: child.TaskUID, URL: child.URL}
+		if tryFirst {
+			httpResponse, err := s.forwarder.Forward(c, child)
+			if httpResponse != nil {
+				childResult.ResponseStatus = httpResponse.Status
+			}
+			if err != nil && !errors.Is(err, forwarder.ErrRetryAsync) {
+				childResult.Error = err.Error()
+				result.Children = append(result.Children, childResult)
+				continue
+			}
+			if err == nil {
+				result.Children = append(result.Children, childResult)
+				continue
+			}
+			// continue async
+		}
+
+		err := s.forwarder.ForwardAsync(c, child)
+		if errors.Is(err, destination.ErrDenied) {
+			// the other targets are still served
+			childResult.Error = err.Error()
+			result.Children = append(result.Children, childResult)
+			continue
+		}
+		if errors.Is(err, warehouse.ErrNotSupported) {
+			reportError(w, http.StatusBadRequest, err)
+			return
+		}
+		if err != nil && !errors.Is(err, queue.ErrTaskAlreadyExists) {
+			reportError(w, http.StatusInternalServerError, fmt.Errorf("Error enqueuing task %s: %s", child.TaskUID, err))
+			return
+		}
+		childResult.Enqueued = true
+		status = http.StatusAccepted
+		result.Children = append(result.Children, childResult)
+	}
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		reportError(w, http.StatusInternalServerError, fmt.Errorf("Error encoding response: %s", err))
+		return
+	}
+	httpResponse := &httpclient.Response{Status: status, Headers: http.Header{"Content-Type": []string{"application/json"}}, Body: body}
+	s.remember(r, idempotencyKey, submission, httpResponse)
+	log.Printf("Fanned out %s to %d targets", parent.TaskUID, len(children))
+	writeResponse(w, httpResponse)
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return u.Host
+}
@@ -1,6 +1,7 @@
 package entrypoint
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -8,52 +9,215 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/MarcGrol/forwardhttp/uniqueid"
 
+	"github.com/MarcGrol/forwardhttp/auth"
+	"github.com/MarcGrol/forwardhttp/destination"
 	"github.com/MarcGrol/forwardhttp/forwarder"
 	"github.com/MarcGrol/forwardhttp/httpclient"
+	"github.com/MarcGrol/forwardhttp/idempotency"
+	"github.com/MarcGrol/forwardhttp/queue"
+	"github.com/MarcGrol/forwardhttp/retry"
+	"github.com/MarcGrol/forwardhttp/route"
+	"github.com/MarcGrol/forwardhttp/warehouse"
 	"github.com/gorilla/mux"
 )
 
-func NewWebService(uidGenerator uniqueid.Generator, forwarder forwarder.Forwarder) *webService {
+func NewWebService(uidGenerator uniqueid.Generator, forwarder forwarder.Forwarder, routes route.Config, idempotency idempotency.Keeper, authenticator auth.Authenticator) *webService {
 	s := &webService{
-		uidGenerator: uidGenerator,
-		forwarder:    forwarder,
+		uidGenerator:  uidGenerator,
+		forwarder:     forwarder,
+		routes:        routes,
+		idempotency:   idempotency,
+		authenticator: authenticator,
 	}
 	return s
 }
 
+const landingPagePath = "/_forwardhttp"
+
 func (s *webService) RegisterEndpoint(router *mux.Router) *mux.Router {
+	router.HandleFunc(landingPagePath, s.explain).Methods("GET")
+	router.PathPrefix(s.routes.PathPrefix + "/{route}").HandlerFunc(s.forwardRoute)
 	router.PathPrefix("/").Handler(s)
 	return router
 }
 
 func (s *webService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if r.Method == "POST" || r.Method == "PUT" {
-		s.forward(w, r)
+	if isForwardable(r) {
+		targets, err := extractHostTargets(r)
+		if err != nil {
+			reportError(w, http.StatusBadRequest, fmt.Errorf("Error parsing request: %s", err))
+			return
+		}
+		s.forward(w, r, targets)
 		return
 	}
 
 	s.explain(w, r)
 }
 
-func (s *webService) forward(w http.ResponseWriter, r *http.Request) {
+func (s *webService) forwardRoute(w http.ResponseWriter, r *http.Request) {
+	routeName := mux.Vars(r)["route"]
+	mountPath := s.routes.PathPrefix + "/" + routeName
+	targets := []target{}
+	if rt, found := s.routes.Get(routeName); found {
+		targets = append(targets, target{route: &rt, mountPath: mountPath})
+	} else if group, found := s.routes.Group(routeName); found {
+		for i := range group {
+			targets = append(targets, target{route: &group[i], mountPath: mountPath})
+		}
+	} else {
+		reportError(w, http.StatusNotFound, fmt.Errorf("Unknown route '%s'", routeName))
+		return
+	}
+	if !isForwardable(r) {
+		reportError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %s is not forwarded", r.Method))
+		return
+	}
+
+	s.forward(w, r, targets)
+}
+
+// target is where a request is forwarded to: either a host, or a route that is mounted at a path
+type target struct {
+	host      string
+	route     *route.Route
+	mountPath string // the part of the request-path that is replaced by the host of the route
+}
+
+// extractHostTargets supports a repeated HostToForwardTo, as well as a comma-separated list of hosts
+func extractHostTargets(r *http.Request) ([]target, error) {
+	values := r.URL.Query()["HostToForwardTo"]
+	if len(values) == 0 {
+		value, err := extractMandatoryStringParameter(r, "HostToForwardTo")
+		if err != nil {
+			return nil, fmt.Errorf("Missing parameter: %s", err)
+		}
+		values = []string{value}
+	}
+	targets := []target{}
+	for _, value := range values {
+		for _, host := range strings.Split(value, ",") {
+			host = strings.TrimSpace(host)
+			if host != "" {
+				targets = append(targets, target{host: host})
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("Missing parameter: Missing mandatory parameter 'HostToForwardTo'")
+	}
+	return targets, nil
+}
+
+// authorize checks every target, so that a single request cannot reach a destination the client may not use
+func (s *webService) authorize(client auth.Client, targets []target) error {
+	for _, tgt := range targets {
+		if tgt.route != nil {
+			name := strings.TrimPrefix(tgt.mountPath, s.routes.PathPrefix+"/")
+			if !client.MayUseRoute(name) {
+				return fmt.Errorf("Client %s may not use route '%s'", client.Name, name)
+			}
+			continue
+		}
+		scheme, hostname := determineSchemeHostname(tgt.host)
+		u, err := url.Parse(scheme + "://" + hostname)
+		if err != nil || !client.MayUseHost(u.Hostname()) {
+			return fmt.Errorf("Client %s may not forward to host '%s'", client.Name, tgt.host)
+		}
+	}
+	return nil
+}
+
+// authorizeCallback prevents the callback from reaching a host the client may not forward to itself
+func authorizeCallback(client auth.Client, callbackURL string) error {
+	if callbackURL == "" {
+		return nil
+	}
+	u, err := url.Parse(callbackURL)
+	if err != nil || !client.MayUseHost(u.Hostname()) {
+		return fmt.Errorf("Client %s may not receive callbacks at '%s'", client.Name, callbackURL)
+	}
+	return nil
+}
+
+func isForwardable(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch:
+		return true
+	case http.MethodGet:
+		// browsers visiting the landing page should not cause requests to be forwarded
+		return extractBool(r, "ForwardGet")
+	default:
+		return false
+	}
+}
+
+func (s *webService) forward(w http.ResponseWriter, r *http.Request, targets []target) {
 	c := r.Context()
 
-	tryFirst, httpRequest, err := s.parseRequest(r)
+	client, err := s.authenticator.Authenticate(r)
+	if errors.Is(err, auth.ErrMissingCredentials) || errors.Is(err, auth.ErrInvalidCredentials) {
+		reportError(w, http.StatusUnauthorized, err)
+		return
+	}
+	if err != nil {
+		reportError(w, http.StatusInternalServerError, fmt.Errorf("Error authenticating request: %s", err))
+		return
+	}
+	err = s.authorize(*client, targets)
+	if err != nil {
+		reportError(w, http.StatusForbidden, err)
+		return
+	}
+
+	idempotencyKey := extractIdempotencyKey(r, *client)
+	tryFirst, httpRequest, err := s.parseRequest(r, idempotencyKey)
+	if err != nil {
+		reportError(w, http.StatusBadRequest, fmt.Errorf("Error parsing request: %s", err))
+		return
+	}
+	httpRequest.Owner = client.Name
+	err = authorizeCallback(*client, httpRequest.CallbackURL)
+	if err != nil {
+		reportError(w, http.StatusForbidden, err)
+		return
+	}
+	if len(targets) > 1 {
+		s.fanOut(w, r, tryFirst, httpRequest, targets, idempotencyKey)
+		return
+	}
+	httpRequest, err = s.applyTarget(r, httpRequest, targets[0])
 	if err != nil {
 		reportError(w, http.StatusBadRequest, fmt.Errorf("Error parsing request: %s", err))
 		return
 	}
 
+	if idempotencyKey.value != "" {
+		replayed := s.replay(w, r, idempotencyKey, httpRequest)
+		if replayed {
+			return
+		}
+	}
+
+	w.Header().Set("X-TaskUid", httpRequest.TaskUID)
+
 	if tryFirst {
 		httpResponse, err := s.forwarder.Forward(c, httpRequest)
-		if err != nil {
+		if errors.Is(err, destination.ErrDenied) {
+			reportError(w, http.StatusForbidden, err)
+			return
+		}
+		if err != nil && !errors.Is(err, forwarder.ErrRetryAsync) {
 			writeResponse(w, &httpclient.Response{Status: 500, Body: []byte(err.Error())})
 			return
 		}
-		if httpResponse.IsPermanentError() {
+		if err == nil {
+			// delivered or given up on, as decided by the retry-policy
+			s.remember(r, idempotencyKey, httpRequest, httpResponse)
 			writeResponse(w, httpResponse)
 			return
 		}
@@ -61,15 +225,90 @@ func (s *webService) forward(w http.ResponseWriter, r *http.Request) {
 	}
 
 	err = s.forwarder.ForwardAsync(c, httpRequest)
+	if errors.Is(err, queue.ErrTaskAlreadyExists) {
+		if idempotencyKey.value != "" && s.replay(w, r, idempotencyKey, httpRequest) {
+			// a concurrent submission with the same key was enqueued first
+			return
+		}
+		reportError(w, http.StatusConflict, fmt.Errorf("Task %s already exists", httpRequest.TaskUID))
+		return
+	}
+	if errors.Is(err, destination.ErrDenied) {
+		reportError(w, http.StatusForbidden, err)
+		return
+	}
+	if errors.Is(err, warehouse.ErrNotSupported) {
+		reportError(w, http.StatusBadRequest, err)
+		return
+	}
 	if err != nil {
 		reportError(w, http.StatusInternalServerError, fmt.Errorf("Error enqueuing task: %s", err))
 		return
 	}
+	s.remember(r, idempotencyKey, httpRequest, nil)
 
 	// Indicate we have successfully received but not yet processed
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// idempotencyKey identifies a submission of a client, via the Idempotency-Key header or a task-uid chosen by the client
+type idempotencyKey struct {
+	value    string // as given by the client
+	storeKey string // scoped to the client, keeping Idempotency-Keys and task-uids apart
+}
+
+// extractIdempotencyKey prefers the Idempotency-Key header over a task-uid that was chosen by the client
+func extractIdempotencyKey(r *http.Request, client auth.Client) idempotencyKey {
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		return idempotencyKey{value: key, storeKey: client.Name + "/idempotency-key/" + key}
+	}
+	if key := extractStringParameter(r, "TaskUid"); key != "" {
+		return idempotencyKey{value: key, storeKey: client.Name + "/task-uid/" + key}
+	}
+	return idempotencyKey{}
+}
+
+// replay answers a repeated submission like the original one
+func (s *webService) replay(w http.ResponseWriter, r *http.Request, key idempotencyKey, httpRequest httpclient.Request) bool {
+	record, found, err := s.idempotency.Get(r.Context(), key.storeKey)
+	if err != nil {
+		// rather risk a duplicate than refuse the request
+		log.Printf("Error checking idempotency-key %s: %s", key.storeKey, err)
+		return false
+	}
+	if !found {
+		return false
+	}
+	if record.Fingerprint != idempotency.Fingerprint(httpRequest) {
+		reportError(w, http.StatusConflict, fmt.Errorf("Idempotency-Key '%s' was already used for a different request", key.value))
+		return true
+	}
+
+	log.Printf("Replaying response for idempotency-key %s of task %s", key.storeKey, record.TaskUID)
+	w.Header().Set("X-TaskUid", record.TaskUID)
+	if record.Response != nil {
+		writeResponse(w, record.Response)
+		return true
+	}
+	w.WriteHeader(http.StatusAccepted)
+	return true
+}
+
+func (s *webService) remember(r *http.Request, key idempotencyKey, httpRequest httpclient.Request, httpResponse *httpclient.Response) {
+	if key.value == "" {
+		return
+	}
+	err := s.idempotency.Put(r.Context(), idempotency.Record{
+		Key:         key.storeKey,
+		TaskUID:     httpRequest.TaskUID,
+		Fingerprint: idempotency.Fingerprint(httpRequest),
+		Response:    httpResponse,
+	})
+	if err != nil {
+		log.Printf("Error remembering idempotency-key %s: %s", key.storeKey, err)
+	}
+}
+
 func writeResponse(w http.ResponseWriter, resp *httpclient.Response) {
 	for k, v := range resp.Headers {
 		for _, hv := range v {
@@ -87,27 +326,42 @@ func reportError(w http.ResponseWriter, httpResponseStatus int, err error) {
 	fmt.Fprintf(w, err.Error())
 }
 
-func (s *webService) parseRequest(r *http.Request) (bool, httpclient.Request, error) {
-	hostToForwardTo, err := extractMandatoryStringParameter(r, "HostToForwardTo")
-	if err != nil {
-		return false, httpclient.Request{}, fmt.Errorf("Missing parameter: %s", err)
-	}
-
+// parseRequest takes everything but the target from the request
+func (s *webService) parseRequest(r *http.Request, key idempotencyKey) (bool, httpclient.Request, error) {
+	var err error
 	taskUID := extractStringParameter(r, "TaskUid")
+	if taskUID == "" && key.value != "" {
+		taskUID = s.idempotency.TaskUID(key.storeKey)
+	}
 	if taskUID == "" {
 		taskUID = s.uidGenerator.Generate()
 	}
 	tryFirst := extractBool(r, "TryFirst")
-
-	req := httpclient.Request{
-		Method:  r.Method,
-		Headers: r.Header,
-		TaskUID: taskUID,
+	callbackURL := extractStringParameter(r, "CallbackURL")
+	if callbackURL != "" && !isAbsoluteURL(callbackURL) {
+		return false, httpclient.Request{}, fmt.Errorf("Invalid callback url '%s'", callbackURL)
 	}
 
-	req.URL, err = composeTargetURL(r.RequestURI, hostToForwardTo)
+	deliverAt, err := extractDeliverAt(r)
 	if err != nil {
-		return tryFirst, req, fmt.Errorf("Error composing target url: %s", err)
+		return false, httpclient.Request{}, err
+	}
+	if tryFirst && !deliverAt.IsZero() {
+		return false, httpclient.Request{}, fmt.Errorf("TryFirst cannot be combined with a scheduled delivery")
+	}
+	orderingKey := extractStringParameter(r, "OrderingKey")
+	if tryFirst && orderingKey != "" {
+		// a synchronous attempt would overtake the requests that wait in the queue
+		return false, httpclient.Request{}, fmt.Errorf("TryFirst cannot be combined with an ordering key")
+	}
+
+	req := httpclient.Request{
+		Method:      r.Method,
+		Headers:     r.Header,
+		TaskUID:     taskUID,
+		CallbackURL: callbackURL,
+		DeliverAt:   deliverAt,
+		OrderingKey: orderingKey,
 	}
 
 	req.Body, err = ioutil.ReadAll(r.Body)
@@ -118,6 +372,38 @@ func (s *webService) parseRequest(r *http.Request) (bool, httpclient.Request, er
 	return tryFirst, req, nil
 }
 
+// applyTarget uses the named route when given, otherwise the host to forward to
+func (s *webService) applyTarget(r *http.Request, req httpclient.Request, tgt target) (httpclient.Request, error) {
+	var err error
+	if tgt.route == nil {
+		req.URL, err = composeTargetURL(r.RequestURI, tgt.host)
+	} else {
+		req.URL, err = composeRouteTargetURL(r.RequestURI, tgt.mountPath, *tgt.route)
+		applyRoute(&req, *tgt.route)
+	}
+	if err != nil {
+		return req, fmt.Errorf("Error composing target url: %s", err)
+	}
+
+	// retry-rules of the request take precedence over the ones of the route
+	rules := retry.Rules{
+		RetryOn:  retry.ParseList(extractStringParameter(r, "RetryOn")),
+		GiveUpOn: retry.ParseList(extractStringParameter(r, "GiveUpOn")),
+	}
+	err = rules.Validate()
+	if err != nil {
+		return req, err
+	}
+	if len(rules.RetryOn) > 0 {
+		req.RetryOn = rules.RetryOn
+	}
+	if len(rules.GiveUpOn) > 0 {
+		req.GiveUpOn = rules.GiveUpOn
+	}
+
+	return req, nil
+}
+
 func composeTargetURL(requestURI, hostToForwardTo string) (string, error) {
 	// strip scheme
 
@@ -125,17 +411,69 @@ func composeTargetURL(requestURI, hostToForwardTo string) (string, error) {
 	if err != nil {
 		return "", fmt.Errorf("Error parsing url path %s: %s", requestURI, err)
 	}
-	queryParams := url.Query()
-	queryParams.Del("HostToForwardTo") // not interesting to remote host
-	queryParams.Del("TryFirst")        // not interesting to remote host
-	queryParams.Del("TaskUid")         // not interesting to remote host
-	url.RawQuery = queryParams.Encode()
+	url.RawQuery = removeControlParameters(url.Query()).Encode()
 	scheme, host := determineSchemeHostname(hostToForwardTo)
 	url.Host = host
 	url.Scheme = scheme
 	return url.String(), nil
 }
 
+// composeRouteTargetURL replaces the mount-path (prefix and name of route or group) by the host (and base-path) of the route
+func composeRouteTargetURL(requestURI, mountPath string, rt route.Route) (string, error) {
+	requestURL, err := url.Parse(requestURI)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing url path %s: %s", requestURI, err)
+	}
+	targetURL, err := url.Parse(rt.Host)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing host %s of route %s: %s", rt.Host, rt.Name, err)
+	}
+	targetURL.Path = targetURL.Path + strings.TrimPrefix(requestURL.Path, mountPath)
+	targetURL.RawQuery = removeControlParameters(requestURL.Query()).Encode()
+	return targetURL.String(), nil
+}
+
+func removeControlParameters(queryParams url.Values) url.Values {
+	queryParams.Del("HostToForwardTo") // not interesting to remote host
+	queryParams.Del("TryFirst")        // not interesting to remote host
+	queryParams.Del("TaskUid")         // not interesting to remote host
+	queryParams.Del("ForwardGet")      // not interesting to remote host
+	queryParams.Del("CallbackURL")     // not interesting to remote host
+	queryParams.Del("RetryOn")         // not interesting to remote host
+	queryParams.Del("GiveUpOn")        // not interesting to remote host
+	queryParams.Del("DeliverAt")       // not interesting to remote host
+	queryParams.Del("DeliverAfter")    // not interesting to remote host
+	queryParams.Del("OrderingKey")     // not interesting to remote host
+	return queryParams
+}
+
+func isAbsoluteURL(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func applyRoute(req *httpclient.Request, rt route.Route) {
+	req.Route = rt.Name
+	req.MaxAttempts = rt.MaxAttempts
+	req.Timeout = rt.Timeout
+	req.RetryOn = rt.RetryRules.RetryOn
+	req.GiveUpOn = rt.RetryRules.GiveUpOn
+	if len(rt.Headers) > 0 {
+		req.Headers = req.Headers.Clone()
+		if req.Headers == nil {
+			req.Headers = http.Header{}
+		}
+		for name, value := range rt.Headers {
+			if req.Headers.Get(name) == "" {
+				req.Headers.Set(name, value)
+			}
+		}
+	}
+}
+
 func determineSchemeHostname(hostToForwardTo string) (string, string) {
 	scheme := ""
 	if strings.HasPrefix(hostToForwardTo, "http://") {
@@ -171,6 +509,30 @@ func extractStringParameter(r *http.Request, fieldName string) string {
 	return value
 }
 
+// extractDeliverAt supports either an absolute time (RFC3339) or a delay (duration like "90s" or "2h")
+func extractDeliverAt(r *http.Request) (time.Time, error) {
+	deliverAt := extractStringParameter(r, "DeliverAt")
+	deliverAfter := extractStringParameter(r, "DeliverAfter")
+	switch {
+	case deliverAt != "" && deliverAfter != "":
+		return time.Time{}, fmt.Errorf("DeliverAt and DeliverAfter cannot be combined")
+	case deliverAt != "":
+		t, err := time.Parse(time.RFC3339, deliverAt)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("Invalid DeliverAt '%s': expected RFC3339 timestamp", deliverAt)
+		}
+		return t, nil
+	case deliverAfter != "":
+		d, err := time.ParseDuration(deliverAfter)
+		if err != nil || d < 0 {
+			return time.Time{}, fmt.Errorf("Invalid DeliverAfter '%s': expected positive duration like 90s or 2h", deliverAfter)
+		}
+		return time.Now().Add(d), nil
+	default:
+		return time.Time{}, nil
+	}
+}
+
 func extractBool(r *http.Request, fieldName string) bool {
 	valueAsString := r.URL.Query().Get(fieldName)
 	if valueAsString == "" {
@@ -209,7 +571,8 @@ const serviceDescription = `<html>
 		<h1>Retrying HTTP forwarder</h1>
 		<p>
 			This web-service will act as a persistent and retrying queue.<br/>
-			Upon receipt of a POST or PUT-request, the service will asynchronously forward the received HTTP request to a remote host.<br/>
+			Upon receipt of a POST, PUT, PATCH or DELETE-request, the service will asynchronously forward the received HTTP request to a remote host.<br/>
+			GET-requests are only forwarded when explicitly requested via the HTTP query parameter "ForwardGet=true" or the HTTP-request-header "X-ForwardGet: true".<br/>
 			When the remote host does not return a success, the request will be retried untill success or 
             untill the retry-scheme is exhausted.<br/>
 			The remote host is indicated by:
@@ -217,6 +580,7 @@ const serviceDescription = `<html>
 				<li>the HTTP query parameeter "HostToForwardTo" or </li>
 				<li>the HTTP-request-header "X-HostToForwardTo"</li>
 			</ul>
+			Alternatively, requests sent to "/forward/{route}/..." are forwarded to the host configured for the named route.
 		</p>
 		
 		<p>
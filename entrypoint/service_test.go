@@ -1,16 +1,26 @@
 package entrypoint
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/MarcGrol/forwardhttp/uniqueid"
 
+	"github.com/MarcGrol/forwardhttp/auth"
+	"github.com/MarcGrol/forwardhttp/destination"
+	"github.com/MarcGrol/forwardhttp/envelope"
 	"github.com/MarcGrol/forwardhttp/forwarder"
 	"github.com/MarcGrol/forwardhttp/httpclient"
+	"github.com/MarcGrol/forwardhttp/idempotency"
+	"github.com/MarcGrol/forwardhttp/queue"
+	"github.com/MarcGrol/forwardhttp/retry"
+	"github.com/MarcGrol/forwardhttp/route"
+	"github.com/MarcGrol/forwardhttp/store"
 	"github.com/golang/mock/gomock"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
@@ -24,6 +34,8 @@ func TestAll(t *testing.T) {
 		name                    string
 		uidGenerator            uniqueid.Generator
 		forwarder               forwarder.Forwarder
+		idempotency             idempotency.Keeper
+		authenticator           auth.Authenticator
 		request                 *http.Request
 		expectedResponseStatus  int
 		expectedResponsePayload string
@@ -34,6 +46,18 @@ func TestAll(t *testing.T) {
 			forwarder:              nil,
 			request:                httpRequest(t, "GET", "/", "request body"),
 			expectedResponseStatus: 200,
+		}, {
+			name:                   "Get website with instructions at dedicated path",
+			uidGenerator:           nil,
+			forwarder:              nil,
+			request:                httpRequest(t, "GET", "/_forwardhttp?HostToForwardTo=home.nl&ForwardGet=true", ""),
+			expectedResponseStatus: 200,
+		}, {
+			name:                   "Get without opt-in is not forwarded",
+			uidGenerator:           nil,
+			forwarder:              nil,
+			request:                httpRequest(t, "GET", "/doit?HostToForwardTo=home.nl", ""),
+			expectedResponseStatus: 200,
 		}, {
 			name:                    "Missing mandatory param",
 			uidGenerator:            nil,
@@ -59,13 +83,21 @@ func TestAll(t *testing.T) {
 			expectedResponsePayload: "response body for request abc POST /doit?a=b",
 		},
 		{
-			name:                    "Synchronous: Networking error",
+			name:                    "Synchronous: Networking error given up on",
 			uidGenerator:            generateUID(ctrl, "abc"),
 			forwarder:               syncForwarder(ctrl, "abc", "POST", "/doit", 0, "", fmt.Errorf("Networking error")),
 			request:                 httpRequest(t, "POST", "/doit?HostToForwardTo=home.nl&TryFirst=true", "request body"),
 			expectedResponseStatus:  500,
 			expectedResponsePayload: "Networking error",
 		},
+		{
+			name:                    "Synchronous: Networking error retried",
+			uidGenerator:            generateUID(ctrl, "abc"),
+			forwarder:               completeForwarder(ctrl, 0, "", nil),
+			request:                 httpRequest(t, "POST", "/doit?HostToForwardTo=home.nl&TryFirst=true", "request body"),
+			expectedResponseStatus:  202,
+			expectedResponsePayload: "",
+		},
 		{
 			name:                    "Synchronous: Permanent http error",
 			uidGenerator:            generateUID(ctrl, "abc"),
@@ -90,6 +122,135 @@ func TestAll(t *testing.T) {
 			expectedResponseStatus:  202,
 			expectedResponsePayload: "",
 		},
+		{
+			name:                    "Asynchronous: delete",
+			uidGenerator:            nil,
+			forwarder:               asyncForwarderExpecting(ctrl, "DELETE", "https://home.nl/doit/123", nil),
+			request:                 httpRequest(t, "DELETE", "/doit/123?HostToForwardTo=home.nl&TaskUid=xx-yy-zz", ""),
+			expectedResponseStatus:  202,
+			expectedResponsePayload: "",
+		},
+		{
+			name:                    "Asynchronous: patch",
+			uidGenerator:            nil,
+			forwarder:               asyncForwarderExpecting(ctrl, "PATCH", "https://home.nl/doit/123", nil),
+			request:                 httpRequest(t, "PATCH", "/doit/123?HostToForwardTo=home.nl&TaskUid=xx-yy-zz", "request body"),
+			expectedResponseStatus:  202,
+			expectedResponsePayload: "",
+		},
+		{
+			name:                    "Asynchronous: get with opt-in",
+			uidGenerator:            nil,
+			forwarder:               asyncForwarderExpecting(ctrl, "GET", "https://home.nl/doit/123?a=b", nil),
+			request:                 httpRequest(t, "GET", "/doit/123?a=b&HostToForwardTo=home.nl&TaskUid=xx-yy-zz&ForwardGet=true", ""),
+			expectedResponseStatus:  202,
+			expectedResponsePayload: "",
+		},
+		{
+			name:                   "Route: success",
+			uidGenerator:           generateUID(ctrl, "abc"),
+			forwarder:              asyncForwarderExpectingRequest(ctrl, httpclient.Request{Method: "POST", URL: "https://billing.example.com/v1/invoices/123?a=b", Route: "billing", MaxAttempts: 3, Timeout: 5 * time.Second, GiveUpOn: []string{"409"}, Headers: http.Header{"Authorization": []string{"Bearer 123"}}}),
+			request:                httpRequest(t, "POST", "/forward/billing/invoices/123?a=b&HostToForwardTo=ignored.nl", "request body"),
+			expectedResponseStatus: 202,
+		},
+		{
+			name:                   "Route: client headers take precedence",
+			uidGenerator:           generateUID(ctrl, "abc"),
+			forwarder:              asyncForwarderExpectingRequest(ctrl, httpclient.Request{Method: "PUT", URL: "https://billing.example.com/v1", Route: "billing", MaxAttempts: 3, Timeout: 5 * time.Second, GiveUpOn: []string{"409"}, Headers: http.Header{"Authorization": []string{"Bearer abc"}}}),
+			request:                httpRequestWithHeaders(t, "PUT", "/forward/billing", "request body", map[string]string{"Authorization": "Bearer abc"}),
+			expectedResponseStatus: 202,
+		},
+		{
+			name:                   "Asynchronous: callback url",
+			uidGenerator:           generateUID(ctrl, "abc"),
+			forwarder:              asyncForwarderExpectingRequest(ctrl, httpclient.Request{Method: "POST", URL: "https://home.nl/doit", CallbackURL: "https://caller.nl/done"}),
+			request:                httpRequest(t, "POST", "/doit?HostToForwardTo=home.nl&CallbackURL=https%3A%2F%2Fcaller.nl%2Fdone", "request body"),
+			expectedResponseStatus: 202,
+		},
+		{
+			name:                    "Asynchronous: invalid callback url",
+			uidGenerator:            generateUID(ctrl, "abc"),
+			forwarder:               nil,
+			request:                 httpRequestWithHeaders(t, "POST", "/doit?HostToForwardTo=home.nl", "request body", map[string]string{"X-CallbackURL": "caller.nl/done"}),
+			expectedResponseStatus:  400,
+			expectedResponsePayload: "Error parsing request: Invalid callback url 'caller.nl/done'",
+		},
+		{
+			name:                   "Route: retry rules of request take precedence",
+			uidGenerator:           generateUID(ctrl, "abc"),
+			forwarder:              asyncForwarderExpectingRequest(ctrl, httpclient.Request{Method: "POST", URL: "https://billing.example.com/v1", Route: "billing", MaxAttempts: 3, Timeout: 5 * time.Second, RetryOn: []string{"404"}, GiveUpOn: []string{"5xx", "network"}}),
+			request:                httpRequest(t, "POST", "/forward/billing?RetryOn=404&GiveUpOn=5xx,network", "request body"),
+			expectedResponseStatus: 202,
+		},
+		{
+			name:                    "Asynchronous: invalid retry rule",
+			uidGenerator:            generateUID(ctrl, "abc"),
+			forwarder:               nil,
+			request:                 httpRequest(t, "POST", "/doit?HostToForwardTo=home.nl&RetryOn=sometimes", "request body"),
+			expectedResponseStatus:  400,
+			expectedResponsePayload: "Error parsing request: Invalid retry pattern 'sometimes': expected a status like 404, 4xx or 500-503, or 'network'",
+		},
+		{
+			name:                   "Scheduled: deliver at",
+			uidGenerator:           generateUID(ctrl, "abc"),
+			forwarder:              asyncForwarderExpectingRequest(ctrl, httpclient.Request{Method: "POST", URL: "https://home.nl/doit", DeliverAt: time.Date(2030, time.January, 1, 9, 0, 0, 0, time.UTC)}),
+			request:                httpRequest(t, "POST", "/doit?HostToForwardTo=home.nl&DeliverAt=2030-01-01T10:00:00%2B01:00", "request body"),
+			expectedResponseStatus: 202,
+		},
+		{
+			name:                   "Scheduled: deliver after",
+			uidGenerator:           generateUID(ctrl, "abc"),
+			forwarder:              asyncForwarderExpectingDelay(ctrl, 2*time.Hour),
+			request:                httpRequestWithHeaders(t, "POST", "/doit?HostToForwardTo=home.nl", "request body", map[string]string{"X-DeliverAfter": "2h"}),
+			expectedResponseStatus: 202,
+		},
+		{
+			name:                    "Scheduled: invalid deliver at",
+			uidGenerator:            generateUID(ctrl, "abc"),
+			forwarder:               nil,
+			request:                 httpRequest(t, "POST", "/doit?HostToForwardTo=home.nl&DeliverAt=tomorrow", "request body"),
+			expectedResponseStatus:  400,
+			expectedResponsePayload: "Error parsing request: Invalid DeliverAt 'tomorrow': expected RFC3339 timestamp",
+		},
+		{
+			name:                    "Scheduled: both deliver at and after",
+			uidGenerator:            generateUID(ctrl, "abc"),
+			forwarder:               nil,
+			request:                 httpRequest(t, "POST", "/doit?HostToForwardTo=home.nl&DeliverAt=2030-01-01T10:00:00Z&DeliverAfter=1h", "request body"),
+			expectedResponseStatus:  400,
+			expectedResponsePayload: "Error parsing request: DeliverAt and DeliverAfter cannot be combined",
+		},
+		{
+			name:                    "Scheduled: not with try first",
+			uidGenerator:            generateUID(ctrl, "abc"),
+			forwarder:               nil,
+			request:                 httpRequest(t, "POST", "/doit?HostToForwardTo=home.nl&DeliverAfter=1h&TryFirst=true", "request body"),
+			expectedResponseStatus:  400,
+			expectedResponsePayload: "Error parsing request: TryFirst cannot be combined with a scheduled delivery",
+		},
+		{
+			name:                   "Ordered",
+			uidGenerator:           generateUID(ctrl, "abc"),
+			forwarder:              asyncForwarderExpectingRequest(ctrl, httpclient.Request{Method: "POST", URL: "https://home.nl/orders/123", OrderingKey: "order-123"}),
+			request:                httpRequest(t, "POST", "/orders/123?HostToForwardTo=home.nl&OrderingKey=order-123", "request body"),
+			expectedResponseStatus: 202,
+		},
+		{
+			name:                    "Ordered: not with try first",
+			uidGenerator:            generateUID(ctrl, "abc"),
+			forwarder:               nil,
+			request:                 httpRequestWithHeaders(t, "POST", "/orders/123?HostToForwardTo=home.nl&TryFirst=true", "request body", map[string]string{"X-OrderingKey": "order-123"}),
+			expectedResponseStatus:  400,
+			expectedResponsePayload: "Error parsing request: TryFirst cannot be combined with an ordering key",
+		},
+		{
+			name:                    "Route: unknown",
+			uidGenerator:            nil,
+			forwarder:               nil,
+			request:                 httpRequest(t, "POST", "/forward/shipping/parcels", "request body"),
+			expectedResponseStatus:  404,
+			expectedResponsePayload: "Unknown route 'shipping'",
+		},
 		{
 			name:                    "Asynchronous: error",
 			uidGenerator:            generateUID(ctrl, "abc"),
@@ -98,12 +259,95 @@ func TestAll(t *testing.T) {
 			expectedResponseStatus:  500,
 			expectedResponsePayload: "Error enqueuing task: queueing error",
 		},
+		{
+			name:                    "Asynchronous: task already exists",
+			uidGenerator:            nil,
+			forwarder:               asyncForwarder(ctrl, "", fmt.Errorf("Error submitting: %w", queue.ErrTaskAlreadyExists)),
+			request:                 httpRequest(t, "POST", "/doit?HostToForwardTo=home.nl&TaskUid=xx-yy-zz", "request body"),
+			expectedResponseStatus:  409,
+			expectedResponsePayload: "Task xx-yy-zz already exists",
+		},
+		{
+			name:                    "Asynchronous: destination denied",
+			uidGenerator:            generateUID(ctrl, "abc"),
+			forwarder:               asyncForwarder(ctrl, "", fmt.Errorf("Error enqueuing: %w", &destination.DeniedError{Destination: "http://localhost/doit", Reason: "resolves to non-public address 127.0.0.1"})),
+			request:                 httpRequest(t, "POST", "/doit?HostToForwardTo=localhost", "request body"),
+			expectedResponseStatus:  403,
+			expectedResponsePayload: "Error enqueuing: Destination not allowed: http://localhost/doit resolves to non-public address 127.0.0.1",
+		},
+		{
+			name:                   "Idempotent: replay enqueued",
+			forwarder:              nil,
+			idempotency:            idempotencyGet(ctrl, "anonymous/idempotency-key/key-1", &idempotency.Record{Key: "key-1", TaskUID: "abc", Fingerprint: testFingerprint()}),
+			request:                httpRequestWithHeaders(t, "POST", "/doit?HostToForwardTo=home.nl", "request body", map[string]string{"Idempotency-Key": "key-1"}),
+			expectedResponseStatus: 202,
+		},
+		{
+			name:                    "Idempotent: replay synchronous response",
+			forwarder:               nil,
+			idempotency:             idempotencyGet(ctrl, "anonymous/idempotency-key/key-1", &idempotency.Record{Key: "key-1", TaskUID: "abc", Fingerprint: testFingerprint(), Response: &httpclient.Response{Status: 201, Body: []byte("created")}}),
+			request:                 httpRequestWithHeaders(t, "POST", "/doit?HostToForwardTo=home.nl&TryFirst=true", "request body", map[string]string{"Idempotency-Key": "key-1"}),
+			expectedResponseStatus:  201,
+			expectedResponsePayload: "created",
+		},
+		{
+			name:                    "Idempotent: different request",
+			forwarder:               nil,
+			idempotency:             idempotencyGet(ctrl, "anonymous/idempotency-key/key-1", &idempotency.Record{Key: "key-1", TaskUID: "abc", Fingerprint: "other"}),
+			request:                 httpRequestWithHeaders(t, "POST", "/doit?HostToForwardTo=home.nl", "request body", map[string]string{"Idempotency-Key": "key-1"}),
+			expectedResponseStatus:  409,
+			expectedResponsePayload: "Idempotency-Key 'key-1' was already used for a different request",
+		},
+		{
+			name:                    "Authentication: missing credentials",
+			authenticator:           authenticate(ctrl, nil, auth.ErrMissingCredentials),
+			request:                 httpRequest(t, "POST", "/doit?HostToForwardTo=home.nl", "request body"),
+			expectedResponseStatus:  401,
+			expectedResponsePayload: "Missing credentials",
+		},
+		{
+			name:                    "Authentication: host not allowed",
+			authenticator:           authenticate(ctrl, &auth.Client{Name: "webshop", Hosts: []string{"*.example.com"}}, nil),
+			request:                 httpRequest(t, "POST", "/doit?HostToForwardTo=api.example.com,home.nl:8080", "request body"),
+			expectedResponseStatus:  403,
+			expectedResponsePayload: "Client webshop may not forward to host 'home.nl:8080'",
+		},
+		{
+			name:                    "Authentication: callback host not allowed",
+			authenticator:           authenticate(ctrl, &auth.Client{Name: "webshop", Hosts: []string{"*.example.com"}}, nil),
+			uidGenerator:            generateUID(ctrl, "abc"),
+			request:                 httpRequest(t, "POST", "/doit?HostToForwardTo=api.example.com&CallbackURL=http%3A%2F%2F169.254.169.254%2Fdone", "request body"),
+			expectedResponseStatus:  403,
+			expectedResponsePayload: "Client webshop may not receive callbacks at 'http://169.254.169.254/done'",
+		},
+		{
+			name:                    "Authentication: route not allowed",
+			authenticator:           authenticate(ctrl, &auth.Client{Name: "webshop", Routes: []string{"billing"}}, nil),
+			request:                 httpRequest(t, "POST", "/forward/orders/doit", "request body"),
+			expectedResponseStatus:  403,
+			expectedResponsePayload: "Client webshop may not use route 'orders'",
+		},
+		{
+			name:                    "Authentication: route allowed",
+			authenticator:           authenticate(ctrl, &auth.Client{Name: "webshop", Routes: []string{"billing"}}, nil),
+			uidGenerator:            generateUID(ctrl, "abc"),
+			forwarder:               asyncForwarderExpecting(ctrl, "POST", "https://billing.example.com/v1/doit", nil),
+			request:                 httpRequest(t, "POST", "/forward/billing/doit", "request body"),
+			expectedResponseStatus:  202,
+			expectedResponsePayload: "",
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			// setup
-			webservice := NewWebService(tc.uidGenerator, tc.forwarder)
+			if tc.idempotency == nil {
+				tc.idempotency = idempotency.NewKeeper(newMemoryStore(t), time.Hour, envelope.Plaintext())
+			}
+			if tc.authenticator == nil {
+				tc.authenticator = auth.Anonymous()
+			}
+			webservice := NewWebService(tc.uidGenerator, tc.forwarder, testRoutes(), tc.idempotency, tc.authenticator)
 
 			// when
 			httpResp := httptest.NewRecorder()
@@ -130,6 +374,37 @@ func httpRequest(t *testing.T, method, url, body string) *http.Request {
 	return httpReq
 }
 
+func httpRequestWithHeaders(t *testing.T, method, url, body string, headers map[string]string) *http.Request {
+	httpReq := httpRequest(t, method, url, body)
+	for k, v := range headers {
+		httpReq.Header.Set(k, v)
+	}
+	return httpReq
+}
+
+func testRoutes() route.Config {
+	return route.Config{
+		PathPrefix: "/forward",
+		Routes: map[string]route.Route{
+			"billing": {
+				Name:        "billing",
+				Host:        "https://billing.example.com/v1",
+				Headers:     map[string]string{"Authorization": "Bearer 123"},
+				MaxAttempts: 3,
+				Timeout:     5 * time.Second,
+				RetryRules:  retry.Rules{GiveUpOn: []string{"409"}},
+			},
+			"stock": {
+				Name: "stock",
+				Host: "https://stock.example.com",
+			},
+		},
+		Groups: map[string][]string{
+			"orders": {"billing", "stock"},
+		},
+	}
+}
+
 func generateUID(ctrlr *gomock.Controller, uid string) uniqueid.Generator {
 	generatorMock := uniqueid.NewMockGenerator(ctrlr)
 	generatorMock.
@@ -159,11 +434,12 @@ func syncForwarder(ctrlr *gomock.Controller, expectedUID, expectedMethod, expect
 	return forwarderMock
 }
 
+// completeForwarder expects a synchronous attempt that the retry-policy retries, followed by an asynchronous one
 func completeForwarder(ctrlr *gomock.Controller, status int, respPayload string, err error) forwarder.Forwarder {
 	forwarderMock := forwarder.NewMockForwarder(ctrlr)
 
 	var resp *httpclient.Response = nil
-	if err == nil {
+	if status != 0 {
 		resp = &httpclient.Response{
 			Status:  status,
 			Headers: http.Header{},
@@ -173,7 +449,7 @@ func completeForwarder(ctrlr *gomock.Controller, status int, respPayload string,
 	forwarderMock.
 		EXPECT().
 		Forward(gomock.Any(), gomock.Any()).
-		Return(resp, err)
+		Return(resp, fmt.Errorf("Error forwarding: %w", forwarder.ErrRetryAsync))
 
 	forwarderMock.
 		EXPECT().
@@ -193,3 +469,305 @@ func asyncForwarder(ctrlr *gomock.Controller, taskUID string, err error) forward
 
 	return forwarderMock
 }
+
+func asyncForwarderExpecting(ctrlr *gomock.Controller, expectedMethod, expectedURL string, err error) forwarder.Forwarder {
+	forwarderMock := forwarder.NewMockForwarder(ctrlr)
+
+	forwarderMock.
+		EXPECT().
+		ForwardAsync(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req httpclient.Request) error {
+			if req.Method != expectedMethod || req.URL != expectedURL {
+				return fmt.Errorf("Unexpected request %s %s", req.Method, req.URL)
+			}
+			return err
+		})
+
+	return forwarderMock
+}
+
+func asyncForwarderExpectingRequest(ctrlr *gomock.Controller, expected httpclient.Request) forwarder.Forwarder {
+	forwarderMock := forwarder.NewMockForwarder(ctrlr)
+
+	forwarderMock.
+		EXPECT().
+		ForwardAsync(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req httpclient.Request) error {
+			if req.Method != expected.Method || req.URL != expected.URL || req.Route != expected.Route ||
+				req.MaxAttempts != expected.MaxAttempts || req.Timeout != expected.Timeout || req.CallbackURL != expected.CallbackURL ||
+				fmt.Sprint(req.RetryOn) != fmt.Sprint(expected.RetryOn) || fmt.Sprint(req.GiveUpOn) != fmt.Sprint(expected.GiveUpOn) ||
+				!req.DeliverAt.Equal(expected.DeliverAt) || req.OrderingKey != expected.OrderingKey {
+				return fmt.Errorf("Unexpected request %+v", req)
+			}
+			for name := range expected.Headers {
+				if req.Headers.Get(name) != expected.Headers.Get(name) {
+					return fmt.Errorf("Unexpected header %s: %s", name, req.Headers.Get(name))
+				}
+			}
+			return nil
+		})
+
+	return forwarderMock
+}
+
+func asyncForwarderExpectingDelay(ctrlr *gomock.Controller, expectedDelay time.Duration) forwarder.Forwarder {
+	forwarderMock := forwarder.NewMockForwarder(ctrlr)
+
+	forwarderMock.
+		EXPECT().
+		ForwardAsync(gomock.Any(), gomock.Any()).
+		DoAndReturn(func(_ context.Context, req httpclient.Request) error {
+			delay := time.Until(req.DeliverAt)
+			if delay > expectedDelay || delay < expectedDelay-time.Minute {
+				return fmt.Errorf("Unexpected delay %s", delay)
+			}
+			return nil
+		})
+
+	return forwarderMock
+}
+
+func TestIdempotentSubmission(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// only the first submission is forwarded, under a task-uid that is derived from the key
+	keeper := idempotency.NewKeeper(newMemoryStore(t), time.Hour, envelope.Plaintext())
+	taskUID := keeper.TaskUID("anonymous/idempotency-key/key-1")
+	webservice := NewWebService(nil, asyncForwarder(ctrl, taskUID, nil), testRoutes(), keeper, auth.Anonymous())
+	router := webservice.RegisterEndpoint(mux.NewRouter())
+
+	for i := 0; i < 2; i++ {
+		httpResp := httptest.NewRecorder()
+		router.ServeHTTP(httpResp, httpRequestWithHeaders(t, "POST", "/doit?HostToForwardTo=home.nl", "request body", map[string]string{"Idempotency-Key": "key-1"}))
+		assert.Equal(t, 202, httpResp.Code)
+		assert.Equal(t, taskUID, httpResp.Header().Get("X-TaskUid"))
+	}
+
+	httpResp := httptest.NewRecorder()
+	router.ServeHTTP(httpResp, httpRequestWithHeaders(t, "POST", "/doit?HostToForwardTo=home.nl", "other body", map[string]string{"Idempotency-Key": "key-1"}))
+	assert.Equal(t, 409, httpResp.Code)
+}
+
+func TestIdempotencyKeyScopedToClient(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	keeper := idempotency.NewKeeper(newMemoryStore(t), time.Hour, envelope.Plaintext())
+	assert.NoError(t, keeper.Put(context.Background(), idempotency.Record{Key: "webshop/idempotency-key/key-1", TaskUID: "abc", Fingerprint: testFingerprint(), Response: &httpclient.Response{Status: 201, Body: []byte("secret of webshop")}}))
+	assert.NoError(t, keeper.Put(context.Background(), idempotency.Record{Key: "backoffice/task-uid/key-1", TaskUID: "key-1", Fingerprint: testFingerprint(), Response: &httpclient.Response{Status: 201, Body: []byte("created by task-uid")}}))
+
+	// another client, and an Idempotency-Key that equals a task-uid, are submissions of their own
+	taskUID := keeper.TaskUID("backoffice/idempotency-key/key-1")
+	client := &auth.Client{Name: "backoffice", Hosts: []string{"*"}}
+	webservice := NewWebService(nil, asyncForwarder(ctrl, taskUID, nil), testRoutes(), keeper, authenticate(ctrl, client, nil))
+
+	httpResp := httptest.NewRecorder()
+	webservice.RegisterEndpoint(mux.NewRouter()).ServeHTTP(httpResp, httpRequestWithHeaders(t, "POST", "/doit?HostToForwardTo=home.nl", "request body", map[string]string{"Idempotency-Key": "key-1"}))
+
+	assert.Equal(t, 202, httpResp.Code)
+	assert.Equal(t, taskUID, httpResp.Header().Get("X-TaskUid"))
+	assert.Equal(t, "", httpResp.Body.String())
+}
+
+func TestConcurrentIdempotentSubmission(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// the other submission was enqueued first, and has been remembered meanwhile
+	taskUID := "5c49733e51386f877d7135e6b52745cd"
+	keeperMock := idempotency.NewMockKeeper(ctrl)
+	keeperMock.EXPECT().TaskUID("anonymous/idempotency-key/key-1").Return(taskUID)
+	gomock.InOrder(
+		keeperMock.EXPECT().Get(gomock.Any(), "anonymous/idempotency-key/key-1").Return(nil, false, nil),
+		keeperMock.EXPECT().Get(gomock.Any(), "anonymous/idempotency-key/key-1").Return(&idempotency.Record{Key: "anonymous/idempotency-key/key-1", TaskUID: taskUID, Fingerprint: testFingerprint()}, true, nil),
+	)
+	webservice := NewWebService(nil, asyncForwarder(ctrl, taskUID, fmt.Errorf("Error enqueuing: %w", queue.ErrTaskAlreadyExists)), testRoutes(), keeperMock, auth.Anonymous())
+
+	httpResp := httptest.NewRecorder()
+	webservice.RegisterEndpoint(mux.NewRouter()).ServeHTTP(httpResp, httpRequestWithHeaders(t, "POST", "/doit?HostToForwardTo=home.nl", "request body", map[string]string{"Idempotency-Key": "key-1"}))
+
+	assert.Equal(t, 202, httpResp.Code)
+	assert.Equal(t, taskUID, httpResp.Header().Get("X-TaskUid"))
+}
+
+func testFingerprint() string {
+	return idempotency.Fingerprint(httpclient.Request{Method: "POST", URL: "https://home.nl/doit", Body: []byte("request body")})
+}
+
+func newMemoryStore(t *testing.T) store.DataStorer {
+	s, cleanup, err := store.NewMemoryStore(context.Background())
+	assert.NoError(t, err)
+	t.Cleanup(cleanup)
+	return s
+}
+
+func generateUIDs(ctrlr *gomock.Controller, uid string, times int) uniqueid.Generator {
+	generatorMock := uniqueid.NewMockGenerator(ctrlr)
+	generatorMock.
+		EXPECT().
+		Generate().
+		Return(uid).
+		Times(times)
+	return generatorMock
+}
+
+func idempotencyGet(ctrlr *gomock.Controller, key string, record *idempotency.Record) idempotency.Keeper {
+	keeperMock := idempotency.NewMockKeeper(ctrlr)
+
+	keeperMock.
+		EXPECT().
+		TaskUID(key).
+		Return("5c49733e51386f877d7135e6b52745cd").
+		AnyTimes()
+	keeperMock.
+		EXPECT().
+		Get(gomock.Any(), key).
+		Return(record, record != nil, nil)
+
+	return keeperMock
+}
+
+func TestFanOut(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	testCases := []struct {
+		name                    string
+		request                 *http.Request
+		syncStatuses            map[string]int
+		deniedURLs              []string
+		expectedEnqueued        []httpclient.Request
+		expectedResponseStatus  int
+		expectedResponsePayload string
+	}{
+		{
+			name:    "Repeated hosts",
+			request: httpRequest(t, "POST", "/doit?HostToForwardTo=https://a.nl&HostToForwardTo=https://b.nl", "request body"),
+			expectedEnqueued: []httpclient.Request{
+				{TaskUID: "abc-1", ParentTaskUID: "abc", URL: "https://a.nl/doit"},
+				{TaskUID: "abc-2", ParentTaskUID: "abc", URL: "https://b.nl/doit"},
+			},
+			expectedResponseStatus: 202,
+			expectedResponsePayload: `{"TaskUID":"abc","Children":[` +
+				`{"TaskUID":"abc-1","URL":"https://a.nl/doit","Enqueued":true},` +
+				`{"TaskUID":"abc-2","URL":"https://b.nl/doit","Enqueued":true}]}`,
+		},
+		{
+			name:    "Comma separated hosts with ordering key",
+			request: httpRequest(t, "POST", "/doit?HostToForwardTo=https://a.nl,https://b.nl&OrderingKey=order-1", "request body"),
+			expectedEnqueued: []httpclient.Request{
+				{TaskUID: "abc-1", ParentTaskUID: "abc", URL: "https://a.nl/doit", OrderingKey: "order-1@a.nl"},
+				{TaskUID: "abc-2", ParentTaskUID: "abc", URL: "https://b.nl/doit", OrderingKey: "order-1@b.nl"},
+			},
+			expectedResponseStatus: 202,
+			expectedResponsePayload: `{"TaskUID":"abc","Children":[` +
+				`{"TaskUID":"abc-1","URL":"https://a.nl/doit","Enqueued":true},` +
+				`{"TaskUID":"abc-2","URL":"https://b.nl/doit","Enqueued":true}]}`,
+		},
+		{
+			name:    "Group",
+			request: httpRequest(t, "POST", "/forward/orders/doit", "request body"),
+			expectedEnqueued: []httpclient.Request{
+				{TaskUID: "abc-1", ParentTaskUID: "abc", URL: "https://billing.example.com/v1/doit", Route: "billing", MaxAttempts: 3},
+				{TaskUID: "abc-2", ParentTaskUID: "abc", URL: "https://stock.example.com/doit", Route: "stock"},
+			},
+			expectedResponseStatus: 202,
+			expectedResponsePayload: `{"TaskUID":"abc","Children":[` +
+				`{"TaskUID":"abc-1","URL":"https://billing.example.com/v1/doit","Enqueued":true},` +
+				`{"TaskUID":"abc-2","URL":"https://stock.example.com/doit","Enqueued":true}]}`,
+		},
+		{
+			name:         "Try first: some delivered",
+			request:      httpRequest(t, "POST", "/doit?HostToForwardTo=https://a.nl,https://b.nl&TryFirst=true", "request body"),
+			syncStatuses: map[string]int{"abc-1": 200, "abc-2": 503},
+			expectedEnqueued: []httpclient.Request{
+				{TaskUID: "abc-2", ParentTaskUID: "abc", URL: "https://b.nl/doit"},
+			},
+			expectedResponseStatus: 202,
+			expectedResponsePayload: `{"TaskUID":"abc","Children":[` +
+				`{"TaskUID":"abc-1","URL":"https://a.nl/doit","ResponseStatus":200,"Enqueued":false},` +
+				`{"TaskUID":"abc-2","URL":"https://b.nl/doit","ResponseStatus":503,"Enqueued":true}]}`,
+		},
+		{
+			name:                   "Try first: all delivered",
+			request:                httpRequest(t, "POST", "/doit?HostToForwardTo=https://a.nl,https://b.nl&TryFirst=true", "request body"),
+			syncStatuses:           map[string]int{"abc-1": 200, "abc-2": 204},
+			expectedEnqueued:       []httpclient.Request{},
+			expectedResponseStatus: 200,
+			expectedResponsePayload: `{"TaskUID":"abc","Children":[` +
+				`{"TaskUID":"abc-1","URL":"https://a.nl/doit","ResponseStatus":200,"Enqueued":false},` +
+				`{"TaskUID":"abc-2","URL":"https://b.nl/doit","ResponseStatus":204,"Enqueued":false}]}`,
+		},
+		{
+			name:       "Denied destination",
+			request:    httpRequest(t, "POST", "/doit?HostToForwardTo=https://a.nl,http://localhost", "request body"),
+			deniedURLs: []string{"http://localhost/doit"},
+			expectedEnqueued: []httpclient.Request{
+				{TaskUID: "abc-1", ParentTaskUID: "abc", URL: "https://a.nl/doit"},
+			},
+			expectedResponseStatus: 202,
+			expectedResponsePayload: `{"TaskUID":"abc","Children":[` +
+				`{"TaskUID":"abc-1","URL":"https://a.nl/doit","Enqueued":true},` +
+				`{"TaskUID":"abc-2","URL":"http://localhost/doit","Error":"Destination not allowed: http://localhost/doit is loopback","Enqueued":false}]}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// setup
+			enqueued := []httpclient.Request{}
+			forwarderMock := forwarder.NewMockForwarder(ctrl)
+			for range tc.syncStatuses {
+				forwarderMock.EXPECT().Forward(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, req httpclient.Request) (*httpclient.Response, error) {
+					resp := &httpclient.Response{Status: tc.syncStatuses[req.TaskUID]}
+					if resp.Status >= http.StatusInternalServerError {
+						return resp, fmt.Errorf("Error forwarding: %w", forwarder.ErrRetryAsync)
+					}
+					return resp, nil
+				})
+			}
+			forwarderMock.EXPECT().ForwardAsync(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, req httpclient.Request) error {
+				for _, denied := range tc.deniedURLs {
+					if req.URL == denied {
+						return &destination.DeniedError{Destination: req.URL, Reason: "is loopback"}
+					}
+				}
+				enqueued = append(enqueued, req)
+				return nil
+			}).Times(len(tc.expectedEnqueued) + len(tc.deniedURLs))
+			webservice := NewWebService(generateUID(ctrl, "abc"), forwarderMock, testRoutes(), idempotency.NewKeeper(newMemoryStore(t), time.Hour, envelope.Plaintext()), auth.Anonymous())
+
+			// when
+			httpResp := httptest.NewRecorder()
+			webservice.RegisterEndpoint(mux.NewRouter()).ServeHTTP(httpResp, tc.request)
+
+			// then
+			assert.Equal(t, tc.expectedResponseStatus, httpResp.Code)
+			assert.Equal(t, tc.expectedResponsePayload, httpResp.Body.String())
+			assert.Equal(t, "abc", httpResp.Header().Get("X-TaskUid"))
+			assert.Len(t, enqueued, len(tc.expectedEnqueued))
+			for i, expected := range tc.expectedEnqueued {
+				assert.Equal(t, expected.TaskUID, enqueued[i].TaskUID)
+				assert.Equal(t, expected.ParentTaskUID, enqueued[i].ParentTaskUID)
+				assert.Equal(t, expected.URL, enqueued[i].URL)
+				assert.Equal(t, expected.Route, enqueued[i].Route)
+				assert.Equal(t, expected.MaxAttempts, enqueued[i].MaxAttempts)
+				assert.Equal(t, expected.OrderingKey, enqueued[i].OrderingKey)
+				assert.Equal(t, "anonymous", enqueued[i].Owner)
+				assert.Equal(t, []byte("request body"), enqueued[i].Body)
+			}
+		})
+	}
+}
+
+func authenticate(ctrlr *gomock.Controller, client *auth.Client, err error) auth.Authenticator {
+	authenticatorMock := auth.NewMockAuthenticator(ctrlr)
+
+	authenticatorMock.
+		EXPECT().
+		Authenticate(gomock.Any()).
+		Return(client, err)
+
+	return authenticatorMock
+}
@@ -0,0 +1,24 @@
+package envelope
+
+import (
+	"context"
+)
+
+//go:generate mockgen -source=api.go -destination=gen_EnvelopeMock.go -package=envelope github.com/MarcGrol/forwardhttp/envelope KeyService,Sealer
+
+// KeyService protects data-keys with key-encryption-keys that never leave it, like a KMS does
+type KeyService interface {
+	// WrapKey encrypts a data-key with the primary key-encryption-key and returns the id of that key
+	WrapKey(c context.Context, dataKey []byte) (string, []byte, error)
+	// UnwrapKey decrypts a data-key with the key-encryption-key it was wrapped with, also after rotation
+	UnwrapKey(c context.Context, keyID string, wrappedKey []byte) ([]byte, error)
+}
+
+type Sealer interface {
+	// Seal encrypts data with a fresh data-key, that is kept wrapped alongside the ciphertext
+	Seal(c context.Context, plaintext []byte) ([]byte, error)
+	// Open decrypts data that was sealed
+	Open(c context.Context, data []byte) ([]byte, error)
+	// Encrypts tells whether Seal encrypts; records remember it, because their data could look sealed by chance
+	Encrypts() bool
+}
@@ -0,0 +1,171 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/MarcGrol/forwardhttp/config"
+)
+
+const (
+	dataKeySize = 32 // AES-256
+	// sealedPrefix tells sealed data apart from data that was stored before encryption was enabled
+	sealedPrefix = "forwardhttp:sealed:v1:"
+)
+
+// sealed is the envelope: the ciphertext together with the wrapped data-key that decrypts it
+type sealed struct {
+	KeyID      string `json:"kid"`
+	WrappedKey []byte `json:"key"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"data"`
+}
+
+type envelopeSealer struct {
+	keys KeyService
+}
+
+// NewFromSettings encrypts with the keys of ENCRYPTION_KEYRING_FILE; without it, data is stored as is
+func NewFromSettings(settings config.Settings) (Sealer, error) {
+	filename := settings.Get("ENCRYPTION_KEYRING_FILE")
+	if filename == "" {
+		log.Printf("No ENCRYPTION_KEYRING_FILE configured: payloads are stored unencrypted")
+		return Plaintext(), nil
+	}
+	keyring, err := LoadKeyring(filename)
+	if err != nil {
+		return nil, err
+	}
+	return New(keyring), nil
+}
+
+func New(keys KeyService) Sealer {
+	return &envelopeSealer{
+		keys: keys,
+	}
+}
+
+func (s *envelopeSealer) Seal(c context.Context, plaintext []byte) ([]byte, error) {
+	if len(plaintext) == 0 {
+		return plaintext, nil
+	}
+	dataKey := make([]byte, dataKeySize)
+	_, err := rand.Read(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("Error generating data-key: %s", err)
+	}
+	keyID, wrappedKey, err := s.keys.WrapKey(c, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("Error wrapping data-key: %s", err)
+	}
+	nonce, ciphertext, err := encrypt(dataKey, plaintext)
+	if err != nil {
+		return nil, fmt.Errorf("Error encrypting: %s", err)
+	}
+	envelope, err := json.Marshal(sealed{KeyID: keyID, WrappedKey: wrappedKey, Nonce: nonce, Ciphertext: ciphertext})
+	if err != nil {
+		return nil, fmt.Errorf("Error encoding envelope: %s", err)
+	}
+	return append([]byte(sealedPrefix), envelope...), nil
+}
+
+func (s *envelopeSealer) Open(c context.Context, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	envelope, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := s.keys.UnwrapKey(c, envelope.KeyID, envelope.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("Error unwrapping data-key: %s", err)
+	}
+	plaintext, err := decrypt(dataKey, envelope.Nonce, envelope.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("Error decrypting: %s", err)
+	}
+	return plaintext, nil
+}
+
+func (s *envelopeSealer) Encrypts() bool {
+	return true
+}
+
+// IsSealed tells whether data looks encrypted by a sealer; only reliable for data that is never supplied by clients
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(sealedPrefix))
+}
+
+func parse(data []byte) (*sealed, error) {
+	if !IsSealed(data) {
+		return nil, fmt.Errorf("Error decoding envelope: data is not sealed")
+	}
+	envelope := &sealed{}
+	err := json.Unmarshal(bytes.TrimPrefix(data, []byte(sealedPrefix)), envelope)
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding envelope: %s", err)
+	}
+	return envelope, nil
+}
+
+func encrypt(key, plaintext []byte) ([]byte, []byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+	return nonce, aead.Seal(nil, nonce, plaintext, nil), nil
+}
+
+func decrypt(key, nonce, ciphertext []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce")
+	}
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+type plaintext struct{}
+
+// Plaintext stores data as is, but refuses to pretend it can read sealed data
+func Plaintext() Sealer {
+	return plaintext{}
+}
+
+func (plaintext) Seal(c context.Context, data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// Open is only asked for data that was sealed with keys
+func (plaintext) Open(c context.Context, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return data, nil
+	}
+	return nil, fmt.Errorf("Error decrypting: data is encrypted, but no keys are configured")
+}
+
+func (plaintext) Encrypts() bool {
+	return false
+}
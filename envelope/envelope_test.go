@@ -0,0 +1,177 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/MarcGrol/forwardhttp/config"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 32)
+	newKey = bytes.Repeat([]byte{2}, 32)
+)
+
+func TestSealAndOpen(t *testing.T) {
+	c := context.Background()
+	keyring, err := NewKeyring("old", map[string][]byte{"old": oldKey})
+	assert.NoError(t, err)
+	sealer := New(keyring)
+
+	sealed, err := sealer.Seal(c, []byte(`{"email":"john@example.com"}`))
+	assert.NoError(t, err)
+	assert.True(t, IsSealed(sealed))
+	assert.NotContains(t, string(sealed), "john@example.com")
+
+	opened, err := sealer.Open(c, sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"email":"john@example.com"}`, string(opened))
+
+	// every seal uses a fresh data-key and nonce
+	other, err := sealer.Seal(c, []byte(`{"email":"john@example.com"}`))
+	assert.NoError(t, err)
+	assert.NotEqual(t, sealed, other)
+
+	// stored before encryption was enabled: records must not ask to open it
+	_, err = sealer.Open(c, []byte(`{"email":"john@example.com"}`))
+	assert.EqualError(t, err, "Error decoding envelope: data is not sealed")
+	assert.True(t, sealer.Encrypts())
+
+	sealed, err = sealer.Seal(c, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, sealed)
+}
+
+func TestOpenTampered(t *testing.T) {
+	c := context.Background()
+	keyring, err := NewKeyring("old", map[string][]byte{"old": oldKey})
+	assert.NoError(t, err)
+	sealer := New(keyring)
+
+	sealed, err := sealer.Seal(c, []byte("payload"))
+	assert.NoError(t, err)
+	tampered := bytes.Replace(sealed, []byte(`"data":"`), []byte(`"data":"A`), 1)
+	_, err = sealer.Open(c, tampered)
+	assert.Error(t, err)
+
+	_, err = sealer.Open(c, []byte(sealedPrefix+"not json"))
+	assert.Error(t, err)
+}
+
+func TestKeyRotation(t *testing.T) {
+	c := context.Background()
+	before, err := NewKeyring("old", map[string][]byte{"old": oldKey})
+	assert.NoError(t, err)
+	sealedBefore, err := New(before).Seal(c, []byte("before rotation"))
+	assert.NoError(t, err)
+
+	after, err := NewKeyring("new", map[string][]byte{"old": oldKey, "new": newKey})
+	assert.NoError(t, err)
+	sealer := New(after)
+	sealedAfter, err := sealer.Seal(c, []byte("after rotation"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(sealedAfter), `"kid":"new"`)
+
+	opened, err := sealer.Open(c, sealedBefore)
+	assert.NoError(t, err)
+	assert.Equal(t, "before rotation", string(opened))
+	opened, err = sealer.Open(c, sealedAfter)
+	assert.NoError(t, err)
+	assert.Equal(t, "after rotation", string(opened))
+
+	// once the old key is removed, older data cannot be read anymore
+	retired, err := NewKeyring("new", map[string][]byte{"new": newKey})
+	assert.NoError(t, err)
+	_, err = New(retired).Open(c, sealedBefore)
+	assert.EqualError(t, err, "Error unwrapping data-key: unknown key 'old'")
+}
+
+func TestPlaintext(t *testing.T) {
+	c := context.Background()
+	sealer := Plaintext()
+
+	sealed, err := sealer.Seal(c, []byte("payload"))
+	assert.NoError(t, err)
+	assert.Equal(t, "payload", string(sealed))
+	assert.False(t, sealer.Encrypts())
+
+	// clients may send anything
+	sealed, err = sealer.Seal(c, []byte(sealedPrefix+"payload"))
+	assert.NoError(t, err)
+	assert.Equal(t, sealedPrefix+"payload", string(sealed))
+
+	keyring, err := NewKeyring("old", map[string][]byte{"old": oldKey})
+	assert.NoError(t, err)
+	sealed, err = New(keyring).Seal(c, []byte("payload"))
+	assert.NoError(t, err)
+	_, err = sealer.Open(c, sealed)
+	assert.EqualError(t, err, "Error decrypting: data is encrypted, but no keys are configured")
+}
+
+func TestNewFromSettings(t *testing.T) {
+	dir := t.TempDir()
+	writeKeyring := func(name, content string) string {
+		filename := filepath.Join(dir, name)
+		assert.NoError(t, os.WriteFile(filename, []byte(content), 0600))
+		return filename
+	}
+	valid := writeKeyring("valid.json", `{"Primary": "new", "Keys": {"old": "`+base64.StdEncoding.EncodeToString(oldKey)+`", "new": "`+base64.StdEncoding.EncodeToString(newKey)+`"}}`)
+	unknownPrimary := writeKeyring("primary.json", `{"Primary": "other", "Keys": {"old": "`+base64.StdEncoding.EncodeToString(oldKey)+`"}}`)
+	shortKey := writeKeyring("short.json", `{"Primary": "old", "Keys": {"old": "c2hvcnQ="}}`)
+	notBase64 := writeKeyring("base64.json", `{"Primary": "old", "Keys": {"old": "!"}}`)
+
+	testCases := []struct {
+		name          string
+		settings      map[string]string
+		expected      Sealer
+		expectedError string
+	}{
+		{
+			name:     "Without keyring",
+			settings: map[string]string{},
+			expected: Plaintext(),
+		},
+		{
+			name:     "Keyring",
+			settings: map[string]string{"ENCRYPTION_KEYRING_FILE": valid},
+			expected: &envelopeSealer{},
+		},
+		{
+			name:          "Missing keyring",
+			settings:      map[string]string{"ENCRYPTION_KEYRING_FILE": filepath.Join(dir, "missing.json")},
+			expectedError: "Error reading keyring " + filepath.Join(dir, "missing.json") + ": open " + filepath.Join(dir, "missing.json") + ": no such file or directory",
+		},
+		{
+			name:          "Unknown primary",
+			settings:      map[string]string{"ENCRYPTION_KEYRING_FILE": unknownPrimary},
+			expectedError: "Invalid keyring " + unknownPrimary + ": primary key 'other' not found",
+		},
+		{
+			name:          "Short key",
+			settings:      map[string]string{"ENCRYPTION_KEYRING_FILE": shortKey},
+			expectedError: "Invalid keyring " + shortKey + ": key 'old' must be 32 bytes",
+		},
+		{
+			name:          "Not base64",
+			settings:      map[string]string{"ENCRYPTION_KEYRING_FILE": notBase64},
+			expectedError: "Invalid key 'old' in keyring " + notBase64 + ": not base64 encoded",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sealer, err := NewFromSettings(config.New(tc.settings))
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.IsType(t, tc.expected, sealer)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package envelope
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// keyring is a local stand-in for a KMS, that keeps its key-encryption-keys in a file
+type keyring struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// keyringFile holds base64 encoded keys of 32 bytes by id, like:
+//
+//	{"Primary": "2024-06", "Keys": {"2024-01": "<base64>", "2024-06": "<base64>"}}
+//
+// To rotate, add a key and make it primary: new data is sealed with it, while older data remains readable
+// as long as the key it was sealed with is kept.
+type keyringFile struct {
+	Primary string
+	Keys    map[string]string
+}
+
+func LoadKeyring(filename string) (KeyService, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading keyring %s: %s", filename, err)
+	}
+	var file keyringFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing keyring %s: %s", filename, err)
+	}
+	keys := map[string][]byte{}
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid key '%s' in keyring %s: not base64 encoded", id, filename)
+		}
+		keys[id] = key
+	}
+	keyring, err := NewKeyring(file.Primary, keys)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid keyring %s: %s", filename, err)
+	}
+	return keyring, nil
+}
+
+// NewKeyring wraps with the primary key; all keys can unwrap
+func NewKeyring(primary string, keys map[string][]byte) (KeyService, error) {
+	for id, key := range keys {
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("key '%s' must be %d bytes", id, dataKeySize)
+		}
+	}
+	if _, found := keys[primary]; !found {
+		return nil, fmt.Errorf("primary key '%s' not found", primary)
+	}
+	return &keyring{
+		primary: primary,
+		keys:    keys,
+	}, nil
+}
+
+func (k *keyring) WrapKey(c context.Context, dataKey []byte) (string, []byte, error) {
+	nonce, ciphertext, err := encrypt(k.keys[k.primary], dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+	return k.primary, append(nonce, ciphertext...), nil
+}
+
+func (k *keyring) UnwrapKey(c context.Context, keyID string, wrappedKey []byte) ([]byte, error) {
+	key, found := k.keys[keyID]
+	if !found {
+		return nil, fmt.Errorf("unknown key '%s'", keyID)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrappedKey) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid wrapped key")
+	}
+	return aead.Open(nil, wrappedKey[:aead.NonceSize()], wrappedKey[aead.NonceSize():], nil)
+}
@@ -2,13 +2,25 @@ package forwarder
 
 import (
 	"context"
+	"errors"
 
 	"github.com/MarcGrol/forwardhttp/httpclient"
 )
 
 //go:generate mockgen -source=api.go -destination=gen_ForwarderClientMock.go -package=forwarder github.com/MarcGrol/forwardhttp/forwarder Forwarder
 
+// ErrTaskCompleted is returned when cancelling a task that was already delivered, given up on or cancelled
+var ErrTaskCompleted = errors.New("Task already completed")
+
+// ErrRetryAsync is returned by Forward when the retry-policy decides that the request is to be retried:
+// the caller continues asynchronously
+var ErrRetryAsync = errors.New("Request is to be retried asynchronously")
+
+// ErrPredecessorStuck is recorded when a request with an ordering-key is given up on, because the request before it did not complete
+var ErrPredecessorStuck = errors.New("Predecessor did not complete in time")
+
 type Forwarder interface {
 	Forward(c context.Context, req httpclient.Request) (*httpclient.Response, error)
 	ForwardAsync(c context.Context, req httpclient.Request) error
+	Cancel(c context.Context, taskUID string) error
 }
@@ -0,0 +1,82 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/MarcGrol/forwardhttp/httpclient"
+	"github.com/MarcGrol/forwardhttp/warehouse"
+)
+
+const callbackTaskUIDSuffix = "-callback"
+
+// Outcome is POSTed to the callback-url of a request once it has been delivered or given up on
+type Outcome struct {
+	TaskUID         string
+	Status          warehouse.TaskState
+	Attempts        int32
+	ResponseStatus  int         `json:",omitempty"`
+	ResponseHeaders http.Header `json:",omitempty"`
+	ResponseBody    string      `json:",omitempty"`
+	Error           string      `json:",omitempty"`
+}
+
+// onLastDelivery informs the last-deliverer and enqueues the callback, if the caller asked for one
+func (s *forwarderService) onLastDelivery(c context.Context, httpReq httpclient.Request, httpResp *httpclient.Response, err error, attempts int32) {
+	s.lastDelivery.OnLastDelivery(c, httpReq, httpResp, err)
+	s.callback(c, httpReq, httpResp, err, attempts)
+}
+
+func (s *forwarderService) callback(c context.Context, httpReq httpclient.Request, httpResp *httpclient.Response, err error, attempts int32) {
+	if httpReq.CallbackURL == "" {
+		return
+	}
+	callbackReq, err := composeCallback(httpReq, httpResp, err, attempts)
+	if err != nil {
+		log.Printf("Error composing callback for %s: %s", httpReq, err)
+		return
+	}
+	// failing to enqueue the callback should not cause the original request to be retried;
+	// the destination-policy applies to the callback like to any other request
+	err = s.ForwardAsync(c, callbackReq)
+	if err != nil {
+		log.Printf("Error enqueuing callback for %s: %s", httpReq, err)
+	}
+}
+
+func composeCallback(httpReq httpclient.Request, httpResp *httpclient.Response, err error, attempts int32) (httpclient.Request, error) {
+	outcome := Outcome{
+		TaskUID:  httpReq.TaskUID,
+		Status:   warehouse.TaskStateDelivered,
+		Attempts: attempts,
+	}
+	if err != nil {
+		outcome.Status = warehouse.TaskStateFailed
+		outcome.Error = err.Error()
+	}
+	if httpResp != nil {
+		if httpResp.IsError() {
+			outcome.Status = warehouse.TaskStateFailed
+		}
+		outcome.ResponseStatus = httpResp.Status
+		outcome.ResponseHeaders = httpResp.Headers
+		outcome.ResponseBody = string(httpResp.Body)
+	}
+
+	body, err := json.Marshal(outcome)
+	if err != nil {
+		return httpclient.Request{}, fmt.Errorf("Error marshalling outcome: %s", err)
+	}
+
+	return httpclient.Request{
+		TaskUID: httpReq.TaskUID + callbackTaskUIDSuffix,
+		Method:  http.MethodPost,
+		URL:     httpReq.CallbackURL,
+		Headers: http.Header{"Content-Type": []string{"application/json"}},
+		Body:    body,
+		Owner:   httpReq.Owner,
+	}, nil
+}
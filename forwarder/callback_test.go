@@ -0,0 +1,140 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MarcGrol/forwardhttp/destination"
+	"github.com/MarcGrol/forwardhttp/envelope"
+	"github.com/MarcGrol/forwardhttp/httpclient"
+	"github.com/MarcGrol/forwardhttp/lastdelivery"
+	"github.com/MarcGrol/forwardhttp/queue"
+	"github.com/MarcGrol/forwardhttp/retry"
+	"github.com/MarcGrol/forwardhttp/warehouse"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallback(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	testCases := []struct {
+		name            string
+		httpClient      httpclient.HTTPSender
+		numAttempts     int32
+		maxAttempts     int32
+		expectedOutcome *Outcome
+	}{
+		{
+			name:            "Delivered",
+			httpClient:      httpClient(ctrl, 200, "success response", nil),
+			numAttempts:     2,
+			maxAttempts:     10,
+			expectedOutcome: &Outcome{TaskUID: "abc", Status: warehouse.TaskStateDelivered, Attempts: 2, ResponseStatus: 200, ResponseBody: "success response"},
+		},
+		{
+			name:            "Given up",
+			httpClient:      httpClient(ctrl, 0, "", fmt.Errorf("network error")),
+			numAttempts:     10,
+			maxAttempts:     10,
+			expectedOutcome: &Outcome{TaskUID: "abc", Status: warehouse.TaskStateFailed, Attempts: 10, Error: "network error"},
+		},
+		{
+			name:            "Not final",
+			httpClient:      httpClient(ctrl, 503, "error response", nil),
+			numAttempts:     2,
+			maxAttempts:     10,
+			expectedOutcome: nil,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// setup
+			queueMock := queue.NewMockTaskQueuer(ctrl)
+			queueMock.EXPECT().IsLastAttempt(gomock.Any(), "abc").Return(tc.numAttempts, tc.maxAttempts)
+			warehouseMock := warehouse.NewMockWarehouser(ctrl)
+			warehouseMock.EXPECT().Get(gomock.Any(), "abc").Return(nil, false, nil)
+			warehouseMock.EXPECT().Put(gomock.Any(), gomock.Any()).Return(nil)
+			lastDelivererMock := lastdelivery.NewMockLastDeliverer(ctrl)
+			var enqueued *queue.Task
+			if tc.expectedOutcome != nil {
+				queueMock.EXPECT().Enqueue(gomock.Any(), gomock.Any()).DoAndReturn(func(_ interface{}, task queue.Task) error {
+					enqueued = &task
+					return nil
+				})
+				warehouseMock.EXPECT().Put(gomock.Any(), gomock.Any()).Return(nil)
+				lastDelivererMock.EXPECT().OnLastDelivery(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any())
+			}
+			service := NewService(queueMock, tc.httpClient, warehouseMock, lastDelivererMock, retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true}, trustedQueue(ctrl), envelope.Plaintext())
+
+			// when
+			httpResp := httptest.NewRecorder()
+			service.RegisterEndPoint(mux.NewRouter()).ServeHTTP(httpResp, callbackRequest(t))
+
+			// then
+			if tc.expectedOutcome == nil {
+				assert.Nil(t, enqueued)
+				return
+			}
+			assert.NotNil(t, enqueued)
+			assert.Equal(t, "abc-callback", enqueued.UID)
+			var callbackReq httpclient.Request
+			assert.NoError(t, json.Unmarshal(enqueued.Payload, &callbackReq))
+			assert.Equal(t, "POST", callbackReq.Method)
+			assert.Equal(t, "https://caller.nl/done", callbackReq.URL)
+			assert.Equal(t, "", callbackReq.CallbackURL)
+			var outcome Outcome
+			assert.NoError(t, json.Unmarshal(callbackReq.Body, &outcome))
+			assert.Equal(t, *tc.expectedOutcome, outcome)
+		})
+	}
+}
+
+func TestCallbackToDeniedDestination(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	queueMock := queue.NewMockTaskQueuer(ctrl)
+	queueMock.EXPECT().IsLastAttempt(gomock.Any(), "abc").Return(int32(1), int32(10))
+	warehouseMock := warehouse.NewMockWarehouser(ctrl)
+	warehouseMock.EXPECT().Get(gomock.Any(), "abc").Return(nil, false, nil)
+	states := map[string]warehouse.TaskState{}
+	warehouseMock.EXPECT().Put(gomock.Any(), gomock.Any()).DoAndReturn(func(_ interface{}, summary warehouse.ForwardSummary) error {
+		states[summary.HttpRequest.TaskUID] = summary.State
+		return nil
+	}).Times(2)
+	lastDelivererMock := lastdelivery.NewMockLastDeliverer(ctrl)
+	lastDelivererMock.EXPECT().OnLastDelivery(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any())
+	service := NewService(queueMock, httpClient(ctrl, 200, "success response", nil), warehouseMock, lastDelivererMock, retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true, DenyHosts: []string{"caller.nl"}}, trustedQueue(ctrl), envelope.Plaintext())
+
+	httpResp := httptest.NewRecorder()
+	service.RegisterEndPoint(mux.NewRouter()).ServeHTTP(httpResp, callbackRequest(t))
+
+	assert.Equal(t, 200, httpResp.Code)
+	// the callback is rejected instead of enqueued
+	assert.Equal(t, map[string]warehouse.TaskState{"abc": warehouse.TaskStateDelivered, "abc-callback": warehouse.TaskStateRejected}, states)
+}
+
+func callbackRequest(t *testing.T) *http.Request {
+	jsonPayload, err := json.Marshal(httpclient.Request{
+		TaskUID:     "abc",
+		Method:      "POST",
+		URL:         "https://home.nl/doit",
+		Body:        []byte("request payload"),
+		CallbackURL: "https://caller.nl/done",
+	})
+	assert.NoError(t, err)
+	httpReq, err := http.NewRequest("POST", "/_ah/tasks/doSend", bytes.NewReader(jsonPayload))
+	if err != nil {
+		t.Fatalf("Error creating http-request: %s", err)
+	}
+	httpReq.RequestURI = "/_ah/tasks/doSend"
+	return httpReq
+}
@@ -34,6 +34,20 @@ func (m *MockForwarder) EXPECT() *MockForwarderMockRecorder {
 	return m.recorder
 }
 
+// Cancel mocks base method
+func (m *MockForwarder) Cancel(c context.Context, taskUID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Cancel", c, taskUID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Cancel indicates an expected call of Cancel
+func (mr *MockForwarderMockRecorder) Cancel(c, taskUID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Cancel", reflect.TypeOf((*MockForwarder)(nil).Cancel), c, taskUID)
+}
+
 // Forward mocks base method
 func (m *MockForwarder) Forward(c context.Context, req httpclient.Request) (*httpclient.Response, error) {
 	m.ctrl.T.Helper()
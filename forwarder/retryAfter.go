@@ -0,0 +1,54 @@
+package forwarder
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/httpclient"
+)
+
+// maxRetryAfter protects against hosts that ask to come back in a distant future
+const maxRetryAfter = 24 * time.Hour
+
+// retryAfter returns when the host asked to be retried, as indicated by the Retry-After header of a 429 or 503 response
+func retryAfter(httpResp *httpclient.Response, now time.Time) (time.Time, bool) {
+	if httpResp == nil || (httpResp.Status != http.StatusTooManyRequests && httpResp.Status != http.StatusServiceUnavailable) {
+		return time.Time{}, false
+	}
+	at, ok := parseRetryAfter(httpResp.Headers.Get("Retry-After"), now)
+	if !ok {
+		return time.Time{}, false
+	}
+	if at.Sub(now) > maxRetryAfter {
+		at = now.Add(maxRetryAfter)
+	}
+	return at, true
+}
+
+// parseRetryAfter supports both delta-seconds ("120") and http-dates ("Wed, 21 Oct 2015 07:28:00 GMT")
+func parseRetryAfter(value string, now time.Time) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err == nil {
+		if seconds < 0 {
+			return time.Time{}, false
+		}
+		if seconds > int64(maxRetryAfter/time.Second) {
+			seconds = int64(maxRetryAfter / time.Second)
+		}
+		return now.Add(time.Duration(seconds) * time.Second), true
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if at.Before(now) {
+		return now, true
+	}
+	return at, true
+}
@@ -0,0 +1,43 @@
+package forwarder
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/httpclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2021, time.November, 11, 10, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name          string
+		status        int
+		retryAfter    string
+		expectedFound bool
+		expectedAt    time.Time
+	}{
+		{name: "Delta seconds", status: 429, retryAfter: "120", expectedFound: true, expectedAt: now.Add(2 * time.Minute)},
+		{name: "Http date", status: 503, retryAfter: "Thu, 11 Nov 2021 10:05:00 GMT", expectedFound: true, expectedAt: now.Add(5 * time.Minute)},
+		{name: "Http date in the past", status: 503, retryAfter: "Thu, 11 Nov 2021 09:00:00 GMT", expectedFound: true, expectedAt: now},
+		{name: "Too far in the future", status: 429, retryAfter: "999999999", expectedFound: true, expectedAt: now.Add(maxRetryAfter)},
+		{name: "Negative", status: 429, retryAfter: "-1", expectedFound: false},
+		{name: "Invalid", status: 429, retryAfter: "soon", expectedFound: false},
+		{name: "Missing", status: 429, retryAfter: "", expectedFound: false},
+		{name: "Other status", status: 500, retryAfter: "120", expectedFound: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp := &httpclient.Response{Status: tc.status, Headers: http.Header{}}
+			if tc.retryAfter != "" {
+				resp.Headers.Set("Retry-After", tc.retryAfter)
+			}
+			at, found := retryAfter(resp, now)
+			assert.Equal(t, tc.expectedFound, found)
+			assert.True(t, tc.expectedAt.Equal(at), "expected %s, got %s", tc.expectedAt, at)
+		})
+	}
+}
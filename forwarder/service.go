@@ -3,14 +3,23 @@ package forwarder
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/MarcGrol/forwardhttp/lastdelivery"
 
+	"github.com/MarcGrol/forwardhttp/circuitbreaker"
+	"github.com/MarcGrol/forwardhttp/destination"
+	"github.com/MarcGrol/forwardhttp/envelope"
 	"github.com/MarcGrol/forwardhttp/httpclient"
 	"github.com/MarcGrol/forwardhttp/queue"
+	"github.com/MarcGrol/forwardhttp/queueauth"
+	"github.com/MarcGrol/forwardhttp/ratelimit"
+	"github.com/MarcGrol/forwardhttp/retry"
 	"github.com/MarcGrol/forwardhttp/warehouse"
 	"github.com/gorilla/mux"
 )
@@ -20,6 +29,13 @@ const (
 	taskEndpointFORWARDPath = "/doSend"
 
 	taskEndpointURL = taskEndpointBaseURL + taskEndpointFORWARDPath
+
+	// orderingDeferral is how long a request first waits before checking its predecessor again;
+	// the wait doubles on every check, up to maxOrderingDeferral
+	orderingDeferral    = 5 * time.Second
+	maxOrderingDeferral = 5 * time.Minute
+	// maxOrderingDeferrals gives up on a request after its predecessor did not complete for about a day
+	maxOrderingDeferrals = 300
 )
 
 type forwarderService struct {
@@ -27,14 +43,22 @@ type forwarderService struct {
 	httpClient   httpclient.HTTPSender
 	warehouse    warehouse.Warehouser
 	lastDelivery lastdelivery.LastDeliverer
+	retryPolicy  retry.Policy
+	destinations destination.Checker
+	queueAuth    queueauth.Verifier
+	sealer       envelope.Sealer
 }
 
-func NewService(queue queue.TaskQueuer, httpClient httpclient.HTTPSender, warehouse warehouse.Warehouser, lastDelivery lastdelivery.LastDeliverer) *forwarderService {
+func NewService(queue queue.TaskQueuer, httpClient httpclient.HTTPSender, warehouse warehouse.Warehouser, lastDelivery lastdelivery.LastDeliverer, retryPolicy retry.Policy, destinations destination.Checker, queueAuth queueauth.Verifier, sealer envelope.Sealer) *forwarderService {
 	s := &forwarderService{
 		queue:        queue,
 		httpClient:   httpClient,
 		warehouse:    warehouse,
 		lastDelivery: lastDelivery,
+		retryPolicy:  retryPolicy,
+		destinations: destinations,
+		queueAuth:    queueAuth,
+		sealer:       sealer,
 	}
 	return s
 }
@@ -46,8 +70,34 @@ func (s *forwarderService) RegisterEndPoint(router *mux.Router) *mux.Router {
 }
 
 func (s *forwarderService) Forward(c context.Context, httpReq httpclient.Request) (*httpclient.Response, error) {
+	err := s.destinations.Check(c, httpReq.URL)
+	if err != nil {
+		s.reject(c, httpReq, err)
+		return nil, fmt.Errorf("Error forwarding %s: %w", httpReq, err)
+	}
+	started := time.Now()
 	httpResp, err := s.httpClient.Send(c, httpReq)
-	defer s.warehouse.Put(c, warehouse.ForwardSummary{HttpRequest: httpReq, HttpResponse: httpResp, Error: err, Stats: warehouse.Stats{RetryCount: 0, MaxRetryCount: 0}})
+	latency := time.Since(started)
+	if _, heldBack := heldBackUntil(err); heldBack {
+		// not an attempt: let the entrypoint continue asynchronously
+		log.Printf("Not forwarding %s: %s", httpReq, err)
+		return &httpclient.Response{Status: http.StatusServiceUnavailable, Body: []byte(err.Error())}, fmt.Errorf("Error forwarding %s: %s: %w", httpReq, err, ErrRetryAsync)
+	}
+	if errors.Is(err, destination.ErrDenied) {
+		s.reject(c, httpReq, err)
+		return nil, err
+	}
+	decision := s.policyFor(httpReq).Decide(httpResp, err)
+	state := syncState(decision)
+	defer s.record(c, warehouse.ForwardSummary{HttpRequest: httpReq, HttpResponse: httpResp, Error: err, Stats: warehouse.Stats{RetryCount: 0, MaxRetryCount: 0}, State: state, Latency: latency})
+	if state.IsCompleted() {
+		// no asynchronous attempts will follow
+		s.callback(c, httpReq, httpResp, err, 1)
+	}
+	if decision == retry.Retry {
+		log.Printf("Forwarding error %s: %s: retrying asynchronously", httpReq, describeFailure(httpResp, err))
+		return httpResp, fmt.Errorf("Error forwarding %s: %s: %w", httpReq, describeFailure(httpResp, err), ErrRetryAsync)
+	}
 	if err != nil {
 		log.Printf("Forwarding error %s: %s", httpReq, err)
 		return nil, err
@@ -62,71 +112,401 @@ func (s *forwarderService) Forward(c context.Context, httpReq httpclient.Request
 	return httpResp, nil
 }
 
+// syncState reflects what the entrypoint does with the outcome: only what the retry-policy retries continues asynchronously
+func syncState(decision retry.Decision) warehouse.TaskState {
+	switch decision {
+	case retry.Success:
+		return warehouse.TaskStateDelivered
+	case retry.Retry:
+		return warehouse.TaskStateRetrying
+	default:
+		return warehouse.TaskStateFailed
+	}
+}
+
 func (s *forwarderService) ForwardAsync(c context.Context, req httpclient.Request) error {
+	err := s.destinations.Check(c, req.URL)
+	if err != nil {
+		s.reject(c, req, err)
+		return fmt.Errorf("Error enqueuing %s: %w", req, err)
+	}
+	if req.OrderingKey != "" {
+		return s.enqueueOrdered(c, req)
+	}
 	return s.enqueue(c, req)
 }
 
+// enqueueOrdered records the task as pending before it becomes the last one of its ordering-key,
+// so that a successor never mistakes its unknown predecessor for a completed one.
+func (s *forwarderService) enqueueOrdered(c context.Context, req httpclient.Request) error {
+	err := s.warehouse.Put(c, warehouse.ForwardSummary{HttpRequest: req, State: warehouse.TaskStatePending})
+	if err != nil {
+		return fmt.Errorf("Error ordering %s: %s", req, err)
+	}
+	predecessor, err := s.warehouse.AppendToOrderingKey(c, req.OrderingKey, req.TaskUID)
+	if err != nil {
+		return fmt.Errorf("Error ordering %s: %w", req, err)
+	}
+	req.Predecessor = predecessor
+
+	err = s.enqueue(c, req)
+	if err != nil && !errors.Is(err, queue.ErrTaskAlreadyExists) {
+		// successors must neither wait for nor follow a task that will never be dispatched
+		removeErr := s.warehouse.RemoveFromOrderingKey(c, req.OrderingKey, req.TaskUID, predecessor)
+		if removeErr != nil {
+			log.Printf("Error removing %s from its ordering-key: %s", req, removeErr)
+		}
+		s.reject(c, req, err)
+	}
+	return err
+}
+
+// reject records a request that may not be sent to its destination
+func (s *forwarderService) reject(c context.Context, httpReq httpclient.Request, err error) {
+	log.Printf("Rejected %s: %s", httpReq, err)
+	putErr := s.warehouse.Put(c, warehouse.ForwardSummary{HttpRequest: httpReq, Error: err, State: warehouse.TaskStateRejected})
+	if putErr != nil {
+		log.Printf("Error recording rejection of %s: %s", httpReq, putErr)
+	}
+}
+
 func (s *forwarderService) enqueue(c context.Context, httpRequest httpclient.Request) error {
+	return s.enqueueAt(c, httpRequest.TaskUID, httpRequest, httpRequest.DeliverAt)
+}
 
-	taskPayload, err := json.Marshal(httpRequest)
+// Cancel removes a request from the queue before it is delivered.
+// A cancel-marker is left in the warehouse, so that a dispatch that is already in flight or rescheduled becomes a no-op.
+func (s *forwarderService) Cancel(c context.Context, taskUID string) error {
+	summary, found, err := s.warehouse.Get(c, taskUID)
+	if err != nil {
+		return fmt.Errorf("Error cancelling task %s: %s", taskUID, err)
+	}
+	if found && summary.State.IsCompleted() {
+		return fmt.Errorf("Error cancelling task %s: %w", taskUID, ErrTaskCompleted)
+	}
+
+	err = s.queue.Delete(c, taskUID)
+	if err != nil && (!errors.Is(err, queue.ErrTaskNotFound) || !found) {
+		// without a known pending task, there is nothing to cancel
+		return fmt.Errorf("Error cancelling task %s: %w", taskUID, err)
+	}
+
+	err = s.warehouse.Put(c, warehouse.ForwardSummary{HttpRequest: httpclient.Request{TaskUID: taskUID}, State: warehouse.TaskStateCancelled})
+	if err != nil {
+		return fmt.Errorf("Error cancelling task %s: %s", taskUID, err)
+	}
+
+	log.Printf("Cancelled task %s", taskUID)
+
+	return nil
+}
+
+// isCancelled looks for the cancel-marker of a task
+func (s *forwarderService) isCancelled(c context.Context, taskUID string) bool {
+	summary, found, err := s.warehouse.Get(c, taskUID)
+	if err != nil {
+		log.Printf("Error checking cancellation of task %s: %s", taskUID, err)
+		return false
+	}
+	return found && summary.State == warehouse.TaskStateCancelled
+}
+
+// enqueueAt uses a separate queue-task-uid, because a queue refuses to re-use the uid of a task it already knows
+func (s *forwarderService) enqueueAt(c context.Context, queueTaskUID string, httpRequest httpclient.Request, scheduleTime time.Time) error {
+
+	jsonPayload, err := json.Marshal(httpRequest)
 	if err != nil {
 		return fmt.Errorf("Error marshalling forwardContext: %s", err)
 	}
+	// the queue keeps the payload for days, while it may hold personal data
+	taskPayload, err := s.sealer.Seal(c, jsonPayload)
+	if err != nil {
+		return fmt.Errorf("Error sealing forwardContext: %s", err)
+	}
 
 	err = s.queue.Enqueue(c, queue.Task{
-		UID:            httpRequest.TaskUID,
+		UID:            queueTaskUID,
 		WebhookURLPath: taskEndpointURL,
 		Payload:        taskPayload,
+		ScheduleTime:   scheduleTime,
 	})
 	if err != nil {
-		return fmt.Errorf("Error submitting forwardContext to queue: %s", err)
+		return fmt.Errorf("Error submitting forwardContext to queue: %w", err)
 	}
 
 	log.Printf("Successfully enqueued for later forwarding: %s", httpRequest)
 
+	// the task is enqueued anyway: failing would make the client submit it again
+	s.record(c, warehouse.ForwardSummary{HttpRequest: httpRequest, State: warehouse.TaskStatePending})
+
 	return nil
 }
 
+// record keeps the state of a task in the warehouse, without affecting its delivery
+func (s *forwarderService) record(c context.Context, summary warehouse.ForwardSummary) {
+	err := s.warehouse.Put(c, summary)
+	if err != nil {
+		log.Printf("Error recording %s as %s: %s", summary.HttpRequest, summary.State, err)
+	}
+}
+
 func (s *forwarderService) dequeue() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		c := r.Context()
 
+		// anyone else could have any request sent, bypassing the checks of the entrypoint
+		err := s.queueAuth.Verify(r)
+		if err != nil {
+			log.Printf("Refusing task: %s", err)
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		payload, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			log.Printf("Error reading task payload: %s", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if envelope.IsSealed(payload) {
+			// the payload is our own json, so it cannot look sealed by chance
+			payload, err = s.sealer.Open(c, payload)
+			if err != nil {
+				// the queue retries, so a missing key can still be added
+				log.Printf("Error opening task payload: %s", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+
 		var httpReq httpclient.Request
-		err := json.NewDecoder(r.Body).Decode(&httpReq)
+		err = json.Unmarshal(payload, &httpReq)
 		if err != nil {
 			log.Printf("Error parsing json task payload:%s", err)
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 
+		if s.isCancelled(c, httpReq.TaskUID) {
+			log.Printf("Ignoring %s: task was cancelled", httpReq)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
 		// collect statistics
-		numAttempts, maxAttempts := s.queue.IsLastAttempt(c, httpReq.TaskUID)
-		stats := warehouse.Stats{RetryCount: numAttempts, MaxRetryCount: maxAttempts}
+		numAttempts, maxAttempts := s.queue.IsLastAttempt(c, queueTaskUID(r, httpReq))
+		stats := warehouse.Stats{RetryCount: httpReq.PrevAttempts + numAttempts, MaxRetryCount: limitAttempts(maxAttempts, httpReq.MaxAttempts)}
+		// the queue knows neither the max-attempts of the route, nor the attempts made before rescheduling;
+		// without a known maximum (unlimited or unknown), there is nothing to limit
+		selfLimited := stats.MaxRetryCount > 0 && (stats.MaxRetryCount != maxAttempts || httpReq.PrevAttempts > 0)
+
+		if selfLimited && stats.RetryCount > stats.MaxRetryCount {
+			log.Printf("Ignoring %s: already made %d attempts", httpReq, stats.MaxRetryCount)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if s.waitsForPredecessor(c, httpReq) {
+			w.WriteHeader(s.deferForPredecessor(c, httpReq, stats))
+			return
+		}
 
 		// doSend
-		w.WriteHeader(s.doSend(c, httpReq, stats))
+		status := s.doSend(c, httpReq, stats)
+		if selfLimited && stats.IsLastAttempt() && status != http.StatusOK {
+			// the queue would retry, but no more attempts are allowed
+			log.Printf("Giving up on %s after %d attempts", httpReq, stats.RetryCount)
+			status = http.StatusOK
+		}
+		w.WriteHeader(status)
+	}
+}
+
+// waitsForPredecessor tells whether the request with the same ordering-key before this one is still to be delivered
+func (s *forwarderService) waitsForPredecessor(c context.Context, httpReq httpclient.Request) bool {
+	if httpReq.Predecessor == "" {
+		return false
 	}
+	status, found, err := s.warehouse.Get(c, httpReq.Predecessor)
+	if err != nil {
+		log.Printf("Error fetching predecessor %s of %s: %s", httpReq.Predecessor, httpReq, err)
+		// rather wait than break the order
+		return true
+	}
+	if found && !status.State.IsCompleted() {
+		log.Printf("Holding back %s: predecessor %s is %s", httpReq, httpReq.Predecessor, status.State)
+		return true
+	}
+	return false
+}
+
+// deferForPredecessor backs off while the predecessor is not completed and gives up once it seems stuck,
+// so that the request ends up as a dead letter instead of being deferred forever
+func (s *forwarderService) deferForPredecessor(c context.Context, httpReq httpclient.Request, stats warehouse.Stats) int {
+	if httpReq.Deferrals >= maxOrderingDeferrals {
+		err := fmt.Errorf("Predecessor %s did not complete after %d checks: %w", httpReq.Predecessor, httpReq.Deferrals, ErrPredecessorStuck)
+		s.reject(c, httpReq, err)
+		s.onLastDelivery(c, httpReq, nil, err, stats.RetryCount)
+		return http.StatusOK
+	}
+	deferred := httpReq
+	deferred.Deferrals++
+	return s.deferUntil(c, deferred, stats, time.Now().Add(orderingBackoff(httpReq.Deferrals)))
+}
+
+// orderingBackoff doubles the wait for a predecessor on every check
+func orderingBackoff(deferrals int32) time.Duration {
+	delay := orderingDeferral
+	for i := int32(0); i < deferrals && delay < maxOrderingDeferral; i++ {
+		delay *= 2
+	}
+	if delay > maxOrderingDeferral {
+		return maxOrderingDeferral
+	}
+	return delay
+}
+
+// queueTaskUID differs from the task-uid of the request once the request has been rescheduled
+func queueTaskUID(r *http.Request, httpReq httpclient.Request) string {
+	uid := r.Header.Get("X-CloudTasks-TaskName")
+	if uid == "" {
+		return httpReq.TaskUID
+	}
+	return uid
+}
+
+// limitAttempts applies the max-attempts of the request when that is stricter than the one of the queue
+func limitAttempts(queueMaxAttempts, requestMaxAttempts int32) int32 {
+	if requestMaxAttempts > 0 && (queueMaxAttempts <= 0 || requestMaxAttempts < queueMaxAttempts) {
+		return requestMaxAttempts
+	}
+	return queueMaxAttempts
 }
 func (s *forwarderService) doSend(c context.Context, httpReq httpclient.Request, stats warehouse.Stats) int {
+	started := time.Now()
 	httpResp, err := s.httpClient.Send(c, httpReq)
-	defer s.warehouse.Put(c, warehouse.ForwardSummary{HttpRequest: httpReq, HttpResponse: httpResp, Error: err, Stats: stats})
-	if err != nil {
-		log.Printf("Error forwarding %s: %s", httpReq.String(), err)
-		if stats.IsLastAttempt() {
-			s.lastDelivery.OnLastDelivery(c, httpReq, nil, err)
-		}
-		return http.StatusInternalServerError
+	latency := time.Since(started)
+	if at, heldBack := heldBackUntil(err); heldBack {
+		return s.deferUntil(c, httpReq, stats, at)
 	}
+	if errors.Is(err, destination.ErrDenied) {
+		// dns changed since the request was enqueued: retrying will not help
+		s.reject(c, httpReq, err)
+		s.onLastDelivery(c, httpReq, nil, err, stats.RetryCount)
+		return http.StatusOK
+	}
+	decision := s.policyFor(httpReq).Decide(httpResp, err)
+	defer s.record(c, warehouse.ForwardSummary{HttpRequest: httpReq, HttpResponse: httpResp, Error: err, Stats: stats, State: asyncState(decision, stats), Latency: latency})
 
-	if httpResp.IsError() {
-		log.Printf("Error forwarding %s: resp-status: %d", httpReq.String(), httpResp.Status)
+	switch decision {
+	case retry.Success:
+		s.onLastDelivery(c, httpReq, httpResp, nil, stats.RetryCount)
+		return http.StatusOK
+	case retry.GiveUp:
+		log.Printf("Giving up on %s: %s", httpReq, describeFailure(httpResp, err))
+		s.onLastDelivery(c, httpReq, httpResp, err, stats.RetryCount)
+		// prevent the queue from retrying
+		return http.StatusOK
+	default:
+		log.Printf("Error forwarding %s: %s", httpReq, describeFailure(httpResp, err))
+		if !stats.IsLastAttempt() && s.reschedule(c, httpReq, httpResp, stats) {
+			// prevent the queue from retrying: the rescheduled task takes over
+			return http.StatusOK
+		}
 		if stats.IsLastAttempt() {
-			s.lastDelivery.OnLastDelivery(c, httpReq, httpResp, nil)
+			s.onLastDelivery(c, httpReq, httpResp, err, stats.RetryCount)
+		}
+		if err != nil || !httpResp.IsError() {
+			return http.StatusInternalServerError
 		}
 		return httpResp.Status
 	}
+}
+
+// reschedule honours the Retry-After of the host, instead of the backoff of the queue
+func (s *forwarderService) reschedule(c context.Context, httpReq httpclient.Request, httpResp *httpclient.Response, stats warehouse.Stats) bool {
+	at, found := retryAfter(httpResp, time.Now())
+	if !found {
+		return false
+	}
+	if s.isCancelled(c, httpReq.TaskUID) {
+		// cancelled while this attempt was in flight
+		log.Printf("Not rescheduling %s: task was cancelled", httpReq)
+		return true
+	}
 
-	s.lastDelivery.OnLastDelivery(c, httpReq, httpResp, nil)
+	rescheduled := httpReq
+	rescheduled.PrevAttempts = stats.RetryCount
+	err := s.enqueueAt(c, fmt.Sprintf("%s-after-%d", httpReq.TaskUID, stats.RetryCount), rescheduled, at)
+	if err != nil {
+		log.Printf("Error rescheduling %s: %s", httpReq, err)
+		return false
+	}
+	log.Printf("Rescheduled %s at %s", httpReq, at.Format(time.RFC3339))
+	return true
+}
+
+// heldBackUntil tells whether a request was not sent to spare the host, and when it can be sent
+func heldBackUntil(err error) (time.Time, bool) {
+	var openErr *circuitbreaker.OpenError
+	if errors.As(err, &openErr) {
+		return openErr.RetryAt, true
+	}
+	var limitErr *ratelimit.LimitError
+	if errors.As(err, &limitErr) {
+		return limitErr.RetryAt, true
+	}
+	return time.Time{}, false
+}
 
+// deferUntil postpones a request that was not sent, without counting it as an attempt
+func (s *forwarderService) deferUntil(c context.Context, httpReq httpclient.Request, stats warehouse.Stats, at time.Time) int {
+	if s.isCancelled(c, httpReq.TaskUID) {
+		log.Printf("Not deferring %s: task was cancelled", httpReq)
+		return http.StatusOK
+	}
+	deferred := httpReq
+	// the deferred attempt is not counted, but a queue that counts from zero reports none on the first one
+	deferred.PrevAttempts = stats.RetryCount - 1
+	if deferred.PrevAttempts < 0 {
+		deferred.PrevAttempts = 0
+	}
+	err := s.enqueueAt(c, fmt.Sprintf("%s-deferred-%d", httpReq.TaskUID, at.UnixNano()), deferred, at)
+	if err != nil {
+		log.Printf("Error deferring %s: %s", httpReq, err)
+		return http.StatusServiceUnavailable
+	}
+	log.Printf("Deferred %s until %s", httpReq, at.Format(time.RFC3339))
+	// prevent the queue from retrying: the deferred task takes over
 	return http.StatusOK
 }
+
+// policyFor applies the retry-rules of the request on top of the default retry-policy
+func (s *forwarderService) policyFor(httpReq httpclient.Request) retry.Policy {
+	rules := retry.Rules{RetryOn: httpReq.RetryOn, GiveUpOn: httpReq.GiveUpOn}
+	if rules.IsEmpty() {
+		return s.retryPolicy
+	}
+	return retry.NewRulesPolicy(rules, s.retryPolicy)
+}
+
+func describeFailure(httpResp *httpclient.Response, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return fmt.Sprintf("resp-status: %d", httpResp.Status)
+}
+
+func asyncState(decision retry.Decision, stats warehouse.Stats) warehouse.TaskState {
+	switch decision {
+	case retry.Success:
+		return warehouse.TaskStateDelivered
+	case retry.GiveUp:
+		return warehouse.TaskStateFailed
+	default:
+		if stats.IsLastAttempt() {
+			return warehouse.TaskStateFailed
+		}
+		return warehouse.TaskStateRetrying
+	}
+}
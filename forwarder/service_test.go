@@ -2,15 +2,25 @@ package forwarder
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/MarcGrol/forwardhttp/circuitbreaker"
 	"github.com/MarcGrol/forwardhttp/lastdelivery"
 
 	"github.com/MarcGrol/forwardhttp/queue"
+	"github.com/MarcGrol/forwardhttp/queueauth"
+	"github.com/MarcGrol/forwardhttp/ratelimit"
+	"github.com/MarcGrol/forwardhttp/retry"
 
+	"github.com/MarcGrol/forwardhttp/destination"
+	"github.com/MarcGrol/forwardhttp/envelope"
 	"github.com/MarcGrol/forwardhttp/httpclient"
 	"github.com/MarcGrol/forwardhttp/warehouse"
 	"github.com/golang/mock/gomock"
@@ -49,25 +59,105 @@ func TestWebHook(t *testing.T) {
 			queue:                   queueClient(ctrl, true),
 			lastDeliverer:           lastDeliveryHandler(ctrl, httpResponse(400, "error response"), nil),
 			request:                 httpRequest(t, "POST", "/_ah/tasks/doSend", "request payload"),
-			expectedResponseStatus:  400,
-			expectedResponsePayload: "error response",
+			expectedResponseStatus:  200,
+			expectedResponsePayload: "",
 		},
 		{
-			name:                    "Http bad request error",
+			name:                    "Http bad request error: give up",
 			httpClient:              httpClient(ctrl, 400, "error response", nil),
 			warehouse:               warehouseClient(ctrl, nil),
 			queue:                   queueClient(ctrl, false),
+			lastDeliverer:           lastDeliveryHandler(ctrl, httpResponse(400, "error response"), nil),
+			request:                 httpRequest(t, "POST", "/_ah/tasks/doSend", "request payload"),
+			expectedResponseStatus:  200,
+			expectedResponsePayload: "",
+		},
+		{
+			name:                    "Http too many requests: retry",
+			httpClient:              httpClient(ctrl, 429, "error response", nil),
+			warehouse:               warehouseClient(ctrl, nil),
+			queue:                   queueClient(ctrl, false),
 			lastDeliverer:           nil,
 			request:                 httpRequest(t, "POST", "/_ah/tasks/doSend", "request payload"),
-			expectedResponseStatus:  400,
+			expectedResponseStatus:  429,
 			expectedResponsePayload: "error response",
 		},
+		{
+			name:                    "Network error: retry",
+			httpClient:              httpClient(ctrl, 0, "", fmt.Errorf("network error")),
+			warehouse:               warehouseClient(ctrl, nil),
+			queue:                   queueClient(ctrl, false),
+			lastDeliverer:           nil,
+			request:                 httpRequest(t, "POST", "/_ah/tasks/doSend", "request payload"),
+			expectedResponseStatus:  500,
+			expectedResponsePayload: "",
+		},
+		{
+			name:                    "Request rule: retry on not found",
+			httpClient:              httpClient(ctrl, 404, "error response", nil),
+			warehouse:               warehouseClient(ctrl, nil),
+			queue:                   queueClient(ctrl, false),
+			lastDeliverer:           nil,
+			request:                 httpRequestWithRules(t, "POST", "/_ah/tasks/doSend", "request payload", retry.Rules{RetryOn: []string{"404"}}),
+			expectedResponseStatus:  404,
+			expectedResponsePayload: "error response",
+		},
+		{
+			name:                    "Request rule: give up on internal server error",
+			httpClient:              httpClient(ctrl, 500, "error response", nil),
+			warehouse:               warehouseClient(ctrl, nil),
+			queue:                   queueClient(ctrl, false),
+			lastDeliverer:           lastDeliveryHandler(ctrl, httpResponse(500, "error response"), nil),
+			request:                 httpRequestWithRules(t, "POST", "/_ah/tasks/doSend", "request payload", retry.Rules{GiveUpOn: []string{"5xx"}}),
+			expectedResponseStatus:  200,
+			expectedResponsePayload: "",
+		},
+		{
+			name:                    "Route max attempts reached: give up",
+			httpClient:              httpClient(ctrl, 500, "error response", nil),
+			warehouse:               warehouseClient(ctrl, nil),
+			queue:                   queueClientWithAttempts(ctrl, 3, 10),
+			lastDeliverer:           lastDeliveryHandler(ctrl, httpResponse(500, "error response"), nil),
+			request:                 httpRequestWithMaxAttempts(t, "POST", "/_ah/tasks/doSend", "request payload", 3),
+			expectedResponseStatus:  200,
+			expectedResponsePayload: "",
+		},
+		{
+			name:                    "Route max attempts not reached",
+			httpClient:              httpClient(ctrl, 500, "error response", nil),
+			warehouse:               warehouseClient(ctrl, nil),
+			queue:                   queueClientWithAttempts(ctrl, 2, 10),
+			lastDeliverer:           nil,
+			request:                 httpRequestWithMaxAttempts(t, "POST", "/_ah/tasks/doSend", "request payload", 3),
+			expectedResponseStatus:  500,
+			expectedResponsePayload: "",
+		},
+		{
+			name:                    "Rescheduled on unlimited queue: retry",
+			httpClient:              httpClient(ctrl, 500, "error response", nil),
+			warehouse:               warehouseClient(ctrl, nil),
+			queue:                   queueClientWithAttempts(ctrl, 1, 0),
+			lastDeliverer:           nil,
+			request:                 httpRequestWithPrevAttempts(t, "POST", "/_ah/tasks/doSend", "request payload", 4),
+			expectedResponseStatus:  500,
+			expectedResponsePayload: "",
+		},
+		{
+			name:                    "Rescheduled on queue with unknown max attempts: deliver",
+			httpClient:              httpClient(ctrl, 200, "success response", nil),
+			warehouse:               warehouseClient(ctrl, nil),
+			queue:                   queueClientWithAttempts(ctrl, 1, -1),
+			lastDeliverer:           lastDeliveryHandler(ctrl, httpResponse(200, "success response"), nil),
+			request:                 httpRequestWithPrevAttempts(t, "POST", "/_ah/tasks/doSend", "request payload", 4),
+			expectedResponseStatus:  200,
+			expectedResponsePayload: "success response",
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			// setup
-			service := NewService(tc.queue, tc.httpClient, tc.warehouse, tc.lastDeliverer)
+			service := NewService(tc.queue, tc.httpClient, tc.warehouse, tc.lastDeliverer, retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true}, trustedQueue(ctrl), envelope.Plaintext())
 
 			// when
 			httpResp := httptest.NewRecorder()
@@ -81,11 +171,39 @@ func TestWebHook(t *testing.T) {
 }
 
 func httpRequest(t *testing.T, method, url, body string) *http.Request {
-	jsonPayload, err := json.Marshal(httpclient.Request{
-		Method: method,
-		URL:    "/myurl",
-		Body:   []byte(body),
+	return httpRequestWithMaxAttempts(t, method, url, body, 0)
+}
+
+func httpRequestWithMaxAttempts(t *testing.T, method, url, body string, maxAttempts int32) *http.Request {
+	return taskRequest(t, method, url, httpclient.Request{
+		Method:      method,
+		URL:         "/myurl",
+		Body:        []byte(body),
+		MaxAttempts: maxAttempts,
+	})
+}
+
+func httpRequestWithPrevAttempts(t *testing.T, method, url, body string, prevAttempts int32) *http.Request {
+	return taskRequest(t, method, url, httpclient.Request{
+		Method:       method,
+		URL:          "/myurl",
+		Body:         []byte(body),
+		PrevAttempts: prevAttempts,
+	})
+}
+
+func httpRequestWithRules(t *testing.T, method, url, body string, rules retry.Rules) *http.Request {
+	return taskRequest(t, method, url, httpclient.Request{
+		Method:   method,
+		URL:      "/myurl",
+		Body:     []byte(body),
+		RetryOn:  rules.RetryOn,
+		GiveUpOn: rules.GiveUpOn,
 	})
+}
+
+func taskRequest(t *testing.T, method, url string, task httpclient.Request) *http.Request {
+	jsonPayload, err := json.Marshal(task)
 	assert.NoError(t, err)
 	httpReq, err := http.NewRequest(method, url, bytes.NewReader(jsonPayload))
 	if err != nil {
@@ -128,6 +246,11 @@ func httpClient(ctrlr *gomock.Controller, status int, respPayload string, err er
 func warehouseClient(ctrlr *gomock.Controller, err error) warehouse.Warehouser {
 	warehouse := warehouse.NewMockWarehouser(ctrlr)
 
+	warehouse.
+		EXPECT().
+		Get(gomock.Any(), gomock.Any()).
+		Return(nil, false, nil)
+
 	warehouse.
 		EXPECT().
 		Put(gomock.Any(), gomock.Any()).
@@ -154,6 +277,29 @@ func queueClient(ctrlr *gomock.Controller, isLast bool) queue.TaskQueuer {
 	return queue
 }
 
+func queueClientWithAttempts(ctrlr *gomock.Controller, numAttempts, maxAttempts int32) queue.TaskQueuer {
+	queue := queue.NewMockTaskQueuer(ctrlr)
+
+	queue.
+		EXPECT().
+		IsLastAttempt(gomock.Any(), gomock.Any()).
+		Return(numAttempts, maxAttempts)
+
+	return queue
+}
+
+func trustedQueue(ctrlr *gomock.Controller) queueauth.Verifier {
+	verifier := queueauth.NewMockVerifier(ctrlr)
+
+	verifier.
+		EXPECT().
+		Verify(gomock.Any()).
+		Return(nil).
+		AnyTimes()
+
+	return verifier
+}
+
 func lastDeliveryHandler(ctrlr *gomock.Controller, resp *httpclient.Response, err error) lastdelivery.LastDeliverer {
 	lastdelivery := lastdelivery.NewMockLastDeliverer(ctrlr)
 
@@ -164,3 +310,585 @@ func lastDeliveryHandler(ctrlr *gomock.Controller, resp *httpclient.Response, er
 
 	return lastdelivery
 }
+
+func TestRescheduleOnRetryAfter(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	httpSender := httpclient.NewMockHTTPSender(ctrl)
+	httpSender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(&httpclient.Response{Status: 503, Headers: http.Header{"Retry-After": []string{"120"}}}, nil)
+	queueMock := queue.NewMockTaskQueuer(ctrl)
+	queueMock.EXPECT().IsLastAttempt(gomock.Any(), "abc-after-1").Return(int32(2), int32(10))
+	var rescheduled queue.Task
+	queueMock.EXPECT().Enqueue(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, task queue.Task) error {
+		rescheduled = task
+		return nil
+	})
+	warehouseMock := warehouse.NewMockWarehouser(ctrl)
+	warehouseMock.EXPECT().Get(gomock.Any(), "abc").Return(&warehouse.TaskStatus{TaskUID: "abc", State: warehouse.TaskStateRetrying}, true, nil).Times(2)
+	warehouseMock.EXPECT().Put(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	service := NewService(queueMock, httpSender, warehouseMock, nil, retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true}, trustedQueue(ctrl), envelope.Plaintext())
+
+	request := taskRequest(t, "POST", "/_ah/tasks/doSend", httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit", PrevAttempts: 1})
+	request.Header.Set("X-CloudTasks-TaskName", "abc-after-1")
+	httpResp := httptest.NewRecorder()
+	service.RegisterEndPoint(mux.NewRouter()).ServeHTTP(httpResp, request)
+
+	assert.Equal(t, 200, httpResp.Code)
+	assert.Equal(t, "abc-after-3", rescheduled.UID)
+	assert.WithinDuration(t, time.Now().Add(2*time.Minute), rescheduled.ScheduleTime, 5*time.Second)
+	var httpReq httpclient.Request
+	assert.NoError(t, json.Unmarshal(rescheduled.Payload, &httpReq))
+	assert.Equal(t, "abc", httpReq.TaskUID)
+	assert.Equal(t, int32(3), httpReq.PrevAttempts)
+}
+
+func TestForwardAsyncSchedules(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	deliverAt := time.Now().Add(time.Hour)
+	queueMock := queue.NewMockTaskQueuer(ctrl)
+	queueMock.EXPECT().Enqueue(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, task queue.Task) error {
+		assert.Equal(t, "abc", task.UID)
+		assert.True(t, deliverAt.Equal(task.ScheduleTime))
+		return nil
+	})
+	warehouseMock := warehouse.NewMockWarehouser(ctrl)
+	warehouseMock.EXPECT().Put(gomock.Any(), gomock.Any()).Return(nil)
+	service := NewService(queueMock, nil, warehouseMock, nil, retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true}, trustedQueue(ctrl), envelope.Plaintext())
+
+	err := service.ForwardAsync(context.Background(), httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit", DeliverAt: deliverAt})
+	assert.NoError(t, err)
+}
+
+func TestForwardAsyncWhenPendingNotRecorded(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	queueMock := queue.NewMockTaskQueuer(ctrl)
+	queueMock.EXPECT().Enqueue(gomock.Any(), gomock.Any()).Return(nil)
+	warehouseMock := warehouse.NewMockWarehouser(ctrl)
+	warehouseMock.EXPECT().Put(gomock.Any(), gomock.Any()).Return(fmt.Errorf("store unavailable"))
+	service := NewService(queueMock, nil, warehouseMock, nil, retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true}, trustedQueue(ctrl), envelope.Plaintext())
+
+	// the task is enqueued: submitting it again would deliver it twice
+	err := service.ForwardAsync(context.Background(), httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit"})
+	assert.NoError(t, err)
+}
+
+func TestCancel(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	testCases := []struct {
+		name          string
+		status        *warehouse.TaskStatus
+		deleteErr     error
+		expectMarker  bool
+		expectedError error
+	}{
+		{
+			name:         "Pending in queue",
+			status:       &warehouse.TaskStatus{TaskUID: "abc", State: warehouse.TaskStatePending},
+			deleteErr:    nil,
+			expectMarker: true,
+		},
+		{
+			name:         "In flight or rescheduled",
+			status:       &warehouse.TaskStatus{TaskUID: "abc", State: warehouse.TaskStateRetrying},
+			deleteErr:    queue.ErrTaskNotFound,
+			expectMarker: true,
+		},
+		{
+			name:          "Unknown",
+			status:        nil,
+			deleteErr:     queue.ErrTaskNotFound,
+			expectedError: queue.ErrTaskNotFound,
+		},
+		{
+			name:          "Already delivered",
+			status:        &warehouse.TaskStatus{TaskUID: "abc", State: warehouse.TaskStateDelivered},
+			expectedError: ErrTaskCompleted,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// setup
+			warehouseMock := warehouse.NewMockWarehouser(ctrl)
+			warehouseMock.EXPECT().Get(gomock.Any(), "abc").Return(tc.status, tc.status != nil, nil)
+			queueMock := queue.NewMockTaskQueuer(ctrl)
+			if tc.status == nil || !tc.status.State.IsCompleted() {
+				queueMock.EXPECT().Delete(gomock.Any(), "abc").Return(tc.deleteErr)
+			}
+			if tc.expectMarker {
+				warehouseMock.EXPECT().Put(gomock.Any(), warehouse.ForwardSummary{HttpRequest: httpclient.Request{TaskUID: "abc"}, State: warehouse.TaskStateCancelled}).Return(nil)
+			}
+			service := NewService(queueMock, nil, warehouseMock, nil, retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true}, trustedQueue(ctrl), envelope.Plaintext())
+
+			// when
+			err := service.Cancel(context.Background(), "abc")
+
+			// then
+			if tc.expectedError != nil {
+				assert.True(t, errors.Is(err, tc.expectedError))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestDispatchOfCancelledTaskIsNoop(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	warehouseMock := warehouse.NewMockWarehouser(ctrl)
+	warehouseMock.EXPECT().Get(gomock.Any(), "abc").Return(&warehouse.TaskStatus{TaskUID: "abc", State: warehouse.TaskStateCancelled}, true, nil)
+	// neither queue nor http-client are touched
+	service := NewService(queue.NewMockTaskQueuer(ctrl), httpclient.NewMockHTTPSender(ctrl), warehouseMock, nil, retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true}, trustedQueue(ctrl), envelope.Plaintext())
+
+	httpResp := httptest.NewRecorder()
+	service.RegisterEndPoint(mux.NewRouter()).ServeHTTP(httpResp, taskRequest(t, "POST", "/_ah/tasks/doSend", httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit"}))
+
+	assert.Equal(t, 200, httpResp.Code)
+}
+
+func TestDeferWhileCircuitOpen(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	retryAt := time.Now().Add(time.Minute)
+	httpSender := httpclient.NewMockHTTPSender(ctrl)
+	httpSender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(nil, &circuitbreaker.OpenError{Host: "home.nl", RetryAt: retryAt})
+	queueMock := queue.NewMockTaskQueuer(ctrl)
+	queueMock.EXPECT().IsLastAttempt(gomock.Any(), "abc").Return(int32(3), int32(10))
+	var deferred queue.Task
+	queueMock.EXPECT().Enqueue(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, task queue.Task) error {
+		deferred = task
+		return nil
+	})
+	warehouseMock := warehouse.NewMockWarehouser(ctrl)
+	warehouseMock.EXPECT().Get(gomock.Any(), "abc").Return(nil, false, nil).Times(2)
+	// only the pending summary of the deferred task: no attempt is recorded
+	warehouseMock.EXPECT().Put(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, summary warehouse.ForwardSummary) error {
+		assert.Equal(t, warehouse.TaskStatePending, summary.State)
+		return nil
+	})
+	service := NewService(queueMock, httpSender, warehouseMock, nil, retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true}, trustedQueue(ctrl), envelope.Plaintext())
+
+	httpResp := httptest.NewRecorder()
+	service.RegisterEndPoint(mux.NewRouter()).ServeHTTP(httpResp, taskRequest(t, "POST", "/_ah/tasks/doSend", httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit"}))
+
+	assert.Equal(t, 200, httpResp.Code)
+	assert.Equal(t, fmt.Sprintf("abc-deferred-%d", retryAt.UnixNano()), deferred.UID)
+	assert.True(t, retryAt.Equal(deferred.ScheduleTime))
+	var httpReq httpclient.Request
+	assert.NoError(t, json.Unmarshal(deferred.Payload, &httpReq))
+	assert.Equal(t, int32(2), httpReq.PrevAttempts)
+}
+
+func TestDeferFirstAttempt(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	retryAt := time.Now().Add(time.Minute)
+	httpSender := httpclient.NewMockHTTPSender(ctrl)
+	httpSender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(nil, &circuitbreaker.OpenError{Host: "home.nl", RetryAt: retryAt})
+	queueMock := queue.NewMockTaskQueuer(ctrl)
+	// a queue that counts retries rather than attempts
+	queueMock.EXPECT().IsLastAttempt(gomock.Any(), "abc").Return(int32(0), int32(10))
+	var deferred queue.Task
+	queueMock.EXPECT().Enqueue(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, task queue.Task) error {
+		deferred = task
+		return nil
+	})
+	warehouseMock := warehouse.NewMockWarehouser(ctrl)
+	warehouseMock.EXPECT().Get(gomock.Any(), "abc").Return(nil, false, nil).Times(2)
+	warehouseMock.EXPECT().Put(gomock.Any(), gomock.Any()).Return(nil)
+	service := NewService(queueMock, httpSender, warehouseMock, nil, retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true}, trustedQueue(ctrl), envelope.Plaintext())
+
+	httpResp := httptest.NewRecorder()
+	service.RegisterEndPoint(mux.NewRouter()).ServeHTTP(httpResp, taskRequest(t, "POST", "/_ah/tasks/doSend", httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit"}))
+
+	assert.Equal(t, 200, httpResp.Code)
+	var httpReq httpclient.Request
+	assert.NoError(t, json.Unmarshal(deferred.Payload, &httpReq))
+	assert.Equal(t, int32(0), httpReq.PrevAttempts)
+}
+
+func TestForwardWhileCircuitOpen(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	httpSender := httpclient.NewMockHTTPSender(ctrl)
+	httpSender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(nil, &circuitbreaker.OpenError{Host: "home.nl", RetryAt: time.Now()})
+	// neither an attempt is recorded, nor a callback made
+	service := NewService(nil, httpSender, warehouse.NewMockWarehouser(ctrl), nil, retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true}, trustedQueue(ctrl), envelope.Plaintext())
+
+	resp, err := service.Forward(context.Background(), httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit", CallbackURL: "https://caller.nl/done"})
+	assert.True(t, errors.Is(err, ErrRetryAsync))
+	assert.Equal(t, 503, resp.Status)
+}
+
+func TestForwardDecidesViaRetryPolicy(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	testCases := []struct {
+		name          string
+		request       httpclient.Request
+		respStatus    int
+		sendErr       error
+		expectedState warehouse.TaskState
+		expectAsync   bool
+	}{
+		{
+			name:          "Delivered",
+			request:       httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit"},
+			respStatus:    200,
+			expectedState: warehouse.TaskStateDelivered,
+		},
+		{
+			name:          "Default retries 5xx",
+			request:       httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit"},
+			respStatus:    503,
+			expectedState: warehouse.TaskStateRetrying,
+			expectAsync:   true,
+		},
+		{
+			name:          "Default retries network error",
+			request:       httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit"},
+			sendErr:       fmt.Errorf("connection refused"),
+			expectedState: warehouse.TaskStateRetrying,
+			expectAsync:   true,
+		},
+		{
+			name:          "Default gives up on 4xx",
+			request:       httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit"},
+			respStatus:    404,
+			expectedState: warehouse.TaskStateFailed,
+		},
+		{
+			name:          "Rules retry 4xx",
+			request:       httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit", RetryOn: []string{"404"}},
+			respStatus:    404,
+			expectedState: warehouse.TaskStateRetrying,
+			expectAsync:   true,
+		},
+		{
+			name:          "Rules give up on 5xx",
+			request:       httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit", GiveUpOn: []string{"5xx"}},
+			respStatus:    503,
+			expectedState: warehouse.TaskStateFailed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// setup
+			var sendResp *httpclient.Response
+			if tc.sendErr == nil {
+				sendResp = &httpclient.Response{Status: tc.respStatus}
+			}
+			httpSender := httpclient.NewMockHTTPSender(ctrl)
+			httpSender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(sendResp, tc.sendErr)
+			warehouseMock := warehouse.NewMockWarehouser(ctrl)
+			warehouseMock.EXPECT().Put(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, summary warehouse.ForwardSummary) error {
+				assert.Equal(t, tc.expectedState, summary.State)
+				return nil
+			})
+			service := NewService(nil, httpSender, warehouseMock, nil, retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true}, trustedQueue(ctrl), envelope.Plaintext())
+
+			// when
+			_, err := service.Forward(context.Background(), tc.request)
+
+			// then
+			assert.Equal(t, tc.expectAsync, errors.Is(err, ErrRetryAsync))
+		})
+	}
+}
+
+func TestDeferWhileLimitExceeded(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	retryAt := time.Now().Add(time.Second)
+	httpSender := httpclient.NewMockHTTPSender(ctrl)
+	httpSender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(nil, &ratelimit.LimitError{Host: "home.nl", Reason: "rate-limit", RetryAt: retryAt})
+	queueMock := queue.NewMockTaskQueuer(ctrl)
+	queueMock.EXPECT().IsLastAttempt(gomock.Any(), "abc").Return(int32(1), int32(10))
+	var deferred queue.Task
+	queueMock.EXPECT().Enqueue(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, task queue.Task) error {
+		deferred = task
+		return nil
+	})
+	warehouseMock := warehouse.NewMockWarehouser(ctrl)
+	warehouseMock.EXPECT().Get(gomock.Any(), "abc").Return(nil, false, nil).Times(2)
+	warehouseMock.EXPECT().Put(gomock.Any(), gomock.Any()).Return(nil)
+	service := NewService(queueMock, httpSender, warehouseMock, nil, retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true}, trustedQueue(ctrl), envelope.Plaintext())
+
+	httpResp := httptest.NewRecorder()
+	service.RegisterEndPoint(mux.NewRouter()).ServeHTTP(httpResp, taskRequest(t, "POST", "/_ah/tasks/doSend", httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit"}))
+
+	assert.Equal(t, 200, httpResp.Code)
+	assert.True(t, retryAt.Equal(deferred.ScheduleTime))
+	var httpReq httpclient.Request
+	assert.NoError(t, json.Unmarshal(deferred.Payload, &httpReq))
+	assert.Equal(t, int32(0), httpReq.PrevAttempts)
+}
+
+func TestForwardAsyncOrdered(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	queueMock := queue.NewMockTaskQueuer(ctrl)
+	warehouseMock := warehouse.NewMockWarehouser(ctrl)
+	gomock.InOrder(
+		// a successor must find this task pending
+		warehouseMock.EXPECT().Put(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, summary warehouse.ForwardSummary) error {
+			assert.Equal(t, warehouse.TaskStatePending, summary.State)
+			return nil
+		}),
+		warehouseMock.EXPECT().AppendToOrderingKey(gomock.Any(), "order-123", "def").Return("abc", nil),
+		queueMock.EXPECT().Enqueue(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, task queue.Task) error {
+			var httpReq httpclient.Request
+			assert.NoError(t, json.Unmarshal(task.Payload, &httpReq))
+			assert.Equal(t, "abc", httpReq.Predecessor)
+			return nil
+		}),
+		warehouseMock.EXPECT().Put(gomock.Any(), gomock.Any()).Return(nil),
+	)
+	service := NewService(queueMock, nil, warehouseMock, nil, retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true}, trustedQueue(ctrl), envelope.Plaintext())
+
+	err := service.ForwardAsync(context.Background(), httpclient.Request{TaskUID: "def", Method: "POST", URL: "https://home.nl/doit", OrderingKey: "order-123"})
+	assert.NoError(t, err)
+}
+
+func TestForwardAsyncOrderedEnqueueFailure(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	queueMock := queue.NewMockTaskQueuer(ctrl)
+	queueMock.EXPECT().Enqueue(gomock.Any(), gomock.Any()).Return(fmt.Errorf("queue unavailable"))
+	warehouseMock := warehouse.NewMockWarehouser(ctrl)
+	gomock.InOrder(
+		warehouseMock.EXPECT().Put(gomock.Any(), gomock.Any()).Return(nil),
+		warehouseMock.EXPECT().AppendToOrderingKey(gomock.Any(), "order-123", "def").Return("abc", nil),
+		warehouseMock.EXPECT().RemoveFromOrderingKey(gomock.Any(), "order-123", "def", "abc").Return(nil),
+		// a successor that found it in the meantime must not wait for it forever
+		warehouseMock.EXPECT().Put(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, summary warehouse.ForwardSummary) error {
+			assert.Equal(t, warehouse.TaskStateRejected, summary.State)
+			return nil
+		}),
+	)
+	service := NewService(queueMock, nil, warehouseMock, nil, retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true}, trustedQueue(ctrl), envelope.Plaintext())
+
+	err := service.ForwardAsync(context.Background(), httpclient.Request{TaskUID: "def", Method: "POST", URL: "https://home.nl/doit", OrderingKey: "order-123"})
+	assert.Error(t, err)
+}
+
+func TestForwardAsyncOrderedNotSupported(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	queueMock := queue.NewMockTaskQueuer(ctrl)
+	service := NewService(queueMock, nil, warehouse.NewLogWarehouse(), nil, retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true}, trustedQueue(ctrl), envelope.Plaintext())
+
+	err := service.ForwardAsync(context.Background(), httpclient.Request{TaskUID: "def", Method: "POST", URL: "https://home.nl/doit", OrderingKey: "order-123"})
+	assert.True(t, errors.Is(err, warehouse.ErrNotSupported))
+}
+
+func TestDispatchOrdered(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	testCases := []struct {
+		name             string
+		predecessorState warehouse.TaskState
+		expectSend       bool
+	}{
+		{
+			name:             "Predecessor still pending",
+			predecessorState: warehouse.TaskStatePending,
+			expectSend:       false,
+		},
+		{
+			name:             "Predecessor retrying",
+			predecessorState: warehouse.TaskStateRetrying,
+			expectSend:       false,
+		},
+		{
+			name:             "Predecessor delivered",
+			predecessorState: warehouse.TaskStateDelivered,
+			expectSend:       true,
+		},
+		{
+			name:             "Predecessor dead-lettered",
+			predecessorState: warehouse.TaskStateFailed,
+			expectSend:       true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// setup
+			queueMock := queue.NewMockTaskQueuer(ctrl)
+			queueMock.EXPECT().IsLastAttempt(gomock.Any(), "def").Return(int32(1), int32(10))
+			warehouseMock := warehouse.NewMockWarehouser(ctrl)
+			warehouseMock.EXPECT().Get(gomock.Any(), "def").Return(nil, false, nil).MinTimes(1)
+			warehouseMock.EXPECT().Get(gomock.Any(), "abc").Return(&warehouse.TaskStatus{TaskUID: "abc", State: tc.predecessorState}, true, nil)
+			warehouseMock.EXPECT().Put(gomock.Any(), gomock.Any()).Return(nil)
+			httpSender := httpclient.NewMockHTTPSender(ctrl)
+			if tc.expectSend {
+				httpSender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(&httpclient.Response{Status: 200}, nil)
+			} else {
+				queueMock.EXPECT().Enqueue(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, task queue.Task) error {
+					assert.WithinDuration(t, time.Now().Add(orderingDeferral), task.ScheduleTime, time.Second)
+					var deferred httpclient.Request
+					assert.NoError(t, json.Unmarshal(task.Payload, &deferred))
+					assert.Equal(t, int32(1), deferred.Deferrals)
+					return nil
+				})
+			}
+			service := NewService(queueMock, httpSender, warehouseMock, lastdelivery.NewLastDelivery(), retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true}, trustedQueue(ctrl), envelope.Plaintext())
+
+			// when
+			httpResp := httptest.NewRecorder()
+			service.RegisterEndPoint(mux.NewRouter()).ServeHTTP(httpResp, taskRequest(t, "POST", "/_ah/tasks/doSend", httpclient.Request{TaskUID: "def", Method: "POST", URL: "https://home.nl/doit", OrderingKey: "order-123", Predecessor: "abc"}))
+
+			// then
+			assert.Equal(t, 200, httpResp.Code)
+		})
+	}
+}
+
+func TestDispatchOrderedGivesUpOnStuckPredecessor(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// setup
+	queueMock := queue.NewMockTaskQueuer(ctrl)
+	queueMock.EXPECT().IsLastAttempt(gomock.Any(), "def").Return(int32(1), int32(10))
+	warehouseMock := warehouse.NewMockWarehouser(ctrl)
+	warehouseMock.EXPECT().Get(gomock.Any(), "def").Return(nil, false, nil).AnyTimes()
+	warehouseMock.EXPECT().Get(gomock.Any(), "abc").Return(&warehouse.TaskStatus{TaskUID: "abc", State: warehouse.TaskStateRetrying}, true, nil)
+	warehouseMock.EXPECT().Put(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, summary warehouse.ForwardSummary) error {
+		assert.Equal(t, warehouse.TaskStateRejected, summary.State)
+		assert.True(t, errors.Is(summary.Error, ErrPredecessorStuck))
+		return nil
+	})
+	lastDeliveryMock := lastdelivery.NewMockLastDeliverer(ctrl)
+	lastDeliveryMock.EXPECT().OnLastDelivery(gomock.Any(), gomock.Any(), gomock.Nil(), gomock.Any()).Do(func(_ context.Context, httpReq httpclient.Request, _ *httpclient.Response, err error) {
+		assert.Equal(t, "def", httpReq.TaskUID)
+		assert.True(t, errors.Is(err, ErrPredecessorStuck))
+	})
+	// nothing is sent or deferred
+	service := NewService(queueMock, nil, warehouseMock, lastDeliveryMock, retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true}, trustedQueue(ctrl), envelope.Plaintext())
+
+	// when
+	httpResp := httptest.NewRecorder()
+	service.RegisterEndPoint(mux.NewRouter()).ServeHTTP(httpResp, taskRequest(t, "POST", "/_ah/tasks/doSend", httpclient.Request{TaskUID: "def", Method: "POST", URL: "https://home.nl/doit", OrderingKey: "order-123", Predecessor: "abc", Deferrals: maxOrderingDeferrals}))
+
+	// then
+	assert.Equal(t, 200, httpResp.Code)
+}
+
+func TestOrderingBackoff(t *testing.T) {
+	assert.Equal(t, 5*time.Second, orderingBackoff(0))
+	assert.Equal(t, 10*time.Second, orderingBackoff(1))
+	assert.Equal(t, 160*time.Second, orderingBackoff(5))
+	assert.Equal(t, 5*time.Minute, orderingBackoff(6))
+	assert.Equal(t, 5*time.Minute, orderingBackoff(maxOrderingDeferrals))
+}
+
+func TestForwardAsyncRejectsDeniedDestination(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// nothing is enqueued
+	warehouseMock := warehouse.NewMockWarehouser(ctrl)
+	warehouseMock.EXPECT().Put(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, summary warehouse.ForwardSummary) error {
+		assert.Equal(t, warehouse.TaskStateRejected, summary.State)
+		assert.True(t, errors.Is(summary.Error, destination.ErrDenied))
+		return nil
+	})
+	service := NewService(queue.NewMockTaskQueuer(ctrl), nil, warehouseMock, nil, retry.NewDefaultPolicy(), &destination.Policy{}, trustedQueue(ctrl), envelope.Plaintext())
+
+	err := service.ForwardAsync(context.Background(), httpclient.Request{TaskUID: "abc", Method: "POST", URL: "http://169.254.169.254/latest/meta-data"})
+	assert.True(t, errors.Is(err, destination.ErrDenied))
+}
+
+func TestRejectDeniedDestinationAtSend(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	deniedErr := &destination.DeniedError{Destination: "10.0.0.1:443", Reason: "is a non-public address"}
+	httpSender := httpclient.NewMockHTTPSender(ctrl)
+	httpSender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(nil, fmt.Errorf("Error sending: %w", deniedErr))
+	queueMock := queue.NewMockTaskQueuer(ctrl)
+	queueMock.EXPECT().IsLastAttempt(gomock.Any(), "abc").Return(int32(1), int32(10))
+	warehouseMock := warehouse.NewMockWarehouser(ctrl)
+	warehouseMock.EXPECT().Get(gomock.Any(), "abc").Return(nil, false, nil)
+	warehouseMock.EXPECT().Put(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, summary warehouse.ForwardSummary) error {
+		assert.Equal(t, warehouse.TaskStateRejected, summary.State)
+		return nil
+	})
+	lastDeliverer := lastdelivery.NewMockLastDeliverer(ctrl)
+	lastDeliverer.EXPECT().OnLastDelivery(gomock.Any(), gomock.Any(), gomock.Nil(), gomock.Any())
+	service := NewService(queueMock, httpSender, warehouseMock, lastDeliverer, retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true}, trustedQueue(ctrl), envelope.Plaintext())
+
+	// not retried by the queue
+	httpResp := httptest.NewRecorder()
+	service.RegisterEndPoint(mux.NewRouter()).ServeHTTP(httpResp, taskRequest(t, "POST", "/_ah/tasks/doSend", httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit"}))
+	assert.Equal(t, 200, httpResp.Code)
+}
+
+func TestRefuseTaskNotFromQueue(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	// nothing is sent
+	verifier := queueauth.NewMockVerifier(ctrl)
+	verifier.EXPECT().Verify(gomock.Any()).Return(fmt.Errorf("%w: missing bearer-token", queueauth.ErrNotFromQueue))
+	service := NewService(queue.NewMockTaskQueuer(ctrl), httpclient.NewMockHTTPSender(ctrl), warehouse.NewMockWarehouser(ctrl), nil, retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true}, verifier, envelope.Plaintext())
+
+	httpResp := httptest.NewRecorder()
+	service.RegisterEndPoint(mux.NewRouter()).ServeHTTP(httpResp, taskRequest(t, "POST", "/_ah/tasks/doSend", httpclient.Request{TaskUID: "abc", Method: "POST", URL: "http://10.0.0.1/admin"}))
+	assert.Equal(t, 403, httpResp.Code)
+}
+
+func TestTaskPayloadEncrypted(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	keyring, err := envelope.NewKeyring("key-1", map[string][]byte{"key-1": []byte("0123456789abcdef0123456789abcdef")})
+	assert.NoError(t, err)
+	var enqueued queue.Task
+	queueMock := queue.NewMockTaskQueuer(ctrl)
+	queueMock.EXPECT().Enqueue(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, task queue.Task) error {
+		enqueued = task
+		return nil
+	})
+	queueMock.EXPECT().IsLastAttempt(gomock.Any(), "abc").Return(int32(1), int32(10))
+	httpSender := httpclient.NewMockHTTPSender(ctrl)
+	httpSender.EXPECT().Send(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, req httpclient.Request) (*httpclient.Response, error) {
+		assert.Equal(t, "personal data", string(req.Body))
+		return &httpclient.Response{Status: 200}, nil
+	})
+	warehouseMock := warehouse.NewMockWarehouser(ctrl)
+	warehouseMock.EXPECT().Get(gomock.Any(), "abc").Return(nil, false, nil)
+	warehouseMock.EXPECT().Put(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+	service := NewService(queueMock, httpSender, warehouseMock, lastdelivery.NewLastDelivery(), retry.NewDefaultPolicy(), &destination.Policy{AllowPrivate: true}, trustedQueue(ctrl), envelope.New(keyring))
+
+	err = service.ForwardAsync(context.Background(), httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit", Body: []byte("personal data")})
+	assert.NoError(t, err)
+	assert.True(t, envelope.IsSealed(enqueued.Payload))
+
+	request, err := http.NewRequest("POST", "/_ah/tasks/doSend", bytes.NewReader(enqueued.Payload))
+	assert.NoError(t, err)
+	httpResp := httptest.NewRecorder()
+	service.RegisterEndPoint(mux.NewRouter()).ServeHTTP(httpResp, request)
+	assert.Equal(t, 200, httpResp.Code)
+}
@@ -5,9 +5,13 @@ go 1.16
 require (
 	cloud.google.com/go/cloudtasks v1.0.0
 	cloud.google.com/go/datastore v1.6.0
+	github.com/golang-jwt/jwt/v4 v4.5.2
 	github.com/golang/mock v1.6.0
 	github.com/google/uuid v1.3.0
 	github.com/gorilla/mux v1.8.0
+	github.com/lib/pq v1.10.9
 	github.com/stretchr/testify v1.7.0
 	google.golang.org/genproto v0.0.0-20211111162719-482062a4217b
+	google.golang.org/grpc v1.40.0
+	google.golang.org/protobuf v1.27.1
 )
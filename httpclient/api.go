@@ -4,16 +4,30 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
 )
 
 //go:generate mockgen -source=api.go -destination=gen_HttpClientMock.go -package=httpclient github.com/MarcGrol/forwardhttp/httpclient HTTPSender
 
 type Request struct {
-	TaskUID string
-	Method  string
-	URL     string
-	Headers http.Header `datastore:"-"`
-	Body    []byte      `datastore:",noindex"`
+	TaskUID       string
+	Method        string
+	URL           string
+	Headers       http.Header `datastore:"-"`
+	Body          []byte      `datastore:",noindex"`
+	Route         string
+	MaxAttempts   int32         // zero means: as many as the queue allows
+	Timeout       time.Duration // zero means: the default timeout
+	CallbackURL   string        `json:",omitempty"` // receives the outcome when the request is finally delivered or given up on
+	RetryOn       []string      `json:",omitempty"` // failures that are retried, on top of the default retry-policy
+	GiveUpOn      []string      `json:",omitempty"` // failures that are not retried, on top of the default retry-policy
+	PrevAttempts  int32         `json:",omitempty"` // attempts made before the request was rescheduled
+	DeliverAt     time.Time     // zero means: deliver as soon as possible
+	OrderingKey   string        `json:",omitempty"` // requests with the same key are delivered one after another
+	Predecessor   string        `json:",omitempty"` // task-uid of the request with the same ordering-key that must complete first
+	Deferrals     int32         `json:",omitempty"` // times the request was held back, waiting for its predecessor
+	ParentTaskUID string        `json:",omitempty"` // task-uid of the request that was fanned out to multiple targets
+	Owner         string        `json:",omitempty"` // name of the client that submitted the request: only it may inspect or cancel the task
 }
 
 func (r Request) String() string {
@@ -6,33 +6,98 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"time"
+
+	"github.com/MarcGrol/forwardhttp/destination"
+	"github.com/MarcGrol/forwardhttp/signature"
 )
 
 const httpClientTimeout = 20 * time.Second
 
 type client struct {
+	signers   map[string]*signature.Signer // per route
+	policy    *destination.Policy          // nil allows all destinations
+	transport http.RoundTripper
+	nowFunc   func() time.Time
 }
 
 func NewClient() HTTPSender {
-	return &client{}
+	return &client{
+		signers:   map[string]*signature.Signer{},
+		transport: http.DefaultTransport,
+		nowFunc:   time.Now,
+	}
+}
+
+// NewGuardedClient only connects to destinations that are allowed by the policy (nil allows all),
+// and signs the requests of each route with the secrets of that route
+func NewGuardedClient(policy *destination.Policy, secretsPerRoute map[string][]string) (HTTPSender, error) {
+	signers := map[string]*signature.Signer{}
+	for route, secrets := range secretsPerRoute {
+		signer, err := signature.NewSigner(secrets)
+		if err != nil {
+			return nil, fmt.Errorf("Error creating signer for route %s: %s", route, err)
+		}
+		signers[route] = signer
+	}
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if policy != nil {
+		// the addresses are checked when connecting, after dns-resolution
+		dialer.Control = policy.Control
+	}
+	// without proxy: a proxy would connect on our behalf, unchecked
+	transport := &http.Transport{
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	return &client{
+		signers:   signers,
+		policy:    policy,
+		transport: transport,
+		nowFunc:   time.Now,
+	}, nil
 }
 
-func (_ client) Send(c context.Context, req Request) (*Response, error) {
+func (cl client) Send(c context.Context, req Request) (*Response, error) {
+	if cl.policy != nil {
+		err := cl.policy.CheckURL(req.URL)
+		if err != nil {
+			return nil, fmt.Errorf("Error sending %s: %w", req.String(), err)
+		}
+	}
 	httpReq, err := http.NewRequest(req.Method, req.URL, bytes.NewReader(req.Body))
 	if err != nil {
 		return nil, fmt.Errorf("Error creating http request for %s: %s", req.String(), err)
 	}
 	copyHeaders(httpReq.Header, req.Headers)
+	signer, found := cl.signers[req.Route]
+	if found {
+		// signed per attempt, so that the timestamp is fresh
+		signer.Sign(httpReq.Header, req.TaskUID, cl.nowFunc(), req.Method, req.URL, req.Body)
+	}
 
 	log.Printf("HTTP request: %s %s", req.Method, req.URL)
+	timeout := httpClientTimeout
+	if req.Timeout > 0 {
+		timeout = req.Timeout
+	}
 	httpClient := &http.Client{
-		Timeout: httpClientTimeout,
+		Timeout:       timeout,
+		Transport:     cl.transport,
+		CheckRedirect: cl.checkRedirect,
 	}
 	httpResp, err := httpClient.Do(httpReq)
 	if err != nil {
-		return nil, fmt.Errorf("Error sending %s: %s", req.String(), err)
+		return nil, fmt.Errorf("Error sending %s: %w", req.String(), err)
 	}
 	defer httpResp.Body.Close()
 
@@ -50,6 +115,17 @@ func (_ client) Send(c context.Context, req Request) (*Response, error) {
 
 }
 
+// checkRedirect applies the policy to the host that is redirected to
+func (cl client) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return fmt.Errorf("stopped after 10 redirects")
+	}
+	if cl.policy != nil {
+		return cl.policy.CheckURL(req.URL.String())
+	}
+	return nil
+}
+
 func copyHeaders(dst, src http.Header) {
 	for k, vv := range src {
 		for _, v := range vv {
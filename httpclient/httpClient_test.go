@@ -0,0 +1,91 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MarcGrol/forwardhttp/destination"
+	"github.com/MarcGrol/forwardhttp/signature"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSigning(t *testing.T) {
+	verifier, err := signature.NewVerifier([]string{"whsec_c2VjcmV0"}, signature.DefaultTolerance)
+	assert.NoError(t, err)
+
+	var verifyErr error
+	var signed bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		signed = r.Header.Get(signature.HeaderSignature) != ""
+		verifyErr = verifier.Verify(r.Header, r.Method, "http://"+r.Host+r.URL.RequestURI(), body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client, err := NewGuardedClient(nil, map[string][]string{"billing": {"whsec_b2xk", "whsec_c2VjcmV0"}})
+	assert.NoError(t, err)
+
+	resp, err := client.Send(context.Background(), Request{TaskUID: "abc", Method: "POST", URL: server.URL + "/doit?a=b", Body: []byte("payload"), Route: "billing"})
+	assert.NoError(t, err)
+	assert.Equal(t, 200, resp.Status)
+	assert.True(t, signed)
+	assert.NoError(t, verifyErr)
+
+	_, err = client.Send(context.Background(), Request{TaskUID: "def", Method: "POST", URL: server.URL + "/doit", Body: []byte("payload"), Route: "shipping"})
+	assert.NoError(t, err)
+	assert.False(t, signed)
+}
+
+func TestSigningClientRejectsInvalidSecret(t *testing.T) {
+	_, err := NewGuardedClient(nil, map[string][]string{"billing": {"whsec_not base64"}})
+	assert.EqualError(t, err, "Error creating signer for route billing: Invalid secret 1: secret with prefix 'whsec_' must be base64 encoded")
+}
+
+func TestDestinationPolicy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	port := server.URL[strings.LastIndex(server.URL, ":")+1:]
+
+	testCases := []struct {
+		name          string
+		policy        *destination.Policy
+		url           string
+		expectedError bool
+	}{
+		{name: "Private allowed", policy: &destination.Policy{AllowPrivate: true}, url: server.URL},
+		{name: "Loopback address", policy: &destination.Policy{}, url: server.URL, expectedError: true},
+		{name: "Host that resolves to loopback", policy: &destination.Policy{}, url: "http://localhost:" + port, expectedError: true},
+		{name: "Port not allowed", policy: &destination.Policy{AllowPrivate: true, AllowPorts: []int{443}}, url: server.URL, expectedError: true},
+		{name: "Host not allowed", policy: &destination.Policy{AllowPrivate: true, AllowHosts: []string{"*.example.com"}}, url: server.URL, expectedError: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := NewGuardedClient(tc.policy, nil)
+			assert.NoError(t, err)
+
+			_, err = client.Send(context.Background(), Request{TaskUID: "abc", Method: "POST", URL: tc.url})
+			assert.Equal(t, tc.expectedError, errors.Is(err, destination.ErrDenied), "unexpected error: %v", err)
+		})
+	}
+}
+
+func TestRedirectToDeniedDestination(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data", http.StatusFound)
+	}))
+	defer server.Close()
+
+	client, err := NewGuardedClient(&destination.Policy{AllowHosts: []string{"127.0.0.1"}, AllowPrivate: true, DenyHosts: []string{"169.254.169.254"}}, nil)
+	assert.NoError(t, err)
+
+	_, err = client.Send(context.Background(), Request{TaskUID: "abc", Method: "GET", URL: server.URL})
+	assert.True(t, errors.Is(err, destination.ErrDenied), "unexpected error: %v", err)
+}
@@ -0,0 +1,40 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/httpclient"
+)
+
+//go:generate mockgen -source=api.go -destination=gen_IdempotencyKeeperMock.go -package=idempotency github.com/MarcGrol/forwardhttp/idempotency Keeper
+
+// Record remembers how a submission was answered, so that a repeated submission gets the same answer
+type Record struct {
+	Key         string
+	TaskUID     string
+	Fingerprint string
+	Response    *httpclient.Response // nil when the request was only enqueued
+	CreatedAt   time.Time
+}
+
+type Keeper interface {
+	// Get only returns records that were created within the window
+	Get(c context.Context, key string) (*Record, bool, error)
+	Put(c context.Context, record Record) error
+	// TaskUID derives the task-uid of a submission from its key and the current window
+	TaskUID(key string) string
+}
+
+// Fingerprint identifies the content of a request, to tell a repeated submission from a different one with the same key
+func Fingerprint(req httpclient.Request) string {
+	h := sha256.New()
+	h.Write([]byte(req.Method))
+	h.Write([]byte("\n"))
+	h.Write([]byte(req.URL))
+	h.Write([]byte("\n"))
+	h.Write(req.Body)
+	return hex.EncodeToString(h.Sum(nil))
+}
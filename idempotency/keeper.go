@@ -0,0 +1,132 @@
+package idempotency
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/config"
+	"github.com/MarcGrol/forwardhttp/envelope"
+	"github.com/MarcGrol/forwardhttp/httpclient"
+	"github.com/MarcGrol/forwardhttp/store"
+)
+
+const (
+	idempotencyKeyKind = "IdempotencyKey"
+	defaultWindow      = 24 * time.Hour
+)
+
+type keeper struct {
+	store   store.DataStorer
+	window  time.Duration
+	sealer  envelope.Sealer
+	nowFunc func() time.Time
+}
+
+func NewKeeper(store store.DataStorer, window time.Duration, sealer envelope.Sealer) *keeper {
+	return &keeper{
+		store:   store,
+		window:  window,
+		sealer:  sealer,
+		nowFunc: time.Now,
+	}
+}
+
+// NewFromSettings keeps submissions for IDEMPOTENCY_WINDOW (default 24h)
+func NewFromSettings(settings config.Settings, store store.DataStorer, sealer envelope.Sealer) (Keeper, error) {
+	window, err := settings.Duration("IDEMPOTENCY_WINDOW", defaultWindow)
+	if err != nil {
+		return nil, err
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("Invalid setting 'IDEMPOTENCY_WINDOW': must be positive")
+	}
+	return NewKeeper(store, window, sealer), nil
+}
+
+// idempotencyRecord keeps the response as json, because datastore cannot store its headers
+type idempotencyRecord struct {
+	Key         string
+	TaskUID     string
+	Fingerprint string `datastore:",noindex"`
+	Response    []byte `datastore:",noindex"`
+	CreatedAt   time.Time
+	Sealed      bool // the response is encrypted
+}
+
+// TaskUID makes concurrent submissions with the same key the same task, that the queue only accepts once.
+// The start of the window is part of it, because the queue may still know the task of a submission
+// of which the window has passed: a submission after the window becomes a task of its own.
+func (k *keeper) TaskUID(key string) string {
+	return taskUID(key, k.nowFunc().Truncate(k.window))
+}
+
+func taskUID(key string, windowStart time.Time) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", key, windowStart.Unix())))
+	return hex.EncodeToString(h[:16])
+}
+
+func (k *keeper) Get(c context.Context, key string) (*Record, bool, error) {
+	record := idempotencyRecord{}
+	found, err := k.store.Get(c, idempotencyKeyKind, key, &record)
+	if err != nil {
+		return nil, false, fmt.Errorf("Error fetching idempotency-key %s: %s", key, err)
+	}
+	if !found || k.nowFunc().Sub(record.CreatedAt) > k.window {
+		return nil, false, nil
+	}
+
+	result := Record{
+		Key:         record.Key,
+		TaskUID:     record.TaskUID,
+		Fingerprint: record.Fingerprint,
+		CreatedAt:   record.CreatedAt,
+	}
+	if len(record.Response) > 0 {
+		if record.Sealed {
+			record.Response, err = k.sealer.Open(c, record.Response)
+			if err != nil {
+				return nil, false, fmt.Errorf("Error opening response of idempotency-key %s: %s", key, err)
+			}
+		}
+		result.Response = &httpclient.Response{}
+		err = json.Unmarshal(record.Response, result.Response)
+		if err != nil {
+			return nil, false, fmt.Errorf("Error unmarshalling response of idempotency-key %s: %s", key, err)
+		}
+	}
+	return &result, true, nil
+}
+
+func (k *keeper) Put(c context.Context, record Record) error {
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = k.nowFunc()
+	}
+	stored := idempotencyRecord{
+		Key:         record.Key,
+		TaskUID:     record.TaskUID,
+		Fingerprint: record.Fingerprint,
+		CreatedAt:   record.CreatedAt,
+		Sealed:      k.sealer.Encrypts(),
+	}
+	if record.Response != nil {
+		var err error
+		stored.Response, err = json.Marshal(record.Response)
+		if err != nil {
+			return fmt.Errorf("Error marshalling response of idempotency-key %s: %s", record.Key, err)
+		}
+		// the response is replayed for days, while it may hold personal data
+		stored.Response, err = k.sealer.Seal(c, stored.Response)
+		if err != nil {
+			return fmt.Errorf("Error sealing response of idempotency-key %s: %s", record.Key, err)
+		}
+	}
+	err := k.store.Put(c, idempotencyKeyKind, record.Key, stored)
+	if err != nil {
+		return fmt.Errorf("Error storing idempotency-key %s: %s", record.Key, err)
+	}
+	return nil
+}
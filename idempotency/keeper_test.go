@@ -0,0 +1,117 @@
+package idempotency
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/config"
+	"github.com/MarcGrol/forwardhttp/envelope"
+	"github.com/MarcGrol/forwardhttp/httpclient"
+	"github.com/MarcGrol/forwardhttp/store"
+	"github.com/stretchr/testify/assert"
+)
+
+var testTimestamp = time.Date(2021, time.November, 11, 10, 0, 0, 0, time.UTC)
+
+func TestKeeper(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := store.NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+	now := testTimestamp
+	k := NewKeeper(s, time.Hour, envelope.Plaintext())
+	k.nowFunc = func() time.Time { return now }
+
+	req := httpclient.Request{Method: "POST", URL: "https://home.nl/doit", Body: []byte("request body")}
+	resp := &httpclient.Response{Status: 201, Headers: http.Header{"Location": []string{"/doit/1"}}, Body: []byte("created")}
+	assert.NoError(t, k.Put(c, Record{Key: "key-1", TaskUID: "abc", Fingerprint: Fingerprint(req), Response: resp}))
+	assert.NoError(t, k.Put(c, Record{Key: "key-2", TaskUID: "def", Fingerprint: Fingerprint(req)}))
+
+	record, found, err := k.Get(c, "key-1")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, &Record{Key: "key-1", TaskUID: "abc", Fingerprint: Fingerprint(req), Response: resp, CreatedAt: testTimestamp}, record)
+
+	record, found, err = k.Get(c, "key-2")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Nil(t, record.Response)
+
+	_, found, err = k.Get(c, "key-3")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	// outside the window
+	now = now.Add(time.Hour + time.Second)
+	_, found, err = k.Get(c, "key-1")
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestKeeperTaskUID(t *testing.T) {
+	now := testTimestamp.Add(10 * time.Minute)
+	k := NewKeeper(nil, time.Hour, envelope.Plaintext())
+	k.nowFunc = func() time.Time { return now }
+
+	taskUID := k.TaskUID("key-1")
+	assert.Len(t, taskUID, 32)
+	assert.NotEqual(t, taskUID, k.TaskUID("key-2"))
+
+	// within the window
+	now = now.Add(45 * time.Minute)
+	assert.Equal(t, taskUID, k.TaskUID("key-1"))
+
+	// after the window the queue may still know the old task
+	now = now.Add(time.Hour)
+	assert.NotEqual(t, taskUID, k.TaskUID("key-1"))
+}
+
+func TestKeeperEncryptsResponse(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := store.NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+	keyring, err := envelope.NewKeyring("key-1", map[string][]byte{"key-1": []byte("0123456789abcdef0123456789abcdef")})
+	assert.NoError(t, err)
+	k := NewKeeper(s, time.Hour, envelope.New(keyring))
+
+	resp := &httpclient.Response{Status: 201, Body: []byte("john@example.com")}
+	assert.NoError(t, k.Put(c, Record{Key: "key-1", TaskUID: "abc", Response: resp}))
+
+	stored := idempotencyRecord{}
+	_, err = s.Get(c, idempotencyKeyKind, "key-1", &stored)
+	assert.NoError(t, err)
+	assert.True(t, stored.Sealed)
+	assert.NotContains(t, string(stored.Response), "john@example.com")
+
+	record, found, err := k.Get(c, "key-1")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, resp, record.Response)
+}
+
+func TestFingerprint(t *testing.T) {
+	req := httpclient.Request{Method: "POST", URL: "https://home.nl/doit", Body: []byte("request body")}
+
+	other := req
+	other.Headers = http.Header{"X-Trace": []string{"123"}}
+	assert.Equal(t, Fingerprint(req), Fingerprint(other), "headers are ignored")
+
+	other = req
+	other.Body = []byte("other body")
+	assert.NotEqual(t, Fingerprint(req), Fingerprint(other))
+
+	other = req
+	other.URL = "https://home.nl/other"
+	assert.NotEqual(t, Fingerprint(req), Fingerprint(other))
+}
+
+func TestNewFromSettings(t *testing.T) {
+	_, err := NewFromSettings(config.New(map[string]string{}), nil, envelope.Plaintext())
+	assert.NoError(t, err)
+
+	_, err = NewFromSettings(config.New(map[string]string{"IDEMPOTENCY_WINDOW": "0s"}), nil, envelope.Plaintext())
+	assert.EqualError(t, err, "Invalid setting 'IDEMPOTENCY_WINDOW': must be positive")
+}
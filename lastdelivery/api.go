@@ -2,12 +2,36 @@ package lastdelivery
 
 import (
 	"context"
+	"time"
 
 	"github.com/MarcGrol/forwardhttp/httpclient"
 )
 
-//go:generate mockgen -source=api.go -destination=gen_LastDeliveryMock.go -package=lastdelivery github.com/MarcGrol/forwardhttp/lastdelivery LastDeliverer
+//go:generate mockgen -source=api.go -destination=gen_LastDeliveryMock.go -package=lastdelivery github.com/MarcGrol/forwardhttp/lastdelivery LastDeliverer,DeadLetterStorer
 
 type LastDeliverer interface {
 	OnLastDelivery(c context.Context, req httpclient.Request, resp *httpclient.Response, err error)
 }
+
+// DeadLetter is a request that could not be delivered, together with the outcome of its last attempt
+type DeadLetter struct {
+	TaskUID   string
+	Host      string
+	Request   httpclient.Request
+	Response  *httpclient.Response `json:",omitempty"`
+	Error     string               `json:",omitempty"`
+	Timestamp time.Time
+}
+
+// DeadLetterFilter selects dead letters: empty fields do not restrict
+type DeadLetterFilter struct {
+	Host  string
+	From  time.Time // inclusive
+	Until time.Time // exclusive
+}
+
+type DeadLetterStorer interface {
+	List(c context.Context, filter DeadLetterFilter) ([]DeadLetter, error)
+	Get(c context.Context, taskUID string) (*DeadLetter, bool, error)
+	Delete(c context.Context, taskUID string) error
+}
@@ -0,0 +1,183 @@
+package lastdelivery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/envelope"
+	"github.com/MarcGrol/forwardhttp/httpclient"
+	"github.com/MarcGrol/forwardhttp/store"
+)
+
+const deadLetterKind = "DeadLetter"
+
+type deadLetters struct {
+	store  store.DataStorer
+	sealer envelope.Sealer
+}
+
+// NewDeadLetterStore keeps the requests that could not be delivered, so that they can be inspected and replayed
+func NewDeadLetterStore(store store.DataStorer, sealer envelope.Sealer) *deadLetters {
+	return &deadLetters{
+		store:  store,
+		sealer: sealer,
+	}
+}
+
+// deadLetterRecord keeps request and response as json, because datastore cannot store their headers
+type deadLetterRecord struct {
+	TaskUID   string
+	Host      string
+	Request   []byte `datastore:",noindex"`
+	Response  []byte `datastore:",noindex"`
+	ErrorMsg  string `datastore:",noindex"`
+	Timestamp time.Time
+	Sealed    bool // request and response are encrypted
+}
+
+func (d *deadLetters) OnLastDelivery(c context.Context, req httpclient.Request, resp *httpclient.Response, err error) {
+	if err == nil && resp != nil && !resp.IsError() {
+		return // delivered
+	}
+
+	record, recordErr := d.newDeadLetterRecord(c, req, resp, err)
+	if recordErr != nil {
+		log.Printf("Error composing dead letter for %s: %s", req, recordErr)
+		return
+	}
+	putErr := d.store.Put(c, deadLetterKind, req.TaskUID, record)
+	if putErr != nil {
+		log.Printf("Error storing dead letter for %s: %s", req, putErr)
+		return
+	}
+	log.Printf("Stored dead letter for %s", req)
+}
+
+// newDeadLetterRecord seals request and response as a whole, because their headers may hold credentials as well
+func (d *deadLetters) newDeadLetterRecord(c context.Context, req httpclient.Request, resp *httpclient.Response, err error) (deadLetterRecord, error) {
+	record := deadLetterRecord{
+		TaskUID:   req.TaskUID,
+		Host:      hostOf(req.URL),
+		Timestamp: time.Now(),
+		Sealed:    d.sealer.Encrypts(),
+	}
+	var marshalErr error
+	record.Request, marshalErr = json.Marshal(req)
+	if marshalErr != nil {
+		return record, fmt.Errorf("Error marshalling request: %s", marshalErr)
+	}
+	record.Request, marshalErr = d.sealer.Seal(c, record.Request)
+	if marshalErr != nil {
+		return record, fmt.Errorf("Error sealing request: %s", marshalErr)
+	}
+	if resp != nil {
+		record.Response, marshalErr = json.Marshal(resp)
+		if marshalErr != nil {
+			return record, fmt.Errorf("Error marshalling response: %s", marshalErr)
+		}
+		record.Response, marshalErr = d.sealer.Seal(c, record.Response)
+		if marshalErr != nil {
+			return record, fmt.Errorf("Error sealing response: %s", marshalErr)
+		}
+	}
+	if err != nil {
+		record.ErrorMsg = err.Error()
+	}
+	return record, nil
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+func (d *deadLetters) List(c context.Context, filter DeadLetterFilter) ([]DeadLetter, error) {
+	filters := []store.Filter{}
+	if filter.Host != "" {
+		filters = append(filters, store.Filter{Field: "Host", Operator: "=", Value: filter.Host})
+	}
+	if !filter.From.IsZero() {
+		filters = append(filters, store.Filter{Field: "Timestamp", Operator: ">=", Value: filter.From})
+	}
+	if !filter.Until.IsZero() {
+		filters = append(filters, store.Filter{Field: "Timestamp", Operator: "<", Value: filter.Until})
+	}
+
+	records := []deadLetterRecord{}
+	err := d.store.Query(c, deadLetterKind, filters, &records)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying dead letters: %s", err)
+	}
+	letters := []DeadLetter{}
+	for _, record := range records {
+		letter, err := d.toDeadLetter(c, record)
+		if err != nil {
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+func (d *deadLetters) Get(c context.Context, taskUID string) (*DeadLetter, bool, error) {
+	record := deadLetterRecord{}
+	found, err := d.store.Get(c, deadLetterKind, taskUID, &record)
+	if err != nil {
+		return nil, false, fmt.Errorf("Error fetching dead letter: %s", err)
+	}
+	if !found {
+		return nil, false, nil
+	}
+	letter, err := d.toDeadLetter(c, record)
+	if err != nil {
+		return nil, false, err
+	}
+	return &letter, true, nil
+}
+
+func (d *deadLetters) Delete(c context.Context, taskUID string) error {
+	err := d.store.Delete(c, deadLetterKind, taskUID)
+	if err != nil {
+		return fmt.Errorf("Error deleting dead letter: %s", err)
+	}
+	return nil
+}
+
+func (d *deadLetters) toDeadLetter(c context.Context, r deadLetterRecord) (DeadLetter, error) {
+	letter := DeadLetter{
+		TaskUID:   r.TaskUID,
+		Host:      r.Host,
+		Error:     r.ErrorMsg,
+		Timestamp: r.Timestamp,
+	}
+	if r.Sealed {
+		var err error
+		r.Request, err = d.sealer.Open(c, r.Request)
+		if err != nil {
+			return letter, fmt.Errorf("Error opening request of dead letter %s: %s", r.TaskUID, err)
+		}
+		r.Response, err = d.sealer.Open(c, r.Response)
+		if err != nil {
+			return letter, fmt.Errorf("Error opening response of dead letter %s: %s", r.TaskUID, err)
+		}
+	}
+	err := json.Unmarshal(r.Request, &letter.Request)
+	if err != nil {
+		return letter, fmt.Errorf("Error unmarshalling request of dead letter %s: %s", r.TaskUID, err)
+	}
+	if len(r.Response) > 0 {
+		letter.Response = &httpclient.Response{}
+		err = json.Unmarshal(r.Response, letter.Response)
+		if err != nil {
+			return letter, fmt.Errorf("Error unmarshalling response of dead letter %s: %s", r.TaskUID, err)
+		}
+	}
+	return letter, nil
+}
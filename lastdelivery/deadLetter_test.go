@@ -0,0 +1,100 @@
+package lastdelivery
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/envelope"
+	"github.com/MarcGrol/forwardhttp/httpclient"
+	"github.com/MarcGrol/forwardhttp/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetterStore(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := store.NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+	d := NewDeadLetterStore(s, envelope.Plaintext())
+
+	before := time.Now()
+	headers := http.Header{"Authorization": []string{"Bearer 123"}}
+	d.OnLastDelivery(c, httpclient.Request{TaskUID: "delivered", Method: "POST", URL: "https://home.nl/doit"}, &httpclient.Response{Status: 200}, nil)
+	d.OnLastDelivery(c, httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit", Headers: headers, Body: []byte("payload")}, &httpclient.Response{Status: 500, Body: []byte("error")}, nil)
+	d.OnLastDelivery(c, httpclient.Request{TaskUID: "def", Method: "PUT", URL: "https://other.nl/doit"}, nil, fmt.Errorf("network error"))
+
+	letter, found, err := d.Get(c, "abc")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "home.nl", letter.Host)
+	assert.Equal(t, headers, letter.Request.Headers)
+	assert.Equal(t, []byte("payload"), letter.Request.Body)
+	assert.Equal(t, 500, letter.Response.Status)
+	assert.Equal(t, "", letter.Error)
+
+	letter, found, err = d.Get(c, "def")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Nil(t, letter.Response)
+	assert.Equal(t, "network error", letter.Error)
+
+	_, found, err = d.Get(c, "delivered")
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	letters, err := d.List(c, DeadLetterFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, letters, 2)
+
+	letters, err = d.List(c, DeadLetterFilter{Host: "other.nl"})
+	assert.NoError(t, err)
+	assert.Len(t, letters, 1)
+	assert.Equal(t, "def", letters[0].TaskUID)
+
+	letters, err = d.List(c, DeadLetterFilter{From: before, Until: time.Now().Add(time.Minute)})
+	assert.NoError(t, err)
+	assert.Len(t, letters, 2)
+
+	letters, err = d.List(c, DeadLetterFilter{Until: before})
+	assert.NoError(t, err)
+	assert.Len(t, letters, 0)
+
+	err = d.Delete(c, "abc")
+	assert.NoError(t, err)
+	letters, err = d.List(c, DeadLetterFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, letters, 1)
+}
+
+func TestDeadLetterEncryptedAtRest(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := store.NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+	keyring, err := envelope.NewKeyring("key-1", map[string][]byte{"key-1": []byte("0123456789abcdef0123456789abcdef")})
+	assert.NoError(t, err)
+	d := NewDeadLetterStore(s, envelope.New(keyring))
+
+	headers := http.Header{"Authorization": []string{"Bearer 123"}}
+	d.OnLastDelivery(c, httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit", Headers: headers, Body: []byte("payload")}, &httpclient.Response{Status: 500, Body: []byte("error")}, nil)
+
+	record := deadLetterRecord{}
+	_, err = s.Get(c, deadLetterKind, "abc", &record)
+	assert.NoError(t, err)
+	assert.True(t, record.Sealed)
+	assert.NotContains(t, string(record.Request), "Bearer 123")
+	assert.NotContains(t, string(record.Response), "error")
+
+	letter, found, err := d.Get(c, "abc")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, headers, letter.Request.Headers)
+	assert.Equal(t, []byte("payload"), letter.Request.Body)
+	assert.Equal(t, []byte("error"), letter.Response.Body)
+
+	_, _, err = NewDeadLetterStore(s, envelope.Plaintext()).Get(c, "abc")
+	assert.Error(t, err)
+}
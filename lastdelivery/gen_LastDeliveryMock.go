@@ -45,3 +45,71 @@ func (mr *MockLastDelivererMockRecorder) OnLastDelivery(c, req, resp, err interf
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "OnLastDelivery", reflect.TypeOf((*MockLastDeliverer)(nil).OnLastDelivery), c, req, resp, err)
 }
+
+// MockDeadLetterStorer is a mock of DeadLetterStorer interface
+type MockDeadLetterStorer struct {
+	ctrl     *gomock.Controller
+	recorder *MockDeadLetterStorerMockRecorder
+}
+
+// MockDeadLetterStorerMockRecorder is the mock recorder for MockDeadLetterStorer
+type MockDeadLetterStorerMockRecorder struct {
+	mock *MockDeadLetterStorer
+}
+
+// NewMockDeadLetterStorer creates a new mock instance
+func NewMockDeadLetterStorer(ctrl *gomock.Controller) *MockDeadLetterStorer {
+	mock := &MockDeadLetterStorer{ctrl: ctrl}
+	mock.recorder = &MockDeadLetterStorerMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockDeadLetterStorer) EXPECT() *MockDeadLetterStorerMockRecorder {
+	return m.recorder
+}
+
+// Delete mocks base method
+func (m *MockDeadLetterStorer) Delete(c context.Context, taskUID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", c, taskUID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete
+func (mr *MockDeadLetterStorerMockRecorder) Delete(c, taskUID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockDeadLetterStorer)(nil).Delete), c, taskUID)
+}
+
+// Get mocks base method
+func (m *MockDeadLetterStorer) Get(c context.Context, taskUID string) (*DeadLetter, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", c, taskUID)
+	ret0, _ := ret[0].(*DeadLetter)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Get indicates an expected call of Get
+func (mr *MockDeadLetterStorerMockRecorder) Get(c, taskUID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockDeadLetterStorer)(nil).Get), c, taskUID)
+}
+
+// List mocks base method
+func (m *MockDeadLetterStorer) List(c context.Context, filter DeadLetterFilter) ([]DeadLetter, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "List", c, filter)
+	ret0, _ := ret[0].([]DeadLetter)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// List indicates an expected call of List
+func (mr *MockDeadLetterStorerMockRecorder) List(c, filter interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "List", reflect.TypeOf((*MockDeadLetterStorer)(nil).List), c, filter)
+}
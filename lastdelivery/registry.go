@@ -0,0 +1,58 @@
+package lastdelivery
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/MarcGrol/forwardhttp/config"
+	"github.com/MarcGrol/forwardhttp/envelope"
+	"github.com/MarcGrol/forwardhttp/store"
+)
+
+// Provider creates a last-delivery backend, after validating the settings it needs
+type Provider func(c context.Context, settings config.Settings, store store.DataStorer, sealer envelope.Sealer) (LastDeliverer, error)
+
+var providers = map[string]Provider{
+	"log":        newLastDeliveryFromSettings,
+	"deadletter": newDeadLetterStoreFromSettings,
+}
+
+func RegisterProvider(name string, provider Provider) {
+	providers[name] = provider
+}
+
+// NewFromSettings creates the last-delivery backend selected by LASTDELIVERY_BACKEND (default "log")
+func NewFromSettings(c context.Context, settings config.Settings, store store.DataStorer, sealer envelope.Sealer) (LastDeliverer, error) {
+	name := settings.GetOrDefault("LASTDELIVERY_BACKEND", "log")
+	provider, found := providers[name]
+	if !found {
+		return nil, fmt.Errorf("Unknown last-delivery backend '%s', expected one of: %s", name, providerNames())
+	}
+	l, err := provider(c, settings, store, sealer)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating last-delivery backend '%s': %s", name, err)
+	}
+	return l, nil
+}
+
+func providerNames() string {
+	names := []string{}
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func newLastDeliveryFromSettings(c context.Context, settings config.Settings, store store.DataStorer, sealer envelope.Sealer) (LastDeliverer, error) {
+	return NewLastDelivery(), nil
+}
+
+func newDeadLetterStoreFromSettings(c context.Context, settings config.Settings, store store.DataStorer, sealer envelope.Sealer) (LastDeliverer, error) {
+	if store == nil {
+		return nil, fmt.Errorf("Missing store")
+	}
+	return NewDeadLetterStore(store, sealer), nil
+}
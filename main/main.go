@@ -5,8 +5,14 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"os"
 
+	"github.com/MarcGrol/forwardhttp/auth"
+	"github.com/MarcGrol/forwardhttp/circuitbreaker"
+	"github.com/MarcGrol/forwardhttp/config"
+	"github.com/MarcGrol/forwardhttp/deadletter"
+	"github.com/MarcGrol/forwardhttp/destination"
+	"github.com/MarcGrol/forwardhttp/envelope"
+	"github.com/MarcGrol/forwardhttp/idempotency"
 	"github.com/MarcGrol/forwardhttp/uniqueid"
 
 	"github.com/MarcGrol/forwardhttp/lastdelivery"
@@ -15,7 +21,13 @@ import (
 	"github.com/MarcGrol/forwardhttp/forwarder"
 	"github.com/MarcGrol/forwardhttp/httpclient"
 	"github.com/MarcGrol/forwardhttp/queue"
+	"github.com/MarcGrol/forwardhttp/queueauth"
+	"github.com/MarcGrol/forwardhttp/ratelimit"
+	"github.com/MarcGrol/forwardhttp/retry"
+	"github.com/MarcGrol/forwardhttp/route"
 	store2 "github.com/MarcGrol/forwardhttp/store"
+	"github.com/MarcGrol/forwardhttp/tasks"
+	"github.com/MarcGrol/forwardhttp/topic"
 	"github.com/MarcGrol/forwardhttp/warehouse"
 	"github.com/gorilla/mux"
 )
@@ -25,35 +37,95 @@ func main() {
 
 	var router = mux.NewRouter()
 
-	queue, qcleanup, err := queue.NewQueue(c)
+	settings, err := config.Load()
+	if err != nil {
+		log.Fatalf("Error loading settings: %s", err)
+	}
+
+	port := settings.Get("PORT")
+	if port == "" {
+		port = "8080"
+		log.Printf("Defaulting to port %s", port)
+	}
+
+	queue, qcleanup, err := queue.NewFromSettings(c, settings)
 	if err != nil {
 		log.Fatalf("Error creating queue: %s", err)
 	}
 	defer qcleanup()
 
-	store, scleanup, err := store2.NewStore(c)
+	store, scleanup, err := store2.NewFromSettings(c, settings)
 	if err != nil {
-		log.Fatalf("Error creating queue: %s", err)
+		log.Fatalf("Error creating store: %s", err)
 	}
 	defer scleanup()
 
-	httpClient := httpclient.NewClient()
-	warehouse := warehouse.New(store)
-	lastdeliverer := lastdelivery.NewLastDelivery()
-	forwarder := forwarder.NewService(queue, httpClient, warehouse, lastdeliverer)
+	sealer, err := envelope.NewFromSettings(settings)
+	if err != nil {
+		log.Fatalf("Error creating encryption: %s", err)
+	}
+
+	warehouse, err := warehouse.NewFromSettings(c, settings, store, sealer)
+	if err != nil {
+		log.Fatalf("Error creating warehouse: %s", err)
+	}
+
+	lastdeliverer, err := lastdelivery.NewFromSettings(c, settings, store, sealer)
+	if err != nil {
+		log.Fatalf("Error creating last-delivery: %s", err)
+	}
+
+	routes, err := route.NewConfigFromSettings(settings)
+	if err != nil {
+		log.Fatalf("Error loading routes: %s", err)
+	}
+
+	destinations, err := destination.NewFromSettings(settings)
+	if err != nil {
+		log.Fatalf("Error loading destination-policy: %s", err)
+	}
+
+	httpClient, err := httpclient.NewGuardedClient(destinations, routes.SigningKeys())
+	if err != nil {
+		log.Fatalf("Error creating http-client: %s", err)
+	}
+
+	// an open circuit refuses a request before it takes a token of the rate-limit
+	limiter := ratelimit.New(httpClient, routes)
+	circuits, err := circuitbreaker.NewFromSettings(settings, limiter)
+	if err != nil {
+		log.Fatalf("Error creating circuit-breaker: %s", err)
+	}
+
+	queueAuth, err := queueauth.NewFromSettings(settings)
+	if err != nil {
+		log.Fatalf("Error creating queue-authentication: %s", err)
+	}
+
+	authenticator, err := auth.NewFromSettings(settings)
+	if err != nil {
+		log.Fatalf("Error creating authenticator: %s", err)
+	}
+
+	forwarder := forwarder.NewService(queue, circuits, warehouse, lastdeliverer, retry.NewDefaultPolicy(), destinations, queueAuth, sealer)
 	forwarder.RegisterEndPoint(router)
+	tasks := tasks.NewWebService(warehouse, forwarder, authenticator)
+	tasks.RegisterEndpoint(router)
 	uidGenerator := uniqueid.NewGenerator()
-	entrypoint := entrypoint.NewWebService(uidGenerator, forwarder)
+	deadLetters := deadletter.NewWebService(lastdelivery.NewDeadLetterStore(store, sealer), forwarder, uidGenerator, authenticator)
+	deadLetters.RegisterEndpoint(router)
+	circuitbreaker.NewWebService(circuits, authenticator).RegisterEndpoint(router)
+	topics := topic.NewWebService(topic.NewRegistry(store, sealer), forwarder, uidGenerator, authenticator, destinations)
+	topics.RegisterEndpoint(router)
+	idempotencyKeeper, err := idempotency.NewFromSettings(settings, store, sealer)
+	if err != nil {
+		log.Fatalf("Error creating idempotency-keeper: %s", err)
+	}
+	entrypoint := entrypoint.NewWebService(uidGenerator, forwarder, routes, idempotencyKeeper, authenticator)
 	entrypoint.RegisterEndpoint(router)
 
 	http.Handle("/", router)
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
-		log.Printf("Defaulting to port %s", port)
-	}
-
 	log.Printf("Listening on port %s", port)
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), nil))
 }
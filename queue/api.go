@@ -2,6 +2,8 @@ package queue
 
 import (
 	"context"
+	"errors"
+	"time"
 )
 
 type Task struct {
@@ -9,6 +11,53 @@ type Task struct {
 	WebhookURLPath string
 	Payload        []byte
 	IsLastAttempt  bool
+	ScheduleTime   time.Time // zero means: deliver as soon as possible
+}
+
+// dueAt returns when the first attempt of the task should be made
+func (t Task) dueAt(now time.Time) time.Time {
+	if t.ScheduleTime.After(now) {
+		return t.ScheduleTime
+	}
+	return now
+}
+
+// ErrTaskAlreadyExists is returned when a task with the same UID was already submitted
+var ErrTaskAlreadyExists = errors.New("Task already exists")
+
+// ErrTaskNotFound is returned when a task to delete is not (or no longer) pending
+var ErrTaskNotFound = errors.New("Task not found")
+
+const maxDoublings = 16 // same as the cloudtasks default
+
+type RetryConfig struct {
+	MaxAttempts int32 // zero or negative means unlimited
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+}
+
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		MaxAttempts: 10,
+		MinBackoff:  1 * time.Second,
+		MaxBackoff:  5 * time.Minute,
+	}
+}
+
+// Backoff returns the delay before the next attempt, doubling per attempt that was already made
+func (rc RetryConfig) Backoff(attemptsMade int32) time.Duration {
+	backoff := rc.MinBackoff
+	for i := int32(1); i < attemptsMade && i <= maxDoublings; i++ {
+		backoff *= 2
+	}
+	if rc.MaxBackoff > 0 && backoff > rc.MaxBackoff {
+		backoff = rc.MaxBackoff
+	}
+	return backoff
+}
+
+func (rc RetryConfig) isExhausted(attemptsMade int32) bool {
+	return rc.MaxAttempts > 0 && attemptsMade >= rc.MaxAttempts
 }
 
 //go:generate mockgen -source=api.go -destination=gen_TaskQueuerMock.go -package=queue github.com/MarcGrol/forwardhttp/queue TaskQueuer
@@ -16,4 +65,5 @@ type Task struct {
 type TaskQueuer interface {
 	Enqueue(c context.Context, task Task) error
 	IsLastAttempt(c context.Context, taskUID string) (int32, int32)
+	Delete(c context.Context, taskUID string) error
 }
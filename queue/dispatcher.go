@@ -0,0 +1,70 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/signature"
+)
+
+const dispatchTimeout = 60 * time.Second
+
+// taskDispatcher delivers tasks of the self-hosted queues to the webhook of this service
+type taskDispatcher struct {
+	queueName  string
+	baseURL    string
+	httpClient *http.Client
+	signer     *signature.Signer // nil means: dispatch unsigned
+	nowFunc    func() time.Time
+}
+
+// newTaskDispatcher signs every dispatch with the secret, so that the webhook can tell it comes from the queue
+func newTaskDispatcher(queueName string, baseURL string, secret string) (*taskDispatcher, error) {
+	d := &taskDispatcher{
+		queueName: queueName,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		httpClient: &http.Client{
+			Timeout: dispatchTimeout,
+		},
+		nowFunc: time.Now,
+	}
+	if secret != "" {
+		signer, err := signature.NewSigner([]string{secret})
+		if err != nil {
+			return nil, fmt.Errorf("Invalid queue secret: %s", err)
+		}
+		d.signer = signer
+	}
+	return d, nil
+}
+
+func (d *taskDispatcher) deliver(c context.Context, task Task, attempt int32) error {
+	httpReq, err := http.NewRequestWithContext(c, http.MethodPost, d.baseURL+task.WebhookURLPath, bytes.NewReader(task.Payload))
+	if err != nil {
+		return fmt.Errorf("Error creating dispatch request for task %s: %s", task.UID, err)
+	}
+	// same headers as cloudtasks adds to its dispatch requests
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-CloudTasks-QueueName", d.queueName)
+	httpReq.Header.Set("X-CloudTasks-TaskName", task.UID)
+	httpReq.Header.Set("X-CloudTasks-TaskRetryCount", fmt.Sprintf("%d", attempt-1))
+	if d.signer != nil {
+		// the path is signed, because the webhook cannot know the base-url it is reached by
+		d.signer.Sign(httpReq.Header, task.UID, d.nowFunc(), http.MethodPost, task.WebhookURLPath, task.Payload)
+	}
+
+	httpResp, err := d.httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("Error dispatching task %s: %s", task.UID, err)
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode < http.StatusOK || httpResp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("Error dispatching task %s: resp-status: %d", task.UID, httpResp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	opEnqueue  = "enqueue"
+	opDispatch = "dispatch"
+	opRetry    = "retry"
+	opFinish   = "finish"
+
+	// compactAfterEntries bounds the growth of the journal of a long-running process
+	compactAfterEntries = 10000
+)
+
+type journalEntry struct {
+	Op            string
+	UID           string `json:",omitempty"`
+	Task          *Task  `json:",omitempty"`
+	DispatchCount int32  `json:",omitempty"`
+	DueAt         time.Time
+	Timestamp     time.Time
+}
+
+func (e journalEntry) taskUID() string {
+	if e.Task != nil {
+		return e.Task.UID
+	}
+	return e.UID
+}
+
+type fileJournal struct {
+	filename     string
+	file         *os.File
+	entries      int
+	compactAfter int
+}
+
+// NewFileQueue creates a queue that behaves like the memory-queue but persists every state change
+// in an append-only log. Upon startup the log is replayed and compacted: pending tasks are rescheduled
+// and tasks that were being dispatched when the process stopped are retried immediately.
+// While running, the log is compacted again every so many entries.
+func NewFileQueue(c context.Context, filename string, baseURL string, secret string, retryConfig RetryConfig) (TaskQueuer, func(), error) {
+	if baseURL == "" {
+		return nil, nil, fmt.Errorf("Error creating file-queue: missing base-url")
+	}
+	if filename == "" {
+		return nil, nil, fmt.Errorf("Error creating file-queue: missing filename")
+	}
+
+	q, err := newMemoryTaskQueue(baseURL, secret, retryConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating file-queue: %s", err)
+	}
+
+	pending, err := restore(filename, q)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error restoring file-queue %s: %s", filename, err)
+	}
+
+	q.journal, err = openJournal(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error opening file-queue %s: %s", filename, err)
+	}
+
+	q.Lock()
+	for _, p := range pending {
+		log.Printf("Recovered task %s with %d attempts", p.task.task.UID, p.task.dispatchCount)
+		q.schedule(p.task, time.Until(p.dueAt))
+	}
+	q.Unlock()
+
+	return q, q.close, nil
+}
+
+type pendingTask struct {
+	task  *memoryTask
+	dueAt time.Time
+}
+
+// restore replays the log into the queue and rewrites the log so that it only contains what is still relevant
+func restore(filename string, q *memoryTaskQueue) ([]pendingTask, error) {
+	entries, err := readJournal(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	pendingByUID := map[string]*pendingTask{}
+	order := []string{}
+	for _, e := range entries {
+		uid := e.taskUID()
+		switch e.Op {
+		case opEnqueue:
+			if e.Task == nil {
+				continue
+			}
+			delete(q.finished, uid)
+			pendingByUID[uid] = &pendingTask{
+				task:  &memoryTask{task: *e.Task, dispatchCount: e.DispatchCount},
+				dueAt: e.DueAt,
+			}
+			order = append(order, uid)
+		case opDispatch:
+			if p, found := pendingByUID[uid]; found {
+				p.task.dispatchCount = e.DispatchCount
+				// in flight: if no retry follows, the process stopped during dispatch
+				p.dueAt = time.Time{}
+			}
+		case opRetry:
+			if p, found := pendingByUID[uid]; found {
+				p.dueAt = e.DueAt
+			}
+		case opFinish:
+			delete(pendingByUID, uid)
+			q.finished[uid] = e.Timestamp
+		}
+	}
+	q.purgeFinished()
+
+	pending := []pendingTask{}
+	for _, uid := range order {
+		if p, found := pendingByUID[uid]; found {
+			if q.retryConfig.isExhausted(p.task.dispatchCount) {
+				// stopped during its final attempt: that attempt is repeated, so that it is still known to be the last one
+				p.task.dispatchCount = q.retryConfig.MaxAttempts - 1
+			}
+			pending = append(pending, *p)
+			delete(pendingByUID, uid) // a uid that was re-enqueued after finishing occurs twice in order
+		}
+	}
+
+	err = compactJournal(filename, pending, q.finished)
+	if err != nil {
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+func readJournal(filename string) ([]journalEntry, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []journalEntry{}, nil
+		}
+		return nil, fmt.Errorf("Error reading journal: %s", err)
+	}
+
+	entries := []journalEntry{}
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) > 0 {
+				log.Printf("Ignoring incomplete last journal entry")
+			}
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error reading journal: %s", err)
+		}
+		var entry journalEntry
+		err = json.Unmarshal(line, &entry)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing journal entry '%s': %s", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func compactJournal(filename string, pending []pendingTask, finished map[string]time.Time) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	for uid, ts := range finished {
+		err := encoder.Encode(journalEntry{Op: opFinish, UID: uid, Timestamp: ts})
+		if err != nil {
+			return fmt.Errorf("Error encoding journal entry: %s", err)
+		}
+	}
+	for _, p := range pending {
+		task := p.task.task
+		err := encoder.Encode(journalEntry{Op: opEnqueue, Task: &task, DispatchCount: p.task.dispatchCount, DueAt: p.dueAt})
+		if err != nil {
+			return fmt.Errorf("Error encoding journal entry: %s", err)
+		}
+	}
+
+	tmpFilename := filename + ".tmp"
+	err := writeFileSynced(tmpFilename, buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("Error writing compacted journal: %s", err)
+	}
+	err = os.Rename(tmpFilename, filename)
+	if err != nil {
+		return fmt.Errorf("Error replacing journal: %s", err)
+	}
+	return nil
+}
+
+func writeFileSynced(filename string, data []byte) error {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(data)
+	if err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func openJournal(filename string) (*fileJournal, error) {
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &fileJournal{filename: filename, file: f, compactAfter: compactAfterEntries}, nil
+}
+
+func (j *fileJournal) record(entry journalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("Error encoding journal entry: %s", err)
+	}
+	_, err = j.file.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("Error writing journal entry: %s", err)
+	}
+	j.entries++
+	return j.file.Sync()
+}
+
+func (j *fileJournal) compactionDue() bool {
+	return j.entries >= j.compactAfter
+}
+
+// compact replaces the journal by one that only contains what is still relevant
+func (j *fileJournal) compact(pending []pendingTask, finished map[string]time.Time) error {
+	err := j.file.Close()
+	if err != nil {
+		return fmt.Errorf("Error closing journal: %s", err)
+	}
+	compactErr := compactJournal(j.filename, pending, finished)
+
+	// also after a failed compaction entries must be appended
+	f, err := os.OpenFile(j.filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("Error reopening journal: %s", err)
+	}
+	j.file = f
+	if compactErr != nil {
+		// retried once as many entries were added again
+		j.entries = 0
+		return compactErr
+	}
+	j.entries = len(pending) + len(finished)
+	if j.entries >= j.compactAfter {
+		// what is still relevant does not fit: wait until the journal doubled
+		j.compactAfter = 2 * j.entries
+	}
+	return nil
+}
+
+func (j *fileJournal) close() error {
+	return j.file.Close()
+}
@@ -0,0 +1,196 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	helperEnvFilename = "FILEQUEUE_HELPER_FILENAME"
+	helperEnvBaseURL  = "FILEQUEUE_HELPER_BASEURL"
+)
+
+func testRetryConfig() RetryConfig {
+	return RetryConfig{MaxAttempts: 5, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+}
+
+// TestFileQueueHelperProcess is not a real test: it is the process that gets killed by TestFileQueueCrashRecovery
+func TestFileQueueHelperProcess(t *testing.T) {
+	filename := os.Getenv(helperEnvFilename)
+	if filename == "" {
+		return
+	}
+
+	c := context.Background()
+	q, _, err := NewFileQueue(c, filename, os.Getenv(helperEnvBaseURL), "", testRetryConfig())
+	if err != nil {
+		t.Fatalf("Error creating queue: %s", err)
+	}
+	err = q.Enqueue(c, Task{UID: "abc", WebhookURLPath: "/_ah/tasks/doSend", Payload: []byte("payload")})
+	if err != nil {
+		t.Fatalf("Error enqueuing task: %s", err)
+	}
+	time.Sleep(time.Minute) // wait to be killed
+}
+
+func TestFileQueueCrashRecovery(t *testing.T) {
+	c := context.Background()
+	filename := filepath.Join(t.TempDir(), "queue.log")
+
+	// given a process that is killed while dispatching a task
+	dispatching := make(chan struct{}, 1)
+	release := make(chan struct{})
+	hangingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dispatching <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer hangingServer.Close()
+	defer close(release)
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestFileQueueHelperProcess")
+	cmd.Env = append(os.Environ(), helperEnvFilename+"="+filename, helperEnvBaseURL+"="+hangingServer.URL)
+	err := cmd.Start()
+	assert.NoError(t, err)
+
+	select {
+	case <-dispatching:
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		t.Fatalf("Timeout waiting for dispatch")
+	}
+	err = cmd.Process.Kill()
+	assert.NoError(t, err)
+	cmd.Wait()
+
+	// when restarted
+	recorder := newAttemptRecorder(0)
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	q, cleanup, err := NewFileQueue(c, filename, server.URL, "", testRetryConfig())
+	assert.NoError(t, err)
+	recorder.setQueue(q)
+
+	// then the interrupted attempt counts and the task is dispatched again
+	assert.Equal(t, []attempt{
+		{dispatchCount: 2, maxAttempts: 5, retryHeader: "1", payload: "payload"},
+	}, recorder.waitFor(t, 1))
+	time.Sleep(50 * time.Millisecond)
+	cleanup()
+
+	// and once delivered it is neither redelivered nor accepted again after the next restart
+	q, cleanup, err = NewFileQueue(c, filename, server.URL, "", testRetryConfig())
+	assert.NoError(t, err)
+	defer cleanup()
+
+	err = q.Enqueue(c, Task{UID: "abc", WebhookURLPath: "/_ah/tasks/doSend", Payload: []byte("payload")})
+	assert.True(t, errors.Is(err, ErrTaskAlreadyExists))
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, recorder.get(), 1)
+}
+
+func TestFileQueueResumesRetries(t *testing.T) {
+	c := context.Background()
+	filename := filepath.Join(t.TempDir(), "queue.log")
+
+	failingServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failingServer.Close()
+
+	slowRetries := RetryConfig{MaxAttempts: 5, MinBackoff: time.Hour}
+	q, cleanup, err := NewFileQueue(c, filename, failingServer.URL, "", slowRetries)
+	assert.NoError(t, err)
+	err = q.Enqueue(c, Task{UID: "abc", WebhookURLPath: "/", Payload: []byte("payload")})
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		count, _ := q.IsLastAttempt(c, "abc")
+		return count == 1
+	}, 2*time.Second, time.Millisecond)
+	cleanup()
+
+	// restart: task is pending for an hour
+	q, cleanup, err = NewFileQueue(c, filename, failingServer.URL, "", slowRetries)
+	assert.NoError(t, err)
+	count, max := q.IsLastAttempt(c, "abc")
+	assert.Equal(t, int32(1), count)
+	assert.Equal(t, int32(5), max)
+	cleanup()
+}
+
+func TestFileQueueRecoversFinalAttempt(t *testing.T) {
+	c := context.Background()
+	filename := filepath.Join(t.TempDir(), "queue.log")
+
+	// given a process that stopped during the final attempt of a task
+	err := writeFileSynced(filename, []byte(
+		`{"Op":"enqueue","Task":{"UID":"abc","WebhookURLPath":"/","Payload":"cGF5bG9hZA=="}}`+"\n"+
+			`{"Op":"dispatch","UID":"abc","DispatchCount":5}`+"\n"))
+	assert.NoError(t, err)
+
+	// when restarted
+	recorder := newAttemptRecorder(1)
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	q, cleanup, err := NewFileQueue(c, filename, server.URL, "", testRetryConfig())
+	assert.NoError(t, err)
+	defer cleanup()
+	recorder.setQueue(q)
+
+	// then the final attempt is repeated as final attempt, after which the task is given up
+	assert.Equal(t, []attempt{
+		{dispatchCount: 5, maxAttempts: 5, retryHeader: "4", payload: "payload"},
+	}, recorder.waitFor(t, 1))
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, recorder.get(), 1)
+}
+
+func TestFileQueueCompactsWhileRunning(t *testing.T) {
+	c := context.Background()
+	filename := filepath.Join(t.TempDir(), "queue.log")
+
+	recorder := newAttemptRecorder(0)
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	q, cleanup, err := NewFileQueue(c, filename, server.URL, "", testRetryConfig())
+	assert.NoError(t, err)
+	q.(*memoryTaskQueue).journal.(*fileJournal).compactAfter = 10
+	recorder.setQueue(q)
+
+	for i := 0; i < 20; i++ {
+		err = q.Enqueue(c, Task{UID: fmt.Sprintf("task-%d", i), WebhookURLPath: "/", Payload: []byte("payload")})
+		assert.NoError(t, err)
+	}
+	err = q.Enqueue(c, Task{UID: "later", WebhookURLPath: "/", Payload: []byte("payload"), ScheduleTime: time.Now().Add(time.Hour)})
+	assert.NoError(t, err)
+	recorder.waitFor(t, 20)
+	time.Sleep(50 * time.Millisecond)
+	cleanup()
+
+	// not every enqueue, dispatch and finish was kept
+	entries, err := readJournal(filename)
+	assert.NoError(t, err)
+	assert.Less(t, len(entries), 21+20+20)
+
+	q, cleanup, err = NewFileQueue(c, filename, server.URL, "", testRetryConfig())
+	assert.NoError(t, err)
+	defer cleanup()
+	count, max := q.IsLastAttempt(c, "later")
+	assert.Equal(t, int32(0), count)
+	assert.Equal(t, int32(5), max)
+	err = q.Enqueue(c, Task{UID: "task-0", WebhookURLPath: "/", Payload: []byte("payload")})
+	assert.True(t, errors.Is(err, ErrTaskAlreadyExists))
+}
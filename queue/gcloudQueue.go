@@ -4,82 +4,104 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"os"
 
 	taskspb "google.golang.org/genproto/googleapis/cloud/tasks/v2beta3"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	cloudtasks "cloud.google.com/go/cloudtasks/apiv2beta3"
 )
 
 type gcloudTaskQueue struct {
-	client *cloudtasks.Client
+	client         *cloudtasks.Client
+	projectID      string
+	locationID     string
+	queueName      string
+	serviceAccount string
+	audience       string
 }
 
-func NewQueue(c context.Context) (TaskQueuer, func(), error) {
+// NewQueue lets cloudtasks add an oidc-token of the service-account to its dispatch requests, when one is given
+func NewQueue(c context.Context, projectID, locationID, queueName, serviceAccount, audience string) (TaskQueuer, func(), error) {
 	cloudTaskClient, err := cloudtasks.NewClient(c)
 	if err != nil {
 		return nil, nil, fmt.Errorf("Error creating cloudtask-client: %s", err)
 	}
 	return &gcloudTaskQueue{
-			client: cloudTaskClient,
+			client:         cloudTaskClient,
+			projectID:      projectID,
+			locationID:     locationID,
+			queueName:      queueName,
+			serviceAccount: serviceAccount,
+			audience:       audience,
 		}, func() {
 			cloudTaskClient.Close()
 		}, nil
 }
 
 func (q *gcloudTaskQueue) Enqueue(c context.Context, task Task) error {
-	taskUID := composeTaskName(task.UID)
+	taskUID := q.composeTaskName(task.UID)
 	log.Printf("task-uid: %s", taskUID)
+	httpRequest := &taskspb.HttpRequest{
+		HttpMethod: taskspb.HttpMethod_POST,
+		Url:        q.composeFullyQualifiedWebhookURL(task.WebhookURLPath),
+		Body:       task.Payload,
+	}
+	if q.serviceAccount != "" {
+		httpRequest.AuthorizationHeader = &taskspb.HttpRequest_OidcToken{
+			OidcToken: &taskspb.OidcToken{ServiceAccountEmail: q.serviceAccount, Audience: q.audience},
+		}
+	}
 	_, err := q.client.CreateTask(c, &taskspb.CreateTaskRequest{
-		Parent: composeQueueName(),
+		Parent: q.composeQueueName(),
 		Task: &taskspb.Task{
 			Name: taskUID, // de-duplicate
 			PayloadType: &taskspb.Task_HttpRequest{
-				HttpRequest: &taskspb.HttpRequest{
-					HttpMethod: taskspb.HttpMethod_POST,
-					Url:        composeFullyQualifiedWebhookURL(task.WebhookURLPath),
-					Body:       task.Payload,
-				},
+				HttpRequest: httpRequest,
 			},
-			View: taskspb.Task_FULL,
+			ScheduleTime: composeScheduleTime(task),
+			View:         taskspb.Task_FULL,
 		},
 	})
+	if status.Code(err) == codes.AlreadyExists {
+		return fmt.Errorf("Error submitting task %s to queue: %w", task.UID, ErrTaskAlreadyExists)
+	}
 	if err != nil {
 		return fmt.Errorf("Error submitting task to queue: %s", err)
 	}
 	return nil
 }
 
-func composeQueueName() string {
-	projectId := os.Getenv("GOOGLE_CLOUD_PROJECT")
-	locationId := os.Getenv("LOCATION_ID")
-	queueName := os.Getenv("QUEUE_NAME")
-	if queueName == "" {
-		queueName = "default"
+func composeScheduleTime(task Task) *timestamppb.Timestamp {
+	if task.ScheduleTime.IsZero() {
+		return nil // as soon as possible
 	}
-	return fmt.Sprintf("projects/%s/locations/%s/queues/%s", projectId, locationId, queueName)
+	return timestamppb.New(task.ScheduleTime)
 }
 
-func composeTaskName(taskUID string) string {
-	return fmt.Sprintf("%s/tasks/%s", composeQueueName(), taskUID)
+func (q *gcloudTaskQueue) composeQueueName() string {
+	return fmt.Sprintf("projects/%s/locations/%s/queues/%s", q.projectID, q.locationID, q.queueName)
 }
 
-func composeFullyQualifiedWebhookURL(webhookUID string) string {
-	projectId := os.Getenv("GOOGLE_CLOUD_PROJECT")
+func (q *gcloudTaskQueue) composeTaskName(taskUID string) string {
+	return fmt.Sprintf("%s/tasks/%s", q.composeQueueName(), taskUID)
+}
 
+func (q *gcloudTaskQueue) composeFullyQualifiedWebhookURL(webhookUID string) string {
 	// We are not publishing to a service within the appengine project.
 	// In this case we would have to use the following project structure
 	// "https://<service-name>-dot-<project-name>.appspot.com/url"
 
 	// we are using the default service
-	return fmt.Sprintf("https://%s.appspot.com/%s", projectId, webhookUID)
+	return fmt.Sprintf("https://%s.appspot.com/%s", q.projectID, webhookUID)
 }
 
 func (q *gcloudTaskQueue) IsLastAttempt(c context.Context, taskUID string) (int32, int32) {
 	var numRetries int32 = 0
 	var maxRetries int32 = -1
 
-	queue, err := q.getQueue(c, composeQueueName())
+	queue, err := q.getQueue(c, q.composeQueueName())
 	if err != nil {
 		log.Printf("%s", err)
 		return numRetries, maxRetries
@@ -99,10 +121,23 @@ func (q *gcloudTaskQueue) IsLastAttempt(c context.Context, taskUID string) (int3
 	return task.DispatchCount, maxRetries
 }
 
+func (q *gcloudTaskQueue) Delete(c context.Context, taskUID string) error {
+	err := q.client.DeleteTask(c, &taskspb.DeleteTaskRequest{
+		Name: q.composeTaskName(taskUID),
+	})
+	if status.Code(err) == codes.NotFound {
+		return fmt.Errorf("Error deleting task %s: %w", taskUID, ErrTaskNotFound)
+	}
+	if err != nil {
+		return fmt.Errorf("Error deleting task %s: %s", taskUID, err)
+	}
+	return nil
+}
+
 func (q *gcloudTaskQueue) getQueue(c context.Context, queueName string) (*taskspb.Queue, error) {
 	// find characteristics of the queue
 	queue, err := q.client.GetQueue(c, &taskspb.GetQueueRequest{
-		Name: composeQueueName(),
+		Name: q.composeQueueName(),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("Error getting queue with name %s: %s", queueName, err)
@@ -113,7 +148,7 @@ func (q *gcloudTaskQueue) getQueue(c context.Context, queueName string) (*tasksp
 func (q *gcloudTaskQueue) getTask(c context.Context, taskUID string) (*taskspb.Task, error) {
 	// find characteristics of the task
 	task, err := q.client.GetTask(c, &taskspb.GetTaskRequest{
-		Name: composeTaskName(taskUID),
+		Name: q.composeTaskName(taskUID),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("Error getting task with uid %s: %s", taskUID, err)
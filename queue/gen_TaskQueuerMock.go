@@ -33,6 +33,20 @@ func (m *MockTaskQueuer) EXPECT() *MockTaskQueuerMockRecorder {
 	return m.recorder
 }
 
+// Delete mocks base method
+func (m *MockTaskQueuer) Delete(c context.Context, taskUID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", c, taskUID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete
+func (mr *MockTaskQueuerMockRecorder) Delete(c, taskUID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockTaskQueuer)(nil).Delete), c, taskUID)
+}
+
 // Enqueue mocks base method
 func (m *MockTaskQueuer) Enqueue(c context.Context, task Task) error {
 	m.ctrl.T.Helper()
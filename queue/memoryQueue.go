@@ -0,0 +1,239 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	memoryQueueName       = "memory"
+	taskNameReuseInterval = time.Hour // cloudtasks refuses re-use of a task name for about an hour
+)
+
+type memoryTask struct {
+	task          Task
+	dispatchCount int32
+	dueAt         time.Time // zero while being dispatched
+	timer         *time.Timer
+}
+
+// journal is used to persist every state change of the queue
+type journal interface {
+	record(entry journalEntry) error
+	compactionDue() bool
+	compact(pending []pendingTask, finished map[string]time.Time) error
+	close() error
+}
+
+type memoryTaskQueue struct {
+	sync.Mutex
+	dispatcher  *taskDispatcher
+	retryConfig RetryConfig
+	journal     journal
+	tasks       map[string]*memoryTask
+	finished    map[string]time.Time
+	seq         int
+	closed      bool
+}
+
+// NewMemoryQueue creates an in-process queue that delivers tasks over HTTP to baseURL,
+// mimicking the retry behaviour of cloudtasks. Pending tasks are lost when the process stops.
+func NewMemoryQueue(c context.Context, baseURL string, secret string, retryConfig RetryConfig) (TaskQueuer, func(), error) {
+	if baseURL == "" {
+		return nil, nil, fmt.Errorf("Error creating memory-queue: missing base-url")
+	}
+	q, err := newMemoryTaskQueue(baseURL, secret, retryConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating memory-queue: %s", err)
+	}
+	return q, q.close, nil
+}
+
+func newMemoryTaskQueue(baseURL string, secret string, retryConfig RetryConfig) (*memoryTaskQueue, error) {
+	dispatcher, err := newTaskDispatcher(memoryQueueName, baseURL, secret)
+	if err != nil {
+		return nil, err
+	}
+	return &memoryTaskQueue{
+		dispatcher:  dispatcher,
+		retryConfig: retryConfig,
+		tasks:       map[string]*memoryTask{},
+		finished:    map[string]time.Time{},
+	}, nil
+}
+
+func (q *memoryTaskQueue) Enqueue(c context.Context, task Task) error {
+	q.Lock()
+	defer q.Unlock()
+
+	if q.closed {
+		return fmt.Errorf("Error submitting task to queue: queue is closed")
+	}
+
+	if task.UID == "" {
+		q.seq++
+		task.UID = fmt.Sprintf("memory-%d", q.seq)
+	}
+
+	q.purgeFinished()
+	_, pending := q.tasks[task.UID]
+	_, finished := q.finished[task.UID]
+	if pending || finished {
+		return fmt.Errorf("Error submitting task %s to queue: %w", task.UID, ErrTaskAlreadyExists)
+	}
+
+	dueAt := task.dueAt(time.Now())
+	err := q.record(journalEntry{Op: opEnqueue, Task: &task, DueAt: dueAt})
+	if err != nil {
+		return fmt.Errorf("Error submitting task %s to queue: %s", task.UID, err)
+	}
+
+	log.Printf("task-uid: %s", task.UID)
+	q.schedule(&memoryTask{task: task}, time.Until(dueAt))
+
+	return nil
+}
+
+func (q *memoryTaskQueue) IsLastAttempt(c context.Context, taskUID string) (int32, int32) {
+	q.Lock()
+	defer q.Unlock()
+
+	t, found := q.tasks[taskUID]
+	if !found {
+		log.Printf("Error getting task with uid %s: not found", taskUID)
+		return 0, -1
+	}
+	return t.dispatchCount, q.retryConfig.MaxAttempts
+}
+
+func (q *memoryTaskQueue) Delete(c context.Context, taskUID string) error {
+	q.Lock()
+	defer q.Unlock()
+
+	t, found := q.tasks[taskUID]
+	if !found {
+		return fmt.Errorf("Error deleting task %s: %w", taskUID, ErrTaskNotFound)
+	}
+	t.timer.Stop()
+	q.finish(taskUID)
+
+	log.Printf("Deleted task %s", taskUID)
+
+	return nil
+}
+
+// schedule must be called with the lock held
+func (q *memoryTaskQueue) schedule(t *memoryTask, delay time.Duration) {
+	q.tasks[t.task.UID] = t
+	t.dueAt = time.Now().Add(delay)
+	t.timer = time.AfterFunc(delay, func() {
+		q.dispatch(t)
+	})
+	q.compactIfDue()
+}
+
+func (q *memoryTaskQueue) dispatch(t *memoryTask) {
+	q.Lock()
+	if q.closed || q.tasks[t.task.UID] != t {
+		q.Unlock()
+		return
+	}
+	t.dispatchCount++
+	t.dueAt = time.Time{}
+	attempt := t.dispatchCount
+	// count the attempt before it is made: a crash during dispatch must not result in an extra attempt
+	q.recordOrLog(journalEntry{Op: opDispatch, UID: t.task.UID, DispatchCount: attempt})
+	q.Unlock()
+
+	err := q.dispatcher.deliver(context.Background(), t.task, attempt)
+
+	q.Lock()
+	defer q.Unlock()
+
+	if q.closed || q.tasks[t.task.UID] != t {
+		return
+	}
+	if err == nil {
+		q.finish(t.task.UID)
+		return
+	}
+	if q.retryConfig.isExhausted(attempt) {
+		log.Printf("Giving up on task %s after %d attempts: %s", t.task.UID, attempt, err)
+		q.finish(t.task.UID)
+		return
+	}
+
+	backoff := q.retryConfig.Backoff(attempt)
+	log.Printf("Retrying task %s in %s: %s", t.task.UID, backoff, err)
+	q.recordOrLog(journalEntry{Op: opRetry, UID: t.task.UID, DueAt: time.Now().Add(backoff)})
+	q.schedule(t, backoff)
+}
+
+// finish must be called with the lock held
+func (q *memoryTaskQueue) finish(taskUID string) {
+	now := time.Now()
+	delete(q.tasks, taskUID)
+	q.finished[taskUID] = now
+	q.recordOrLog(journalEntry{Op: opFinish, UID: taskUID, Timestamp: now})
+	q.compactIfDue()
+}
+
+// compactIfDue must be called with the lock held, after the state change was applied to the queue
+func (q *memoryTaskQueue) compactIfDue() {
+	if q.journal == nil || !q.journal.compactionDue() {
+		return
+	}
+	q.purgeFinished()
+	pending := []pendingTask{}
+	for _, t := range q.tasks {
+		pending = append(pending, pendingTask{task: t, dueAt: t.dueAt})
+	}
+	err := q.journal.compact(pending, q.finished)
+	if err != nil {
+		log.Printf("Error compacting journal: %s", err)
+	}
+}
+
+// record must be called with the lock held
+func (q *memoryTaskQueue) record(entry journalEntry) error {
+	if q.journal == nil {
+		return nil
+	}
+	return q.journal.record(entry)
+}
+
+// recordOrLog must be called with the lock held
+func (q *memoryTaskQueue) recordOrLog(entry journalEntry) {
+	err := q.record(entry)
+	if err != nil {
+		log.Printf("Error journaling %s of task %s: %s", entry.Op, entry.UID, err)
+	}
+}
+
+// purgeFinished must be called with the lock held
+func (q *memoryTaskQueue) purgeFinished() {
+	for uid, ts := range q.finished {
+		if time.Since(ts) > taskNameReuseInterval {
+			delete(q.finished, uid)
+		}
+	}
+}
+
+func (q *memoryTaskQueue) close() {
+	q.Lock()
+	defer q.Unlock()
+
+	q.closed = true
+	for _, t := range q.tasks {
+		t.timer.Stop()
+	}
+	if q.journal != nil {
+		err := q.journal.close()
+		if err != nil {
+			log.Printf("Error closing journal: %s", err)
+		}
+	}
+}
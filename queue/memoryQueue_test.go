@@ -0,0 +1,255 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/signature"
+	"github.com/stretchr/testify/assert"
+)
+
+type attempt struct {
+	dispatchCount int32
+	maxAttempts   int32
+	retryHeader   string
+	payload       string
+}
+
+func TestMemoryQueue(t *testing.T) {
+	testCases := []struct {
+		name             string
+		maxAttempts      int32
+		failuresUpfront  int
+		expectedAttempts []attempt
+	}{
+		{
+			name:            "Success at first attempt",
+			maxAttempts:     3,
+			failuresUpfront: 0,
+			expectedAttempts: []attempt{
+				{dispatchCount: 1, maxAttempts: 3, retryHeader: "0", payload: "payload"},
+			},
+		},
+		{
+			name:            "Success after retries",
+			maxAttempts:     3,
+			failuresUpfront: 2,
+			expectedAttempts: []attempt{
+				{dispatchCount: 1, maxAttempts: 3, retryHeader: "0", payload: "payload"},
+				{dispatchCount: 2, maxAttempts: 3, retryHeader: "1", payload: "payload"},
+				{dispatchCount: 3, maxAttempts: 3, retryHeader: "2", payload: "payload"},
+			},
+		},
+		{
+			name:            "Retries exhausted",
+			maxAttempts:     2,
+			failuresUpfront: 5,
+			expectedAttempts: []attempt{
+				{dispatchCount: 1, maxAttempts: 2, retryHeader: "0", payload: "payload"},
+				{dispatchCount: 2, maxAttempts: 2, retryHeader: "1", payload: "payload"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// setup
+			c := context.Background()
+			recorder := newAttemptRecorder(tc.failuresUpfront)
+			server := httptest.NewServer(recorder)
+			defer server.Close()
+
+			q, cleanup, err := NewMemoryQueue(c, server.URL, "", RetryConfig{MaxAttempts: tc.maxAttempts, MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond})
+			assert.NoError(t, err)
+			defer cleanup()
+			recorder.setQueue(q)
+
+			// when
+			err = q.Enqueue(c, Task{UID: "abc", WebhookURLPath: "/_ah/tasks/doSend", Payload: []byte("payload")})
+			assert.NoError(t, err)
+
+			// then
+			assert.Equal(t, tc.expectedAttempts, recorder.waitFor(t, len(tc.expectedAttempts)))
+			time.Sleep(50 * time.Millisecond)
+			assert.Len(t, recorder.get(), len(tc.expectedAttempts))
+		})
+	}
+}
+
+func TestMemoryQueueDeduplicates(t *testing.T) {
+	c := context.Background()
+	recorder := newAttemptRecorder(0)
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	q, cleanup, err := NewMemoryQueue(c, server.URL, "", DefaultRetryConfig())
+	assert.NoError(t, err)
+	defer cleanup()
+	recorder.setQueue(q)
+
+	err = q.Enqueue(c, Task{UID: "abc", WebhookURLPath: "/", Payload: []byte("payload")})
+	assert.NoError(t, err)
+	recorder.waitFor(t, 1)
+
+	err = q.Enqueue(c, Task{UID: "abc", WebhookURLPath: "/", Payload: []byte("payload")})
+	assert.True(t, errors.Is(err, ErrTaskAlreadyExists))
+
+	err = q.Enqueue(c, Task{UID: "def", WebhookURLPath: "/", Payload: []byte("payload")})
+	assert.NoError(t, err)
+	recorder.waitFor(t, 2)
+}
+
+func TestMemoryQueueScheduleTime(t *testing.T) {
+	c := context.Background()
+	recorder := newAttemptRecorder(0)
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	q, cleanup, err := NewMemoryQueue(c, server.URL, "", DefaultRetryConfig())
+	assert.NoError(t, err)
+	defer cleanup()
+	recorder.setQueue(q)
+
+	err = q.Enqueue(c, Task{UID: "abc", WebhookURLPath: "/", Payload: []byte("payload"), ScheduleTime: time.Now().Add(100 * time.Millisecond)})
+	assert.NoError(t, err)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(t, recorder.get(), 0)
+	recorder.waitFor(t, 1)
+}
+
+func TestMemoryQueueDelete(t *testing.T) {
+	c := context.Background()
+	recorder := newAttemptRecorder(0)
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+
+	q, cleanup, err := NewMemoryQueue(c, server.URL, "", DefaultRetryConfig())
+	assert.NoError(t, err)
+	defer cleanup()
+	recorder.setQueue(q)
+
+	err = q.Enqueue(c, Task{UID: "abc", WebhookURLPath: "/", Payload: []byte("payload"), ScheduleTime: time.Now().Add(50 * time.Millisecond)})
+	assert.NoError(t, err)
+
+	err = q.Delete(c, "abc")
+	assert.NoError(t, err)
+	err = q.Delete(c, "abc")
+	assert.True(t, errors.Is(err, ErrTaskNotFound))
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Len(t, recorder.get(), 0)
+
+	// a deleted uid cannot be re-used right away, just like with cloudtasks
+	err = q.Enqueue(c, Task{UID: "abc", WebhookURLPath: "/", Payload: []byte("payload")})
+	assert.True(t, errors.Is(err, ErrTaskAlreadyExists))
+}
+
+func TestBackoff(t *testing.T) {
+	rc := RetryConfig{MinBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	assert.Equal(t, 1*time.Second, rc.Backoff(1))
+	assert.Equal(t, 2*time.Second, rc.Backoff(2))
+	assert.Equal(t, 4*time.Second, rc.Backoff(3))
+	assert.Equal(t, 8*time.Second, rc.Backoff(4))
+	assert.Equal(t, 10*time.Second, rc.Backoff(5))
+	assert.Equal(t, 10*time.Second, rc.Backoff(100))
+}
+
+type attemptRecorder struct {
+	sync.Mutex
+	failuresUpfront int
+	queue           TaskQueuer
+	queueSet        chan struct{}
+	attempts        []attempt
+}
+
+func newAttemptRecorder(failuresUpfront int) *attemptRecorder {
+	return &attemptRecorder{
+		failuresUpfront: failuresUpfront,
+		queueSet:        make(chan struct{}),
+		attempts:        []attempt{},
+	}
+}
+
+// setQueue provides the queue under test, that is asked for its attempt-count during dispatch
+func (ar *attemptRecorder) setQueue(q TaskQueuer) {
+	ar.Lock()
+	defer ar.Unlock()
+	ar.queue = q
+	close(ar.queueSet)
+}
+
+func (ar *attemptRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	payload, _ := ioutil.ReadAll(r.Body)
+
+	<-ar.queueSet
+	ar.Lock()
+	q := ar.queue
+	ar.Unlock()
+	dispatchCount, maxAttempts := q.IsLastAttempt(r.Context(), r.Header.Get("X-CloudTasks-TaskName"))
+
+	ar.Lock()
+	defer ar.Unlock()
+
+	ar.attempts = append(ar.attempts, attempt{
+		dispatchCount: dispatchCount,
+		maxAttempts:   maxAttempts,
+		retryHeader:   r.Header.Get("X-CloudTasks-TaskRetryCount"),
+		payload:       string(payload),
+	})
+	if len(ar.attempts) <= ar.failuresUpfront {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (ar *attemptRecorder) get() []attempt {
+	ar.Lock()
+	defer ar.Unlock()
+	return append([]attempt{}, ar.attempts...)
+}
+
+func (ar *attemptRecorder) waitFor(t *testing.T, count int) []attempt {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		attempts := ar.get()
+		if len(attempts) >= count {
+			return attempts
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Timeout waiting for %d attempts", count)
+	return nil
+}
+
+func TestMemoryQueueSignsDispatch(t *testing.T) {
+	c := context.Background()
+	verifier, err := signature.NewVerifier([]string{"s3cr3t"}, signature.DefaultTolerance)
+	assert.NoError(t, err)
+	verified := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ := ioutil.ReadAll(r.Body)
+		verified <- verifier.Verify(r.Header, r.Method, r.URL.RequestURI(), payload)
+	}))
+	defer server.Close()
+
+	q, cleanup, err := NewMemoryQueue(c, server.URL, "s3cr3t", DefaultRetryConfig())
+	assert.NoError(t, err)
+	defer cleanup()
+
+	err = q.Enqueue(c, Task{UID: "abc", WebhookURLPath: "/_ah/tasks/doSend", Payload: []byte("payload")})
+	assert.NoError(t, err)
+	select {
+	case err := <-verified:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("task was not dispatched")
+	}
+}
@@ -0,0 +1,308 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	_ "github.com/lib/pq" // postgres driver
+)
+
+const (
+	postgresQueueName       = "postgres"
+	postgresPollInterval    = 1 * time.Second
+	postgresPurgeInterval   = 1 * time.Minute
+	maxConcurrentDispatches = 5
+	// a claimed task becomes visible to other replicas again when it was not completed within this period
+	visibilityTimeout = 2 * dispatchTimeout
+)
+
+const (
+	taskStatusPending   = "pending"
+	taskStatusDone      = "done"
+	taskStatusFailed    = "failed"
+	taskStatusCancelled = "cancelled"
+)
+
+const createTasksTableSQL = `
+CREATE TABLE IF NOT EXISTS forwardhttp_tasks (
+	uid              TEXT PRIMARY KEY,
+	webhook_url_path TEXT NOT NULL,
+	payload          BYTEA NOT NULL,
+	status           TEXT NOT NULL,
+	dispatch_count   INTEGER NOT NULL DEFAULT 0,
+	due_at           TIMESTAMPTZ NOT NULL,
+	created_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+	finished_at      TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS forwardhttp_tasks_due_idx ON forwardhttp_tasks (due_at) WHERE status = 'pending';
+`
+
+type postgresTaskQueue struct {
+	db          *sql.DB
+	dispatcher  *taskDispatcher
+	retryConfig RetryConfig
+	cancel      context.CancelFunc
+	wg          sync.WaitGroup
+	lastPurge   time.Time
+	slots       chan struct{} // one per dispatch in progress
+	freed       chan struct{} // signals the poller that a slot became available
+}
+
+// NewPostgresQueue creates a queue on top of a postgres table. Every replica polls the table and claims due tasks
+// using "FOR UPDATE SKIP LOCKED", so multiple replicas can share the same table without dispatching a task twice.
+func NewPostgresQueue(c context.Context, dsn string, baseURL string, secret string, retryConfig RetryConfig) (TaskQueuer, func(), error) {
+	if baseURL == "" {
+		return nil, nil, fmt.Errorf("Error creating postgres-queue: missing base-url")
+	}
+	dispatcher, err := newTaskDispatcher(postgresQueueName, baseURL, secret)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating postgres-queue: %s", err)
+	}
+	db, err := openPostgres(c, dsn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating postgres-queue: %s", err)
+	}
+	_, err = db.ExecContext(c, createTasksTableSQL)
+	if err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("Error creating postgres-queue table: %s", err)
+	}
+
+	workerContext, cancel := context.WithCancel(context.Background())
+	q := &postgresTaskQueue{
+		db:          db,
+		dispatcher:  dispatcher,
+		retryConfig: retryConfig,
+		cancel:      cancel,
+		slots:       make(chan struct{}, maxConcurrentDispatches),
+		freed:       make(chan struct{}, 1),
+	}
+	q.wg.Add(1)
+	go q.run(workerContext)
+
+	return q, q.close, nil
+}
+
+func openPostgres(c context.Context, dsn string) (*sql.DB, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("missing postgres dsn")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening postgres: %s", err)
+	}
+	err = db.PingContext(c)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("Error connecting to postgres: %s", err)
+	}
+	return db, nil
+}
+
+func (q *postgresTaskQueue) Enqueue(c context.Context, task Task) error {
+	result, err := q.db.ExecContext(c, `
+		INSERT INTO forwardhttp_tasks (uid, webhook_url_path, payload, status, due_at)
+		VALUES ($1, $2, $3, $4, GREATEST(now(), $5::timestamptz))
+		ON CONFLICT (uid) DO NOTHING`,
+		task.UID, task.WebhookURLPath, task.Payload, taskStatusPending, task.ScheduleTime)
+	if err != nil {
+		return fmt.Errorf("Error submitting task %s to queue: %s", task.UID, err)
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Error submitting task %s to queue: %s", task.UID, err)
+	}
+	if inserted == 0 {
+		return fmt.Errorf("Error submitting task %s to queue: %w", task.UID, ErrTaskAlreadyExists)
+	}
+
+	log.Printf("task-uid: %s", task.UID)
+
+	return nil
+}
+
+func (q *postgresTaskQueue) IsLastAttempt(c context.Context, taskUID string) (int32, int32) {
+	var dispatchCount int32
+	err := q.db.QueryRowContext(c, `SELECT dispatch_count FROM forwardhttp_tasks WHERE uid = $1`, taskUID).Scan(&dispatchCount)
+	if err != nil {
+		log.Printf("Error getting task with uid %s: %s", taskUID, err)
+		return 0, -1
+	}
+	return dispatchCount, q.retryConfig.MaxAttempts
+}
+
+func (q *postgresTaskQueue) Delete(c context.Context, taskUID string) error {
+	// the row is kept as tombstone, so that the uid cannot be re-used right away
+	result, err := q.db.ExecContext(c, `
+		UPDATE forwardhttp_tasks
+		SET status = $1, finished_at = now()
+		WHERE uid = $2 AND status = $3`,
+		taskStatusCancelled, taskUID, taskStatusPending)
+	if err != nil {
+		return fmt.Errorf("Error deleting task %s: %s", taskUID, err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("Error deleting task %s: %s", taskUID, err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("Error deleting task %s: %w", taskUID, ErrTaskNotFound)
+	}
+
+	log.Printf("Deleted task %s", taskUID)
+
+	return nil
+}
+
+func (q *postgresTaskQueue) run(c context.Context) {
+	defer q.wg.Done()
+
+	for {
+		claimed, free := q.poll(c)
+		if claimed > 0 && claimed == free {
+			continue // there might be more work waiting
+		}
+		select {
+		case <-c.Done():
+			return
+		case <-q.freed:
+		case <-time.After(postgresPollInterval):
+		}
+	}
+}
+
+// poll claims as many due tasks as there are free slots, so that a slow dispatch does not hold back the others.
+// It returns the number of claimed tasks and the number of free slots.
+func (q *postgresTaskQueue) poll(c context.Context) (int, int) {
+	q.purgeFinished(c)
+
+	// only the poller takes slots, so they cannot run out in between
+	free := cap(q.slots) - len(q.slots)
+	if free == 0 {
+		return 0, 0
+	}
+	claims, err := q.claim(c, free)
+	if err != nil {
+		if c.Err() == nil {
+			log.Printf("Error claiming tasks: %s", err)
+		}
+		return 0, free
+	}
+
+	for _, claim := range claims {
+		q.slots <- struct{}{}
+		q.wg.Add(1)
+		go func(claim claimedTask) {
+			defer q.wg.Done()
+			defer q.release()
+			q.process(c, claim)
+		}(claim)
+	}
+
+	return len(claims), free
+}
+
+func (q *postgresTaskQueue) release() {
+	<-q.slots
+	select {
+	case q.freed <- struct{}{}:
+	default: // the poller was already signalled
+	}
+}
+
+type claimedTask struct {
+	task          Task
+	dispatchCount int32
+}
+
+// claim counts the attempt and hides the claimed tasks from other replicas for the duration of the visibility-timeout
+func (q *postgresTaskQueue) claim(c context.Context, limit int) ([]claimedTask, error) {
+	rows, err := q.db.QueryContext(c, `
+		UPDATE forwardhttp_tasks
+		SET dispatch_count = dispatch_count + 1, due_at = now() + $1::double precision * interval '1 millisecond'
+		WHERE uid IN (
+			SELECT uid FROM forwardhttp_tasks
+			WHERE status = $2 AND due_at <= now()
+			ORDER BY due_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING uid, webhook_url_path, payload, dispatch_count`,
+		visibilityTimeout.Milliseconds(), taskStatusPending, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	claims := []claimedTask{}
+	for rows.Next() {
+		var claim claimedTask
+		err = rows.Scan(&claim.task.UID, &claim.task.WebhookURLPath, &claim.task.Payload, &claim.dispatchCount)
+		if err != nil {
+			return nil, err
+		}
+		claims = append(claims, claim)
+	}
+	return claims, rows.Err()
+}
+
+func (q *postgresTaskQueue) process(c context.Context, claim claimedTask) {
+	err := q.dispatcher.deliver(c, claim.task, claim.dispatchCount)
+	if c.Err() != nil {
+		return // shutting down: task becomes visible again after the visibility-timeout
+	}
+	if err == nil {
+		q.update(c, claim, taskStatusDone, 0)
+		return
+	}
+	if q.retryConfig.isExhausted(claim.dispatchCount) {
+		log.Printf("Giving up on task %s after %d attempts: %s", claim.task.UID, claim.dispatchCount, err)
+		q.update(c, claim, taskStatusFailed, 0)
+		return
+	}
+
+	backoff := q.retryConfig.Backoff(claim.dispatchCount)
+	log.Printf("Retrying task %s in %s: %s", claim.task.UID, backoff, err)
+	q.update(c, claim, taskStatusPending, backoff)
+}
+
+func (q *postgresTaskQueue) update(c context.Context, claim claimedTask, status string, delay time.Duration) {
+	// the dispatch-count check prevents overwriting the state of a newer claim made after our visibility-timeout expired,
+	// the status check prevents overwriting a deletion made during dispatch
+	_, err := q.db.ExecContext(c, `
+		UPDATE forwardhttp_tasks
+		SET status = $1,
+			due_at = now() + $2::double precision * interval '1 millisecond',
+			finished_at = CASE WHEN $3::boolean THEN now() ELSE NULL END
+		WHERE uid = $4 AND dispatch_count = $5 AND status = $6`,
+		status, delay.Milliseconds(), status != taskStatusPending, claim.task.UID, claim.dispatchCount, taskStatusPending)
+	if err != nil {
+		log.Printf("Error updating task %s to status %s: %s", claim.task.UID, status, err)
+	}
+}
+
+// purgeFinished removes finished tasks once their uid may be re-used
+func (q *postgresTaskQueue) purgeFinished(c context.Context) {
+	if time.Since(q.lastPurge) < postgresPurgeInterval {
+		return
+	}
+	q.lastPurge = time.Now()
+
+	_, err := q.db.ExecContext(c, `
+		DELETE FROM forwardhttp_tasks
+		WHERE status <> $1 AND finished_at < now() - $2::double precision * interval '1 millisecond'`,
+		taskStatusPending, taskNameReuseInterval.Milliseconds())
+	if err != nil && c.Err() == nil {
+		log.Printf("Error purging finished tasks: %s", err)
+	}
+}
+
+func (q *postgresTaskQueue) close() {
+	q.cancel()
+	q.wg.Wait()
+	q.db.Close()
+}
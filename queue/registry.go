@@ -0,0 +1,144 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/MarcGrol/forwardhttp/config"
+)
+
+// Provider creates a queue backend, after validating the settings it needs
+type Provider func(c context.Context, settings config.Settings) (TaskQueuer, func(), error)
+
+var providers = map[string]Provider{
+	"cloudtasks": newCloudTasksQueueFromSettings,
+	"memory":     newMemoryQueueFromSettings,
+	"file":       newFileQueueFromSettings,
+	"postgres":   newPostgresQueueFromSettings,
+}
+
+func RegisterProvider(name string, provider Provider) {
+	providers[name] = provider
+}
+
+// NewFromSettings creates the queue backend selected by QUEUE_BACKEND (default "cloudtasks")
+func NewFromSettings(c context.Context, settings config.Settings) (TaskQueuer, func(), error) {
+	name := settings.GetOrDefault("QUEUE_BACKEND", "cloudtasks")
+	provider, found := providers[name]
+	if !found {
+		return nil, nil, fmt.Errorf("Unknown queue backend '%s', expected one of: %s", name, providerNames())
+	}
+	q, cleanup, err := provider(c, settings)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating queue backend '%s': %s", name, err)
+	}
+	return q, cleanup, nil
+}
+
+func providerNames() string {
+	names := []string{}
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func newCloudTasksQueueFromSettings(c context.Context, settings config.Settings) (TaskQueuer, func(), error) {
+	projectID, err := settings.Mandatory("GOOGLE_CLOUD_PROJECT")
+	if err != nil {
+		return nil, nil, err
+	}
+	locationID, err := settings.Mandatory("LOCATION_ID")
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewQueue(c, projectID, locationID, settings.GetOrDefault("QUEUE_NAME", "default"),
+		settings.Get("QUEUE_SERVICE_ACCOUNT"), OIDCAudienceFromSettings(settings))
+}
+
+// OIDCAudienceFromSettings is the audience of the oidc-tokens that cloudtasks adds to its dispatch requests
+func OIDCAudienceFromSettings(settings config.Settings) string {
+	project := settings.Get("GOOGLE_CLOUD_PROJECT")
+	if project == "" {
+		return settings.Get("QUEUE_OIDC_AUDIENCE")
+	}
+	return settings.GetOrDefault("QUEUE_OIDC_AUDIENCE", fmt.Sprintf("https://%s.appspot.com", project))
+}
+
+func newMemoryQueueFromSettings(c context.Context, settings config.Settings) (TaskQueuer, func(), error) {
+	retryConfig, err := retryConfigFromSettings(settings)
+	if err != nil {
+		return nil, nil, err
+	}
+	secret, err := settings.Mandatory("QUEUE_SECRET")
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewMemoryQueue(c, baseURLFromSettings(settings), secret, retryConfig)
+}
+
+func newFileQueueFromSettings(c context.Context, settings config.Settings) (TaskQueuer, func(), error) {
+	filename, err := settings.Mandatory("QUEUE_FILE")
+	if err != nil {
+		return nil, nil, err
+	}
+	retryConfig, err := retryConfigFromSettings(settings)
+	if err != nil {
+		return nil, nil, err
+	}
+	secret, err := settings.Mandatory("QUEUE_SECRET")
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewFileQueue(c, filename, baseURLFromSettings(settings), secret, retryConfig)
+}
+
+func newPostgresQueueFromSettings(c context.Context, settings config.Settings) (TaskQueuer, func(), error) {
+	dsn, err := settings.Mandatory("POSTGRES_DSN")
+	if err != nil {
+		return nil, nil, err
+	}
+	retryConfig, err := retryConfigFromSettings(settings)
+	if err != nil {
+		return nil, nil, err
+	}
+	secret, err := settings.Mandatory("QUEUE_SECRET")
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewPostgresQueue(c, dsn, baseURLFromSettings(settings), secret, retryConfig)
+}
+
+// baseURLFromSettings determines where self-hosted queues deliver their tasks: by default to ourselves
+func baseURLFromSettings(settings config.Settings) string {
+	return settings.GetOrDefault("QUEUE_BASE_URL", fmt.Sprintf("http://localhost:%s", settings.GetOrDefault("PORT", "8080")))
+}
+
+func retryConfigFromSettings(settings config.Settings) (RetryConfig, error) {
+	defaults := DefaultRetryConfig()
+
+	maxAttempts, err := settings.Int("QUEUE_MAX_ATTEMPTS", int(defaults.MaxAttempts))
+	if err != nil {
+		return RetryConfig{}, err
+	}
+	minBackoff, err := settings.Duration("QUEUE_MIN_BACKOFF", defaults.MinBackoff)
+	if err != nil {
+		return RetryConfig{}, err
+	}
+	maxBackoff, err := settings.Duration("QUEUE_MAX_BACKOFF", defaults.MaxBackoff)
+	if err != nil {
+		return RetryConfig{}, err
+	}
+	if minBackoff > maxBackoff {
+		return RetryConfig{}, fmt.Errorf("Invalid setting 'QUEUE_MIN_BACKOFF': %s exceeds QUEUE_MAX_BACKOFF %s", minBackoff, maxBackoff)
+	}
+
+	return RetryConfig{
+		MaxAttempts: int32(maxAttempts),
+		MinBackoff:  minBackoff,
+		MaxBackoff:  maxBackoff,
+	}, nil
+}
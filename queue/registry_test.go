@@ -0,0 +1,75 @@
+package queue
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/MarcGrol/forwardhttp/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewFromSettings(t *testing.T) {
+	testCases := []struct {
+		name          string
+		settings      map[string]string
+		expectedError string
+	}{
+		{
+			name:          "Unknown backend",
+			settings:      map[string]string{"QUEUE_BACKEND": "kafka"},
+			expectedError: "Unknown queue backend 'kafka', expected one of: cloudtasks, file, memory, postgres",
+		},
+		{
+			name:          "Cloudtasks without project",
+			settings:      map[string]string{},
+			expectedError: "Error creating queue backend 'cloudtasks': Missing mandatory setting 'GOOGLE_CLOUD_PROJECT'",
+		},
+		{
+			name:          "File without filename",
+			settings:      map[string]string{"QUEUE_BACKEND": "file"},
+			expectedError: "Error creating queue backend 'file': Missing mandatory setting 'QUEUE_FILE'",
+		},
+		{
+			name:          "Postgres without dsn",
+			settings:      map[string]string{"QUEUE_BACKEND": "postgres"},
+			expectedError: "Error creating queue backend 'postgres': Missing mandatory setting 'POSTGRES_DSN'",
+		},
+		{
+			name:          "Invalid retry config",
+			settings:      map[string]string{"QUEUE_BACKEND": "memory", "QUEUE_MAX_ATTEMPTS": "many"},
+			expectedError: "Error creating queue backend 'memory': Invalid setting 'QUEUE_MAX_ATTEMPTS': 'many' is not a number",
+		},
+		{
+			name:          "Memory without secret",
+			settings:      map[string]string{"QUEUE_BACKEND": "memory"},
+			expectedError: "Error creating queue backend 'memory': Missing mandatory setting 'QUEUE_SECRET'",
+		},
+		{
+			name:          "Memory with invalid secret",
+			settings:      map[string]string{"QUEUE_BACKEND": "memory", "QUEUE_SECRET": "whsec_!"},
+			expectedError: "Error creating queue backend 'memory': Error creating memory-queue: Invalid queue secret: Invalid secret 1: secret with prefix 'whsec_' must be base64 encoded",
+		},
+		{
+			name:     "Memory",
+			settings: map[string]string{"QUEUE_BACKEND": "memory", "QUEUE_SECRET": "s3cr3t"},
+		},
+		{
+			name:     "File",
+			settings: map[string]string{"QUEUE_BACKEND": "file", "QUEUE_FILE": filepath.Join(t.TempDir(), "queue.log"), "QUEUE_SECRET": "s3cr3t"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			q, cleanup, err := NewFromSettings(context.Background(), config.New(tc.settings))
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.NotNil(t, q)
+			cleanup()
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package queueauth
+
+import (
+	"errors"
+	"net/http"
+)
+
+//go:generate mockgen -source=api.go -destination=gen_QueueVerifierMock.go -package=queueauth github.com/MarcGrol/forwardhttp/queueauth Verifier
+
+var ErrNotFromQueue = errors.New("Request does not come from the queue")
+
+type Verifier interface {
+	// Verify returns ErrNotFromQueue (wrapped) when the request was not dispatched by the task queue
+	Verify(r *http.Request) error
+}
@@ -0,0 +1,28 @@
+package queueauth
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// appEngineVerifier trusts the X-CloudTasks-* headers, because App Engine removes those from requests of external callers
+type appEngineVerifier struct {
+	queueName string
+}
+
+func NewAppEngineVerifier(queueName string) Verifier {
+	return &appEngineVerifier{
+		queueName: queueName,
+	}
+}
+
+func (v *appEngineVerifier) Verify(r *http.Request) error {
+	queueName := r.Header.Get("X-CloudTasks-QueueName")
+	if queueName == "" || r.Header.Get("X-CloudTasks-TaskName") == "" {
+		return fmt.Errorf("%w: missing X-CloudTasks headers", ErrNotFromQueue)
+	}
+	if queueName != v.queueName {
+		return fmt.Errorf("%w: unexpected queue '%s'", ErrNotFromQueue, queueName)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package queueauth
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/MarcGrol/forwardhttp/signature"
+)
+
+// hmacVerifier accepts requests that the self-hosted queues signed with the shared secret
+type hmacVerifier struct {
+	verifier *signature.Verifier
+}
+
+func NewHMACVerifier(secret string) (Verifier, error) {
+	verifier, err := signature.NewVerifier([]string{secret}, signature.DefaultTolerance)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid queue secret: %s", err)
+	}
+	return &hmacVerifier{
+		verifier: verifier,
+	}, nil
+}
+
+func (v *hmacVerifier) Verify(r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("Error reading request body: %s", err)
+	}
+	// the handler still needs the body
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	err = v.verifier.Verify(r.Header, r.Method, r.URL.RequestURI(), body)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrNotFromQueue, err)
+	}
+	return nil
+}
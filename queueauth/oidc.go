@@ -0,0 +1,152 @@
+package queueauth
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+
+	"github.com/MarcGrol/forwardhttp/auth"
+)
+
+const (
+	googleJWKSURL      = "https://www.googleapis.com/oauth2/v3/certs"
+	minRefreshInterval = time.Minute
+	maxJWKSSize        = 1 << 20
+)
+
+var googleIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+// oidcVerifier accepts the oidc-tokens that cloudtasks adds on behalf of a service-account
+type oidcVerifier struct {
+	keys           *keySet
+	audience       string
+	serviceAccount string
+}
+
+// NewOIDCVerifier reads the public keys from a jwks file or url, like the one of Google
+func NewOIDCVerifier(jwksSource, audience, serviceAccount string) (Verifier, error) {
+	if audience == "" {
+		// without it, a token of the service-account that was meant for any other service would be accepted
+		return nil, fmt.Errorf("Missing oidc audience: set 'QUEUE_OIDC_AUDIENCE' or 'GOOGLE_CLOUD_PROJECT'")
+	}
+	keys := &keySet{
+		source:     jwksSource,
+		keys:       map[string]interface{}{},
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+	err := keys.refresh()
+	if err != nil {
+		if !keys.isURL() {
+			return nil, err
+		}
+		// the keys will be fetched again upon the first request
+		log.Printf("%s", err)
+	}
+	return &oidcVerifier{
+		keys:           keys,
+		audience:       audience,
+		serviceAccount: serviceAccount,
+	}, nil
+}
+
+func (v *oidcVerifier) Verify(r *http.Request) error {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return fmt.Errorf("%w: missing bearer-token", ErrNotFromQueue)
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(strings.TrimPrefix(authorization, "Bearer "), claims, auth.AsymmetricKeyFunc(v.keys.lookup))
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrNotFromQueue, err)
+	}
+	// parsing only checks the expiry of tokens that have one
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return fmt.Errorf("%w: missing expiry", ErrNotFromQueue)
+	}
+	if !hasIssuer(claims, googleIssuers) {
+		return fmt.Errorf("%w: unexpected issuer", ErrNotFromQueue)
+	}
+	if !claims.VerifyAudience(v.audience, true) {
+		return fmt.Errorf("%w: unexpected audience", ErrNotFromQueue)
+	}
+	email, _ := claims["email"].(string)
+	verified, _ := claims["email_verified"].(bool)
+	if email != v.serviceAccount || !verified {
+		return fmt.Errorf("%w: unexpected service-account '%s'", ErrNotFromQueue, email)
+	}
+	return nil
+}
+
+func hasIssuer(claims jwt.MapClaims, issuers []string) bool {
+	for _, issuer := range issuers {
+		if claims.VerifyIssuer(issuer, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// keySet fetches the keys again when a token refers to an unknown key, because keys are rotated
+type keySet struct {
+	sync.Mutex
+	source     string
+	keys       map[string]interface{}
+	fetchedAt  time.Time
+	httpClient *http.Client
+}
+
+func (ks *keySet) lookup(kid string) (interface{}, bool) {
+	ks.Lock()
+	defer ks.Unlock()
+
+	key, found := ks.keys[kid]
+	if !found && time.Since(ks.fetchedAt) >= minRefreshInterval {
+		err := ks.refresh()
+		if err != nil {
+			log.Printf("%s", err)
+		}
+		key, found = ks.keys[kid]
+	}
+	return key, found
+}
+
+func (ks *keySet) refresh() error {
+	ks.fetchedAt = time.Now()
+	data, err := ks.read()
+	if err != nil {
+		return fmt.Errorf("Error reading jwks %s: %s", ks.source, err)
+	}
+	keys, err := auth.ParseJWKS(data)
+	if err != nil {
+		return fmt.Errorf("Error parsing jwks %s: %s", ks.source, err)
+	}
+	ks.keys = keys
+	return nil
+}
+
+func (ks *keySet) isURL() bool {
+	return strings.HasPrefix(ks.source, "https://") || strings.HasPrefix(ks.source, "http://")
+}
+
+func (ks *keySet) read() ([]byte, error) {
+	if !ks.isURL() {
+		return os.ReadFile(ks.source)
+	}
+	resp, err := ks.httpClient.Get(ks.source)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("resp-status: %d", resp.StatusCode)
+	}
+	return ioutil.ReadAll(io.LimitReader(resp.Body, maxJWKSSize))
+}
@@ -0,0 +1,40 @@
+package queueauth
+
+import (
+	"fmt"
+
+	"github.com/MarcGrol/forwardhttp/config"
+	"github.com/MarcGrol/forwardhttp/queue"
+)
+
+// NewFromSettings selects via QUEUE_AUTH how requests of the queue are recognized: "oidc" (default for cloudtasks),
+// "appengine" or "hmac" (default for the self-hosted queues)
+func NewFromSettings(settings config.Settings) (Verifier, error) {
+	mode := "hmac"
+	if settings.GetOrDefault("QUEUE_BACKEND", "cloudtasks") == "cloudtasks" {
+		mode = "oidc"
+	}
+	mode = settings.GetOrDefault("QUEUE_AUTH", mode)
+
+	switch mode {
+	case "oidc":
+		serviceAccount, err := settings.Mandatory("QUEUE_SERVICE_ACCOUNT")
+		if err != nil {
+			return nil, err
+		}
+		return NewOIDCVerifier(settings.GetOrDefault("QUEUE_OIDC_JWKS", googleJWKSURL), queue.OIDCAudienceFromSettings(settings), serviceAccount)
+	case "appengine":
+		if settings.Get("GAE_APPLICATION") == "" {
+			return nil, fmt.Errorf("Invalid setting 'QUEUE_AUTH': 'appengine' can only be used on App Engine")
+		}
+		return NewAppEngineVerifier(settings.GetOrDefault("QUEUE_NAME", "default")), nil
+	case "hmac":
+		secret, err := settings.Mandatory("QUEUE_SECRET")
+		if err != nil {
+			return nil, err
+		}
+		return NewHMACVerifier(secret)
+	default:
+		return nil, fmt.Errorf("Invalid setting 'QUEUE_AUTH': '%s' is not one of oidc, appengine, hmac", mode)
+	}
+}
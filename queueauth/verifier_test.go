@@ -0,0 +1,277 @@
+package queueauth
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/config"
+	"github.com/MarcGrol/forwardhttp/signature"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+const serviceAccount = "tasks@my-project.iam.gserviceaccount.com"
+
+func TestOIDCVerifier(t *testing.T) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NoError(t, os.WriteFile(jwksFile, jwks(privateKey, "key-1"), 0600))
+	verifier, err := NewOIDCVerifier(jwksFile, "https://my-project.appspot.com", serviceAccount)
+	assert.NoError(t, err)
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{"iss": "https://accounts.google.com", "aud": "https://my-project.appspot.com",
+			"email": serviceAccount, "email_verified": true, "exp": time.Now().Add(time.Hour).Unix()}
+	}
+	withClaim := func(name string, value interface{}) jwt.MapClaims {
+		claims := validClaims()
+		claims[name] = value
+		return claims
+	}
+	withoutClaim := func(name string) jwt.MapClaims {
+		claims := validClaims()
+		delete(claims, name)
+		return claims
+	}
+
+	testCases := []struct {
+		name          string
+		authorization string
+		expectedError string
+	}{
+		{
+			name:          "Valid token",
+			authorization: bearer(t, privateKey, "key-1", validClaims()),
+		},
+		{
+			name:          "Missing token",
+			authorization: "",
+			expectedError: "Request does not come from the queue: missing bearer-token",
+		},
+		{
+			name:          "Unknown key",
+			authorization: bearer(t, privateKey, "key-2", validClaims()),
+			expectedError: "Request does not come from the queue: unknown key 'key-2'",
+		},
+		{
+			name:          "Expired",
+			authorization: bearer(t, privateKey, "key-1", withClaim("exp", time.Now().Add(-time.Minute).Unix())),
+			expectedError: "Request does not come from the queue: Token is expired",
+		},
+		{
+			name:          "Without expiry",
+			authorization: bearer(t, privateKey, "key-1", withoutClaim("exp")),
+			expectedError: "Request does not come from the queue: missing expiry",
+		},
+		{
+			name:          "Other issuer",
+			authorization: bearer(t, privateKey, "key-1", withClaim("iss", "https://idp.example.com")),
+			expectedError: "Request does not come from the queue: unexpected issuer",
+		},
+		{
+			name:          "Other audience",
+			authorization: bearer(t, privateKey, "key-1", withClaim("aud", "https://other.appspot.com")),
+			expectedError: "Request does not come from the queue: unexpected audience",
+		},
+		{
+			name:          "Other service-account",
+			authorization: bearer(t, privateKey, "key-1", withClaim("email", "someone@gmail.com")),
+			expectedError: "Request does not come from the queue: unexpected service-account 'someone@gmail.com'",
+		},
+		{
+			name:          "Unverified email",
+			authorization: bearer(t, privateKey, "key-1", withClaim("email_verified", false)),
+			expectedError: "Request does not come from the queue: unexpected service-account '" + serviceAccount + "'",
+		},
+		{
+			name:          "Hmac signed",
+			authorization: "Bearer " + hmacToken(t, validClaims()),
+			expectedError: "Request does not come from the queue: unexpected signing method HS256",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request := taskRequest(t, map[string]string{"Authorization": tc.authorization})
+			err := verifier.Verify(request)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				assert.True(t, errors.Is(err, ErrNotFromQueue))
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestOIDCVerifierFetchesRotatedKeys(t *testing.T) {
+	oldKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	newKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	served := jwks(oldKey, "old")
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write(served)
+	}))
+	defer server.Close()
+
+	verifier, err := NewOIDCVerifier(server.URL, "https://my-project.appspot.com", serviceAccount)
+	assert.NoError(t, err)
+	claims := jwt.MapClaims{"iss": "accounts.google.com", "aud": "https://my-project.appspot.com", "email": serviceAccount, "email_verified": true, "exp": time.Now().Add(time.Hour).Unix()}
+
+	served = jwks(newKey, "new")
+	verifier.(*oidcVerifier).keys.fetchedAt = time.Now().Add(-minRefreshInterval)
+
+	assert.NoError(t, verifier.Verify(taskRequest(t, map[string]string{"Authorization": bearer(t, newKey, "new", claims)})))
+	// an unknown key does not cause fetching again right away
+	assert.Error(t, verifier.Verify(taskRequest(t, map[string]string{"Authorization": bearer(t, oldKey, "unknown", claims)})))
+	assert.Equal(t, 2, fetches)
+}
+
+func TestAppEngineVerifier(t *testing.T) {
+	verifier := NewAppEngineVerifier("default")
+
+	assert.NoError(t, verifier.Verify(taskRequest(t, map[string]string{"X-CloudTasks-QueueName": "default", "X-CloudTasks-TaskName": "abc"})))
+	assert.EqualError(t, verifier.Verify(taskRequest(t, map[string]string{})), "Request does not come from the queue: missing X-CloudTasks headers")
+	assert.EqualError(t, verifier.Verify(taskRequest(t, map[string]string{"X-CloudTasks-QueueName": "other", "X-CloudTasks-TaskName": "abc"})), "Request does not come from the queue: unexpected queue 'other'")
+}
+
+func TestHMACVerifier(t *testing.T) {
+	verifier, err := NewHMACVerifier("s3cr3t")
+	assert.NoError(t, err)
+
+	signed := func(secret string, path string) *http.Request {
+		signer, err := signature.NewSigner([]string{secret})
+		assert.NoError(t, err)
+		request := taskRequest(t, map[string]string{})
+		signer.Sign(request.Header, "abc", time.Now(), "POST", path, []byte(`{"TaskUID":"abc"}`))
+		return request
+	}
+
+	request := signed("s3cr3t", "/_ah/tasks/doSend")
+	assert.NoError(t, verifier.Verify(request))
+	body, _ := ioutil.ReadAll(request.Body)
+	assert.Equal(t, `{"TaskUID":"abc"}`, string(body))
+
+	err = verifier.Verify(signed("other", "/_ah/tasks/doSend"))
+	assert.True(t, errors.Is(err, ErrNotFromQueue))
+	err = verifier.Verify(signed("s3cr3t", "/_ah/tasks/other"))
+	assert.True(t, errors.Is(err, ErrNotFromQueue))
+	err = verifier.Verify(taskRequest(t, map[string]string{}))
+	assert.True(t, errors.Is(err, ErrNotFromQueue))
+}
+
+func TestNewFromSettings(t *testing.T) {
+	jwksFile := filepath.Join(t.TempDir(), "jwks.json")
+	assert.NoError(t, os.WriteFile(jwksFile, []byte(`{"keys": []}`), 0600))
+
+	testCases := []struct {
+		name          string
+		settings      map[string]string
+		expected      Verifier
+		expectedError string
+	}{
+		{
+			name:          "Cloudtasks without service-account",
+			settings:      map[string]string{},
+			expectedError: "Missing mandatory setting 'QUEUE_SERVICE_ACCOUNT'",
+		},
+		{
+			name:          "Cloudtasks without audience",
+			settings:      map[string]string{"QUEUE_SERVICE_ACCOUNT": serviceAccount, "QUEUE_OIDC_JWKS": jwksFile},
+			expectedError: "Missing oidc audience: set 'QUEUE_OIDC_AUDIENCE' or 'GOOGLE_CLOUD_PROJECT'",
+		},
+		{
+			name:     "Cloudtasks",
+			settings: map[string]string{"QUEUE_SERVICE_ACCOUNT": serviceAccount, "QUEUE_OIDC_JWKS": jwksFile, "GOOGLE_CLOUD_PROJECT": "my-project"},
+			expected: &oidcVerifier{},
+		},
+		{
+			name:          "Missing jwks file",
+			settings:      map[string]string{"QUEUE_SERVICE_ACCOUNT": serviceAccount, "QUEUE_OIDC_JWKS": jwksFile + ".missing", "GOOGLE_CLOUD_PROJECT": "my-project"},
+			expectedError: "Error reading jwks " + jwksFile + ".missing: open " + jwksFile + ".missing: no such file or directory",
+		},
+		{
+			name:          "App Engine elsewhere",
+			settings:      map[string]string{"QUEUE_AUTH": "appengine"},
+			expectedError: "Invalid setting 'QUEUE_AUTH': 'appengine' can only be used on App Engine",
+		},
+		{
+			name:     "App Engine",
+			settings: map[string]string{"QUEUE_AUTH": "appengine", "GAE_APPLICATION": "my-project"},
+			expected: &appEngineVerifier{},
+		},
+		{
+			name:          "Self-hosted without secret",
+			settings:      map[string]string{"QUEUE_BACKEND": "memory"},
+			expectedError: "Missing mandatory setting 'QUEUE_SECRET'",
+		},
+		{
+			name:     "Self-hosted",
+			settings: map[string]string{"QUEUE_BACKEND": "memory", "QUEUE_SECRET": "s3cr3t"},
+			expected: &hmacVerifier{},
+		},
+		{
+			name:          "Unknown",
+			settings:      map[string]string{"QUEUE_AUTH": "none"},
+			expectedError: "Invalid setting 'QUEUE_AUTH': 'none' is not one of oidc, appengine, hmac",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			verifier, err := NewFromSettings(config.New(tc.settings))
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+			} else {
+				assert.NoError(t, err)
+				assert.IsType(t, tc.expected, verifier)
+			}
+		})
+	}
+}
+
+func jwks(key *ecdsa.PrivateKey, kid string) []byte {
+	return []byte(fmt.Sprintf(`{"keys": [{"kid": "%s", "kty": "EC", "crv": "P-256", "x": "%s", "y": "%s"}]}`, kid,
+		base64.RawURLEncoding.EncodeToString(key.X.Bytes()), base64.RawURLEncoding.EncodeToString(key.Y.Bytes())))
+}
+
+func bearer(t *testing.T, key *ecdsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	assert.NoError(t, err)
+	return "Bearer " + signed
+}
+
+func hmacToken(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = "key-1"
+	signed, err := token.SignedString([]byte("public key"))
+	assert.NoError(t, err)
+	return signed
+}
+
+func taskRequest(t *testing.T, headers map[string]string) *http.Request {
+	request, err := http.NewRequest("POST", "/_ah/tasks/doSend", bytes.NewReader([]byte(`{"TaskUID":"abc"}`)))
+	assert.NoError(t, err)
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	return request
+}
@@ -0,0 +1,25 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrLimitExceeded is wrapped by the error that is returned when a request is held back
+var ErrLimitExceeded = errors.New("Limit exceeded")
+
+// LimitError tells when a request that was held back can be sent
+type LimitError struct {
+	Host    string
+	Reason  string
+	RetryAt time.Time
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("Limit of host %s exceeded (%s) until %s", e.Host, e.Reason, e.RetryAt.Format(time.RFC3339))
+}
+
+func (e *LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
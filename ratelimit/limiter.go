@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/httpclient"
+	"github.com/MarcGrol/forwardhttp/route"
+)
+
+// inFlightDeferral is how long a request is held back when the host has too many requests in flight
+const inFlightDeferral = time.Second
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// limiter applies the strictest limits of the routes to the destination host of a request,
+// whether the request has a route, like forwarded requests, or not, like callbacks and topic deliveries.
+// Routes that share a host share its limits. State is kept in memory, so each instance of the service has its own limits.
+type limiter struct {
+	sync.Mutex
+	next     httpclient.HTTPSender
+	routes   route.Config
+	byHost   map[string]route.Limits
+	nowFunc  func() time.Time
+	buckets  map[string]*bucket
+	inFlight map[string]int
+}
+
+func New(next httpclient.HTTPSender, routes route.Config) *limiter {
+	return &limiter{
+		next:     next,
+		routes:   routes,
+		byHost:   limitsByHost(routes),
+		nowFunc:  time.Now,
+		buckets:  map[string]*bucket{},
+		inFlight: map[string]int{},
+	}
+}
+
+// limitsByHost combines the limits of routes that share a host into the strictest ones
+func limitsByHost(routes route.Config) map[string]route.Limits {
+	byHost := map[string]route.Limits{}
+	for _, rt := range routes.Routes {
+		if rt.Limits.IsEmpty() {
+			continue
+		}
+		host := hostOf(rt.Host)
+		byHost[host] = stricter(byHost[host], rt.Limits)
+	}
+	return byHost
+}
+
+func stricter(a, b route.Limits) route.Limits {
+	if b.RatePerSecond > 0 && (a.RatePerSecond == 0 || b.RatePerSecond < a.RatePerSecond) {
+		a.RatePerSecond = b.RatePerSecond
+		a.Burst = b.Burst
+	}
+	if b.MaxInFlight > 0 && (a.MaxInFlight == 0 || b.MaxInFlight < a.MaxInFlight) {
+		a.MaxInFlight = b.MaxInFlight
+	}
+	return a
+}
+
+func (l *limiter) Send(c context.Context, req httpclient.Request) (*httpclient.Response, error) {
+	host := hostOf(req.URL)
+	// a route without limits of its own must not bypass those of the other routes to its host
+	limits := l.byHost[host]
+	rt, found := l.routes.Get(req.Route)
+	if found {
+		limits = stricter(limits, rt.Limits)
+	}
+	if limits.IsEmpty() {
+		return l.next.Send(c, req)
+	}
+
+	err := l.acquire(host, limits)
+	if err != nil {
+		return nil, err
+	}
+	defer l.release(host)
+
+	return l.next.Send(c, req)
+}
+
+func (l *limiter) acquire(host string, limits route.Limits) error {
+	l.Lock()
+	defer l.Unlock()
+
+	now := l.nowFunc()
+	if limits.MaxInFlight > 0 && l.inFlight[host] >= limits.MaxInFlight {
+		return &LimitError{Host: host, Reason: "max-in-flight", RetryAt: now.Add(inFlightDeferral)}
+	}
+
+	if limits.RatePerSecond > 0 {
+		burst := float64(limits.Burst)
+		if burst <= 0 {
+			burst = math.Max(1, math.Ceil(limits.RatePerSecond))
+		}
+		b, found := l.buckets[host]
+		if !found {
+			b = &bucket{tokens: burst, updated: now}
+			l.buckets[host] = b
+		}
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.updated).Seconds()*limits.RatePerSecond)
+		b.updated = now
+		if b.tokens < 1 {
+			wait := time.Duration((1 - b.tokens) / limits.RatePerSecond * float64(time.Second))
+			return &LimitError{Host: host, Reason: "rate-limit", RetryAt: now.Add(wait)}
+		}
+		b.tokens--
+	}
+
+	l.inFlight[host]++
+	return nil
+}
+
+func (l *limiter) release(host string) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.inFlight[host]--
+	if l.inFlight[host] <= 0 {
+		delete(l.inFlight, host)
+	}
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	return u.Host
+}
@@ -0,0 +1,114 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/httpclient"
+	"github.com/MarcGrol/forwardhttp/route"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+var testTimestamp = time.Date(2021, time.November, 11, 10, 0, 0, 0, time.UTC)
+
+func testRoutes(limits route.Limits) route.Config {
+	return route.Config{PathPrefix: "/forward", Routes: map[string]route.Route{
+		"billing":   {Name: "billing", Host: "https://billing.example.com", Limits: limits},
+		"unlimited": {Name: "unlimited", Host: "https://billing.example.com"},
+	}}
+}
+
+func TestRateLimit(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c := context.Background()
+	now := testTimestamp
+	sender := httpclient.NewMockHTTPSender(ctrl)
+	sender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(&httpclient.Response{Status: 200}, nil).Times(4)
+	l := New(sender, testRoutes(route.Limits{RatePerSecond: 2, Burst: 2}))
+	l.nowFunc = func() time.Time { return now }
+	req := httpclient.Request{Method: "POST", URL: "https://billing.example.com/invoice", Route: "billing"}
+
+	// burst
+	_, err := l.Send(c, req)
+	assert.NoError(t, err)
+	_, err = l.Send(c, req)
+	assert.NoError(t, err)
+
+	// bucket is empty
+	_, err = l.Send(c, req)
+	assert.True(t, errors.Is(err, ErrLimitExceeded))
+	var limitErr *LimitError
+	assert.True(t, errors.As(err, &limitErr))
+	assert.Equal(t, "rate-limit", limitErr.Reason)
+	assert.Equal(t, testTimestamp.Add(500*time.Millisecond), limitErr.RetryAt)
+
+	// a route without limits of its own shares those of its host
+	_, err = l.Send(c, httpclient.Request{Method: "POST", URL: "https://billing.example.com/invoice", Route: "unlimited"})
+	assert.True(t, errors.Is(err, ErrLimitExceeded))
+
+	// requests to other hosts are not held back
+	_, err = l.Send(c, httpclient.Request{Method: "POST", URL: "https://other.example.com/invoice"})
+	assert.NoError(t, err)
+
+	// bucket refills
+	now = now.Add(500 * time.Millisecond)
+	_, err = l.Send(c, req)
+	assert.NoError(t, err)
+}
+
+func TestLimitsByHost(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c := context.Background()
+	sender := httpclient.NewMockHTTPSender(ctrl)
+	sender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(&httpclient.Response{Status: 200}, nil).Times(2)
+	routes := testRoutes(route.Limits{RatePerSecond: 2, Burst: 2})
+	routes.Routes["strict"] = route.Route{Name: "strict", Host: "https://billing.example.com/v2", Limits: route.Limits{RatePerSecond: 1, Burst: 1, MaxInFlight: 3}}
+	l := New(sender, routes)
+	l.nowFunc = func() time.Time { return testTimestamp }
+
+	// callbacks and topic deliveries have no route: the strictest limits of the host apply
+	req := httpclient.Request{Method: "POST", URL: "https://billing.example.com/callback"}
+	_, err := l.Send(c, req)
+	assert.NoError(t, err)
+	_, err = l.Send(c, req)
+	assert.True(t, errors.Is(err, ErrLimitExceeded))
+
+	// other hosts are not limited
+	_, err = l.Send(c, httpclient.Request{Method: "POST", URL: "https://other.example.com/callback"})
+	assert.NoError(t, err)
+}
+
+func TestMaxInFlight(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	c := context.Background()
+	sender := httpclient.NewMockHTTPSender(ctrl)
+	l := New(sender, testRoutes(route.Limits{MaxInFlight: 1}))
+	l.nowFunc = func() time.Time { return testTimestamp }
+	req := httpclient.Request{Method: "POST", URL: "https://billing.example.com/invoice", Route: "billing"}
+
+	sender.EXPECT().Send(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, _ httpclient.Request) (*httpclient.Response, error) {
+		// a second request while the first one is in flight
+		_, err := l.Send(c, req)
+		var limitErr *LimitError
+		assert.True(t, errors.As(err, &limitErr))
+		assert.Equal(t, "max-in-flight", limitErr.Reason)
+		assert.Equal(t, testTimestamp.Add(time.Second), limitErr.RetryAt)
+		return &httpclient.Response{Status: 200}, nil
+	})
+	_, err := l.Send(c, req)
+	assert.NoError(t, err)
+
+	// released after completion
+	sender.EXPECT().Send(gomock.Any(), gomock.Any()).Return(&httpclient.Response{Status: 200}, nil)
+	_, err = l.Send(c, req)
+	assert.NoError(t, err)
+}
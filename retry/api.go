@@ -0,0 +1,20 @@
+package retry
+
+import (
+	"github.com/MarcGrol/forwardhttp/httpclient"
+)
+
+//go:generate mockgen -source=api.go -destination=gen_RetryPolicyMock.go -package=retry github.com/MarcGrol/forwardhttp/retry Policy
+
+type Decision string
+
+const (
+	Success Decision = "success" // delivered: no more attempts
+	Retry   Decision = "retry"   // failed, but a next attempt might succeed
+	GiveUp  Decision = "give-up" // failed, and a next attempt would fail as well
+)
+
+// Policy classifies the outcome of a delivery attempt
+type Policy interface {
+	Decide(resp *httpclient.Response, err error) Decision
+}
@@ -0,0 +1,48 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: api.go
+
+// Package retry is a generated GoMock package.
+package retry
+
+import (
+	httpclient "github.com/MarcGrol/forwardhttp/httpclient"
+	gomock "github.com/golang/mock/gomock"
+	reflect "reflect"
+)
+
+// MockPolicy is a mock of Policy interface
+type MockPolicy struct {
+	ctrl     *gomock.Controller
+	recorder *MockPolicyMockRecorder
+}
+
+// MockPolicyMockRecorder is the mock recorder for MockPolicy
+type MockPolicyMockRecorder struct {
+	mock *MockPolicy
+}
+
+// NewMockPolicy creates a new mock instance
+func NewMockPolicy(ctrl *gomock.Controller) *MockPolicy {
+	mock := &MockPolicy{ctrl: ctrl}
+	mock.recorder = &MockPolicyMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use
+func (m *MockPolicy) EXPECT() *MockPolicyMockRecorder {
+	return m.recorder
+}
+
+// Decide mocks base method
+func (m *MockPolicy) Decide(resp *httpclient.Response, err error) Decision {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Decide", resp, err)
+	ret0, _ := ret[0].(Decision)
+	return ret0
+}
+
+// Decide indicates an expected call of Decide
+func (mr *MockPolicyMockRecorder) Decide(resp, err interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Decide", reflect.TypeOf((*MockPolicy)(nil).Decide), resp, err)
+}
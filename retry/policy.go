@@ -0,0 +1,150 @@
+package retry
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/MarcGrol/forwardhttp/httpclient"
+)
+
+// NetworkError matches attempts that did not result in a response
+const NetworkError = "network"
+
+type defaultPolicy struct{}
+
+// NewDefaultPolicy retries on network errors, 408, 425, 429 and 5xx, and gives up on other 4xx
+func NewDefaultPolicy() Policy {
+	return &defaultPolicy{}
+}
+
+func (p defaultPolicy) Decide(resp *httpclient.Response, err error) Decision {
+	if err != nil || resp == nil {
+		return Retry
+	}
+	if !resp.IsError() {
+		return Success
+	}
+	switch resp.Status {
+	case http.StatusRequestTimeout, http.StatusTooEarly, http.StatusTooManyRequests:
+		return Retry
+	}
+	if resp.Status >= http.StatusInternalServerError {
+		return Retry
+	}
+	return GiveUp
+}
+
+// Rules overrule another policy for the failures they match. Both lists contain patterns like
+// "404", "4xx", "500-503" or "network"; give-up rules take precedence over retry rules.
+type Rules struct {
+	RetryOn  []string
+	GiveUpOn []string
+}
+
+func (r Rules) IsEmpty() bool {
+	return len(r.RetryOn) == 0 && len(r.GiveUpOn) == 0
+}
+
+func (r Rules) Validate() error {
+	for _, pattern := range append(append([]string{}, r.RetryOn...), r.GiveUpOn...) {
+		_, err := parsePattern(pattern)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseList splits a comma-separated list of patterns
+func ParseList(value string) []string {
+	patterns := []string{}
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+type rulesPolicy struct {
+	rules    Rules
+	fallback Policy
+}
+
+// NewRulesPolicy applies the rules and leaves what they do not match to the fallback policy
+func NewRulesPolicy(rules Rules, fallback Policy) Policy {
+	return &rulesPolicy{
+		rules:    rules,
+		fallback: fallback,
+	}
+}
+
+func (p rulesPolicy) Decide(resp *httpclient.Response, err error) Decision {
+	if err == nil && resp != nil && !resp.IsError() {
+		return Success
+	}
+	if matchesAny(p.rules.GiveUpOn, resp, err) {
+		return GiveUp
+	}
+	if matchesAny(p.rules.RetryOn, resp, err) {
+		return Retry
+	}
+	return p.fallback.Decide(resp, err)
+}
+
+func matchesAny(patterns []string, resp *httpclient.Response, err error) bool {
+	for _, pattern := range patterns {
+		m, parseErr := parsePattern(pattern)
+		if parseErr == nil && m.matches(resp, err) {
+			return true
+		}
+	}
+	return false
+}
+
+type matcher struct {
+	network  bool
+	from, to int
+}
+
+func (m matcher) matches(resp *httpclient.Response, err error) bool {
+	if err != nil || resp == nil {
+		return m.network
+	}
+	return !m.network && resp.Status >= m.from && resp.Status <= m.to
+}
+
+func parsePattern(pattern string) (matcher, error) {
+	p := strings.ToLower(strings.TrimSpace(pattern))
+	if p == NetworkError {
+		return matcher{network: true}, nil
+	}
+	if len(p) == 3 && strings.HasSuffix(p, "xx") {
+		class, err := strconv.Atoi(p[:1])
+		if err == nil && class >= 1 && class <= 5 {
+			return matcher{from: class * 100, to: class*100 + 99}, nil
+		}
+	}
+	if parts := strings.SplitN(p, "-", 2); len(parts) == 2 {
+		from, fromErr := parseStatus(parts[0])
+		to, toErr := parseStatus(parts[1])
+		if fromErr == nil && toErr == nil && from <= to {
+			return matcher{from: from, to: to}, nil
+		}
+	}
+	if status, err := parseStatus(p); err == nil {
+		return matcher{from: status, to: status}, nil
+	}
+	return matcher{}, fmt.Errorf("Invalid retry pattern '%s': expected a status like 404, 4xx or 500-503, or '%s'", pattern, NetworkError)
+}
+
+func parseStatus(value string) (int, error) {
+	status, err := strconv.Atoi(value)
+	if err != nil || status < 100 || status > 599 {
+		return 0, fmt.Errorf("invalid status '%s'", value)
+	}
+	return status, nil
+}
@@ -0,0 +1,60 @@
+package retry
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/MarcGrol/forwardhttp/httpclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicies(t *testing.T) {
+	networkError := fmt.Errorf("connection refused")
+
+	testCases := []struct {
+		name             string
+		rules            Rules
+		resp             *httpclient.Response
+		err              error
+		expectedDecision Decision
+	}{
+		{name: "Default: ok", resp: response(200), expectedDecision: Success},
+		{name: "Default: not modified", resp: response(304), expectedDecision: Success},
+		{name: "Default: network error", err: networkError, expectedDecision: Retry},
+		{name: "Default: bad request", resp: response(400), expectedDecision: GiveUp},
+		{name: "Default: not found", resp: response(404), expectedDecision: GiveUp},
+		{name: "Default: request timeout", resp: response(408), expectedDecision: Retry},
+		{name: "Default: too early", resp: response(425), expectedDecision: Retry},
+		{name: "Default: too many requests", resp: response(429), expectedDecision: Retry},
+		{name: "Default: internal server error", resp: response(500), expectedDecision: Retry},
+		{name: "Default: service unavailable", resp: response(503), expectedDecision: Retry},
+		{name: "Rules: retry on status", rules: Rules{RetryOn: []string{"404"}}, resp: response(404), expectedDecision: Retry},
+		{name: "Rules: retry on class", rules: Rules{RetryOn: []string{"4xx"}}, resp: response(409), expectedDecision: Retry},
+		{name: "Rules: give up on range", rules: Rules{GiveUpOn: []string{"500-502"}}, resp: response(501), expectedDecision: GiveUp},
+		{name: "Rules: outside range falls back", rules: Rules{GiveUpOn: []string{"500-502"}}, resp: response(503), expectedDecision: Retry},
+		{name: "Rules: give up on network error", rules: Rules{GiveUpOn: []string{"network"}}, err: networkError, expectedDecision: GiveUp},
+		{name: "Rules: give up takes precedence", rules: Rules{RetryOn: []string{"5xx"}, GiveUpOn: []string{"503"}}, resp: response(503), expectedDecision: GiveUp},
+		{name: "Rules: success cannot be overruled", rules: Rules{GiveUpOn: []string{"2xx"}}, resp: response(200), expectedDecision: Success},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy := NewDefaultPolicy()
+			if !tc.rules.IsEmpty() {
+				policy = NewRulesPolicy(tc.rules, policy)
+			}
+			assert.Equal(t, tc.expectedDecision, policy.Decide(tc.resp, tc.err))
+		})
+	}
+}
+
+func TestValidateRules(t *testing.T) {
+	assert.NoError(t, Rules{RetryOn: ParseList("404, 4xx,500-503,network"), GiveUpOn: ParseList("")}.Validate())
+	assert.EqualError(t, Rules{RetryOn: []string{"6xx"}}.Validate(), "Invalid retry pattern '6xx': expected a status like 404, 4xx or 500-503, or 'network'")
+	assert.EqualError(t, Rules{GiveUpOn: []string{"503-500"}}.Validate(), "Invalid retry pattern '503-500': expected a status like 404, 4xx or 500-503, or 'network'")
+	assert.EqualError(t, Rules{GiveUpOn: []string{"sometimes"}}.Validate(), "Invalid retry pattern 'sometimes': expected a status like 404, 4xx or 500-503, or 'network'")
+}
+
+func response(status int) *httpclient.Response {
+	return &httpclient.Response{Status: status}
+}
@@ -0,0 +1,214 @@
+package route
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/config"
+	"github.com/MarcGrol/forwardhttp/retry"
+	"github.com/MarcGrol/forwardhttp/signature"
+)
+
+const DefaultPathPrefix = "/forward"
+
+// Route describes a named destination, so that clients do not need to pass the host to forward to on every call
+type Route struct {
+	Name        string
+	Host        string            // scheme, host and optional base-path, e.g. "https://api.example.com/v1"
+	Headers     map[string]string // added to the forwarded request, unless the client provided them
+	MaxAttempts int32             // zero means: as many as the queue allows
+	Timeout     time.Duration     // zero means: the default timeout of the http-client
+	RetryRules  retry.Rules       // overrule the default retry-policy
+	Limits      Limits            // protect the host against overload
+	SigningKeys []string          // each key signs every forwarded request, so that keys can be rotated
+}
+
+// Limits apply per destination host; zero means: unlimited
+type Limits struct {
+	RatePerSecond float64 // sustained rate of requests
+	Burst         int     // requests that may be sent at once, defaults to the rate rounded up
+	MaxInFlight   int     // concurrent requests of this instance
+}
+
+func (l Limits) IsEmpty() bool {
+	return l.RatePerSecond == 0 && l.MaxInFlight == 0
+}
+
+// Config determines where routes are mounted and which routes exist
+type Config struct {
+	PathPrefix string
+	Routes     map[string]Route
+	Groups     map[string][]string // subscriber-groups: each request is delivered to all routes of the group
+}
+
+func (c Config) Get(name string) (Route, bool) {
+	r, found := c.Routes[name]
+	return r, found
+}
+
+// SigningKeys returns the signing-keys per route, for the routes that have them
+func (c Config) SigningKeys() map[string][]string {
+	keys := map[string][]string{}
+	for name, r := range c.Routes {
+		if len(r.SigningKeys) > 0 {
+			keys[name] = r.SigningKeys
+		}
+	}
+	return keys
+}
+
+// Group returns the routes of a subscriber-group
+func (c Config) Group(name string) ([]Route, bool) {
+	names, found := c.Groups[name]
+	if !found {
+		return nil, false
+	}
+	routes := []Route{}
+	for _, name := range names {
+		routes = append(routes, c.Routes[name])
+	}
+	return routes, true
+}
+
+type routeFile struct {
+	Groups map[string][]string
+	Routes map[string]struct {
+		Host        string
+		Headers     map[string]string
+		MaxAttempts int32
+		Timeout     string
+		RetryOn     []string
+		GiveUpOn    []string
+		RateLimit   float64
+		Burst       int
+		MaxInFlight int
+		SigningKeys []string
+	}
+}
+
+// NewConfigFromSettings mounts routes at FORWARD_PATH_PREFIX (default "/forward") and reads them from ROUTES_FILE (optional)
+func NewConfigFromSettings(settings config.Settings) (Config, error) {
+	pathPrefix := strings.TrimSuffix(settings.GetOrDefault("FORWARD_PATH_PREFIX", DefaultPathPrefix), "/")
+	if !strings.HasPrefix(pathPrefix, "/") {
+		return Config{}, fmt.Errorf("Invalid setting 'FORWARD_PATH_PREFIX': '%s' must start with a '/'", pathPrefix)
+	}
+
+	routes := map[string]Route{}
+	groups := map[string][]string{}
+	filename := settings.Get("ROUTES_FILE")
+	if filename != "" {
+		var err error
+		routes, groups, err = Load(filename)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	return Config{
+		PathPrefix: pathPrefix,
+		Routes:     routes,
+		Groups:     groups,
+	}, nil
+}
+
+// Load reads routes and subscriber-groups from a json-file like:
+//
+//	{"Routes": {"billing": {"Host": "https://billing.example.com", "Headers": {"Authorization": "Bearer 123"}, "MaxAttempts": 5, "Timeout": "10s",
+//		"RetryOn": ["409"], "GiveUpOn": ["501"], "RateLimit": 10, "Burst": 20, "MaxInFlight": 5, "SigningKeys": ["whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw"]}},
+//	 "Groups": {"orders": ["billing", "shipping"]}}
+func Load(filename string) (map[string]Route, map[string][]string, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading routes file %s: %s", filename, err)
+	}
+	var file routeFile
+	err = json.Unmarshal(data, &file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error parsing routes file %s: %s", filename, err)
+	}
+
+	routes := map[string]Route{}
+	for name, r := range file.Routes {
+		route := Route{
+			Name:        name,
+			Host:        strings.TrimSuffix(r.Host, "/"),
+			Headers:     r.Headers,
+			MaxAttempts: r.MaxAttempts,
+			RetryRules:  retry.Rules{RetryOn: r.RetryOn, GiveUpOn: r.GiveUpOn},
+			Limits:      Limits{RatePerSecond: r.RateLimit, Burst: r.Burst, MaxInFlight: r.MaxInFlight},
+			SigningKeys: r.SigningKeys,
+		}
+		if r.Timeout != "" {
+			route.Timeout, err = time.ParseDuration(r.Timeout)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Invalid route '%s': invalid timeout '%s'", name, r.Timeout)
+			}
+		}
+		err = route.validate()
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid route '%s': %s", name, err)
+		}
+		routes[name] = route
+	}
+
+	groups := map[string][]string{}
+	for name, members := range file.Groups {
+		err = validateGroup(name, members, routes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid group '%s': %s", name, err)
+		}
+		groups[name] = members
+	}
+	return routes, groups, nil
+}
+
+func validateGroup(name string, members []string, routes map[string]Route) error {
+	if name == "" || strings.Contains(name, "/") {
+		return fmt.Errorf("name must be non-empty and must not contain a '/'")
+	}
+	if _, found := routes[name]; found {
+		return fmt.Errorf("name is already used by a route")
+	}
+	if len(members) == 0 {
+		return fmt.Errorf("missing routes")
+	}
+	for _, member := range members {
+		if _, found := routes[member]; !found {
+			return fmt.Errorf("unknown route '%s'", member)
+		}
+	}
+	return nil
+}
+
+func (r Route) validate() error {
+	if r.Name == "" || strings.Contains(r.Name, "/") {
+		return fmt.Errorf("name must be non-empty and must not contain a '/'")
+	}
+	if r.Host == "" {
+		return fmt.Errorf("missing host")
+	}
+	u, err := url.Parse(r.Host)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("host '%s' must be an absolute http(s)-url", r.Host)
+	}
+	if r.MaxAttempts < 0 {
+		return fmt.Errorf("max-attempts must not be negative")
+	}
+	if r.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	if r.Limits.RatePerSecond < 0 || r.Limits.Burst < 0 || r.Limits.MaxInFlight < 0 {
+		return fmt.Errorf("rate-limit, burst and max-in-flight must not be negative")
+	}
+	for i, key := range r.SigningKeys {
+		_, err := signature.ParseSecret(key)
+		if err != nil {
+			return fmt.Errorf("invalid signing-key %d: %s", i+1, err)
+		}
+	}
+	return r.RetryRules.Validate()
+}
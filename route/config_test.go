@@ -0,0 +1,117 @@
+package route
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/config"
+	"github.com/MarcGrol/forwardhttp/retry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewConfigFromSettings(t *testing.T) {
+	testCases := []struct {
+		name           string
+		routesFile     string
+		prefix         string
+		expectedError  string
+		expectedConfig Config
+	}{
+		{
+			name:           "No routes",
+			expectedConfig: Config{PathPrefix: "/forward", Routes: map[string]Route{}, Groups: map[string][]string{}},
+		},
+		{
+			name:       "Valid routes",
+			prefix:     "/relay/",
+			routesFile: `{"Routes": {"billing": {"Host": "https://billing.example.com/v1/", "Headers": {"Authorization": "Bearer 123"}, "MaxAttempts": 5, "Timeout": "10s", "RetryOn": ["409"], "GiveUpOn": ["500-503"], "RateLimit": 2.5, "Burst": 5, "MaxInFlight": 3}}}`,
+			expectedConfig: Config{PathPrefix: "/relay", Routes: map[string]Route{
+				"billing": {Name: "billing", Host: "https://billing.example.com/v1", Headers: map[string]string{"Authorization": "Bearer 123"}, MaxAttempts: 5, Timeout: 10 * time.Second, RetryRules: retry.Rules{RetryOn: []string{"409"}, GiveUpOn: []string{"500-503"}}, Limits: Limits{RatePerSecond: 2.5, Burst: 5, MaxInFlight: 3}},
+			}, Groups: map[string][]string{}},
+		},
+		{
+			name:       "Valid group",
+			routesFile: `{"Routes": {"billing": {"Host": "https://billing.example.com"}, "shipping": {"Host": "https://shipping.example.com"}}, "Groups": {"orders": ["billing", "shipping"]}}`,
+			expectedConfig: Config{PathPrefix: "/forward", Routes: map[string]Route{
+				"billing":  {Name: "billing", Host: "https://billing.example.com"},
+				"shipping": {Name: "shipping", Host: "https://shipping.example.com"},
+			}, Groups: map[string][]string{"orders": {"billing", "shipping"}}},
+		},
+		{
+			name:          "Group with unknown route",
+			routesFile:    `{"Routes": {"billing": {"Host": "https://billing.example.com"}}, "Groups": {"orders": ["billing", "shipping"]}}`,
+			expectedError: "Invalid group 'orders': unknown route 'shipping'",
+		},
+		{
+			name:          "Group named like a route",
+			routesFile:    `{"Routes": {"billing": {"Host": "https://billing.example.com"}}, "Groups": {"billing": ["billing"]}}`,
+			expectedError: "Invalid group 'billing': name is already used by a route",
+		},
+		{
+			name:          "Invalid prefix",
+			prefix:        "relay",
+			expectedError: "Invalid setting 'FORWARD_PATH_PREFIX': 'relay' must start with a '/'",
+		},
+		{
+			name:          "Missing host",
+			routesFile:    `{"Routes": {"billing": {}}}`,
+			expectedError: "Invalid route 'billing': missing host",
+		},
+		{
+			name:          "Relative host",
+			routesFile:    `{"Routes": {"billing": {"Host": "billing.example.com"}}}`,
+			expectedError: "Invalid route 'billing': host 'billing.example.com' must be an absolute http(s)-url",
+		},
+		{
+			name:          "Invalid timeout",
+			routesFile:    `{"Routes": {"billing": {"Host": "https://billing.example.com", "Timeout": "10"}}}`,
+			expectedError: "Invalid route 'billing': invalid timeout '10'",
+		},
+		{
+			name:          "Invalid retry pattern",
+			routesFile:    `{"Routes": {"billing": {"Host": "https://billing.example.com", "GiveUpOn": ["6xx"]}}}`,
+			expectedError: "Invalid route 'billing': Invalid retry pattern '6xx': expected a status like 404, 4xx or 500-503, or 'network'",
+		},
+		{
+			name:          "Negative rate limit",
+			routesFile:    `{"Routes": {"billing": {"Host": "https://billing.example.com", "RateLimit": -1}}}`,
+			expectedError: "Invalid route 'billing': rate-limit, burst and max-in-flight must not be negative",
+		},
+		{
+			name:       "Signing keys",
+			routesFile: `{"Routes": {"billing": {"Host": "https://billing.example.com", "SigningKeys": ["whsec_c2VjcmV0", "plain-secret"]}}}`,
+			expectedConfig: Config{PathPrefix: "/forward", Routes: map[string]Route{
+				"billing": {Name: "billing", Host: "https://billing.example.com", SigningKeys: []string{"whsec_c2VjcmV0", "plain-secret"}},
+			}, Groups: map[string][]string{}},
+		},
+		{
+			name:          "Invalid signing key",
+			routesFile:    `{"Routes": {"billing": {"Host": "https://billing.example.com", "SigningKeys": ["whsec_not base64"]}}}`,
+			expectedError: "Invalid route 'billing': invalid signing-key 1: secret with prefix 'whsec_' must be base64 encoded",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			values := map[string]string{}
+			if tc.prefix != "" {
+				values["FORWARD_PATH_PREFIX"] = tc.prefix
+			}
+			if tc.routesFile != "" {
+				filename := filepath.Join(t.TempDir(), "routes.json")
+				assert.NoError(t, os.WriteFile(filename, []byte(tc.routesFile), 0600))
+				values["ROUTES_FILE"] = filename
+			}
+
+			cfg, err := NewConfigFromSettings(config.New(values))
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedConfig, cfg)
+		})
+	}
+}
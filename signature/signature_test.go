@@ -0,0 +1,89 @@
+package signature
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testTimestamp = time.Unix(1614265330, 0)
+
+func TestStandardWebhooksCompatible(t *testing.T) {
+	// example of the Standard Webhooks specification
+	signer, err := NewSigner([]string{"whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw"})
+	assert.NoError(t, err)
+
+	headers := http.Header{}
+	signer.Sign(headers, "msg_p5jXN8AQM9LWM0D4loKWxJek", testTimestamp, "POST", "https://home.nl/doit", []byte(`{"test": 2432232314}`))
+
+	assert.Equal(t, "msg_p5jXN8AQM9LWM0D4loKWxJek", headers.Get(HeaderID))
+	assert.Equal(t, "1614265330", headers.Get(HeaderTimestamp))
+	assert.Equal(t, "v1,g0hM9SsE+OTPJTGt/tmIKtSyZlE3uFJELVlNIOLJ1OE=", headers.Get(HeaderSignature))
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte("request payload")
+
+	testCases := []struct {
+		name           string
+		signingKeys    []string
+		verifyingKeys  []string
+		method         string
+		url            string
+		body           []byte
+		now            time.Time
+		expectedError  error
+		expectedWebErr error
+	}{
+		{name: "Valid", signingKeys: []string{"secret"}, verifyingKeys: []string{"secret"}, method: "POST", url: "https://home.nl/doit", body: body, now: testTimestamp},
+		{name: "Rotated: signed with old and new key", signingKeys: []string{"old", "new"}, verifyingKeys: []string{"new"}, method: "POST", url: "https://home.nl/doit", body: body, now: testTimestamp},
+		{name: "Rotated: verified with old and new key", signingKeys: []string{"old"}, verifyingKeys: []string{"new", "old"}, method: "POST", url: "https://home.nl/doit", body: body, now: testTimestamp},
+		{name: "Other key", signingKeys: []string{"secret"}, verifyingKeys: []string{"other"}, method: "POST", url: "https://home.nl/doit", body: body, now: testTimestamp, expectedError: ErrNoMatch, expectedWebErr: ErrNoMatch},
+		{name: "Other body", signingKeys: []string{"secret"}, verifyingKeys: []string{"secret"}, method: "POST", url: "https://home.nl/doit", body: []byte("other payload"), now: testTimestamp, expectedError: ErrNoMatch, expectedWebErr: ErrNoMatch},
+		{name: "Other url", signingKeys: []string{"secret"}, verifyingKeys: []string{"secret"}, method: "POST", url: "https://evil.nl/doit", body: body, now: testTimestamp, expectedError: ErrNoMatch},
+		{name: "Other method", signingKeys: []string{"secret"}, verifyingKeys: []string{"secret"}, method: "DELETE", url: "https://home.nl/doit", body: body, now: testTimestamp, expectedError: ErrNoMatch},
+		{name: "Too old", signingKeys: []string{"secret"}, verifyingKeys: []string{"secret"}, method: "POST", url: "https://home.nl/doit", body: body, now: testTimestamp.Add(10 * time.Minute), expectedError: ErrInvalidTimestamp, expectedWebErr: ErrInvalidTimestamp},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			signer, err := NewSigner(tc.signingKeys)
+			assert.NoError(t, err)
+			headers := http.Header{}
+			signer.Sign(headers, "abc", testTimestamp, "POST", "https://home.nl/doit", body)
+
+			verifier, err := NewVerifier(tc.verifyingKeys, DefaultTolerance)
+			assert.NoError(t, err)
+			verifier.nowFunc = func() time.Time { return tc.now }
+
+			err = verifier.Verify(headers, tc.method, tc.url, tc.body)
+			assert.True(t, errors.Is(err, tc.expectedError), "unexpected error: %v", err)
+			err = verifier.VerifyWebhook(headers, tc.body)
+			assert.True(t, errors.Is(err, tc.expectedWebErr), "unexpected error: %v", err)
+		})
+	}
+}
+
+func TestVerifyUnsigned(t *testing.T) {
+	verifier, err := NewVerifier([]string{"secret"}, DefaultTolerance)
+	assert.NoError(t, err)
+
+	err = verifier.Verify(http.Header{}, "POST", "https://home.nl/doit", nil)
+	assert.Equal(t, ErrMissingHeaders, err)
+}
+
+func TestParseSecret(t *testing.T) {
+	key, err := ParseSecret("whsec_c2VjcmV0")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("secret"), key)
+
+	key, err = ParseSecret("secret")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("secret"), key)
+
+	_, err = ParseSecret("")
+	assert.Error(t, err)
+}
@@ -0,0 +1,107 @@
+// Package signature signs forwarded requests, so that receivers can verify they came through the forwarder.
+//
+// The headers follow Standard Webhooks (https://www.standardwebhooks.com):
+//
+//	webhook-id:                <task-uid>
+//	webhook-timestamp:         <unix seconds of the attempt>
+//	webhook-signature:         v1,<base64 hmac-sha256 of "<id>.<timestamp>.<body>">
+//	webhook-request-signature: v1,<base64 hmac-sha256 of "<id>.<timestamp>.<method>.<url>.<body>">
+//
+// Each active key adds a signature, separated by a space, so that keys can be rotated without downtime.
+// Standard Webhooks libraries verify webhook-signature; the Verifier of this package also covers method and url.
+package signature
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderID               = "webhook-id"
+	HeaderTimestamp        = "webhook-timestamp"
+	HeaderSignature        = "webhook-signature"
+	HeaderRequestSignature = "webhook-request-signature"
+
+	secretPrefix     = "whsec_"
+	signatureVersion = "v1"
+)
+
+// ParseSecret decodes a secret like "whsec_<base64>"; a secret without the prefix is used as is
+func ParseSecret(secret string) ([]byte, error) {
+	if secret == "" {
+		return nil, fmt.Errorf("secret must not be empty")
+	}
+	if !strings.HasPrefix(secret, secretPrefix) {
+		return []byte(secret), nil
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, secretPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("secret with prefix '%s' must be base64 encoded", secretPrefix)
+	}
+	return key, nil
+}
+
+type Signer struct {
+	keys [][]byte
+}
+
+// NewSigner signs with all given secrets
+func NewSigner(secrets []string) (*Signer, error) {
+	keys, err := parseSecrets(secrets)
+	if err != nil {
+		return nil, err
+	}
+	return &Signer{keys: keys}, nil
+}
+
+// Sign adds the signature-headers to the headers of a request
+func (s *Signer) Sign(headers http.Header, id string, timestamp time.Time, method, url string, body []byte) {
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	headers.Set(HeaderID, id)
+	headers.Set(HeaderTimestamp, ts)
+	headers.Set(HeaderSignature, sign(s.keys, webhookContent(id, ts, body)))
+	headers.Set(HeaderRequestSignature, sign(s.keys, requestContent(id, ts, method, url, body)))
+}
+
+func parseSecrets(secrets []string) ([][]byte, error) {
+	if len(secrets) == 0 {
+		return nil, fmt.Errorf("missing secret")
+	}
+	keys := [][]byte{}
+	for i, secret := range secrets {
+		key, err := ParseSecret(secret)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid secret %d: %s", i+1, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func webhookContent(id, timestamp string, body []byte) []byte {
+	return append([]byte(id+"."+timestamp+"."), body...)
+}
+
+func requestContent(id, timestamp, method, url string, body []byte) []byte {
+	return append([]byte(id+"."+timestamp+"."+method+"."+url+"."), body...)
+}
+
+func sign(keys [][]byte, content []byte) string {
+	signatures := []string{}
+	for _, key := range keys {
+		signatures = append(signatures, signatureVersion+","+base64.StdEncoding.EncodeToString(mac(key, content)))
+	}
+	return strings.Join(signatures, " ")
+}
+
+func mac(key, content []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(content)
+	return h.Sum(nil)
+}
@@ -0,0 +1,98 @@
+package signature
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultTolerance is how far the timestamp of a request may differ from the clock of the receiver
+const DefaultTolerance = 5 * time.Minute
+
+var (
+	ErrMissingHeaders   = errors.New("Missing signature headers")
+	ErrInvalidTimestamp = errors.New("Timestamp outside tolerance")
+	ErrNoMatch          = errors.New("No matching signature")
+)
+
+type Verifier struct {
+	keys      [][]byte
+	tolerance time.Duration
+	nowFunc   func() time.Time
+}
+
+// NewVerifier accepts signatures of any of the given secrets, which eases rotation
+func NewVerifier(secrets []string, tolerance time.Duration) (*Verifier, error) {
+	keys, err := parseSecrets(secrets)
+	if err != nil {
+		return nil, err
+	}
+	return &Verifier{
+		keys:      keys,
+		tolerance: tolerance,
+		nowFunc:   time.Now,
+	}, nil
+}
+
+// Verify checks the signature that covers method, url and body; url is the url as sent by the forwarder
+func (v *Verifier) Verify(headers http.Header, method, url string, body []byte) error {
+	id, ts, err := v.verifyTimestamp(headers)
+	if err != nil {
+		return err
+	}
+	return v.verifySignature(headers.Get(HeaderRequestSignature), requestContent(id, ts, method, url, body))
+}
+
+// VerifyWebhook checks the Standard Webhooks signature, that only covers the body
+func (v *Verifier) VerifyWebhook(headers http.Header, body []byte) error {
+	id, ts, err := v.verifyTimestamp(headers)
+	if err != nil {
+		return err
+	}
+	return v.verifySignature(headers.Get(HeaderSignature), webhookContent(id, ts, body))
+}
+
+func (v *Verifier) verifyTimestamp(headers http.Header) (string, string, error) {
+	id := headers.Get(HeaderID)
+	ts := headers.Get(HeaderTimestamp)
+	if id == "" || ts == "" {
+		return "", "", ErrMissingHeaders
+	}
+	seconds, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: '%s' is not a unix timestamp", ErrInvalidTimestamp, ts)
+	}
+	diff := v.nowFunc().Sub(time.Unix(seconds, 0))
+	if diff > v.tolerance || diff < -v.tolerance {
+		return "", "", fmt.Errorf("%w: %s", ErrInvalidTimestamp, time.Unix(seconds, 0).UTC().Format(time.RFC3339))
+	}
+	return id, ts, nil
+}
+
+func (v *Verifier) verifySignature(header string, content []byte) error {
+	if header == "" {
+		return ErrMissingHeaders
+	}
+	for _, key := range v.keys {
+		expected := mac(key, content)
+		for _, candidate := range strings.Fields(header) {
+			parts := strings.SplitN(candidate, ",", 2)
+			if len(parts) != 2 || parts[0] != signatureVersion {
+				continue
+			}
+			signature, err := base64.StdEncoding.DecodeString(parts[1])
+			if err != nil {
+				continue
+			}
+			if hmac.Equal(signature, expected) {
+				return nil
+			}
+		}
+	}
+	return ErrNoMatch
+}
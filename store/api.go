@@ -2,6 +2,20 @@ package store
 
 import "context"
 
+// Filter restricts a query to entities of which the field compares to the value
+type Filter struct {
+	Field    string // dotted path for nested fields, e.g. "Request.TaskUID"
+	Operator string // one of "=", "<", "<=", ">", ">="
+	Value    interface{}
+}
+
 type DataStorer interface {
 	Put(c context.Context, kind, uid string, value interface{}) error
+	Get(c context.Context, kind, uid string, value interface{}) (bool, error)
+	Query(c context.Context, kind string, filters []Filter, values interface{}) error
+	Delete(c context.Context, kind, uid string) error
+	// Update reads the entity into value, lets update change it and stores it, without interference of
+	// concurrent updates. Value keeps its zero value when the entity is not found. Nothing is stored when update
+	// returns an error, which is returned as is. Update may be called more than once and must not use the store.
+	Update(c context.Context, kind, uid string, value interface{}, update func(found bool) error) error
 }
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	supportedOperators = map[string]bool{"=": true, "<": true, "<=": true, ">": true, ">=": true}
+	fieldPattern       = regexp.MustCompile(`^[A-Za-z0-9_]+(\.[A-Za-z0-9_]+)*$`)
+)
+
+func validateFilters(filters []Filter) error {
+	for _, f := range filters {
+		if !fieldPattern.MatchString(f.Field) {
+			return fmt.Errorf("Invalid field '%s' in filter", f.Field)
+		}
+		if !supportedOperators[f.Operator] {
+			return fmt.Errorf("Unsupported operator '%s' in filter on %s", f.Operator, f.Field)
+		}
+	}
+	return nil
+}
+
+func (f Filter) path() []string {
+	return strings.Split(f.Field, ".")
+}
+
+// matches evaluates the filter against an entity that was decoded from json into generic maps
+func (f Filter) matches(entity map[string]interface{}) bool {
+	var value interface{} = entity
+	for _, name := range f.path() {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		value, ok = m[name]
+		if !ok {
+			return false
+		}
+	}
+
+	cmp, ok := compare(value, f.Value)
+	if !ok {
+		return false
+	}
+	switch f.Operator {
+	case "=":
+		return cmp == 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// compare compares a json-decoded value with a filter-value
+func compare(jsonValue interface{}, filterValue interface{}) (int, bool) {
+	switch fv := filterValue.(type) {
+	case string:
+		s, ok := jsonValue.(string)
+		if !ok {
+			return 0, false
+		}
+		return strings.Compare(s, fv), true
+	case time.Time:
+		s, ok := jsonValue.(string)
+		if !ok {
+			return 0, false
+		}
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return 0, false
+		}
+		return compareFloats(float64(t.UnixNano()), float64(fv.UnixNano())), true
+	case bool:
+		b, ok := jsonValue.(bool)
+		if !ok || b != fv {
+			return 1, ok
+		}
+		return 0, true
+	default:
+		number, ok := toFloat(filterValue)
+		if !ok {
+			return 0, false
+		}
+		f, ok := jsonValue.(float64)
+		if !ok {
+			return 0, false
+		}
+		return compareFloats(f, number), true
+	}
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+func compareFloats(a, b float64) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
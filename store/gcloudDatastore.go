@@ -3,7 +3,7 @@ package store
 import (
 	"context"
 	"fmt"
-	"os"
+	"reflect"
 
 	"cloud.google.com/go/datastore"
 )
@@ -12,9 +12,8 @@ type gcloudDataStore struct {
 	client *datastore.Client
 }
 
-func NewStore(c context.Context) (DataStorer, func(), error) {
-	projectId := os.Getenv("GOOGLE_CLOUD_PROJECT")
-	client, err := datastore.NewClient(c, projectId)
+func NewStore(c context.Context, projectID string) (DataStorer, func(), error) {
+	client, err := datastore.NewClient(c, projectID)
 	if err != nil {
 		return nil, nil, fmt.Errorf("Error creating datastore-client: %s", err)
 	}
@@ -32,3 +31,70 @@ func (s *gcloudDataStore) Put(c context.Context, kind, uid string, objectToStore
 	}
 	return nil
 }
+
+func (s *gcloudDataStore) Get(c context.Context, kind, uid string, objectToLoad interface{}) (bool, error) {
+	err := s.client.Get(c, datastore.NameKey(kind, uid, nil), objectToLoad)
+	if err == datastore.ErrNoSuchEntity {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Error getting entity %s-%s: %s", kind, uid, err)
+	}
+	return true, nil
+}
+
+// Update runs in a transaction, that datastore retries when the entity was changed concurrently
+func (s *gcloudDataStore) Update(c context.Context, kind, uid string, objectToUpdate interface{}, update func(found bool) error) error {
+	key := datastore.NameKey(kind, uid, nil)
+	var updateErr error
+	_, err := s.client.RunInTransaction(c, func(tx *datastore.Transaction) error {
+		// a retry starts from what is stored, not from the outcome of the previous try
+		value := reflect.ValueOf(objectToUpdate).Elem()
+		value.Set(reflect.Zero(value.Type()))
+
+		err := tx.Get(key, objectToUpdate)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return fmt.Errorf("Error getting entity %s-%s: %s", kind, uid, err)
+		}
+		updateErr = update(err == nil)
+		if updateErr != nil {
+			return updateErr
+		}
+		_, err = tx.Put(key, objectToUpdate)
+		if err != nil {
+			return fmt.Errorf("Error updating entity %s-%s: %s", kind, uid, err)
+		}
+		return nil
+	})
+	if updateErr != nil {
+		return updateErr
+	}
+	if err != nil {
+		return fmt.Errorf("Error updating entity %s-%s: %s", kind, uid, err)
+	}
+	return nil
+}
+
+func (s *gcloudDataStore) Query(c context.Context, kind string, filters []Filter, objectsToLoad interface{}) error {
+	err := validateFilters(filters)
+	if err != nil {
+		return err
+	}
+	q := datastore.NewQuery(kind)
+	for _, f := range filters {
+		q = q.Filter(f.Field+" "+f.Operator, f.Value)
+	}
+	_, err = s.client.GetAll(c, q, objectsToLoad)
+	if err != nil {
+		return fmt.Errorf("Error querying entities of kind %s: %s", kind, err)
+	}
+	return nil
+}
+
+func (s *gcloudDataStore) Delete(c context.Context, kind, uid string) error {
+	err := s.client.Delete(c, datastore.NameKey(kind, uid, nil))
+	if err != nil {
+		return fmt.Errorf("Error deleting entity %s-%s: %s", kind, uid, err)
+	}
+	return nil
+}
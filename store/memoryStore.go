@@ -0,0 +1,137 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type memoryDataStore struct {
+	sync.Mutex
+	entities map[string]map[string][]byte
+}
+
+// NewMemoryStore keeps entities in memory as json: they are lost when the process stops
+func NewMemoryStore(c context.Context) (DataStorer, func(), error) {
+	return &memoryDataStore{
+		entities: map[string]map[string][]byte{},
+	}, func() {}, nil
+}
+
+func (s *memoryDataStore) Put(c context.Context, kind, uid string, objectToStore interface{}) error {
+	value, err := json.Marshal(objectToStore)
+	if err != nil {
+		return fmt.Errorf("Error marshalling entity %s-%s: %s", kind, uid, err)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	if _, found := s.entities[kind]; !found {
+		s.entities[kind] = map[string][]byte{}
+	}
+	s.entities[kind][uid] = value
+	return nil
+}
+
+func (s *memoryDataStore) Get(c context.Context, kind, uid string, objectToLoad interface{}) (bool, error) {
+	s.Lock()
+	value, found := s.entities[kind][uid]
+	s.Unlock()
+
+	if !found {
+		return false, nil
+	}
+	err := json.Unmarshal(value, objectToLoad)
+	if err != nil {
+		return false, fmt.Errorf("Error unmarshalling entity %s-%s: %s", kind, uid, err)
+	}
+	return true, nil
+}
+
+func (s *memoryDataStore) Update(c context.Context, kind, uid string, objectToUpdate interface{}, update func(found bool) error) error {
+	s.Lock()
+	defer s.Unlock()
+
+	value, found := s.entities[kind][uid]
+	if found {
+		err := json.Unmarshal(value, objectToUpdate)
+		if err != nil {
+			return fmt.Errorf("Error unmarshalling entity %s-%s: %s", kind, uid, err)
+		}
+	}
+	err := update(found)
+	if err != nil {
+		return err
+	}
+	value, err = json.Marshal(objectToUpdate)
+	if err != nil {
+		return fmt.Errorf("Error marshalling entity %s-%s: %s", kind, uid, err)
+	}
+	if _, found := s.entities[kind]; !found {
+		s.entities[kind] = map[string][]byte{}
+	}
+	s.entities[kind][uid] = value
+	return nil
+}
+
+func (s *memoryDataStore) Query(c context.Context, kind string, filters []Filter, objectsToLoad interface{}) error {
+	err := validateFilters(filters)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	uids := []string{}
+	for uid := range s.entities[kind] {
+		uids = append(uids, uid)
+	}
+	sort.Strings(uids)
+	values := [][]byte{}
+	for _, uid := range uids {
+		values = append(values, s.entities[kind][uid])
+	}
+	s.Unlock()
+
+	matches := [][]byte{}
+	for _, value := range values {
+		var entity map[string]interface{}
+		err := json.Unmarshal(value, &entity)
+		if err != nil {
+			return fmt.Errorf("Error unmarshalling entity of kind %s: %s", kind, err)
+		}
+		if matchesAll(entity, filters) {
+			matches = append(matches, value)
+		}
+	}
+
+	err = json.Unmarshal(joinJSONArray(matches), objectsToLoad)
+	if err != nil {
+		return fmt.Errorf("Error unmarshalling entities of kind %s: %s", kind, err)
+	}
+	return nil
+}
+
+func (s *memoryDataStore) Delete(c context.Context, kind, uid string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.entities[kind], uid)
+	return nil
+}
+
+func matchesAll(entity map[string]interface{}, filters []Filter) bool {
+	for _, f := range filters {
+		if !f.matches(entity) {
+			return false
+		}
+	}
+	return true
+}
+
+func joinJSONArray(values [][]byte) []byte {
+	return append(append([]byte("["), bytes.Join(values, []byte(","))...), ']')
+}
@@ -0,0 +1,142 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type nestedEntity struct {
+	Name string
+}
+
+type queryEntity struct {
+	Nested    nestedEntity
+	Count     int
+	Completed bool
+	Timestamp time.Time
+}
+
+func TestMemoryStoreGet(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+
+	err = s.Put(c, "TestEntity", "abc", queryEntity{Nested: nestedEntity{Name: "first"}, Count: 1})
+	assert.NoError(t, err)
+
+	var entity queryEntity
+	found, err := s.Get(c, "TestEntity", "abc", &entity)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "first", entity.Nested.Name)
+
+	found, err = s.Get(c, "TestEntity", "def", &entity)
+	assert.NoError(t, err)
+	assert.False(t, found)
+
+	err = s.Delete(c, "TestEntity", "abc")
+	assert.NoError(t, err)
+	found, err = s.Get(c, "TestEntity", "abc", &entity)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestMemoryStoreQuery(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+
+	now := time.Now()
+	assert.NoError(t, s.Put(c, "TestEntity", "1", queryEntity{Nested: nestedEntity{Name: "a"}, Count: 1, Completed: true, Timestamp: now.Add(-time.Hour)}))
+	assert.NoError(t, s.Put(c, "TestEntity", "2", queryEntity{Nested: nestedEntity{Name: "b"}, Count: 2, Completed: false, Timestamp: now}))
+	assert.NoError(t, s.Put(c, "TestEntity", "3", queryEntity{Nested: nestedEntity{Name: "a"}, Count: 3, Completed: false, Timestamp: now.Add(time.Hour)}))
+	assert.NoError(t, s.Put(c, "OtherEntity", "4", queryEntity{Nested: nestedEntity{Name: "a"}}))
+
+	testCases := []struct {
+		name           string
+		filters        []Filter
+		expectedCounts []int
+		expectedError  string
+	}{
+		{name: "All", filters: nil, expectedCounts: []int{1, 2, 3}},
+		{name: "Nested string", filters: []Filter{{Field: "Nested.Name", Operator: "=", Value: "a"}}, expectedCounts: []int{1, 3}},
+		{name: "Number", filters: []Filter{{Field: "Count", Operator: ">=", Value: 2}}, expectedCounts: []int{2, 3}},
+		{name: "Bool", filters: []Filter{{Field: "Completed", Operator: "=", Value: false}}, expectedCounts: []int{2, 3}},
+		{name: "Time range", filters: []Filter{{Field: "Timestamp", Operator: ">", Value: now.Add(-time.Minute)}, {Field: "Timestamp", Operator: "<", Value: now.Add(time.Minute)}}, expectedCounts: []int{2}},
+		{name: "Invalid operator", filters: []Filter{{Field: "Count", Operator: "!=", Value: 1}}, expectedError: "Unsupported operator '!=' in filter on Count"},
+		{name: "Invalid field", filters: []Filter{{Field: "Count'; --", Operator: "=", Value: 1}}, expectedError: "Invalid field 'Count'; --' in filter"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			entities := []queryEntity{}
+			err := s.Query(c, "TestEntity", tc.filters, &entities)
+			if tc.expectedError != "" {
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			counts := []int{}
+			for _, e := range entities {
+				counts = append(counts, e.Count)
+			}
+			assert.Equal(t, tc.expectedCounts, counts)
+		})
+	}
+}
+
+func TestMemoryStoreUpdate(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+
+	assertUpdatesAreAtomic(t, s)
+}
+
+// assertUpdatesAreAtomic increments a counter concurrently: no increment may get lost
+func assertUpdatesAreAtomic(t *testing.T, s DataStorer) {
+	c := context.Background()
+	uid := fmt.Sprintf("counter-%d", time.Now().UnixNano())
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			entity := queryEntity{}
+			err := s.Update(c, "TestEntity", uid, &entity, func(found bool) error {
+				entity.Count++
+				return nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+
+	entity := queryEntity{}
+	found, err := s.Get(c, "TestEntity", uid, &entity)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 20, entity.Count)
+
+	// a failing update stores nothing
+	err = s.Update(c, "TestEntity", uid, &entity, func(found bool) error {
+		assert.True(t, found)
+		entity.Count = 0
+		return fmt.Errorf("refused")
+	})
+	assert.EqualError(t, err, "refused")
+	_, err = s.Get(c, "TestEntity", uid, &entity)
+	assert.NoError(t, err)
+	assert.Equal(t, 20, entity.Count)
+
+	assert.NoError(t, s.Delete(c, "TestEntity", uid))
+}
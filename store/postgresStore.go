@@ -0,0 +1,199 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	_ "github.com/lib/pq" // postgres driver
+)
+
+const createEntitiesTableSQL = `
+CREATE TABLE IF NOT EXISTS forwardhttp_entities (
+	kind       TEXT NOT NULL,
+	uid        TEXT NOT NULL,
+	value      JSONB NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	PRIMARY KEY (kind, uid)
+);
+`
+
+type postgresDataStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore stores entities as json-documents in a single postgres table
+func NewPostgresStore(c context.Context, dsn string) (DataStorer, func(), error) {
+	if dsn == "" {
+		return nil, nil, fmt.Errorf("Error creating postgres-store: missing postgres dsn")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error opening postgres: %s", err)
+	}
+	err = db.PingContext(c)
+	if err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("Error connecting to postgres: %s", err)
+	}
+	_, err = db.ExecContext(c, createEntitiesTableSQL)
+	if err != nil {
+		db.Close()
+		return nil, nil, fmt.Errorf("Error creating postgres-store table: %s", err)
+	}
+	return &postgresDataStore{
+		db: db,
+	}, func() {
+		db.Close()
+	}, nil
+}
+
+func (s *postgresDataStore) Put(c context.Context, kind, uid string, objectToStore interface{}) error {
+	value, err := json.Marshal(objectToStore)
+	if err != nil {
+		return fmt.Errorf("Error marshalling entity %s-%s: %s", kind, uid, err)
+	}
+	_, err = s.db.ExecContext(c, `
+		INSERT INTO forwardhttp_entities (kind, uid, value, updated_at)
+		VALUES ($1, $2, $3, now())
+		ON CONFLICT (kind, uid) DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at`,
+		kind, uid, string(value))
+	if err != nil {
+		return fmt.Errorf("Error creating entity %s-%s: %s", kind, uid, err)
+	}
+	return nil
+}
+
+func (s *postgresDataStore) Get(c context.Context, kind, uid string, objectToLoad interface{}) (bool, error) {
+	var value string
+	err := s.db.QueryRowContext(c, `SELECT value FROM forwardhttp_entities WHERE kind = $1 AND uid = $2`, kind, uid).Scan(&value)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("Error getting entity %s-%s: %s", kind, uid, err)
+	}
+	err = json.Unmarshal([]byte(value), objectToLoad)
+	if err != nil {
+		return false, fmt.Errorf("Error unmarshalling entity %s-%s: %s", kind, uid, err)
+	}
+	return true, nil
+}
+
+// Update locks the row, and the key for entities that do not exist yet, until the transaction completes
+func (s *postgresDataStore) Update(c context.Context, kind, uid string, objectToUpdate interface{}, update func(found bool) error) error {
+	tx, err := s.db.BeginTx(c, nil)
+	if err != nil {
+		return fmt.Errorf("Error starting transaction on entity %s-%s: %s", kind, uid, err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(c, `SELECT pg_advisory_xact_lock(hashtext($1::text || '/' || $2::text))`, kind, uid)
+	if err != nil {
+		return fmt.Errorf("Error locking entity %s-%s: %s", kind, uid, err)
+	}
+	var value string
+	found := true
+	err = tx.QueryRowContext(c, `SELECT value FROM forwardhttp_entities WHERE kind = $1 AND uid = $2 FOR UPDATE`, kind, uid).Scan(&value)
+	if err == sql.ErrNoRows {
+		found = false
+	} else if err != nil {
+		return fmt.Errorf("Error getting entity %s-%s: %s", kind, uid, err)
+	}
+	if found {
+		err = json.Unmarshal([]byte(value), objectToUpdate)
+		if err != nil {
+			return fmt.Errorf("Error unmarshalling entity %s-%s: %s", kind, uid, err)
+		}
+	}
+
+	err = update(found)
+	if err != nil {
+		return err
+	}
+
+	updated, err := json.Marshal(objectToUpdate)
+	if err != nil {
+		return fmt.Errorf("Error marshalling entity %s-%s: %s", kind, uid, err)
+	}
+	_, err = tx.ExecContext(c, `
+		INSERT INTO forwardhttp_entities (kind, uid, value, updated_at)
+		VALUES ($1, $2, $3, now())
+		ON CONFLICT (kind, uid) DO UPDATE SET value = EXCLUDED.value, updated_at = EXCLUDED.updated_at`,
+		kind, uid, string(updated))
+	if err != nil {
+		return fmt.Errorf("Error updating entity %s-%s: %s", kind, uid, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("Error committing entity %s-%s: %s", kind, uid, err)
+	}
+	return nil
+}
+
+func (s *postgresDataStore) Query(c context.Context, kind string, filters []Filter, objectsToLoad interface{}) error {
+	err := validateFilters(filters)
+	if err != nil {
+		return err
+	}
+
+	query := `SELECT value FROM forwardhttp_entities WHERE kind = $1`
+	args := []interface{}{kind}
+	for _, f := range filters {
+		expression, arg := postgresFilter(f, len(args)+1)
+		query += " AND " + expression
+		args = append(args, arg)
+	}
+	query += " ORDER BY uid"
+
+	rows, err := s.db.QueryContext(c, query, args...)
+	if err != nil {
+		return fmt.Errorf("Error querying entities of kind %s: %s", kind, err)
+	}
+	defer rows.Close()
+
+	values := [][]byte{}
+	for rows.Next() {
+		var value string
+		err = rows.Scan(&value)
+		if err != nil {
+			return fmt.Errorf("Error querying entities of kind %s: %s", kind, err)
+		}
+		values = append(values, []byte(value))
+	}
+	if rows.Err() != nil {
+		return fmt.Errorf("Error querying entities of kind %s: %s", kind, rows.Err())
+	}
+
+	err = json.Unmarshal(joinJSONArray(values), objectsToLoad)
+	if err != nil {
+		return fmt.Errorf("Error unmarshalling entities of kind %s: %s", kind, err)
+	}
+	return nil
+}
+
+func (s *postgresDataStore) Delete(c context.Context, kind, uid string) error {
+	_, err := s.db.ExecContext(c, `DELETE FROM forwardhttp_entities WHERE kind = $1 AND uid = $2`, kind, uid)
+	if err != nil {
+		return fmt.Errorf("Error deleting entity %s-%s: %s", kind, uid, err)
+	}
+	return nil
+}
+
+// postgresFilter translates a filter into an expression on the json-document, casting to the type of the filter-value
+func postgresFilter(f Filter, argIndex int) (string, interface{}) {
+	field := fmt.Sprintf("(value #>> '{%s}')", strings.Join(f.path(), ","))
+	switch v := f.Value.(type) {
+	case time.Time:
+		return fmt.Sprintf("%s::timestamptz %s $%d::timestamptz", field, f.Operator, argIndex), v.Format(time.RFC3339Nano)
+	case bool:
+		return fmt.Sprintf("%s::boolean %s $%d::boolean", field, f.Operator, argIndex), v
+	case string:
+		return fmt.Sprintf("%s %s $%d", field, f.Operator, argIndex), v
+	default:
+		return fmt.Sprintf("%s::numeric %s $%d::numeric", field, f.Operator, argIndex), v
+	}
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testEntity struct {
+	Name  string
+	Count int
+}
+
+func TestPostgresStore(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_DSN not set")
+	}
+	c := context.Background()
+
+	store, cleanup, err := NewPostgresStore(c, dsn)
+	assert.NoError(t, err)
+	defer cleanup()
+
+	err = store.Put(c, "TestEntity", "abc", testEntity{Name: "first", Count: 1})
+	assert.NoError(t, err)
+
+	// overwrites
+	err = store.Put(c, "TestEntity", "abc", testEntity{Name: "second", Count: 2})
+	assert.NoError(t, err)
+
+	var entity testEntity
+	found, err := store.Get(c, "TestEntity", "abc", &entity)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, testEntity{Name: "second", Count: 2}, entity)
+
+	entities := []testEntity{}
+	err = store.Query(c, "TestEntity", []Filter{{Field: "Name", Operator: "=", Value: "second"}, {Field: "Count", Operator: ">", Value: 1}}, &entities)
+	assert.NoError(t, err)
+	assert.Equal(t, []testEntity{{Name: "second", Count: 2}}, entities)
+
+	err = store.Delete(c, "TestEntity", "abc")
+	assert.NoError(t, err)
+	found, err = store.Get(c, "TestEntity", "abc", &entity)
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestPostgresStoreUpdate(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_DSN not set")
+	}
+	c := context.Background()
+
+	store, cleanup, err := NewPostgresStore(c, dsn)
+	assert.NoError(t, err)
+	defer cleanup()
+
+	assertUpdatesAreAtomic(t, store)
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/MarcGrol/forwardhttp/config"
+)
+
+// Provider creates a store backend, after validating the settings it needs
+type Provider func(c context.Context, settings config.Settings) (DataStorer, func(), error)
+
+var providers = map[string]Provider{
+	"datastore": newDatastoreFromSettings,
+	"memory":    newMemoryStoreFromSettings,
+	"postgres":  newPostgresStoreFromSettings,
+}
+
+func RegisterProvider(name string, provider Provider) {
+	providers[name] = provider
+}
+
+// NewFromSettings creates the store backend selected by STORE_BACKEND (default "datastore")
+func NewFromSettings(c context.Context, settings config.Settings) (DataStorer, func(), error) {
+	name := settings.GetOrDefault("STORE_BACKEND", "datastore")
+	provider, found := providers[name]
+	if !found {
+		return nil, nil, fmt.Errorf("Unknown store backend '%s', expected one of: %s", name, providerNames())
+	}
+	s, cleanup, err := provider(c, settings)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating store backend '%s': %s", name, err)
+	}
+	return s, cleanup, nil
+}
+
+func providerNames() string {
+	names := []string{}
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func newDatastoreFromSettings(c context.Context, settings config.Settings) (DataStorer, func(), error) {
+	projectID, err := settings.Mandatory("GOOGLE_CLOUD_PROJECT")
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewStore(c, projectID)
+}
+
+func newMemoryStoreFromSettings(c context.Context, settings config.Settings) (DataStorer, func(), error) {
+	return NewMemoryStore(c)
+}
+
+func newPostgresStoreFromSettings(c context.Context, settings config.Settings) (DataStorer, func(), error) {
+	dsn, err := settings.Mandatory("POSTGRES_DSN")
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewPostgresStore(c, dsn)
+}
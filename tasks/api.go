@@ -0,0 +1,13 @@
+package tasks
+
+import (
+	"github.com/MarcGrol/forwardhttp/auth"
+	"github.com/MarcGrol/forwardhttp/forwarder"
+	"github.com/MarcGrol/forwardhttp/warehouse"
+)
+
+type webService struct {
+	warehouse     warehouse.Warehouser
+	forwarder     forwarder.Forwarder
+	authenticator auth.Authenticator
+}
@@ -0,0 +1,204 @@
+package tasks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+
+	"github.com/MarcGrol/forwardhttp/auth"
+	"github.com/MarcGrol/forwardhttp/forwarder"
+	"github.com/MarcGrol/forwardhttp/queue"
+	"github.com/MarcGrol/forwardhttp/warehouse"
+	"github.com/gorilla/mux"
+)
+
+const tasksPath = "/tasks"
+
+func NewWebService(warehouse warehouse.Warehouser, forwarder forwarder.Forwarder, authenticator auth.Authenticator) *webService {
+	s := &webService{
+		warehouse:     warehouse,
+		forwarder:     forwarder,
+		authenticator: authenticator,
+	}
+	return s
+}
+
+func (s *webService) RegisterEndpoint(router *mux.Router) *mux.Router {
+	subRouter := router.PathPrefix(tasksPath).Subrouter()
+	subRouter.HandleFunc("", auth.Required(s.authenticator, s.queryTasks())).Methods("GET")
+	subRouter.HandleFunc("/{taskUid}", auth.Required(s.authenticator, s.getTask())).Methods("GET")
+	subRouter.HandleFunc("/{taskUid}", auth.Required(s.authenticator, s.cancelTask())).Methods("DELETE")
+	subRouter.HandleFunc("/{taskUid}/attempts", auth.Required(s.authenticator, s.listAttempts())).Methods("GET")
+	return router
+}
+
+func (s *webService) getTask() auth.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, client auth.Client) {
+		c := r.Context()
+		taskUID := mux.Vars(r)["taskUid"]
+
+		status, found, err := s.warehouse.Get(c, taskUID)
+		if err != nil {
+			reportError(w, http.StatusInternalServerError, fmt.Errorf("Error fetching task %s: %s", taskUID, err))
+			return
+		}
+		if found {
+			err = authorize(client, *status)
+			if err != nil {
+				reportError(w, http.StatusForbidden, err)
+				return
+			}
+			writeJSON(w, http.StatusOK, status)
+			return
+		}
+
+		// a request that was fanned out only exists as its children
+		children, err := s.warehouse.ListChildren(c, taskUID)
+		if err != nil {
+			reportError(w, http.StatusInternalServerError, fmt.Errorf("Error fetching children of task %s: %s", taskUID, err))
+			return
+		}
+		children = authorized(client, children)
+		if len(children) == 0 {
+			reportError(w, http.StatusNotFound, fmt.Errorf("Task %s not found", taskUID))
+			return
+		}
+
+		writeJSON(w, http.StatusOK, warehouse.NewFanOutStatus(taskUID, children))
+	}
+}
+
+func (s *webService) cancelTask() auth.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, client auth.Client) {
+		c := r.Context()
+		taskUID := mux.Vars(r)["taskUid"]
+
+		found := s.lookup(w, r, client)
+		if !found {
+			return
+		}
+
+		err := s.forwarder.Cancel(c, taskUID)
+		if errors.Is(err, queue.ErrTaskNotFound) {
+			reportError(w, http.StatusNotFound, fmt.Errorf("Task %s is not pending", taskUID))
+			return
+		}
+		if errors.Is(err, forwarder.ErrTaskCompleted) {
+			reportError(w, http.StatusConflict, fmt.Errorf("Task %s is already completed", taskUID))
+			return
+		}
+		if err != nil {
+			reportError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *webService) listAttempts() auth.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, client auth.Client) {
+		c := r.Context()
+		taskUID := mux.Vars(r)["taskUid"]
+
+		found := s.lookup(w, r, client)
+		if !found {
+			return
+		}
+
+		attempts, err := s.warehouse.ListAttempts(c, taskUID)
+		if err != nil {
+			reportError(w, http.StatusInternalServerError, fmt.Errorf("Error fetching attempts of task %s: %s", taskUID, err))
+			return
+		}
+
+		writeJSON(w, http.StatusOK, attempts)
+	}
+}
+
+func (s *webService) queryTasks() auth.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, client auth.Client) {
+		c := r.Context()
+		state := warehouse.TaskState(r.URL.Query().Get("state"))
+		if !state.IsValid() {
+			reportError(w, http.StatusBadRequest, fmt.Errorf("Invalid state '%s': expected one of pending, retrying, delivered, failed, cancelled, rejected", state))
+			return
+		}
+
+		statuses, err := s.warehouse.Query(c, state)
+		if err != nil {
+			reportError(w, http.StatusInternalServerError, fmt.Errorf("Error querying tasks: %s", err))
+			return
+		}
+
+		writeJSON(w, http.StatusOK, authorized(client, statuses))
+	}
+}
+
+// lookup only finds tasks the client may see
+func (s *webService) lookup(w http.ResponseWriter, r *http.Request, client auth.Client) bool {
+	taskUID := mux.Vars(r)["taskUid"]
+
+	status, found, err := s.warehouse.Get(r.Context(), taskUID)
+	if err != nil {
+		reportError(w, http.StatusInternalServerError, fmt.Errorf("Error fetching task %s: %s", taskUID, err))
+		return false
+	}
+	if !found {
+		reportError(w, http.StatusNotFound, fmt.Errorf("Task %s not found", taskUID))
+		return false
+	}
+	err = authorize(client, *status)
+	if err != nil {
+		reportError(w, http.StatusForbidden, err)
+		return false
+	}
+	return true
+}
+
+// authorize lets a client only see and cancel its own tasks, as long as it may still use their destination
+func authorize(client auth.Client, status warehouse.TaskStatus) error {
+	if status.Owner != client.Name {
+		return fmt.Errorf("Client %s may not access task %s", client.Name, status.TaskUID)
+	}
+	if status.Route != "" {
+		if !client.MayUseRoute(status.Route) {
+			return fmt.Errorf("Client %s may not use route '%s'", client.Name, status.Route)
+		}
+		return nil
+	}
+	u, err := url.Parse(status.URL)
+	if err != nil || !client.MayUseHost(u.Hostname()) {
+		return fmt.Errorf("Client %s may not forward to '%s'", client.Name, status.URL)
+	}
+	return nil
+}
+
+func authorized(client auth.Client, statuses []warehouse.TaskStatus) []warehouse.TaskStatus {
+	result := []warehouse.TaskStatus{}
+	for _, status := range statuses {
+		if authorize(client, status) == nil {
+			result = append(result, status)
+		}
+	}
+	return result
+}
+
+func writeJSON(w http.ResponseWriter, httpResponseStatus int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpResponseStatus)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		log.Printf("Error encoding response: %s", err)
+	}
+}
+
+func reportError(w http.ResponseWriter, httpResponseStatus int, err error) {
+	log.Print(err.Error())
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(httpResponseStatus)
+	fmt.Fprint(w, err.Error())
+}
@@ -0,0 +1,294 @@
+package tasks
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/auth"
+	"github.com/MarcGrol/forwardhttp/forwarder"
+	"github.com/MarcGrol/forwardhttp/queue"
+	"github.com/MarcGrol/forwardhttp/warehouse"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+var testTimestamp = time.Date(2021, time.November, 11, 10, 0, 0, 0, time.UTC)
+
+// ownedTask belongs to the anonymous client that is used when no authenticator is given
+var ownedTask = &warehouse.TaskStatus{TaskUID: "abc", State: warehouse.TaskStatePending, Method: "POST", URL: "https://home.nl/doit", Owner: "anonymous"}
+
+func TestTasks(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	testCases := []struct {
+		name                    string
+		warehouse               warehouse.Warehouser
+		forwarder               forwarder.Forwarder
+		authenticator           auth.Authenticator
+		request                 *http.Request
+		expectedResponseStatus  int
+		expectedResponsePayload string
+	}{
+		{
+			name:                    "Get task: found",
+			warehouse:               warehouseGet(ctrl, "abc", &warehouse.TaskStatus{TaskUID: "abc", State: warehouse.TaskStateRetrying, Method: "POST", URL: "https://home.nl/doit", Owner: "anonymous", Attempts: 2, MaxAttempts: 10, LastResponseStatus: 503, CreatedAt: testTimestamp, UpdatedAt: testTimestamp}, nil),
+			request:                 httpRequest(t, "GET", "/tasks/abc"),
+			expectedResponseStatus:  200,
+			expectedResponsePayload: `{"TaskUID":"abc","State":"retrying","Method":"POST","URL":"https://home.nl/doit","Owner":"anonymous","Attempts":2,"MaxAttempts":10,"LastResponseStatus":503,"CreatedAt":"2021-11-11T10:00:00Z","UpdatedAt":"2021-11-11T10:00:00Z"}` + "\n",
+		},
+		{
+			name:                    "Get task: of another client",
+			warehouse:               warehouseGet(ctrl, "abc", &warehouse.TaskStatus{TaskUID: "abc", URL: "https://home.nl/doit", Owner: "webshop"}, nil),
+			request:                 httpRequest(t, "GET", "/tasks/abc"),
+			expectedResponseStatus:  403,
+			expectedResponsePayload: "Client anonymous may not access task abc",
+		},
+		{
+			name:                    "Get task: host no longer allowed",
+			warehouse:               warehouseGet(ctrl, "abc", &warehouse.TaskStatus{TaskUID: "abc", URL: "https://home.nl/doit", Owner: "webshop"}, nil),
+			authenticator:           authenticate(ctrl, &auth.Client{Name: "webshop", Hosts: []string{"*.example.com"}}, nil),
+			request:                 httpRequest(t, "GET", "/tasks/abc"),
+			expectedResponseStatus:  403,
+			expectedResponsePayload: "Client webshop may not forward to 'https://home.nl/doit'",
+		},
+		{
+			name:                    "Get task: not found",
+			warehouse:               warehouseGetChildren(ctrl, "abc", []warehouse.TaskStatus{}),
+			request:                 httpRequest(t, "GET", "/tasks/abc"),
+			expectedResponseStatus:  404,
+			expectedResponsePayload: "Task abc not found",
+		},
+		{
+			name: "Get task: fanned out",
+			warehouse: warehouseGetChildren(ctrl, "abc", []warehouse.TaskStatus{
+				{TaskUID: "abc-1", ParentTaskUID: "abc", State: warehouse.TaskStateDelivered, Method: "POST", URL: "https://billing.example.com", Owner: "anonymous", Attempts: 1, CreatedAt: testTimestamp, UpdatedAt: testTimestamp},
+				{TaskUID: "abc-2", ParentTaskUID: "abc", State: warehouse.TaskStateRetrying, Method: "POST", URL: "https://shipping.example.com", Owner: "anonymous", Attempts: 2, CreatedAt: testTimestamp, UpdatedAt: testTimestamp},
+				{TaskUID: "abc-3", ParentTaskUID: "abc", State: warehouse.TaskStateFailed, Method: "POST", URL: "https://other.example.com", Owner: "webshop", Attempts: 1, CreatedAt: testTimestamp, UpdatedAt: testTimestamp},
+			}),
+			request:                httpRequest(t, "GET", "/tasks/abc"),
+			expectedResponseStatus: 200,
+			expectedResponsePayload: `{"TaskUID":"abc","State":"retrying","Progress":{"delivered":1,"retrying":1},"Children":[` +
+				`{"TaskUID":"abc-1","State":"delivered","Method":"POST","URL":"https://billing.example.com","ParentTaskUID":"abc","Owner":"anonymous","Attempts":1,"MaxAttempts":0,"CreatedAt":"2021-11-11T10:00:00Z","UpdatedAt":"2021-11-11T10:00:00Z"},` +
+				`{"TaskUID":"abc-2","State":"retrying","Method":"POST","URL":"https://shipping.example.com","ParentTaskUID":"abc","Owner":"anonymous","Attempts":2,"MaxAttempts":0,"CreatedAt":"2021-11-11T10:00:00Z","UpdatedAt":"2021-11-11T10:00:00Z"}]}` + "\n",
+		},
+		{
+			name: "Get task: fanned out by another client",
+			warehouse: warehouseGetChildren(ctrl, "abc", []warehouse.TaskStatus{
+				{TaskUID: "abc-1", ParentTaskUID: "abc", URL: "https://billing.example.com", Owner: "webshop"},
+			}),
+			request:                 httpRequest(t, "GET", "/tasks/abc"),
+			expectedResponseStatus:  404,
+			expectedResponsePayload: "Task abc not found",
+		},
+		{
+			name:                    "Get task: error",
+			warehouse:               warehouseGet(ctrl, "abc", nil, fmt.Errorf("store error")),
+			request:                 httpRequest(t, "GET", "/tasks/abc"),
+			expectedResponseStatus:  500,
+			expectedResponsePayload: "Error fetching task abc: store error",
+		},
+		{
+			name:                    "Cancel task",
+			warehouse:               warehouseGet(ctrl, "abc", ownedTask, nil),
+			forwarder:               forwarderCancel(ctrl, "abc", nil),
+			request:                 httpRequest(t, "DELETE", "/tasks/abc"),
+			expectedResponseStatus:  204,
+			expectedResponsePayload: "",
+		},
+		{
+			name:                    "Cancel task: not pending",
+			warehouse:               warehouseGet(ctrl, "abc", ownedTask, nil),
+			forwarder:               forwarderCancel(ctrl, "abc", fmt.Errorf("Error cancelling task abc: %w", queue.ErrTaskNotFound)),
+			request:                 httpRequest(t, "DELETE", "/tasks/abc"),
+			expectedResponseStatus:  404,
+			expectedResponsePayload: "Task abc is not pending",
+		},
+		{
+			name:                    "Cancel task: already completed",
+			warehouse:               warehouseGet(ctrl, "abc", ownedTask, nil),
+			forwarder:               forwarderCancel(ctrl, "abc", fmt.Errorf("Error cancelling task abc: %w", forwarder.ErrTaskCompleted)),
+			request:                 httpRequest(t, "DELETE", "/tasks/abc"),
+			expectedResponseStatus:  409,
+			expectedResponsePayload: "Task abc is already completed",
+		},
+		{
+			name:                    "Cancel task: error",
+			warehouse:               warehouseGet(ctrl, "abc", ownedTask, nil),
+			forwarder:               forwarderCancel(ctrl, "abc", fmt.Errorf("queue error")),
+			request:                 httpRequest(t, "DELETE", "/tasks/abc"),
+			expectedResponseStatus:  500,
+			expectedResponsePayload: "queue error",
+		},
+		{
+			name:                    "Cancel task: unknown",
+			warehouse:               warehouseGet(ctrl, "abc", nil, nil),
+			request:                 httpRequest(t, "DELETE", "/tasks/abc"),
+			expectedResponseStatus:  404,
+			expectedResponsePayload: "Task abc not found",
+		},
+		{
+			name:                    "Cancel task: of another client",
+			warehouse:               warehouseGet(ctrl, "abc", &warehouse.TaskStatus{TaskUID: "abc", URL: "https://home.nl/doit", Owner: "webshop"}, nil),
+			request:                 httpRequest(t, "DELETE", "/tasks/abc"),
+			expectedResponseStatus:  403,
+			expectedResponsePayload: "Client anonymous may not access task abc",
+		},
+		{
+			name:                    "List attempts",
+			warehouse:               warehouseListAttempts(ctrl, "abc", []warehouse.Attempt{{TaskUID: "abc", Attempt: 1, State: warehouse.TaskStateRetrying, Method: "POST", URL: "https://home.nl/doit", ResponseStatus: 503, ResponseBody: "unavailable", LatencyMillis: 12, Timestamp: testTimestamp}}, nil),
+			request:                 httpRequest(t, "GET", "/tasks/abc/attempts"),
+			expectedResponseStatus:  200,
+			expectedResponsePayload: `[{"TaskUID":"abc","Attempt":1,"State":"retrying","Method":"POST","URL":"https://home.nl/doit","ResponseStatus":503,"ResponseBody":"unavailable","LatencyMillis":12,"Timestamp":"2021-11-11T10:00:00Z"}]` + "\n",
+		},
+		{
+			name:                    "List attempts: error",
+			warehouse:               warehouseListAttempts(ctrl, "abc", nil, fmt.Errorf("store error")),
+			request:                 httpRequest(t, "GET", "/tasks/abc/attempts"),
+			expectedResponseStatus:  500,
+			expectedResponsePayload: "Error fetching attempts of task abc: store error",
+		},
+		{
+			name:                    "List attempts: of another client",
+			warehouse:               warehouseGet(ctrl, "abc", &warehouse.TaskStatus{TaskUID: "abc", URL: "https://home.nl/doit", Owner: "webshop"}, nil),
+			request:                 httpRequest(t, "GET", "/tasks/abc/attempts"),
+			expectedResponseStatus:  403,
+			expectedResponsePayload: "Client anonymous may not access task abc",
+		},
+		{
+			name:                    "Query tasks",
+			warehouse:               warehouseQuery(ctrl, warehouse.TaskStateFailed, []warehouse.TaskStatus{{TaskUID: "abc", State: warehouse.TaskStateFailed, URL: "https://home.nl/doit", Owner: "anonymous", CreatedAt: testTimestamp, UpdatedAt: testTimestamp}, {TaskUID: "def", State: warehouse.TaskStateFailed, URL: "https://home.nl/doit", Owner: "webshop"}}),
+			request:                 httpRequest(t, "GET", "/tasks?state=failed"),
+			expectedResponseStatus:  200,
+			expectedResponsePayload: `[{"TaskUID":"abc","State":"failed","Method":"","URL":"https://home.nl/doit","Owner":"anonymous","Attempts":0,"MaxAttempts":0,"CreatedAt":"2021-11-11T10:00:00Z","UpdatedAt":"2021-11-11T10:00:00Z"}]` + "\n",
+		},
+		{
+			name:                    "Query tasks: invalid state",
+			warehouse:               nil,
+			request:                 httpRequest(t, "GET", "/tasks?state=unknown"),
+			expectedResponseStatus:  400,
+			expectedResponsePayload: "Invalid state 'unknown': expected one of pending, retrying, delivered, failed, cancelled, rejected",
+		},
+		{
+			name:                    "Get task: not authenticated",
+			authenticator:           authenticate(ctrl, nil, auth.ErrInvalidCredentials),
+			request:                 httpRequest(t, "GET", "/tasks/abc"),
+			expectedResponseStatus:  401,
+			expectedResponsePayload: "Invalid credentials",
+		},
+		{
+			name:                    "Cancel task: not authenticated",
+			authenticator:           authenticate(ctrl, nil, auth.ErrMissingCredentials),
+			request:                 httpRequest(t, "DELETE", "/tasks/abc"),
+			expectedResponseStatus:  401,
+			expectedResponsePayload: "Missing credentials",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// setup
+			if tc.authenticator == nil {
+				tc.authenticator = auth.Anonymous()
+			}
+			webservice := NewWebService(tc.warehouse, tc.forwarder, tc.authenticator)
+
+			// when
+			httpResp := httptest.NewRecorder()
+			webservice.RegisterEndpoint(mux.NewRouter()).ServeHTTP(httpResp, tc.request)
+
+			// then
+			assert.Equal(t, tc.expectedResponseStatus, httpResp.Code)
+			assert.Equal(t, tc.expectedResponsePayload, httpResp.Body.String())
+		})
+	}
+}
+
+func httpRequest(t *testing.T, method, url string) *http.Request {
+	httpReq, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatalf("Error creating http-request: %s", err)
+	}
+	httpReq.RequestURI = url
+	return httpReq
+}
+
+func warehouseGet(ctrlr *gomock.Controller, taskUID string, status *warehouse.TaskStatus, err error) warehouse.Warehouser {
+	warehouseMock := warehouse.NewMockWarehouser(ctrlr)
+
+	warehouseMock.
+		EXPECT().
+		Get(gomock.Any(), taskUID).
+		Return(status, status != nil, err)
+
+	return warehouseMock
+}
+
+func warehouseGetChildren(ctrlr *gomock.Controller, taskUID string, children []warehouse.TaskStatus) warehouse.Warehouser {
+	warehouseMock := warehouse.NewMockWarehouser(ctrlr)
+
+	warehouseMock.
+		EXPECT().
+		Get(gomock.Any(), taskUID).
+		Return(nil, false, nil)
+
+	warehouseMock.
+		EXPECT().
+		ListChildren(gomock.Any(), taskUID).
+		Return(children, nil)
+
+	return warehouseMock
+}
+
+func warehouseQuery(ctrlr *gomock.Controller, state warehouse.TaskState, statuses []warehouse.TaskStatus) warehouse.Warehouser {
+	warehouseMock := warehouse.NewMockWarehouser(ctrlr)
+
+	warehouseMock.
+		EXPECT().
+		Query(gomock.Any(), state).
+		Return(statuses, nil)
+
+	return warehouseMock
+}
+
+func warehouseListAttempts(ctrlr *gomock.Controller, taskUID string, attempts []warehouse.Attempt, err error) warehouse.Warehouser {
+	warehouseMock := warehouse.NewMockWarehouser(ctrlr)
+
+	warehouseMock.
+		EXPECT().
+		Get(gomock.Any(), taskUID).
+		Return(ownedTask, true, nil)
+
+	warehouseMock.
+		EXPECT().
+		ListAttempts(gomock.Any(), taskUID).
+		Return(attempts, err)
+
+	return warehouseMock
+}
+
+func forwarderCancel(ctrlr *gomock.Controller, taskUID string, err error) forwarder.Forwarder {
+	forwarderMock := forwarder.NewMockForwarder(ctrlr)
+
+	forwarderMock.
+		EXPECT().
+		Cancel(gomock.Any(), taskUID).
+		Return(err)
+
+	return forwarderMock
+}
+
+func authenticate(ctrlr *gomock.Controller, client *auth.Client, err error) auth.Authenticator {
+	authenticatorMock := auth.NewMockAuthenticator(ctrlr)
+
+	authenticatorMock.
+		EXPECT().
+		Authenticate(gomock.Any()).
+		Return(client, err)
+
+	return authenticatorMock
+}
@@ -0,0 +1,44 @@
+package topic
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//go:generate mockgen -source=api.go -destination=gen_TopicRegistryMock.go -package=topic github.com/MarcGrol/forwardhttp/topic Registry
+
+// Subscription tells where the messages that are published on a topic are delivered
+type Subscription struct {
+	UID       string
+	Topic     string
+	URL       string            // absolute http(s)-url the messages are POSTed to
+	Filter    map[string]string // headers a message must carry; a value ending with '*' matches on prefix
+	Headers   map[string]string // added to each delivery
+	Secret    string            `json:",omitempty"` // signs each delivery; never returned by the api
+	Owner     string            // name of the client that subscribed: only it may see, change or remove the subscription
+	CreatedAt time.Time
+}
+
+// Matches tells whether a message with the given headers passes the filter of the subscription
+func (s Subscription) Matches(headers http.Header) bool {
+	for name, pattern := range s.Filter {
+		value := headers.Get(name)
+		if strings.HasSuffix(pattern, "*") {
+			if !strings.HasPrefix(value, strings.TrimSuffix(pattern, "*")) {
+				return false
+			}
+		} else if value != pattern {
+			return false
+		}
+	}
+	return true
+}
+
+type Registry interface {
+	List(c context.Context, topic string) ([]Subscription, error)
+	Get(c context.Context, uid string) (*Subscription, bool, error)
+	Put(c context.Context, subscription Subscription) error
+	Delete(c context.Context, uid string) error
+}
@@ -0,0 +1,138 @@
+package topic
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/envelope"
+	"github.com/MarcGrol/forwardhttp/store"
+)
+
+const subscriptionKind = "Subscription"
+
+type registry struct {
+	store  store.DataStorer
+	sealer envelope.Sealer
+}
+
+// NewRegistry keeps the subscriptions of all topics
+func NewRegistry(store store.DataStorer, sealer envelope.Sealer) *registry {
+	return &registry{
+		store:  store,
+		sealer: sealer,
+	}
+}
+
+// subscriptionRecord keeps filter and headers as json, because datastore cannot store maps
+type subscriptionRecord struct {
+	UID       string
+	Topic     string
+	URL       string `datastore:",noindex"`
+	Filter    []byte `datastore:",noindex"`
+	Headers   []byte `datastore:",noindex"`
+	Secret    string `datastore:",noindex"`
+	Owner     string
+	CreatedAt time.Time
+	Sealed    bool // the secret is encrypted
+}
+
+func (r *registry) List(c context.Context, topic string) ([]Subscription, error) {
+	records := []subscriptionRecord{}
+	err := r.store.Query(c, subscriptionKind, []store.Filter{{Field: "Topic", Operator: "=", Value: topic}}, &records)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying subscriptions of topic %s: %s", topic, err)
+	}
+	subscriptions := []Subscription{}
+	for _, record := range records {
+		subscription, err := r.toSubscription(c, record)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, subscription)
+	}
+	return subscriptions, nil
+}
+
+func (r *registry) Get(c context.Context, uid string) (*Subscription, bool, error) {
+	record := subscriptionRecord{}
+	found, err := r.store.Get(c, subscriptionKind, uid, &record)
+	if err != nil {
+		return nil, false, fmt.Errorf("Error fetching subscription %s: %s", uid, err)
+	}
+	if !found {
+		return nil, false, nil
+	}
+	subscription, err := r.toSubscription(c, record)
+	if err != nil {
+		return nil, false, err
+	}
+	return &subscription, true, nil
+}
+
+func (r *registry) Put(c context.Context, subscription Subscription) error {
+	record := subscriptionRecord{
+		UID:       subscription.UID,
+		Topic:     subscription.Topic,
+		URL:       subscription.URL,
+		Owner:     subscription.Owner,
+		CreatedAt: subscription.CreatedAt,
+		Sealed:    r.sealer.Encrypts(),
+	}
+	// the secret lets anyone sign deliveries for the subscriber; an envelope is json, so it can be kept as string
+	secret, err := r.sealer.Seal(c, []byte(subscription.Secret))
+	if err != nil {
+		return fmt.Errorf("Error sealing secret of subscription %s: %s", subscription.UID, err)
+	}
+	record.Secret = string(secret)
+	record.Filter, err = json.Marshal(subscription.Filter)
+	if err != nil {
+		return fmt.Errorf("Error marshalling filter of subscription %s: %s", subscription.UID, err)
+	}
+	record.Headers, err = json.Marshal(subscription.Headers)
+	if err != nil {
+		return fmt.Errorf("Error marshalling headers of subscription %s: %s", subscription.UID, err)
+	}
+	err = r.store.Put(c, subscriptionKind, subscription.UID, record)
+	if err != nil {
+		return fmt.Errorf("Error storing subscription %s: %s", subscription.UID, err)
+	}
+	return nil
+}
+
+func (r *registry) Delete(c context.Context, uid string) error {
+	err := r.store.Delete(c, subscriptionKind, uid)
+	if err != nil {
+		return fmt.Errorf("Error deleting subscription %s: %s", uid, err)
+	}
+	return nil
+}
+
+func (r *registry) toSubscription(c context.Context, record subscriptionRecord) (Subscription, error) {
+	subscription := Subscription{
+		UID:       record.UID,
+		Topic:     record.Topic,
+		URL:       record.URL,
+		Owner:     record.Owner,
+		CreatedAt: record.CreatedAt,
+	}
+	secret := []byte(record.Secret)
+	if record.Sealed {
+		var err error
+		secret, err = r.sealer.Open(c, secret)
+		if err != nil {
+			return subscription, fmt.Errorf("Error opening secret of subscription %s: %s", record.UID, err)
+		}
+	}
+	subscription.Secret = string(secret)
+	err := json.Unmarshal(record.Filter, &subscription.Filter)
+	if err != nil {
+		return subscription, fmt.Errorf("Error unmarshalling filter of subscription %s: %s", record.UID, err)
+	}
+	err = json.Unmarshal(record.Headers, &subscription.Headers)
+	if err != nil {
+		return subscription, fmt.Errorf("Error unmarshalling headers of subscription %s: %s", record.UID, err)
+	}
+	return subscription, nil
+}
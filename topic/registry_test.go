@@ -0,0 +1,40 @@
+package topic
+
+import (
+	"context"
+	"testing"
+
+	"github.com/MarcGrol/forwardhttp/envelope"
+	"github.com/MarcGrol/forwardhttp/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := store.NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+	r := NewRegistry(s, envelope.Plaintext())
+
+	subscription := Subscription{UID: "sub1", Topic: "orders", URL: "https://billing.example.com/events", Filter: map[string]string{"X-Event-Type": "order.*"}, Headers: map[string]string{"Authorization": "Bearer 123"}, Secret: "s3cr3t", Owner: "webshop", CreatedAt: testTimestamp}
+	assert.NoError(t, r.Put(c, subscription))
+	assert.NoError(t, r.Put(c, Subscription{UID: "sub2", Topic: "invoices", URL: "https://billing.example.com/invoices", CreatedAt: testTimestamp}))
+
+	found, exists, err := r.Get(c, "sub1")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, subscription, *found)
+
+	subscriptions, err := r.List(c, "orders")
+	assert.NoError(t, err)
+	assert.Equal(t, []Subscription{subscription}, subscriptions)
+
+	assert.NoError(t, r.Delete(c, "sub1"))
+	_, exists, err = r.Get(c, "sub1")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	subscriptions, err = r.List(c, "orders")
+	assert.NoError(t, err)
+	assert.Empty(t, subscriptions)
+}
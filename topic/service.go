@@ -0,0 +1,400 @@
+package topic
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/auth"
+	"github.com/MarcGrol/forwardhttp/destination"
+	"github.com/MarcGrol/forwardhttp/forwarder"
+	"github.com/MarcGrol/forwardhttp/httpclient"
+	"github.com/MarcGrol/forwardhttp/queue"
+	"github.com/MarcGrol/forwardhttp/uniqueid"
+	"github.com/gorilla/mux"
+)
+
+const topicsPath = "/topics"
+
+type webService struct {
+	registry      Registry
+	forwarder     forwarder.Forwarder
+	uidGenerator  uniqueid.Generator
+	authenticator auth.Authenticator
+	destinations  destination.Checker
+	nowFunc       func() time.Time
+}
+
+func NewWebService(registry Registry, forwarder forwarder.Forwarder, uidGenerator uniqueid.Generator, authenticator auth.Authenticator, destinations destination.Checker) *webService {
+	s := &webService{
+		registry:      registry,
+		forwarder:     forwarder,
+		uidGenerator:  uidGenerator,
+		authenticator: authenticator,
+		destinations:  destinations,
+		nowFunc:       time.Now,
+	}
+	return s
+}
+
+func (s *webService) RegisterEndpoint(router *mux.Router) *mux.Router {
+	subRouter := router.PathPrefix(topicsPath).Subrouter()
+	subRouter.HandleFunc("/{topic}", auth.Required(s.authenticator, s.publish())).Methods("POST")
+	subRouter.HandleFunc("/{topic}/subscriptions", auth.Required(s.authenticator, s.list())).Methods("GET")
+	subRouter.HandleFunc("/{topic}/subscriptions", auth.Required(s.authenticator, s.create())).Methods("POST")
+	subRouter.HandleFunc("/{topic}/subscriptions/{uid}", auth.Required(s.authenticator, s.get())).Methods("GET")
+	subRouter.HandleFunc("/{topic}/subscriptions/{uid}", auth.Required(s.authenticator, s.update())).Methods("PUT")
+	subRouter.HandleFunc("/{topic}/subscriptions/{uid}", auth.Required(s.authenticator, s.delete())).Methods("DELETE")
+	return router
+}
+
+// publishResult tells the publisher which delivery-task was created per subscription
+type publishResult struct {
+	TaskUID    string
+	Deliveries []deliveryResult
+}
+
+type deliveryResult struct {
+	TaskUID         string
+	SubscriptionUID string
+	URL             string
+	Error           string `json:",omitempty"` // why the delivery was not enqueued
+}
+
+// subscriptionRequest holds the fields of a subscription that can be chosen by the client
+type subscriptionRequest struct {
+	URL     string
+	Filter  map[string]string
+	Headers map[string]string
+	Secret  string
+}
+
+func (s *webService) publish() auth.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, client auth.Client) {
+		c := r.Context()
+		topicName := mux.Vars(r)["topic"]
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			reportError(w, http.StatusBadRequest, fmt.Errorf("Error reading message: %s", err))
+			return
+		}
+
+		subscriptions, err := s.registry.List(c, topicName)
+		if err != nil {
+			reportError(w, http.StatusInternalServerError, fmt.Errorf("Error listing subscriptions of topic %s: %s", topicName, err))
+			return
+		}
+
+		taskUID := extractTaskUID(r)
+		if taskUID == "" {
+			taskUID = s.uidGenerator.Generate()
+		}
+		w.Header().Set("X-TaskUid", taskUID)
+
+		result := publishResult{TaskUID: taskUID, Deliveries: []deliveryResult{}}
+		for _, subscription := range subscriptions {
+			if !subscription.Matches(r.Header) {
+				continue
+			}
+			req := newDelivery(taskUID, subscription, r.Header, body)
+			req.Owner = client.Name
+			delivery := deliveryResult{TaskUID: req.TaskUID, SubscriptionUID: subscription.UID, URL: subscription.URL}
+			err := authorizePublication(client, subscription)
+			if err != nil {
+				// the publisher cannot reach a host via a topic that it may not forward to itself
+				delivery.Error = err.Error()
+				result.Deliveries = append(result.Deliveries, delivery)
+				continue
+			}
+			err = s.forwarder.ForwardAsync(c, req)
+			if errors.Is(err, destination.ErrDenied) {
+				// the other subscribers are still served
+				delivery.Error = err.Error()
+				result.Deliveries = append(result.Deliveries, delivery)
+				continue
+			}
+			if err != nil && !errors.Is(err, queue.ErrTaskAlreadyExists) {
+				reportError(w, http.StatusInternalServerError, fmt.Errorf("Error enqueuing delivery %s: %s", req.TaskUID, err))
+				return
+			}
+			result.Deliveries = append(result.Deliveries, delivery)
+		}
+
+		log.Printf("Published %s on topic %s to %d subscriptions", taskUID, topicName, len(result.Deliveries))
+		writeJSON(w, http.StatusAccepted, result)
+	}
+}
+
+// newDelivery derives the task-uid from the subscription, so that a repeated publication is not delivered twice
+func newDelivery(taskUID string, subscription Subscription, headers http.Header, body []byte) httpclient.Request {
+	deliveryHeaders := http.Header{}
+	for name, values := range headers {
+		if !isPassedOn(name) {
+			continue
+		}
+		deliveryHeaders[name] = values
+	}
+	for name, value := range subscription.Headers {
+		deliveryHeaders.Set(name, value)
+	}
+	deliveryHeaders.Set("X-Topic", subscription.Topic)
+	deliveryHeaders.Set("X-Subscription-Uid", subscription.UID)
+	if subscription.Secret != "" {
+		deliveryHeaders.Set("X-Hub-Signature-256", Sign(subscription.Secret, body))
+	}
+
+	return httpclient.Request{
+		TaskUID:       fmt.Sprintf("%s-%s", taskUID, subscription.UID),
+		ParentTaskUID: taskUID,
+		Method:        http.MethodPost,
+		URL:           subscription.URL,
+		Headers:       deliveryHeaders,
+		Body:          body,
+	}
+}
+
+// isPassedOn keeps the credentials of the publisher away from the subscribers
+func isPassedOn(headerName string) bool {
+	switch http.CanonicalHeaderKey(headerName) {
+	case "Authorization", "Cookie", "X-Taskuid", "Idempotency-Key":
+		return false
+	}
+	return true
+}
+
+// Sign returns the signature of a delivery as "sha256=<hex of the hmac-sha256 of the body>"
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *webService) list() auth.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, client auth.Client) {
+		c := r.Context()
+		topicName := mux.Vars(r)["topic"]
+
+		subscriptions, err := s.registry.List(c, topicName)
+		if err != nil {
+			reportError(w, http.StatusInternalServerError, fmt.Errorf("Error listing subscriptions of topic %s: %s", topicName, err))
+			return
+		}
+		owned := []Subscription{}
+		for _, subscription := range subscriptions {
+			if authorizeOwner(client, subscription) != nil {
+				continue
+			}
+			subscription.Secret = ""
+			owned = append(owned, subscription)
+		}
+
+		writeJSON(w, http.StatusOK, owned)
+	}
+}
+
+func (s *webService) create() auth.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, client auth.Client) {
+		c := r.Context()
+		topicName := mux.Vars(r)["topic"]
+
+		subscription, err := parseSubscription(r)
+		if err != nil {
+			reportError(w, http.StatusBadRequest, err)
+			return
+		}
+		err = authorize(client, subscription)
+		if err != nil {
+			reportError(w, http.StatusForbidden, err)
+			return
+		}
+		err = s.destinations.Check(c, subscription.URL)
+		if err != nil {
+			reportError(w, http.StatusBadRequest, fmt.Errorf("Invalid subscription: %s", err))
+			return
+		}
+		subscription.UID = s.uidGenerator.Generate()
+		subscription.Topic = topicName
+		subscription.Owner = client.Name
+		subscription.CreatedAt = s.nowFunc()
+
+		err = s.registry.Put(c, subscription)
+		if err != nil {
+			reportError(w, http.StatusInternalServerError, fmt.Errorf("Error creating subscription: %s", err))
+			return
+		}
+
+		log.Printf("Subscribed %s to topic %s as %s", subscription.URL, topicName, subscription.UID)
+		subscription.Secret = ""
+		writeJSON(w, http.StatusCreated, subscription)
+	}
+}
+
+func (s *webService) get() auth.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, client auth.Client) {
+		subscription, found := s.lookup(w, r, client)
+		if !found {
+			return
+		}
+
+		subscription.Secret = ""
+		writeJSON(w, http.StatusOK, subscription)
+	}
+}
+
+func (s *webService) update() auth.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, client auth.Client) {
+		c := r.Context()
+
+		existing, found := s.lookup(w, r, client)
+		if !found {
+			return
+		}
+		subscription, err := parseSubscription(r)
+		if err != nil {
+			reportError(w, http.StatusBadRequest, err)
+			return
+		}
+		err = authorize(client, subscription)
+		if err != nil {
+			reportError(w, http.StatusForbidden, err)
+			return
+		}
+		err = s.destinations.Check(c, subscription.URL)
+		if err != nil {
+			reportError(w, http.StatusBadRequest, fmt.Errorf("Invalid subscription: %s", err))
+			return
+		}
+		subscription.UID = existing.UID
+		subscription.Topic = existing.Topic
+		subscription.Owner = existing.Owner
+		subscription.CreatedAt = existing.CreatedAt
+
+		err = s.registry.Put(c, subscription)
+		if err != nil {
+			reportError(w, http.StatusInternalServerError, fmt.Errorf("Error updating subscription %s: %s", subscription.UID, err))
+			return
+		}
+
+		subscription.Secret = ""
+		writeJSON(w, http.StatusOK, subscription)
+	}
+}
+
+func (s *webService) delete() auth.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, client auth.Client) {
+		c := r.Context()
+
+		subscription, found := s.lookup(w, r, client)
+		if !found {
+			return
+		}
+
+		err := s.registry.Delete(c, subscription.UID)
+		if err != nil {
+			reportError(w, http.StatusInternalServerError, fmt.Errorf("Error deleting subscription %s: %s", subscription.UID, err))
+			return
+		}
+
+		log.Printf("Unsubscribed %s from topic %s", subscription.UID, subscription.Topic)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// lookup only finds subscriptions of the topic in the path, that the client may access
+func (s *webService) lookup(w http.ResponseWriter, r *http.Request, client auth.Client) (Subscription, bool) {
+	topicName := mux.Vars(r)["topic"]
+	uid := mux.Vars(r)["uid"]
+
+	subscription, found, err := s.registry.Get(r.Context(), uid)
+	if err != nil {
+		reportError(w, http.StatusInternalServerError, fmt.Errorf("Error fetching subscription %s: %s", uid, err))
+		return Subscription{}, false
+	}
+	if !found || subscription.Topic != topicName {
+		reportError(w, http.StatusNotFound, fmt.Errorf("Subscription %s of topic %s not found", uid, topicName))
+		return Subscription{}, false
+	}
+	err = authorizeOwner(client, *subscription)
+	if err != nil {
+		reportError(w, http.StatusForbidden, err)
+		return Subscription{}, false
+	}
+	return *subscription, true
+}
+
+// authorize keeps a client from having messages delivered to hosts it may not forward to itself
+func authorize(client auth.Client, subscription Subscription) error {
+	u, err := url.Parse(subscription.URL)
+	if err != nil || !client.MayUseHost(u.Hostname()) {
+		return fmt.Errorf("Client %s may not subscribe '%s'", client.Name, subscription.URL)
+	}
+	return nil
+}
+
+// authorizeOwner only gives a client access to its own subscriptions, as long as it may still use their host
+func authorizeOwner(client auth.Client, subscription Subscription) error {
+	if subscription.Owner != client.Name {
+		return fmt.Errorf("Client %s may not access subscription %s", client.Name, subscription.UID)
+	}
+	return authorize(client, subscription)
+}
+
+// authorizePublication keeps a client from reaching the hosts of subscribers it may not forward to itself
+func authorizePublication(client auth.Client, subscription Subscription) error {
+	u, err := url.Parse(subscription.URL)
+	if err != nil || !client.MayUseHost(u.Hostname()) {
+		return fmt.Errorf("Client %s may not publish to '%s'", client.Name, subscription.URL)
+	}
+	return nil
+}
+
+func parseSubscription(r *http.Request) (Subscription, error) {
+	req := subscriptionRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return Subscription{}, fmt.Errorf("Error parsing subscription: %s", err)
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Subscription{}, fmt.Errorf("Invalid subscription: url '%s' must be an absolute http(s)-url", req.URL)
+	}
+	return Subscription{
+		URL:     req.URL,
+		Filter:  req.Filter,
+		Headers: req.Headers,
+		Secret:  req.Secret,
+	}, nil
+}
+
+func extractTaskUID(r *http.Request) string {
+	value := r.Header.Get("X-TaskUid")
+	if value == "" {
+		value = r.URL.Query().Get("TaskUid")
+	}
+	return value
+}
+
+func writeJSON(w http.ResponseWriter, httpResponseStatus int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpResponseStatus)
+	err := json.NewEncoder(w).Encode(value)
+	if err != nil {
+		log.Printf("Error encoding response: %s", err)
+	}
+}
+
+func reportError(w http.ResponseWriter, httpResponseStatus int, err error) {
+	log.Print(err.Error())
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(httpResponseStatus)
+	fmt.Fprint(w, err.Error())
+}
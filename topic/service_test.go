@@ -0,0 +1,395 @@
+package topic
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/auth"
+	"github.com/MarcGrol/forwardhttp/destination"
+	"github.com/MarcGrol/forwardhttp/forwarder"
+	"github.com/MarcGrol/forwardhttp/httpclient"
+	"github.com/MarcGrol/forwardhttp/uniqueid"
+	"github.com/golang/mock/gomock"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+var testTimestamp = time.Date(2021, time.November, 11, 10, 0, 0, 0, time.UTC)
+
+func TestTopics(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	created := Subscription{UID: "sub1", Topic: "orders", URL: "https://billing.example.com/events", Filter: map[string]string{"X-Event-Type": "order.*"}, Secret: "s3cr3t", Owner: "anonymous", CreatedAt: testTimestamp}
+	other := Subscription{UID: "sub2", Topic: "orders", URL: "https://audit.example.com/events", Owner: "anonymous", CreatedAt: testTimestamp}
+	ofWebshop := Subscription{UID: "sub3", Topic: "orders", URL: "https://webshop.example.com/events", Owner: "webshop", CreatedAt: testTimestamp}
+
+	testCases := []struct {
+		name                    string
+		registry                Registry
+		forwarder               forwarder.Forwarder
+		uidGenerator            uniqueid.Generator
+		authenticator           auth.Authenticator
+		destinations            destination.Checker
+		request                 *http.Request
+		expectedResponseStatus  int
+		expectedResponsePayload string
+	}{
+		{
+			name:                    "Publish",
+			registry:                registryList(ctrl, "orders", []Subscription{created, other}),
+			forwarder:               forwarderExpecting(ctrl, []string{"abc-sub1", "abc-sub2"}, nil),
+			uidGenerator:            generateUID(ctrl, "abc"),
+			request:                 httpRequest(t, "POST", "/topics/orders", `{"order":1}`, map[string]string{"X-Event-Type": "order.created"}),
+			expectedResponseStatus:  202,
+			expectedResponsePayload: `{"TaskUID":"abc","Deliveries":[{"TaskUID":"abc-sub1","SubscriptionUID":"sub1","URL":"https://billing.example.com/events"},{"TaskUID":"abc-sub2","SubscriptionUID":"sub2","URL":"https://audit.example.com/events"}]}` + "\n",
+		},
+		{
+			name:                    "Publish: filtered",
+			registry:                registryList(ctrl, "orders", []Subscription{created, other}),
+			forwarder:               forwarderExpecting(ctrl, []string{"xyz-sub2"}, nil),
+			request:                 httpRequest(t, "POST", "/topics/orders?TaskUid=xyz", `{"invoice":1}`, map[string]string{"X-Event-Type": "invoice.created"}),
+			expectedResponseStatus:  202,
+			expectedResponsePayload: `{"TaskUID":"xyz","Deliveries":[{"TaskUID":"xyz-sub2","SubscriptionUID":"sub2","URL":"https://audit.example.com/events"}]}` + "\n",
+		},
+		{
+			name:                    "Publish: no subscriptions",
+			registry:                registryList(ctrl, "orders", []Subscription{}),
+			uidGenerator:            generateUID(ctrl, "abc"),
+			request:                 httpRequest(t, "POST", "/topics/orders", `{"order":1}`, nil),
+			expectedResponseStatus:  202,
+			expectedResponsePayload: `{"TaskUID":"abc","Deliveries":[]}` + "\n",
+		},
+		{
+			name:                    "Publish: enqueue error",
+			registry:                registryList(ctrl, "orders", []Subscription{other}),
+			forwarder:               forwarderExpecting(ctrl, []string{"abc-sub2"}, fmt.Errorf("queue error")),
+			uidGenerator:            generateUID(ctrl, "abc"),
+			request:                 httpRequest(t, "POST", "/topics/orders", `{"order":1}`, nil),
+			expectedResponseStatus:  500,
+			expectedResponsePayload: "Error enqueuing delivery abc-sub2: queue error",
+		},
+		{
+			name:                    "Publish: denied destination does not stop the others",
+			registry:                registryList(ctrl, "orders", []Subscription{created, other}),
+			forwarder:               forwarderDenying(ctrl, "abc-sub1", "abc-sub2"),
+			uidGenerator:            generateUID(ctrl, "abc"),
+			request:                 httpRequest(t, "POST", "/topics/orders", `{"order":1}`, map[string]string{"X-Event-Type": "order.created"}),
+			expectedResponseStatus:  202,
+			expectedResponsePayload: `{"TaskUID":"abc","Deliveries":[{"TaskUID":"abc-sub1","SubscriptionUID":"sub1","URL":"https://billing.example.com/events","Error":"Destination not allowed: https://billing.example.com/events is not an allowed host"},{"TaskUID":"abc-sub2","SubscriptionUID":"sub2","URL":"https://audit.example.com/events"}]}` + "\n",
+		},
+		{
+			name:                    "Publish: to host not allowed",
+			registry:                registryList(ctrl, "orders", []Subscription{other, ofWebshop}),
+			forwarder:               forwarderExpecting(ctrl, []string{"abc-sub3"}, nil),
+			uidGenerator:            generateUID(ctrl, "abc"),
+			authenticator:           authenticate(ctrl, &auth.Client{Name: "webshop", Hosts: []string{"webshop.example.com"}}, nil),
+			request:                 httpRequest(t, "POST", "/topics/orders", `{"order":1}`, nil),
+			expectedResponseStatus:  202,
+			expectedResponsePayload: `{"TaskUID":"abc","Deliveries":[{"TaskUID":"abc-sub2","SubscriptionUID":"sub2","URL":"https://audit.example.com/events","Error":"Client webshop may not publish to 'https://audit.example.com/events'"},{"TaskUID":"abc-sub3","SubscriptionUID":"sub3","URL":"https://webshop.example.com/events"}]}` + "\n",
+		},
+		{
+			name:                    "Publish: not authenticated",
+			authenticator:           authenticate(ctrl, nil, auth.ErrMissingCredentials),
+			request:                 httpRequest(t, "POST", "/topics/orders", `{"order":1}`, nil),
+			expectedResponseStatus:  401,
+			expectedResponsePayload: "Missing credentials",
+		},
+		{
+			name:                    "List: secrets and subscriptions of other clients are not returned",
+			registry:                registryList(ctrl, "orders", []Subscription{created, ofWebshop}),
+			request:                 httpRequest(t, "GET", "/topics/orders/subscriptions", "", nil),
+			expectedResponseStatus:  200,
+			expectedResponsePayload: `[{"UID":"sub1","Topic":"orders","URL":"https://billing.example.com/events","Filter":{"X-Event-Type":"order.*"},"Headers":null,"Owner":"anonymous","CreatedAt":"2021-11-11T10:00:00Z"}]` + "\n",
+		},
+		{
+			name:                    "Create",
+			registry:                registryPut(ctrl, registryMock(ctrl), created),
+			uidGenerator:            generateUID(ctrl, "sub1"),
+			request:                 httpRequest(t, "POST", "/topics/orders/subscriptions", `{"URL":"https://billing.example.com/events","Filter":{"X-Event-Type":"order.*"},"Secret":"s3cr3t"}`, nil),
+			expectedResponseStatus:  201,
+			expectedResponsePayload: `{"UID":"sub1","Topic":"orders","URL":"https://billing.example.com/events","Filter":{"X-Event-Type":"order.*"},"Headers":null,"Owner":"anonymous","CreatedAt":"2021-11-11T10:00:00Z"}` + "\n",
+		},
+		{
+			name:                    "Create: invalid url",
+			request:                 httpRequest(t, "POST", "/topics/orders/subscriptions", `{"URL":"billing.example.com"}`, nil),
+			expectedResponseStatus:  400,
+			expectedResponsePayload: "Invalid subscription: url 'billing.example.com' must be an absolute http(s)-url",
+		},
+		{
+			name:                    "Create: host not allowed",
+			authenticator:           authenticate(ctrl, &auth.Client{Name: "webshop", Hosts: []string{"*.example.com"}}, nil),
+			request:                 httpRequest(t, "POST", "/topics/orders/subscriptions", `{"URL":"http://169.254.169.254/latest/meta-data"}`, nil),
+			expectedResponseStatus:  403,
+			expectedResponsePayload: "Client webshop may not subscribe 'http://169.254.169.254/latest/meta-data'",
+		},
+		{
+			name:                    "Create: denied destination",
+			destinations:            &destination.Policy{},
+			request:                 httpRequest(t, "POST", "/topics/orders/subscriptions", `{"URL":"http://169.254.169.254/latest/meta-data"}`, nil),
+			expectedResponseStatus:  400,
+			expectedResponsePayload: "Invalid subscription: Destination not allowed: http://169.254.169.254/latest/meta-data is non-public address 169.254.169.254",
+		},
+		{
+			name:                    "Get: of other topic",
+			registry:                registryGet(ctrl, registryMock(ctrl), "sub1", &created),
+			request:                 httpRequest(t, "GET", "/topics/invoices/subscriptions/sub1", "", nil),
+			expectedResponseStatus:  404,
+			expectedResponsePayload: "Subscription sub1 of topic invoices not found",
+		},
+		{
+			name:                    "Get: of another client",
+			registry:                registryGet(ctrl, registryMock(ctrl), "sub3", &ofWebshop),
+			request:                 httpRequest(t, "GET", "/topics/orders/subscriptions/sub3", "", nil),
+			expectedResponseStatus:  403,
+			expectedResponsePayload: "Client anonymous may not access subscription sub3",
+		},
+		{
+			name:                    "Update",
+			registry:                registryPut(ctrl, registryGet(ctrl, registryMock(ctrl), "sub2", &other), Subscription{UID: "sub2", Topic: "orders", URL: "https://audit.example.com/v2/events", Headers: map[string]string{"Authorization": "Bearer 123"}, Owner: "anonymous", CreatedAt: testTimestamp}),
+			request:                 httpRequest(t, "PUT", "/topics/orders/subscriptions/sub2", `{"URL":"https://audit.example.com/v2/events","Headers":{"Authorization":"Bearer 123"}}`, nil),
+			expectedResponseStatus:  200,
+			expectedResponsePayload: `{"UID":"sub2","Topic":"orders","URL":"https://audit.example.com/v2/events","Filter":null,"Headers":{"Authorization":"Bearer 123"},"Owner":"anonymous","CreatedAt":"2021-11-11T10:00:00Z"}` + "\n",
+		},
+		{
+			name:                    "Update: of another client",
+			registry:                registryGet(ctrl, registryMock(ctrl), "sub2", &other),
+			authenticator:           authenticate(ctrl, &auth.Client{Name: "webshop", Hosts: []string{"*.example.com"}}, nil),
+			request:                 httpRequest(t, "PUT", "/topics/orders/subscriptions/sub2", `{"URL":"https://webshop.example.com/events"}`, nil),
+			expectedResponseStatus:  403,
+			expectedResponsePayload: "Client webshop may not access subscription sub2",
+		},
+		{
+			name:                    "Update: host not allowed",
+			registry:                registryGet(ctrl, registryMock(ctrl), "sub3", &ofWebshop),
+			authenticator:           authenticate(ctrl, &auth.Client{Name: "webshop", Hosts: []string{"*.example.com"}}, nil),
+			request:                 httpRequest(t, "PUT", "/topics/orders/subscriptions/sub3", `{"URL":"https://home.nl/events"}`, nil),
+			expectedResponseStatus:  403,
+			expectedResponsePayload: "Client webshop may not subscribe 'https://home.nl/events'",
+		},
+		{
+			name:                    "Update: denied destination",
+			registry:                registryGet(ctrl, registryMock(ctrl), "sub2", &other),
+			destinations:            &destination.Policy{DenyHosts: []string{"*.example.com"}},
+			request:                 httpRequest(t, "PUT", "/topics/orders/subscriptions/sub2", `{"URL":"https://audit.example.com/v2/events"}`, nil),
+			expectedResponseStatus:  400,
+			expectedResponsePayload: "Invalid subscription: Destination not allowed: https://audit.example.com/v2/events matches denied host '*.example.com'",
+		},
+		{
+			name:                    "Delete",
+			registry:                registryDelete(ctrl, registryGet(ctrl, registryMock(ctrl), "sub2", &other), "sub2"),
+			request:                 httpRequest(t, "DELETE", "/topics/orders/subscriptions/sub2", "", nil),
+			expectedResponseStatus:  204,
+			expectedResponsePayload: "",
+		},
+		{
+			name:                    "Delete: of another client",
+			registry:                registryGet(ctrl, registryMock(ctrl), "sub3", &ofWebshop),
+			request:                 httpRequest(t, "DELETE", "/topics/orders/subscriptions/sub3", "", nil),
+			expectedResponseStatus:  403,
+			expectedResponsePayload: "Client anonymous may not access subscription sub3",
+		},
+		{
+			name:                    "Delete: not found",
+			registry:                registryGet(ctrl, registryMock(ctrl), "sub4", nil),
+			request:                 httpRequest(t, "DELETE", "/topics/orders/subscriptions/sub4", "", nil),
+			expectedResponseStatus:  404,
+			expectedResponsePayload: "Subscription sub4 of topic orders not found",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// setup
+			if tc.authenticator == nil {
+				tc.authenticator = auth.Anonymous()
+			}
+			if tc.destinations == nil {
+				tc.destinations = &destination.Policy{AllowPrivate: true}
+			}
+			webservice := NewWebService(tc.registry, tc.forwarder, tc.uidGenerator, tc.authenticator, tc.destinations)
+			webservice.nowFunc = func() time.Time { return testTimestamp }
+
+			// when
+			httpResp := httptest.NewRecorder()
+			webservice.RegisterEndpoint(mux.NewRouter()).ServeHTTP(httpResp, tc.request)
+
+			// then
+			assert.Equal(t, tc.expectedResponseStatus, httpResp.Code)
+			assert.Equal(t, tc.expectedResponsePayload, httpResp.Body.String())
+		})
+	}
+}
+
+func TestDelivery(t *testing.T) {
+	subscription := Subscription{UID: "sub1", Topic: "orders", URL: "https://billing.example.com/events", Headers: map[string]string{"Authorization": "Bearer 123"}, Secret: "s3cr3t"}
+	headers := http.Header{"Content-Type": []string{"application/json"}, "Authorization": []string{"Bearer of-publisher"}, "X-Event-Type": []string{"order.created"}}
+
+	req := newDelivery("abc", subscription, headers, []byte(`{"order":1}`))
+
+	assert.Equal(t, "abc-sub1", req.TaskUID)
+	assert.Equal(t, "abc", req.ParentTaskUID)
+	assert.Equal(t, "POST", req.Method)
+	assert.Equal(t, "https://billing.example.com/events", req.URL)
+	assert.Equal(t, "application/json", req.Headers.Get("Content-Type"))
+	assert.Equal(t, "order.created", req.Headers.Get("X-Event-Type"))
+	assert.Equal(t, "Bearer 123", req.Headers.Get("Authorization"))
+	assert.Equal(t, "orders", req.Headers.Get("X-Topic"))
+	assert.Equal(t, "sub1", req.Headers.Get("X-Subscription-Uid"))
+	assert.Equal(t, "sha256=446bbed863cae2ba8dccd26ba586e688a02bd11e267feaac1b1b1b1815b0f08b", req.Headers.Get("X-Hub-Signature-256"))
+
+	req = newDelivery("abc", Subscription{UID: "sub2", Topic: "orders"}, headers, []byte(`{"order":1}`))
+	assert.Equal(t, "", req.Headers.Get("Authorization"))
+	assert.Equal(t, "", req.Headers.Get("X-Hub-Signature-256"))
+}
+
+func TestMatches(t *testing.T) {
+	headers := http.Header{"X-Event-Type": []string{"order.created"}, "X-Region": []string{"eu"}}
+
+	testCases := []struct {
+		name    string
+		filter  map[string]string
+		matches bool
+	}{
+		{name: "No filter", filter: nil, matches: true},
+		{name: "Exact", filter: map[string]string{"X-Event-Type": "order.created"}, matches: true},
+		{name: "Exact: other value", filter: map[string]string{"X-Event-Type": "order.updated"}, matches: false},
+		{name: "Prefix", filter: map[string]string{"x-event-type": "order.*"}, matches: true},
+		{name: "Prefix: other value", filter: map[string]string{"X-Event-Type": "invoice.*"}, matches: false},
+		{name: "All must match", filter: map[string]string{"X-Event-Type": "order.*", "X-Region": "us"}, matches: false},
+		{name: "Missing header", filter: map[string]string{"X-Tenant": "acme"}, matches: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.matches, Subscription{Filter: tc.filter}.Matches(headers))
+		})
+	}
+}
+
+func httpRequest(t *testing.T, method, url, body string, headers map[string]string) *http.Request {
+	httpReq, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatalf("Error creating http-request: %s", err)
+	}
+	httpReq.RequestURI = url
+	for k, v := range headers {
+		httpReq.Header.Set(k, v)
+	}
+	return httpReq
+}
+
+func registryMock(ctrlr *gomock.Controller) *MockRegistry {
+	return NewMockRegistry(ctrlr)
+}
+
+func registryList(ctrlr *gomock.Controller, topic string, subscriptions []Subscription) *MockRegistry {
+	registryMock := NewMockRegistry(ctrlr)
+
+	registryMock.
+		EXPECT().
+		List(gomock.Any(), topic).
+		Return(subscriptions, nil)
+
+	return registryMock
+}
+
+func registryGet(ctrlr *gomock.Controller, registryMock *MockRegistry, uid string, subscription *Subscription) *MockRegistry {
+	registryMock.
+		EXPECT().
+		Get(gomock.Any(), uid).
+		Return(subscription, subscription != nil, nil)
+
+	return registryMock
+}
+
+func registryPut(ctrlr *gomock.Controller, registryMock *MockRegistry, subscription Subscription) *MockRegistry {
+	registryMock.
+		EXPECT().
+		Put(gomock.Any(), subscription).
+		Return(nil)
+
+	return registryMock
+}
+
+func registryDelete(ctrlr *gomock.Controller, registryMock *MockRegistry, uid string) *MockRegistry {
+	registryMock.
+		EXPECT().
+		Delete(gomock.Any(), uid).
+		Return(nil)
+
+	return registryMock
+}
+
+func forwarderExpecting(ctrlr *gomock.Controller, expectedTaskUIDs []string, err error) forwarder.Forwarder {
+	forwarderMock := forwarder.NewMockForwarder(ctrlr)
+
+	for _, expectedTaskUID := range expectedTaskUIDs {
+		expectedTaskUID := expectedTaskUID
+		forwarderMock.
+			EXPECT().
+			ForwardAsync(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req httpclient.Request) error {
+				if req.TaskUID != expectedTaskUID {
+					return fmt.Errorf("Unexpected task %s", req.TaskUID)
+				}
+				return err
+			})
+	}
+
+	return forwarderMock
+}
+
+// forwarderDenying refuses the first delivery, like a destination-policy that changed after subscribing
+func forwarderDenying(ctrlr *gomock.Controller, deniedTaskUID, expectedTaskUID string) forwarder.Forwarder {
+	forwarderMock := forwarder.NewMockForwarder(ctrlr)
+
+	gomock.InOrder(
+		forwarderMock.
+			EXPECT().
+			ForwardAsync(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req httpclient.Request) error {
+				if req.TaskUID != deniedTaskUID {
+					return fmt.Errorf("Unexpected task %s", req.TaskUID)
+				}
+				return &destination.DeniedError{Destination: req.URL, Reason: "is not an allowed host"}
+			}),
+		forwarderMock.
+			EXPECT().
+			ForwardAsync(gomock.Any(), gomock.Any()).
+			DoAndReturn(func(_ context.Context, req httpclient.Request) error {
+				if req.TaskUID != expectedTaskUID {
+					return fmt.Errorf("Unexpected task %s", req.TaskUID)
+				}
+				return nil
+			}),
+	)
+
+	return forwarderMock
+}
+
+func authenticate(ctrlr *gomock.Controller, client *auth.Client, err error) auth.Authenticator {
+	authenticatorMock := auth.NewMockAuthenticator(ctrlr)
+
+	authenticatorMock.
+		EXPECT().
+		Authenticate(gomock.Any()).
+		Return(client, err)
+
+	return authenticatorMock
+}
+
+func generateUID(ctrlr *gomock.Controller, uid string) uniqueid.Generator {
+	generator := uniqueid.NewMockGenerator(ctrlr)
+
+	generator.
+		EXPECT().
+		Generate().
+		Return(uid)
+
+	return generator
+}
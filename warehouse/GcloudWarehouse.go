@@ -2,21 +2,37 @@ package warehouse
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/MarcGrol/forwardhttp/envelope"
 	"github.com/MarcGrol/forwardhttp/httpclient"
 	"github.com/MarcGrol/forwardhttp/store"
 )
 
+const (
+	forwardSummaryKind = "ForwardSummary"
+	forwardAttemptKind = "ForwardAttempt"
+	orderingKeyKind    = "OrderingKey"
+	maxBodyExcerpt     = 1024
+	redacted           = "[redacted]"
+)
+
 type Warehouse struct {
-	store store.DataStorer
+	store  store.DataStorer
+	sealer envelope.Sealer // for the bodies, that may hold personal data
 }
 
-func New(store store.DataStorer) Warehouser {
+func New(store store.DataStorer, sealer envelope.Sealer) Warehouser {
 	return &Warehouse{
-		store: store,
+		store:  store,
+		sealer: sealer,
 	}
 }
 
@@ -27,27 +43,469 @@ type forwardStatsRecord struct {
 	ErrorMsg  string
 	Stats     Stats
 	Completed bool
+	State     TaskState
+	Attempts  int32
+	CreatedAt time.Time
+	Headers   []byte `datastore:",noindex"` // headers of request and response, sealed like the bodies
+	Sealed    bool   // bodies and headers are encrypted
+}
+
+// recordHeaders are kept apart from request and response, so that they can be sealed as a whole
+type recordHeaders struct {
+	Request  http.Header `json:",omitempty"`
+	Response http.Header `json:",omitempty"`
 }
 
+// Put updates the task-status in a transaction, so that a concurrent update cannot undo a cancellation
 func (w Warehouse) Put(c context.Context, summary ForwardSummary) error {
-	fs := &forwardStatsRecord{
-		Timestamp: time.Now(),
-		Request:   summary.HttpRequest,
-		Response:  summary.HttpResponse,
-		ErrorMsg: func() string {
+	now := time.Now()
+
+	attempt := int32(0)
+	fs := forwardStatsRecord{}
+	err := w.store.Update(c, forwardSummaryKind, summary.HttpRequest.TaskUID, &fs, func(found bool) error {
+		err := w.open(c, &fs)
+		if err != nil {
+			return err
+		}
+		attempt = fs.apply(summary, now)
+		fs, err = w.seal(c, fs)
+		return err
+	})
+	if err != nil {
+		log.Printf("Error storing task-status: %s", err)
+		return fmt.Errorf("Error storing task-status: %s", err)
+	}
+	if attempt == 0 {
+		return nil
+	}
+	return w.putAttempt(c, summary, attempt, now)
+}
+
+// apply merges the summary into the record, and returns the number of the attempt it describes (if any)
+func (fs *forwardStatsRecord) apply(summary ForwardSummary, now time.Time) int32 {
+	if fs.CreatedAt.IsZero() {
+		fs.CreatedAt = now
+	}
+
+	attempt := int32(0)
+	fs.Timestamp = now
+	switch summary.State {
+	case TaskStatePending:
+		// enqueued: keep the outcome of a synchronous attempt that preceded
+		fs.Request = summary.HttpRequest
+		if fs.State == "" {
+			fs.State = TaskStatePending
+		}
+	case TaskStateCancelled:
+		// the cancel-request only carries the task-uid
+		if fs.Request.TaskUID == "" {
+			fs.Request.TaskUID = summary.HttpRequest.TaskUID
+		}
+		fs.State = TaskStateCancelled
+	case TaskStateRejected:
+		// the request was never sent, so no attempt is recorded
+		fs.Request = summary.HttpRequest
+		if summary.Error != nil {
+			fs.ErrorMsg = summary.Error.Error()
+		}
+		fs.State = TaskStateRejected
+	default:
+		fs.Request = summary.HttpRequest
+		fs.Attempts++
+		attempt = fs.Attempts
+		fs.Response = summary.HttpResponse
+		fs.ErrorMsg = func() string {
 			if summary.Error != nil {
 				return summary.Error.Error()
 			}
 			return ""
-		}(),
-		Stats:     summary.Stats,
-		Completed: summary.Stats.IsLastAttempt(),
+		}()
+		fs.Stats = summary.Stats
+		if fs.State != TaskStateCancelled {
+			// an attempt that was in flight while cancelling must not revive the task
+			fs.State = summary.State
+		}
+	}
+	fs.Completed = fs.State.IsCompleted()
+	return attempt
+}
+
+// seal returns a copy of the record with encrypted bodies and headers
+func (w Warehouse) seal(c context.Context, fs forwardStatsRecord) (forwardStatsRecord, error) {
+	var err error
+	fs.Sealed = w.sealer.Encrypts()
+	headers := recordHeaders{Request: redactHeaders(fs.Request.Headers)}
+	fs.Request.Headers = nil
+	fs.Request.Body, err = w.sealer.Seal(c, fs.Request.Body)
+	if err != nil {
+		return fs, fmt.Errorf("Error sealing request of task-status: %s", err)
+	}
+	if fs.Response != nil {
+		// the response is shared with the caller
+		response := *fs.Response
+		headers.Response = redactHeaders(response.Headers)
+		response.Headers = nil
+		response.Body, err = w.sealer.Seal(c, response.Body)
+		if err != nil {
+			return fs, fmt.Errorf("Error sealing response of task-status: %s", err)
+		}
+		fs.Response = &response
+	}
+	fs.Headers, err = w.sealHeaders(c, headers)
+	if err != nil {
+		return fs, fmt.Errorf("Error sealing headers of task-status: %s", err)
+	}
+	return fs, nil
+}
+
+func (w Warehouse) open(c context.Context, fs *forwardStatsRecord) error {
+	if len(fs.Headers) > 0 {
+		headers := recordHeaders{}
+		err := w.openHeaders(c, fs.Headers, fs.Sealed, &headers)
+		if err != nil {
+			return fmt.Errorf("Error opening headers of task-status: %s", err)
+		}
+		fs.Request.Headers = headers.Request
+		if fs.Response != nil {
+			fs.Response.Headers = headers.Response
+		}
+		fs.Headers = nil
+	}
+	if !fs.Sealed {
+		// stored before encryption was enabled
+		return nil
+	}
+	var err error
+	fs.Request.Body, err = w.sealer.Open(c, fs.Request.Body)
+	if err != nil {
+		return fmt.Errorf("Error opening request of task-status: %s", err)
+	}
+	if fs.Response != nil {
+		fs.Response.Body, err = w.sealer.Open(c, fs.Response.Body)
+		if err != nil {
+			return fmt.Errorf("Error opening response of task-status: %s", err)
+		}
+	}
+	return nil
+}
+
+// sealHeaders keeps headers as sealed json, because they may carry personal data and datastore cannot store maps
+func (w Warehouse) sealHeaders(c context.Context, headers interface{}) ([]byte, error) {
+	data, err := json.Marshal(headers)
+	if err != nil {
+		return nil, fmt.Errorf("Error marshalling headers: %s", err)
+	}
+	return w.sealer.Seal(c, data)
+}
+
+func (w Warehouse) openHeaders(c context.Context, data []byte, sealed bool, headers interface{}) error {
+	var err error
+	if sealed {
+		data, err = w.sealer.Open(c, data)
+		if err != nil {
+			return err
+		}
+	}
+	err = json.Unmarshal(data, headers)
+	if err != nil {
+		return fmt.Errorf("Error unmarshalling headers: %s", err)
+	}
+	return nil
+}
+
+func (w Warehouse) Get(c context.Context, taskUID string) (*TaskStatus, bool, error) {
+	fs := forwardStatsRecord{}
+	found, err := w.store.Get(c, forwardSummaryKind, taskUID, &fs)
+	if err != nil {
+		return nil, false, fmt.Errorf("Error fetching task-status: %s", err)
+	}
+	if !found {
+		return nil, false, nil
+	}
+	status := fs.toStatus()
+	return &status, true, nil
+}
+
+func (w Warehouse) Query(c context.Context, state TaskState) ([]TaskStatus, error) {
+	records := []forwardStatsRecord{}
+	err := w.store.Query(c, forwardSummaryKind, []store.Filter{{Field: "State", Operator: "=", Value: string(state)}}, &records)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying task-statuses: %s", err)
+	}
+	statuses := []TaskStatus{}
+	for _, fs := range records {
+		statuses = append(statuses, fs.toStatus())
+	}
+	return statuses, nil
+}
+
+func (w Warehouse) ListChildren(c context.Context, parentTaskUID string) ([]TaskStatus, error) {
+	records := []forwardStatsRecord{}
+	err := w.store.Query(c, forwardSummaryKind, []store.Filter{{Field: "Request.ParentTaskUID", Operator: "=", Value: parentTaskUID}}, &records)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying children of task %s: %s", parentTaskUID, err)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Request.TaskUID < records[j].Request.TaskUID
+	})
+	statuses := []TaskStatus{}
+	for _, fs := range records {
+		statuses = append(statuses, fs.toStatus())
+	}
+	return statuses, nil
+}
+
+// forwardAttemptRecord is kept per attempt, so that retries do not overwrite the history of a task
+type forwardAttemptRecord struct {
+	TaskUID         string
+	Attempt         int32
+	State           TaskState
+	Method          string
+	URL             string
+	RequestHeaders  []string `datastore:",noindex"` // only for attempts that were stored before headers were sealed
+	RequestBody     string   `datastore:",noindex"`
+	ResponseStatus  int
+	ResponseHeaders []string `datastore:",noindex"` // only for attempts that were stored before headers were sealed
+	ResponseBody    string   `datastore:",noindex"`
+	Headers         string   `datastore:",noindex"` // headers of request and response, sealed like the bodies
+	ErrorMsg        string   `datastore:",noindex"`
+	LatencyMillis   int64
+	Timestamp       time.Time
+	Sealed          bool // bodies and headers are encrypted
+}
+
+func (w Warehouse) putAttempt(c context.Context, summary ForwardSummary, attempt int32, now time.Time) error {
+	req := summary.HttpRequest
+	ar := forwardAttemptRecord{
+		TaskUID:       req.TaskUID,
+		Attempt:       attempt,
+		State:         summary.State,
+		Method:        req.Method,
+		URL:           req.URL,
+		RequestBody:   excerpt(req.Body),
+		LatencyMillis: summary.Latency.Milliseconds(),
+		Timestamp:     now,
+	}
+	headers := recordHeaders{Request: redactHeaders(req.Headers)}
+	if summary.HttpResponse != nil {
+		ar.ResponseStatus = summary.HttpResponse.Status
+		headers.Response = redactHeaders(summary.HttpResponse.Headers)
+		ar.ResponseBody = excerpt(summary.HttpResponse.Body)
+	}
+	if summary.Error != nil {
+		ar.ErrorMsg = summary.Error.Error()
+	}
+	ar.Sealed = w.sealer.Encrypts()
+	sealedHeaders, err := w.sealHeaders(c, headers)
+	if err != nil {
+		return fmt.Errorf("Error sealing task-attempt: %s", err)
+	}
+	ar.Headers = string(sealedHeaders)
+	ar.RequestBody, err = w.sealExcerpt(c, ar.RequestBody)
+	if err != nil {
+		return err
+	}
+	ar.ResponseBody, err = w.sealExcerpt(c, ar.ResponseBody)
+	if err != nil {
+		return err
 	}
 
-	putErr := w.store.Put(c, "ForwardSummary", summary.HttpRequest.TaskUID, fs)
-	if putErr != nil {
-		log.Printf("Error storing task-status: %s", putErr)
-		return fmt.Errorf("Error storing task-status: %s", putErr)
+	// zero-padded so that the attempts of a task sort in order
+	uid := fmt.Sprintf("%s-%05d", req.TaskUID, attempt)
+	err = w.store.Put(c, forwardAttemptKind, uid, ar)
+	if err != nil {
+		log.Printf("Error storing task-attempt: %s", err)
+		return fmt.Errorf("Error storing task-attempt: %s", err)
+	}
+	return nil
+}
+
+func (w Warehouse) ListAttempts(c context.Context, taskUID string) ([]Attempt, error) {
+	records := []forwardAttemptRecord{}
+	err := w.store.Query(c, forwardAttemptKind, []store.Filter{{Field: "TaskUID", Operator: "=", Value: taskUID}}, &records)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying task-attempts: %s", err)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Attempt < records[j].Attempt
+	})
+	attempts := []Attempt{}
+	for _, ar := range records {
+		// attempts that were stored before headers were sealed keep them as lines
+		headers := recordHeaders{Request: unflattenHeaders(ar.RequestHeaders), Response: unflattenHeaders(ar.ResponseHeaders)}
+		if ar.Headers != "" {
+			err = w.openHeaders(c, []byte(ar.Headers), ar.Sealed, &headers)
+			if err != nil {
+				return nil, fmt.Errorf("Error opening task-attempt: %s", err)
+			}
+		}
+		if ar.Sealed {
+			ar.RequestBody, err = w.openExcerpt(c, ar.RequestBody)
+			if err != nil {
+				return nil, err
+			}
+			ar.ResponseBody, err = w.openExcerpt(c, ar.ResponseBody)
+			if err != nil {
+				return nil, err
+			}
+		}
+		attempts = append(attempts, ar.toAttempt(headers))
+	}
+	return attempts, nil
+}
+
+// sealExcerpt keeps sealed excerpts as strings, which is possible because an envelope is json
+func (w Warehouse) sealExcerpt(c context.Context, excerpt string) (string, error) {
+	sealed, err := w.sealer.Seal(c, []byte(excerpt))
+	if err != nil {
+		return "", fmt.Errorf("Error sealing task-attempt: %s", err)
+	}
+	return string(sealed), nil
+}
+
+func (w Warehouse) openExcerpt(c context.Context, excerpt string) (string, error) {
+	opened, err := w.sealer.Open(c, []byte(excerpt))
+	if err != nil {
+		return "", fmt.Errorf("Error opening task-attempt: %s", err)
+	}
+	return string(opened), nil
+}
+
+func (ar forwardAttemptRecord) toAttempt(headers recordHeaders) Attempt {
+	return Attempt{
+		TaskUID:         ar.TaskUID,
+		Attempt:         ar.Attempt,
+		State:           ar.State,
+		Method:          ar.Method,
+		URL:             ar.URL,
+		RequestHeaders:  headers.Request,
+		RequestBody:     ar.RequestBody,
+		ResponseStatus:  ar.ResponseStatus,
+		ResponseHeaders: headers.Response,
+		ResponseBody:    ar.ResponseBody,
+		Error:           ar.ErrorMsg,
+		LatencyMillis:   ar.LatencyMillis,
+		Timestamp:       ar.Timestamp,
+	}
+}
+
+// redactHeaders keeps the names of headers that carry credentials, but not their values
+func redactHeaders(headers http.Header) http.Header {
+	result := http.Header{}
+	for name, values := range headers {
+		if !isCredential(name) {
+			result[name] = values
+			continue
+		}
+		for range values {
+			result.Add(name, redacted)
+		}
+	}
+	return result
+}
+
+func isCredential(headerName string) bool {
+	name := strings.ToLower(headerName)
+	switch name {
+	case "authorization", "proxy-authorization", "cookie", "set-cookie":
+		return true
+	}
+	for _, part := range []string{"api-key", "apikey", "token", "secret", "signature", "password"} {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// unflattenHeaders reads the "Name: value" lines of attempts that were stored before headers were sealed
+func unflattenHeaders(lines []string) http.Header {
+	if len(lines) == 0 {
+		return nil
+	}
+	headers := http.Header{}
+	for _, line := range lines {
+		parts := strings.SplitN(line, ": ", 2)
+		if len(parts) == 2 {
+			headers.Add(parts[0], parts[1])
+		}
+	}
+	return headers
+}
+
+func excerpt(body []byte) string {
+	if len(body) > maxBodyExcerpt {
+		return string(body[:maxBodyExcerpt]) + "..."
+	}
+	return string(body)
+}
+
+func (fs forwardStatsRecord) toStatus() TaskStatus {
+	status := TaskStatus{
+		TaskUID:       fs.Request.TaskUID,
+		State:         fs.State,
+		Method:        fs.Request.Method,
+		URL:           fs.Request.URL,
+		Route:         fs.Request.Route,
+		OrderingKey:   fs.Request.OrderingKey,
+		ParentTaskUID: fs.Request.ParentTaskUID,
+		Owner:         fs.Request.Owner,
+		Attempts:      fs.Attempts,
+		MaxAttempts:   fs.Stats.MaxRetryCount,
+		LastError:     fs.ErrorMsg,
+		CreatedAt:     fs.CreatedAt,
+		UpdatedAt:     fs.Timestamp,
+	}
+	if fs.Response != nil {
+		status.LastResponseStatus = fs.Response.Status
+	}
+	return status
+}
+
+// orderingKeyRecord remembers the task that was enqueued last for an ordering-key
+type orderingKeyRecord struct {
+	LastTaskUID string
+	Timestamp   time.Time
+}
+
+// AppendToOrderingKey updates the ordering-key in a transaction, because replicas of the service share it
+func (w Warehouse) AppendToOrderingKey(c context.Context, orderingKey, taskUID string) (string, error) {
+	predecessor := ""
+	record := orderingKeyRecord{}
+	err := w.store.Update(c, orderingKeyKind, orderingKey, &record, func(found bool) error {
+		predecessor = record.LastTaskUID
+		if predecessor == taskUID {
+			// enqueued again: must not wait for itself
+			predecessor = ""
+		}
+		record = orderingKeyRecord{LastTaskUID: taskUID, Timestamp: time.Now()}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error storing ordering-key %s: %s", orderingKey, err)
+	}
+	return predecessor, nil
+}
+
+// errOrderingKeyMoved leaves the ordering-key as it is
+var errOrderingKeyMoved = errors.New("another task was appended to the ordering-key")
+
+// RemoveFromOrderingKey leaves the ordering-key alone when another task was appended in the meantime
+func (w Warehouse) RemoveFromOrderingKey(c context.Context, orderingKey, taskUID, predecessor string) error {
+	record := orderingKeyRecord{}
+	err := w.store.Update(c, orderingKeyKind, orderingKey, &record, func(found bool) error {
+		if !found || record.LastTaskUID != taskUID {
+			return errOrderingKeyMoved
+		}
+		// without predecessor, the next task of the key has nothing to wait for
+		record = orderingKeyRecord{LastTaskUID: predecessor, Timestamp: time.Now()}
+		return nil
+	})
+	if err == errOrderingKeyMoved {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error restoring ordering-key %s: %s", orderingKey, err)
 	}
 	return nil
 }
@@ -0,0 +1,483 @@
+package warehouse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MarcGrol/forwardhttp/envelope"
+	"github.com/MarcGrol/forwardhttp/httpclient"
+	"github.com/MarcGrol/forwardhttp/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTaskStatusLifecycle(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := store.NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+	w := New(s, envelope.Plaintext())
+
+	req := httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit"}
+
+	// synchronous attempt with temporary error, followed by enqueue
+	assert.NoError(t, w.Put(c, ForwardSummary{HttpRequest: req, HttpResponse: &httpclient.Response{Status: 503}, State: TaskStateRetrying}))
+	assert.NoError(t, w.Put(c, ForwardSummary{HttpRequest: req, State: TaskStatePending}))
+	status, found, err := w.Get(c, "abc")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, TaskStateRetrying, status.State)
+	assert.Equal(t, int32(1), status.Attempts)
+	assert.Equal(t, 503, status.LastResponseStatus)
+	createdAt := status.CreatedAt
+
+	// failing asynchronous attempt
+	assert.NoError(t, w.Put(c, ForwardSummary{HttpRequest: req, Error: fmt.Errorf("network error"), Stats: Stats{RetryCount: 1, MaxRetryCount: 10}, State: TaskStateRetrying}))
+	status, _, err = w.Get(c, "abc")
+	assert.NoError(t, err)
+	assert.Equal(t, TaskStateRetrying, status.State)
+	assert.Equal(t, int32(2), status.Attempts)
+	assert.Equal(t, int32(10), status.MaxAttempts)
+	assert.Equal(t, 0, status.LastResponseStatus)
+	assert.Equal(t, "network error", status.LastError)
+
+	// successful asynchronous attempt
+	assert.NoError(t, w.Put(c, ForwardSummary{HttpRequest: req, HttpResponse: &httpclient.Response{Status: 200}, Stats: Stats{RetryCount: 2, MaxRetryCount: 10}, State: TaskStateDelivered}))
+	status, _, err = w.Get(c, "abc")
+	assert.NoError(t, err)
+	assert.Equal(t, TaskStateDelivered, status.State)
+	assert.Equal(t, int32(3), status.Attempts)
+	assert.Equal(t, 200, status.LastResponseStatus)
+	assert.Equal(t, "", status.LastError)
+	assert.True(t, createdAt.Equal(status.CreatedAt))
+
+	delivered, err := w.Query(c, TaskStateDelivered)
+	assert.NoError(t, err)
+	assert.Len(t, delivered, 1)
+	pending, err := w.Query(c, TaskStatePending)
+	assert.NoError(t, err)
+	assert.Len(t, pending, 0)
+
+	_, found, err = w.Get(c, "def")
+	assert.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestTaskCancelled(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := store.NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+	w := New(s, envelope.Plaintext())
+
+	req := httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit"}
+	assert.NoError(t, w.Put(c, ForwardSummary{HttpRequest: req, State: TaskStatePending}))
+
+	// the cancel-request only knows the task-uid
+	assert.NoError(t, w.Put(c, ForwardSummary{HttpRequest: httpclient.Request{TaskUID: "abc"}, State: TaskStateCancelled}))
+	status, found, err := w.Get(c, "abc")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, TaskStateCancelled, status.State)
+	assert.Equal(t, "POST", status.Method)
+	assert.Equal(t, "https://home.nl/doit", status.URL)
+	assert.Equal(t, int32(0), status.Attempts)
+	assert.True(t, status.State.IsCompleted())
+
+	cancelled, err := w.Query(c, TaskStateCancelled)
+	assert.NoError(t, err)
+	assert.Len(t, cancelled, 1)
+
+	attempts, err := w.ListAttempts(c, "abc")
+	assert.NoError(t, err)
+	assert.Len(t, attempts, 0)
+
+	// an attempt that was in flight while cancelling is recorded, but does not revive the task
+	assert.NoError(t, w.Put(c, ForwardSummary{HttpRequest: req, State: TaskStateRetrying}))
+	status, found, err = w.Get(c, "abc")
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, TaskStateCancelled, status.State)
+	assert.Equal(t, int32(1), status.Attempts)
+	assert.True(t, status.State.IsCompleted())
+}
+
+func TestConcurrentAttempts(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := store.NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+	w := New(s, envelope.Plaintext())
+
+	req := httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit"}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, w.Put(c, ForwardSummary{HttpRequest: req, State: TaskStateRetrying}))
+		}()
+	}
+	wg.Wait()
+
+	status, _, err := w.Get(c, "abc")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(10), status.Attempts)
+	attempts, err := w.ListAttempts(c, "abc")
+	assert.NoError(t, err)
+	assert.Len(t, attempts, 10)
+}
+
+// unreadableStore fails to read, like a store that is temporarily unavailable
+type unreadableStore struct {
+	store.DataStorer
+}
+
+func (s unreadableStore) Update(c context.Context, kind, uid string, value interface{}, update func(found bool) error) error {
+	return fmt.Errorf("store unavailable")
+}
+
+func TestPutDoesNotOverwriteWhatCannotBeRead(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := store.NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+
+	err = New(unreadableStore{s}, envelope.Plaintext()).Put(c, ForwardSummary{HttpRequest: httpclient.Request{TaskUID: "abc"}, State: TaskStateRetrying})
+	assert.EqualError(t, err, "Error storing task-status: store unavailable")
+	attempts, err := New(s, envelope.Plaintext()).ListAttempts(c, "abc")
+	assert.NoError(t, err)
+	assert.Len(t, attempts, 0)
+}
+
+func TestAttemptHistory(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := store.NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+	w := New(s, envelope.Plaintext())
+
+	req := httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit", Headers: http.Header{"Content-Type": []string{"application/json"}}, Body: []byte(`{"a":1}`)}
+	otherReq := httpclient.Request{TaskUID: "def", Method: "POST", URL: "https://home.nl/doit"}
+
+	assert.NoError(t, w.Put(c, ForwardSummary{HttpRequest: req, State: TaskStatePending}))
+	assert.NoError(t, w.Put(c, ForwardSummary{HttpRequest: req, Error: fmt.Errorf("network error"), State: TaskStateRetrying, Latency: 30 * time.Second}))
+	assert.NoError(t, w.Put(c, ForwardSummary{HttpRequest: otherReq, HttpResponse: &httpclient.Response{Status: 200}, State: TaskStateDelivered}))
+	assert.NoError(t, w.Put(c, ForwardSummary{HttpRequest: req, HttpResponse: &httpclient.Response{Status: 200, Headers: http.Header{"X-Trace": []string{"1", "2"}}, Body: []byte(strings.Repeat("x", 2000))}, State: TaskStateDelivered, Latency: 20 * time.Millisecond}))
+
+	attempts, err := w.ListAttempts(c, "abc")
+	assert.NoError(t, err)
+	assert.Len(t, attempts, 2)
+
+	assert.Equal(t, int32(1), attempts[0].Attempt)
+	assert.Equal(t, TaskStateRetrying, attempts[0].State)
+	assert.Equal(t, "POST", attempts[0].Method)
+	assert.Equal(t, http.Header{"Content-Type": []string{"application/json"}}, attempts[0].RequestHeaders)
+	assert.Equal(t, `{"a":1}`, attempts[0].RequestBody)
+	assert.Equal(t, 0, attempts[0].ResponseStatus)
+	assert.Equal(t, "network error", attempts[0].Error)
+	assert.Equal(t, int64(30000), attempts[0].LatencyMillis)
+
+	assert.Equal(t, int32(2), attempts[1].Attempt)
+	assert.Equal(t, TaskStateDelivered, attempts[1].State)
+	assert.Equal(t, 200, attempts[1].ResponseStatus)
+	assert.Equal(t, http.Header{"X-Trace": []string{"1", "2"}}, attempts[1].ResponseHeaders)
+	assert.Equal(t, strings.Repeat("x", maxBodyExcerpt)+"...", attempts[1].ResponseBody)
+	assert.Equal(t, "", attempts[1].Error)
+	assert.Equal(t, int64(20), attempts[1].LatencyMillis)
+
+	attempts, err = w.ListAttempts(c, "unknown")
+	assert.NoError(t, err)
+	assert.Len(t, attempts, 0)
+}
+
+func TestAttemptCredentialsRedacted(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := store.NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+	w := New(s, envelope.Plaintext())
+
+	req := httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit", Headers: http.Header{
+		"Content-Type":      []string{"application/json"},
+		"Authorization":     []string{"Bearer 123"},
+		"Cookie":            []string{"session=456"},
+		"X-Api-Key":         []string{"k3y"},
+		"Webhook-Signature": []string{"v1,abc", "v1,def"},
+	}}
+	resp := &httpclient.Response{Status: 200, Headers: http.Header{"Set-Cookie": []string{"session=789"}, "X-Trace": []string{"1"}}}
+	assert.NoError(t, w.Put(c, ForwardSummary{HttpRequest: req, HttpResponse: resp, State: TaskStateDelivered}))
+
+	attempts, err := w.ListAttempts(c, "abc")
+	assert.NoError(t, err)
+	assert.Len(t, attempts, 1)
+	assert.Equal(t, http.Header{
+		"Content-Type":      []string{"application/json"},
+		"Authorization":     []string{"[redacted]"},
+		"Cookie":            []string{"[redacted]"},
+		"X-Api-Key":         []string{"[redacted]"},
+		"Webhook-Signature": []string{"[redacted]", "[redacted]"},
+	}, attempts[0].RequestHeaders)
+	assert.Equal(t, http.Header{"Set-Cookie": []string{"[redacted]"}, "X-Trace": []string{"1"}}, attempts[0].ResponseHeaders)
+
+	// the request itself is left alone, because it is still to be sent
+	assert.Equal(t, "Bearer 123", req.Headers.Get("Authorization"))
+}
+
+func TestOrderingKey(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := store.NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+	w := New(s, envelope.Plaintext())
+
+	predecessor, err := w.AppendToOrderingKey(c, "order-123", "abc")
+	assert.NoError(t, err)
+	assert.Equal(t, "", predecessor)
+
+	predecessor, err = w.AppendToOrderingKey(c, "order-123", "def")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", predecessor)
+
+	// other keys are independent
+	predecessor, err = w.AppendToOrderingKey(c, "order-456", "ghi")
+	assert.NoError(t, err)
+	assert.Equal(t, "", predecessor)
+
+	// enqueued again
+	predecessor, err = w.AppendToOrderingKey(c, "order-123", "def")
+	assert.NoError(t, err)
+	assert.Equal(t, "", predecessor)
+}
+
+func TestOrderingKeySharedByReplicas(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := store.NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+	replicas := []Warehouser{New(s, envelope.Plaintext()), New(s, envelope.Plaintext())}
+
+	mutex := sync.Mutex{}
+	predecessors := map[string]string{}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(taskUID string, w Warehouser) {
+			defer wg.Done()
+			predecessor, err := w.AppendToOrderingKey(c, "order-123", taskUID)
+			assert.NoError(t, err)
+			mutex.Lock()
+			predecessors[predecessor] = taskUID
+			mutex.Unlock()
+		}(fmt.Sprintf("task-%d", i), replicas[i%2])
+	}
+	wg.Wait()
+
+	// a single chain: every task is followed by at most one other
+	assert.Len(t, predecessors, 20)
+	taskUID, length := predecessors[""], 1
+	for ; predecessors[taskUID] != ""; length++ {
+		taskUID = predecessors[taskUID]
+	}
+	assert.Equal(t, 20, length)
+}
+
+func TestRemoveFromOrderingKey(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := store.NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+	w := New(s, envelope.Plaintext())
+
+	_, err = w.AppendToOrderingKey(c, "order-123", "abc")
+	assert.NoError(t, err)
+	_, err = w.AppendToOrderingKey(c, "order-123", "def")
+	assert.NoError(t, err)
+
+	// def could not be enqueued
+	assert.NoError(t, w.RemoveFromOrderingKey(c, "order-123", "def", "abc"))
+	predecessor, err := w.AppendToOrderingKey(c, "order-123", "ghi")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", predecessor)
+
+	// abc is no longer the last one, so it stays behind ghi
+	assert.NoError(t, w.RemoveFromOrderingKey(c, "order-123", "abc", ""))
+	predecessor, err = w.AppendToOrderingKey(c, "order-123", "jkl")
+	assert.NoError(t, err)
+	assert.Equal(t, "ghi", predecessor)
+
+	// the first task of a key leaves nothing to wait for
+	_, err = w.AppendToOrderingKey(c, "order-456", "mno")
+	assert.NoError(t, err)
+	assert.NoError(t, w.RemoveFromOrderingKey(c, "order-456", "mno", ""))
+	predecessor, err = w.AppendToOrderingKey(c, "order-456", "pqr")
+	assert.NoError(t, err)
+	assert.Equal(t, "", predecessor)
+}
+
+func TestLogWarehouseRefusesOrdering(t *testing.T) {
+	_, err := NewLogWarehouse().AppendToOrderingKey(context.Background(), "order-123", "abc")
+	assert.True(t, errors.Is(err, ErrNotSupported))
+}
+
+func TestFanOutStatus(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := store.NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+	w := New(s, envelope.Plaintext())
+
+	for _, uid := range []string{"abc-2", "abc-1"} {
+		req := httpclient.Request{TaskUID: uid, ParentTaskUID: "abc", Method: "POST", URL: "https://home.nl/doit"}
+		assert.NoError(t, w.Put(c, ForwardSummary{HttpRequest: req, State: TaskStatePending}))
+	}
+	assert.NoError(t, w.Put(c, ForwardSummary{HttpRequest: httpclient.Request{TaskUID: "def", Method: "POST", URL: "https://home.nl/doit"}, State: TaskStatePending}))
+
+	children, err := w.ListChildren(c, "abc")
+	assert.NoError(t, err)
+	assert.Len(t, children, 2)
+	assert.Equal(t, "abc-1", children[0].TaskUID)
+	assert.Equal(t, "abc", children[0].ParentTaskUID)
+	assert.Equal(t, TaskStatePending, NewFanOutStatus("abc", children).State)
+
+	testCases := []struct {
+		name          string
+		states        []TaskState
+		expectedState TaskState
+	}{
+		{name: "Some retrying", states: []TaskState{TaskStateDelivered, TaskStateRetrying, TaskStatePending}, expectedState: TaskStateRetrying},
+		{name: "Some pending", states: []TaskState{TaskStateDelivered, TaskStatePending}, expectedState: TaskStatePending},
+		{name: "All completed, one failed", states: []TaskState{TaskStateDelivered, TaskStateFailed}, expectedState: TaskStateFailed},
+		{name: "All delivered", states: []TaskState{TaskStateDelivered, TaskStateDelivered}, expectedState: TaskStateDelivered},
+		{name: "Delivered or cancelled", states: []TaskState{TaskStateDelivered, TaskStateCancelled}, expectedState: TaskStateDelivered},
+		{name: "All cancelled", states: []TaskState{TaskStateCancelled}, expectedState: TaskStateCancelled},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			children := []TaskStatus{}
+			for _, state := range tc.states {
+				children = append(children, TaskStatus{State: state})
+			}
+			status := NewFanOutStatus("abc", children)
+			assert.Equal(t, tc.expectedState, status.State)
+			assert.Equal(t, len(tc.states), len(status.Children))
+		})
+	}
+}
+
+func TestBodiesEncryptedAtRest(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := store.NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+	keyring, err := envelope.NewKeyring("key-1", map[string][]byte{"key-1": []byte("0123456789abcdef0123456789abcdef")})
+	assert.NoError(t, err)
+
+	// written before encryption was enabled
+	assert.NoError(t, New(s, envelope.Plaintext()).Put(c, ForwardSummary{HttpRequest: httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit", Body: []byte("old request")}, State: TaskStateRetrying}))
+
+	w := New(s, envelope.New(keyring))
+	response := &httpclient.Response{Status: 200, Body: []byte("new response")}
+	req := httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit", Body: []byte("new request")}
+	assert.NoError(t, w.Put(c, ForwardSummary{HttpRequest: req, HttpResponse: response, State: TaskStateDelivered}))
+	assert.Equal(t, "new response", string(response.Body))
+
+	record := forwardStatsRecord{}
+	_, err = s.Get(c, forwardSummaryKind, "abc", &record)
+	assert.NoError(t, err)
+	assert.True(t, envelope.IsSealed(record.Request.Body))
+	assert.True(t, envelope.IsSealed(record.Response.Body))
+
+	// cancelling keeps the stored request: it is not sealed twice
+	assert.NoError(t, w.Put(c, ForwardSummary{HttpRequest: httpclient.Request{TaskUID: "abc"}, State: TaskStateCancelled}))
+	_, err = s.Get(c, forwardSummaryKind, "abc", &record)
+	assert.NoError(t, err)
+	opened, err := envelope.New(keyring).Open(c, record.Request.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "new request", string(opened))
+
+	attempts, err := w.ListAttempts(c, "abc")
+	assert.NoError(t, err)
+	assert.Len(t, attempts, 2)
+	assert.Equal(t, "old request", attempts[0].RequestBody)
+	assert.Equal(t, "new request", attempts[1].RequestBody)
+	assert.Equal(t, "new response", attempts[1].ResponseBody)
+
+	_, err = New(s, envelope.Plaintext()).ListAttempts(c, "abc")
+	assert.Error(t, err)
+}
+
+func TestHeadersEncryptedAtRest(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := store.NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+	keyring, err := envelope.NewKeyring("key-1", map[string][]byte{"key-1": []byte("0123456789abcdef0123456789abcdef")})
+	assert.NoError(t, err)
+	w := New(s, envelope.New(keyring))
+
+	req := httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit", Headers: http.Header{"Authorization": []string{"Bearer 123"}, "X-Customer": []string{"jane@home.nl"}}}
+	resp := &httpclient.Response{Status: 200, Headers: http.Header{"X-Customer": []string{"jane@home.nl"}}}
+	assert.NoError(t, w.Put(c, ForwardSummary{HttpRequest: req, HttpResponse: resp, State: TaskStateDelivered}))
+	assert.Equal(t, "jane@home.nl", resp.Headers.Get("X-Customer"))
+
+	record := forwardStatsRecord{}
+	_, err = s.Get(c, forwardSummaryKind, "abc", &record)
+	assert.NoError(t, err)
+	assert.Nil(t, record.Request.Headers)
+	assert.Nil(t, record.Response.Headers)
+	assert.True(t, envelope.IsSealed(record.Headers))
+	assert.NoError(t, w.(*Warehouse).open(c, &record))
+	assert.Equal(t, http.Header{"Authorization": []string{"[redacted]"}, "X-Customer": []string{"jane@home.nl"}}, record.Request.Headers)
+	assert.Equal(t, http.Header{"X-Customer": []string{"jane@home.nl"}}, record.Response.Headers)
+
+	attemptRecord := forwardAttemptRecord{}
+	_, err = s.Get(c, forwardAttemptKind, "abc-00001", &attemptRecord)
+	assert.NoError(t, err)
+	assert.Empty(t, attemptRecord.RequestHeaders)
+	assert.True(t, envelope.IsSealed([]byte(attemptRecord.Headers)))
+
+	attempts, err := w.ListAttempts(c, "abc")
+	assert.NoError(t, err)
+	assert.Len(t, attempts, 1)
+	assert.Equal(t, http.Header{"Authorization": []string{"[redacted]"}, "X-Customer": []string{"jane@home.nl"}}, attempts[0].RequestHeaders)
+	assert.Equal(t, http.Header{"X-Customer": []string{"jane@home.nl"}}, attempts[0].ResponseHeaders)
+}
+
+func TestAttemptHeadersStoredAsLines(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := store.NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+
+	// stored before headers were sealed
+	assert.NoError(t, s.Put(c, forwardAttemptKind, "abc-00001", forwardAttemptRecord{TaskUID: "abc", Attempt: 1, RequestHeaders: []string{"Content-Type: application/json"}}))
+
+	attempts, err := New(s, envelope.Plaintext()).ListAttempts(c, "abc")
+	assert.NoError(t, err)
+	assert.Len(t, attempts, 1)
+	assert.Equal(t, http.Header{"Content-Type": []string{"application/json"}}, attempts[0].RequestHeaders)
+}
+
+func TestPlaintextBodyThatLooksSealed(t *testing.T) {
+	c := context.Background()
+	s, cleanup, err := store.NewMemoryStore(c)
+	assert.NoError(t, err)
+	defer cleanup()
+	w := New(s, envelope.Plaintext())
+
+	// clients may send anything
+	req := httpclient.Request{TaskUID: "abc", Method: "POST", URL: "https://home.nl/doit", Body: []byte("forwardhttp:sealed:v1:{}")}
+	assert.NoError(t, w.Put(c, ForwardSummary{HttpRequest: req, State: TaskStateRetrying}))
+	assert.NoError(t, w.Put(c, ForwardSummary{HttpRequest: req, HttpResponse: &httpclient.Response{Status: 200}, State: TaskStateDelivered}))
+
+	attempts, err := w.ListAttempts(c, "abc")
+	assert.NoError(t, err)
+	assert.Len(t, attempts, 2)
+	assert.Equal(t, "forwardhttp:sealed:v1:{}", attempts[1].RequestBody)
+}
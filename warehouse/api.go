@@ -2,10 +2,16 @@ package warehouse
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"time"
 
 	"github.com/MarcGrol/forwardhttp/httpclient"
 )
 
+// ErrNotSupported is returned for features the configured warehouse cannot provide
+var ErrNotSupported = errors.New("Not supported by this warehouse")
+
 type Stats struct {
 	RetryCount    int32
 	MaxRetryCount int32
@@ -15,6 +21,30 @@ func (s Stats) IsLastAttempt() bool {
 	return s.RetryCount == s.MaxRetryCount
 }
 
+type TaskState string
+
+const (
+	TaskStatePending   TaskState = "pending"   // enqueued, no attempt made yet
+	TaskStateRetrying  TaskState = "retrying"  // attempted without success, more attempts will follow
+	TaskStateDelivered TaskState = "delivered" // successfully delivered
+	TaskStateFailed    TaskState = "failed"    // no more attempts will follow
+	TaskStateCancelled TaskState = "cancelled" // cancelled before it was delivered
+	TaskStateRejected  TaskState = "rejected"  // not sent, because the destination is not allowed, it could not be enqueued or its predecessor got stuck
+)
+
+func (s TaskState) IsValid() bool {
+	switch s {
+	case TaskStatePending, TaskStateRetrying, TaskStateDelivered, TaskStateFailed, TaskStateCancelled, TaskStateRejected:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s TaskState) IsCompleted() bool {
+	return s == TaskStateDelivered || s == TaskStateFailed || s == TaskStateCancelled || s == TaskStateRejected
+}
+
 //go:generate mockgen -source=api.go -destination=gen_WarehouseClientMock.go -package=warehouse github.com/MarcGrol/forwardhttp/warehouse Warehouser
 
 type ForwardSummary struct {
@@ -22,7 +52,86 @@ type ForwardSummary struct {
 	HttpResponse *httpclient.Response
 	Error        error
 	Stats        Stats
+	State        TaskState     // pending when enqueued, cancelled when cancelled, rejected when not allowed, otherwise the outcome of an attempt
+	Latency      time.Duration // duration of the attempt
 }
+
+// TaskStatus describes what happened to a forwarded request so far
+type TaskStatus struct {
+	TaskUID            string
+	State              TaskState
+	Method             string
+	URL                string
+	Route              string `json:",omitempty"`
+	OrderingKey        string `json:",omitempty"`
+	ParentTaskUID      string `json:",omitempty"`
+	Owner              string `json:",omitempty"` // name of the client that submitted the task
+	Attempts           int32
+	MaxAttempts        int32
+	LastResponseStatus int    `json:",omitempty"`
+	LastError          string `json:",omitempty"`
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+}
+
+// Attempt describes a single delivery attempt of a forwarded request
+type Attempt struct {
+	TaskUID         string
+	Attempt         int32
+	State           TaskState
+	Method          string
+	URL             string
+	RequestHeaders  http.Header `json:",omitempty"`
+	RequestBody     string      `json:",omitempty"` // excerpt
+	ResponseStatus  int         `json:",omitempty"`
+	ResponseHeaders http.Header `json:",omitempty"`
+	ResponseBody    string      `json:",omitempty"` // excerpt
+	Error           string      `json:",omitempty"`
+	LatencyMillis   int64
+	Timestamp       time.Time
+}
+
+// FanOutStatus aggregates the progress of the child-tasks of a request that was delivered to multiple targets
+type FanOutStatus struct {
+	TaskUID  string
+	State    TaskState
+	Progress map[TaskState]int
+	Children []TaskStatus
+}
+
+// NewFanOutStatus is delivered once all children are, and failed once all children are completed and one of them failed
+func NewFanOutStatus(taskUID string, children []TaskStatus) FanOutStatus {
+	status := FanOutStatus{
+		TaskUID:  taskUID,
+		Progress: map[TaskState]int{},
+		Children: children,
+	}
+	for _, child := range children {
+		status.Progress[child.State]++
+	}
+	switch {
+	case status.Progress[TaskStateRetrying] > 0:
+		status.State = TaskStateRetrying
+	case status.Progress[TaskStatePending] > 0:
+		status.State = TaskStatePending
+	case status.Progress[TaskStateFailed] > 0 || status.Progress[TaskStateRejected] > 0:
+		status.State = TaskStateFailed
+	case status.Progress[TaskStateDelivered] > 0:
+		status.State = TaskStateDelivered
+	default:
+		status.State = TaskStateCancelled
+	}
+	return status
+}
+
 type Warehouser interface {
 	Put(c context.Context, summary ForwardSummary) error
+	Get(c context.Context, taskUID string) (*TaskStatus, bool, error)
+	Query(c context.Context, state TaskState) ([]TaskStatus, error)
+	ListAttempts(c context.Context, taskUID string) ([]Attempt, error)
+	ListChildren(c context.Context, parentTaskUID string) ([]TaskStatus, error)
+	// AppendToOrderingKey makes the task the last one of the ordering-key and returns the task it follows
+	AppendToOrderingKey(c context.Context, orderingKey, taskUID string) (string, error)
+	// RemoveFromOrderingKey makes the predecessor the last task of the ordering-key again, when the task is still the last one
+	RemoveFromOrderingKey(c context.Context, orderingKey, taskUID, predecessor string) error
 }
@@ -33,6 +33,67 @@ func (m *MockWarehouser) EXPECT() *MockWarehouserMockRecorder {
 	return m.recorder
 }
 
+// AppendToOrderingKey mocks base method
+func (m *MockWarehouser) AppendToOrderingKey(c context.Context, orderingKey, taskUID string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AppendToOrderingKey", c, orderingKey, taskUID)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AppendToOrderingKey indicates an expected call of AppendToOrderingKey
+func (mr *MockWarehouserMockRecorder) AppendToOrderingKey(c, orderingKey, taskUID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AppendToOrderingKey", reflect.TypeOf((*MockWarehouser)(nil).AppendToOrderingKey), c, orderingKey, taskUID)
+}
+
+// Get mocks base method
+func (m *MockWarehouser) Get(c context.Context, taskUID string) (*TaskStatus, bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Get", c, taskUID)
+	ret0, _ := ret[0].(*TaskStatus)
+	ret1, _ := ret[1].(bool)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// Get indicates an expected call of Get
+func (mr *MockWarehouserMockRecorder) Get(c, taskUID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Get", reflect.TypeOf((*MockWarehouser)(nil).Get), c, taskUID)
+}
+
+// ListAttempts mocks base method
+func (m *MockWarehouser) ListAttempts(c context.Context, taskUID string) ([]Attempt, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListAttempts", c, taskUID)
+	ret0, _ := ret[0].([]Attempt)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListAttempts indicates an expected call of ListAttempts
+func (mr *MockWarehouserMockRecorder) ListAttempts(c, taskUID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListAttempts", reflect.TypeOf((*MockWarehouser)(nil).ListAttempts), c, taskUID)
+}
+
+// ListChildren mocks base method
+func (m *MockWarehouser) ListChildren(c context.Context, parentTaskUID string) ([]TaskStatus, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListChildren", c, parentTaskUID)
+	ret0, _ := ret[0].([]TaskStatus)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListChildren indicates an expected call of ListChildren
+func (mr *MockWarehouserMockRecorder) ListChildren(c, parentTaskUID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListChildren", reflect.TypeOf((*MockWarehouser)(nil).ListChildren), c, parentTaskUID)
+}
+
 // Put mocks base method
 func (m *MockWarehouser) Put(c context.Context, summary ForwardSummary) error {
 	m.ctrl.T.Helper()
@@ -46,3 +107,32 @@ func (mr *MockWarehouserMockRecorder) Put(c, summary interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Put", reflect.TypeOf((*MockWarehouser)(nil).Put), c, summary)
 }
+
+// Query mocks base method
+func (m *MockWarehouser) Query(c context.Context, state TaskState) ([]TaskStatus, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Query", c, state)
+	ret0, _ := ret[0].([]TaskStatus)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Query indicates an expected call of Query
+func (mr *MockWarehouserMockRecorder) Query(c, state interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Query", reflect.TypeOf((*MockWarehouser)(nil).Query), c, state)
+}
+
+// RemoveFromOrderingKey mocks base method
+func (m *MockWarehouser) RemoveFromOrderingKey(c context.Context, orderingKey, taskUID, predecessor string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveFromOrderingKey", c, orderingKey, taskUID, predecessor)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveFromOrderingKey indicates an expected call of RemoveFromOrderingKey
+func (mr *MockWarehouserMockRecorder) RemoveFromOrderingKey(c, orderingKey, taskUID, predecessor interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveFromOrderingKey", reflect.TypeOf((*MockWarehouser)(nil).RemoveFromOrderingKey), c, orderingKey, taskUID, predecessor)
+}
@@ -0,0 +1,44 @@
+package warehouse
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+type logWarehouse struct{}
+
+// NewLogWarehouse only logs the summaries, for setups that do not need to keep track of what was forwarded
+func NewLogWarehouse() Warehouser {
+	return &logWarehouse{}
+}
+
+func (w logWarehouse) Put(c context.Context, summary ForwardSummary) error {
+	log.Printf("Forward summary: req: %s, state: %s, resp: %+v, err: %v, stats: %+v, latency: %s", summary.HttpRequest, summary.State, summary.HttpResponse, summary.Error, summary.Stats, summary.Latency)
+	return nil
+}
+
+func (w logWarehouse) Get(c context.Context, taskUID string) (*TaskStatus, bool, error) {
+	return nil, false, nil
+}
+
+func (w logWarehouse) Query(c context.Context, state TaskState) ([]TaskStatus, error) {
+	return []TaskStatus{}, nil
+}
+
+func (w logWarehouse) ListAttempts(c context.Context, taskUID string) ([]Attempt, error) {
+	return []Attempt{}, nil
+}
+
+func (w logWarehouse) ListChildren(c context.Context, parentTaskUID string) ([]TaskStatus, error) {
+	return []TaskStatus{}, nil
+}
+
+// AppendToOrderingKey refuses, because without history tasks cannot be held back to preserve order
+func (w logWarehouse) AppendToOrderingKey(c context.Context, orderingKey, taskUID string) (string, error) {
+	return "", fmt.Errorf("Error ordering on key %s: %w", orderingKey, ErrNotSupported)
+}
+
+func (w logWarehouse) RemoveFromOrderingKey(c context.Context, orderingKey, taskUID, predecessor string) error {
+	return nil
+}
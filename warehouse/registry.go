@@ -0,0 +1,58 @@
+package warehouse
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/MarcGrol/forwardhttp/config"
+	"github.com/MarcGrol/forwardhttp/envelope"
+	"github.com/MarcGrol/forwardhttp/store"
+)
+
+// Provider creates a warehouse backend, after validating the settings it needs
+type Provider func(c context.Context, settings config.Settings, store store.DataStorer, sealer envelope.Sealer) (Warehouser, error)
+
+var providers = map[string]Provider{
+	"store": newStoreWarehouseFromSettings,
+	"log":   newLogWarehouseFromSettings,
+}
+
+func RegisterProvider(name string, provider Provider) {
+	providers[name] = provider
+}
+
+// NewFromSettings creates the warehouse backend selected by WAREHOUSE_BACKEND (default "store")
+func NewFromSettings(c context.Context, settings config.Settings, store store.DataStorer, sealer envelope.Sealer) (Warehouser, error) {
+	name := settings.GetOrDefault("WAREHOUSE_BACKEND", "store")
+	provider, found := providers[name]
+	if !found {
+		return nil, fmt.Errorf("Unknown warehouse backend '%s', expected one of: %s", name, providerNames())
+	}
+	w, err := provider(c, settings, store, sealer)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating warehouse backend '%s': %s", name, err)
+	}
+	return w, nil
+}
+
+func providerNames() string {
+	names := []string{}
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func newStoreWarehouseFromSettings(c context.Context, settings config.Settings, store store.DataStorer, sealer envelope.Sealer) (Warehouser, error) {
+	if store == nil {
+		return nil, fmt.Errorf("Missing store")
+	}
+	return New(store, sealer), nil
+}
+
+func newLogWarehouseFromSettings(c context.Context, settings config.Settings, store store.DataStorer, sealer envelope.Sealer) (Warehouser, error) {
+	return NewLogWarehouse(), nil
+}